@@ -17,6 +17,8 @@ type App struct {
 	fileService     *service.FileService
 	configService   *service.ConfigService
 	aiService       *service.AIService
+	historyService  *service.HistoryService
+	usageService    *service.UsageService
 	promptService   *service.PromptService
 	modelService    *service.ModelService
 	modelFileServer *service.ModelFileServer
@@ -28,7 +30,9 @@ func NewApp() *App {
 	// 创建服务实例
 	configService := service.NewConfigService()
 	fileService := service.NewFileService()
-	aiService := service.NewAIService(configService)
+	historyService := service.NewHistoryService()
+	usageService := service.NewUsageService(configService)
+	aiService := service.NewAIService(configService, historyService, usageService)
 	promptService := service.NewPromptService(configService)
 	modelService := service.NewModelService(configService)
 
@@ -45,6 +49,8 @@ func NewApp() *App {
 		fileService:     fileService,
 		configService:   configService,
 		aiService:       aiService,
+		historyService:  historyService,
+		usageService:    usageService,
 		promptService:   promptService,
 		modelService:    modelService,
 		modelFileServer: modelFileServer,
@@ -214,6 +220,47 @@ func (a *App) LoadSettings() (string, error) {
 	return a.configService.LoadSettings()
 }
 
+// SetActiveAIProfile 切换当前 AI 配置档案
+// 提供商按档案缓存，切换后无需重新加载
+func (a *App) SetActiveAIProfile(id string) error {
+	return a.configService.SetActiveAIProfile(id)
+}
+
+// SaveAIProfile 新建或更新 AI 配置档案
+// profileJSON: {"id": "", "name": string, "type": "gemini|openai|cloud|comfyui|sdwebui|ollama|mock", ...连接配置}
+// id 为空时新建档案；返回保存后的档案 JSON
+func (a *App) SaveAIProfile(profileJSON string) (string, error) {
+	var profile types.AIProfile
+	if err := json.Unmarshal([]byte(profileJSON), &profile); err != nil {
+		return "", fmt.Errorf("invalid profile format: %w", err)
+	}
+
+	saved, err := a.configService.SaveAIProfile(profile)
+	if err != nil {
+		return "", err
+	}
+	if err := a.aiService.ReloadProvider(saved.ID); err != nil {
+		fmt.Printf("[App] Warning: failed to reload AI provider: %v\n", err)
+	}
+
+	data, err := json.Marshal(saved)
+	if err != nil {
+		return "", fmt.Errorf("failed to serialize profile: %w", err)
+	}
+	return string(data), nil
+}
+
+// DeleteAIProfile 删除 AI 配置档案
+func (a *App) DeleteAIProfile(id string) error {
+	if err := a.configService.DeleteAIProfile(id); err != nil {
+		return err
+	}
+	if err := a.aiService.ReloadProvider(id); err != nil {
+		fmt.Printf("[App] Warning: failed to reload AI provider: %v\n", err)
+	}
+	return nil
+}
+
 // ===== AI 服务方法 =====
 
 // GenerateImage 生成图像
@@ -221,11 +268,49 @@ func (a *App) GenerateImage(paramsJSON string) (string, error) {
 	return a.aiService.GenerateImage(paramsJSON)
 }
 
+// GenerateImages 生成多张候选图像
+// 返回 JSON 格式的图像数组：["data:image/png;base64,...", ...]
+func (a *App) GenerateImages(paramsJSON string) (string, error) {
+	images, err := a.aiService.GenerateImages(paramsJSON)
+	if err != nil {
+		return "", err
+	}
+
+	data, err := json.Marshal(images)
+	if err != nil {
+		return "", fmt.Errorf("failed to serialize images: %w", err)
+	}
+
+	return string(data), nil
+}
+
+// GenerateImagesWithMeta 生成图像并返回结果元数据
+// 返回 JSON 格式：{"images": [...], "meta": {"provider": string, "cached": bool, "cachedAt": string}}
+func (a *App) GenerateImagesWithMeta(paramsJSON string) (string, error) {
+	return marshalAIResponse(a.aiService.GenerateImagesWithMeta(paramsJSON))
+}
+
 // EditImage 编辑图像
 func (a *App) EditImage(paramsJSON string) (string, error) {
 	return a.aiService.EditImage(paramsJSON)
 }
 
+// EditImageWithMeta 编辑图像并返回结果元数据
+// 返回 JSON 格式：{"images": ["..."], "meta": {...}}
+func (a *App) EditImageWithMeta(paramsJSON string) (string, error) {
+	return marshalAIResponse(a.aiService.EditImageWithMeta(paramsJSON))
+}
+
+// ExtendImage 扩展图像（外绘）
+func (a *App) ExtendImage(paramsJSON string) (string, error) {
+	return a.aiService.ExtendImage(paramsJSON)
+}
+
+// UpscaleImage 放大图像（2x 或 4x）
+func (a *App) UpscaleImage(paramsJSON string) (string, error) {
+	return a.aiService.UpscaleImage(paramsJSON)
+}
+
 // RemoveBackground 移除背景
 func (a *App) RemoveBackground(imageData string) (string, error) {
 	return a.aiService.RemoveBackground(imageData)
@@ -241,17 +326,64 @@ func (a *App) EnhancePrompt(prompt string) (string, error) {
 	return a.aiService.EnhancePrompt(prompt)
 }
 
+// EnhancePromptWithMeta 增强提示词并返回结果元数据
+// paramsJSON: {"prompt": string, "bypassCache": bool}
+// 返回 JSON 格式：{"text": string, "meta": {...}}
+func (a *App) EnhancePromptWithMeta(paramsJSON string) (string, error) {
+	return marshalAIResponse(a.aiService.EnhancePromptWithMeta(paramsJSON))
+}
+
+// marshalAIResponse 序列化带元数据的 AI 调用结果
+func marshalAIResponse(response *types.AIResponse, err error) (string, error) {
+	if err != nil {
+		return "", err
+	}
+
+	data, err := json.Marshal(response)
+	if err != nil {
+		return "", fmt.Errorf("failed to serialize response: %w", err)
+	}
+
+	return string(data), nil
+}
+
+// CancelAIOperation 取消进行中的 AI 操作
+// operationID 来自 "ai-operation-started" 事件，或调用时在参数 JSON 中指定的 operationId
+func (a *App) CancelAIOperation(operationID string) error {
+	return a.aiService.CancelOperation(operationID)
+}
+
+// GetAIProviderCapabilities 获取 AI 提供商支持的功能
+// 返回 JSON 格式：{"generateImage": bool, ..., "aspectRatios": [...], "imageSizes": [...], "maxImageCount": int}
+func (a *App) GetAIProviderCapabilities(providerName string) (string, error) {
+	caps, err := a.aiService.GetProviderCapabilities(providerName)
+	if err != nil {
+		return "", err
+	}
+
+	data, err := json.Marshal(caps)
+	if err != nil {
+		return "", fmt.Errorf("failed to serialize capabilities: %w", err)
+	}
+
+	return string(data), nil
+}
+
 // CheckAIProviderAvailability 检测 AI 提供商可用性
-// 返回 JSON 格式：{"available": bool, "message": string}
+// 返回 JSON 格式：{"available": bool, "message": string, "code"?: string}（不可用时 code 为错误代码，见 apperr.Code）
 func (a *App) CheckAIProviderAvailability(providerName string) (string, error) {
-	available, message, err := a.aiService.CheckProviderAvailability(providerName)
+	available, reason, err := a.aiService.CheckProviderAvailability(providerName)
 	if err != nil {
 		return "", err
 	}
 
 	result := map[string]interface{}{
 		"available": available,
-		"message":   message,
+		"message":   "",
+	}
+	if reason != nil {
+		result["code"] = reason.Code
+		result["message"] = reason.Message
 	}
 
 	data, err := json.Marshal(result)
@@ -262,6 +394,103 @@ func (a *App) CheckAIProviderAvailability(providerName string) (string, error) {
 	return string(data), nil
 }
 
+// ListAIProviderModels 列出 AI 提供商的可用模型（如 Stable Diffusion WebUI 的模型检查点）
+// 返回 JSON 格式：[{"id": string, "name": string}]
+func (a *App) ListAIProviderModels(providerName string) (string, error) {
+	models, err := a.aiService.ListProviderModels(providerName)
+	if err != nil {
+		return "", err
+	}
+
+	data, err := json.Marshal(models)
+	if err != nil {
+		return "", fmt.Errorf("failed to serialize models: %w", err)
+	}
+
+	return string(data), nil
+}
+
+// ===== 生成历史服务方法 =====
+
+// ListHistory 分页查询生成历史
+// queryJSON: {"page": 1, "pageSize": 20, "feature": "", "provider": "", "search": "", "since": "", "until": ""}
+// 返回 JSON 格式：{"entries": [...], "total": int, "page": int, "pageSize": int}
+func (a *App) ListHistory(queryJSON string) (string, error) {
+	var query service.HistoryQuery
+	if queryJSON != "" {
+		if err := json.Unmarshal([]byte(queryJSON), &query); err != nil {
+			return "", fmt.Errorf("invalid query: %w", err)
+		}
+	}
+
+	page, err := a.historyService.List(query)
+	if err != nil {
+		return "", err
+	}
+
+	data, err := json.Marshal(page)
+	if err != nil {
+		return "", fmt.Errorf("failed to serialize history: %w", err)
+	}
+
+	return string(data), nil
+}
+
+// GetHistoryEntry 获取生成历史详情（包括结果图像和原始参数）
+func (a *App) GetHistoryEntry(id string) (string, error) {
+	detail, err := a.historyService.Get(id)
+	if err != nil {
+		return "", err
+	}
+
+	data, err := json.Marshal(detail)
+	if err != nil {
+		return "", fmt.Errorf("failed to serialize history entry: %w", err)
+	}
+
+	return string(data), nil
+}
+
+// DeleteHistoryEntry 删除生成历史
+func (a *App) DeleteHistoryEntry(id string) error {
+	return a.historyService.Delete(id)
+}
+
+// RerunHistoryEntry 使用历史记录的参数重新生成
+// 返回 JSON 格式的图像数组：["data:image/png;base64,...", ...]
+func (a *App) RerunHistoryEntry(id string) (string, error) {
+	images, err := a.aiService.RerunHistoryEntry(id)
+	if err != nil {
+		return "", err
+	}
+
+	data, err := json.Marshal(images)
+	if err != nil {
+		return "", fmt.Errorf("failed to serialize images: %w", err)
+	}
+
+	return string(data), nil
+}
+
+// ===== 用量统计服务方法 =====
+
+// GetUsageReport 获取用量和估算费用报告
+// rangeSpec: "all"、"today"、"month"、"7d"/"30d"（最近 N 天）或 "2006-01-02..2006-01-31"
+// 返回 JSON 格式：{"totals": {...}, "byProvider": [...], "byModel": [...], "entries": [...]}
+func (a *App) GetUsageReport(rangeSpec string) (string, error) {
+	report, err := a.usageService.GetReport(rangeSpec)
+	if err != nil {
+		return "", err
+	}
+
+	data, err := json.Marshal(report)
+	if err != nil {
+		return "", fmt.Errorf("failed to serialize usage report: %w", err)
+	}
+
+	return string(data), nil
+}
+
 // ===== 提示词服务方法 =====
 
 // FetchPrompts 获取提示词列表
@@ -406,10 +635,11 @@ func (a *App) GetCurrentVersion() string {
 }
 
 // Update 执行程序内更新（下载并替换当前可执行文件）
-// 返回错误信息字符串，如果成功则返回空字符串
+// 返回安装的版本号，已是最新版本时返回空字符串
 func (a *App) Update() (string, error) {
-	if err := a.updateService.Update(); err != nil {
-		return "", fmt.Errorf("更新失败: %w", err)
+	version, err := a.updateService.Update()
+	if err != nil {
+		return "", fmt.Errorf("update failed: %w", err)
 	}
-	return "", nil
-}
\ No newline at end of file
+	return version, nil
+}
@@ -0,0 +1,131 @@
+package apperr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+)
+
+// ==================== 错误代码 ====================
+
+// Code 稳定的错误代码，前端据此做程序化处理和本地化，不应依赖错误信息的文本
+type Code string
+
+const (
+	CodeAuthInvalid        Code = "AUTH_INVALID"        // API 密钥缺失、无效或没有权限
+	CodeQuotaExceeded      Code = "QUOTA_EXCEEDED"      // 速率限制或额度用尽
+	CodeSafetyBlocked      Code = "SAFETY_BLOCKED"      // 被安全策略拦截或模型拒绝
+	CodeUnsupportedFeature Code = "UNSUPPORTED_FEATURE" // 提供商不支持请求的功能
+	CodeNetwork            Code = "NETWORK"             // 无法连接服务或服务暂时不可用
+	CodeTimeout            Code = "TIMEOUT"             // 请求超时
+	CodeCancelled          Code = "CANCELLED"           // 操作被用户取消
+	CodeInvalidInput       Code = "INVALID_INPUT"       // 参数、文件或数据无效
+	CodeUnknown            Code = "UNKNOWN"             // 无法归类的错误
+)
+
+// ==================== 错误类型 ====================
+
+// Error 带错误代码的错误
+// 通过 Wails 的 ErrorFormatter（见 Format）序列化为 JSON 传给前端：
+// {"code": "QUOTA_EXCEEDED", "message": "...", "details": {...}}
+type Error struct {
+	Code    Code                   `json:"code"`              // 错误代码
+	Message string                 `json:"message"`           // 英文错误信息（前端无对应翻译时显示）
+	Details map[string]interface{} `json:"details,omitempty"` // 附加信息，如 HTTP 状态码、拦截原因
+	Err     error                  `json:"-"`                 // 原始错误
+}
+
+// Error 实现 error 接口
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Unwrap 返回原始错误
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// With 添加附加信息，返回错误本身便于链式调用
+func (e *Error) With(key string, value interface{}) *Error {
+	if e.Details == nil {
+		e.Details = make(map[string]interface{})
+	}
+	e.Details[key] = value
+	return e
+}
+
+// New 创建带错误代码的错误，格式化规则与 fmt.Errorf 相同（支持 %w 包装原始错误）
+func New(code Code, format string, args ...interface{}) *Error {
+	err := fmt.Errorf(format, args...)
+	return &Error{Code: code, Message: err.Error(), Err: errors.Unwrap(err)}
+}
+
+// Wrap 为已有错误附加错误代码，错误信息保持不变
+func Wrap(code Code, err error) *Error {
+	return &Error{Code: code, Message: err.Error(), Err: err}
+}
+
+// ==================== 错误归类 ====================
+
+// CodeOf 返回错误的代码（见 From）
+func CodeOf(err error) Code {
+	if err == nil {
+		return ""
+	}
+	return From(err).Code
+}
+
+// From 将任意错误转换为带代码的错误
+// 错误链中已有代码时沿用该代码和附加信息，错误信息使用最外层的完整信息；
+// 否则按取消、超时和网络错误归类，无法归类的为 UNKNOWN
+func From(err error) *Error {
+	if err == nil {
+		return nil
+	}
+
+	var coded *Error
+	if errors.As(err, &coded) {
+		return &Error{Code: coded.Code, Message: err.Error(), Details: coded.Details, Err: err}
+	}
+
+	code := CodeUnknown
+	var netErr net.Error
+	switch {
+	case errors.Is(err, context.Canceled):
+		code = CodeCancelled
+	case errors.Is(err, context.DeadlineExceeded):
+		code = CodeTimeout
+	case errors.As(err, &netErr):
+		if netErr.Timeout() {
+			code = CodeTimeout
+		} else {
+			code = CodeNetwork
+		}
+	}
+	return Wrap(code, err)
+}
+
+// CodeForStatus 根据 HTTP 状态码确定错误代码
+func CodeForStatus(status int) Code {
+	switch status {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return CodeAuthInvalid
+	case http.StatusPaymentRequired, http.StatusTooManyRequests:
+		return CodeQuotaExceeded
+	case http.StatusBadRequest, http.StatusNotFound, http.StatusRequestEntityTooLarge,
+		http.StatusUnsupportedMediaType, http.StatusUnprocessableEntity:
+		return CodeInvalidInput
+	case http.StatusRequestTimeout, http.StatusGatewayTimeout:
+		return CodeTimeout
+	case http.StatusBadGateway, http.StatusServiceUnavailable:
+		return CodeNetwork
+	}
+	return CodeUnknown
+}
+
+// Format 用作 Wails 的 ErrorFormatter，绑定方法返回的错误以 JSON 对象传给前端
+func Format(err error) any {
+	return From(err)
+}
@@ -2,7 +2,9 @@ package provider
 
 import (
 	"context"
+	"fmt"
 	"indraw/core/types"
+	"sync"
 )
 
 // ==================== AI 功能枚举 ====================
@@ -23,6 +25,12 @@ const (
 	FeatureRemoveBackground AIFeature = "removeBackground"
 	// FeatureReferenceImage 参考图像功能
 	FeatureReferenceImage AIFeature = "referenceImage"
+	// FeatureInpaint 蒙版局部重绘功能
+	FeatureInpaint AIFeature = "inpaint"
+	// FeatureExtendImage 图像扩展（外绘）功能
+	FeatureExtendImage AIFeature = "extendImage"
+	// FeatureUpscale 图像放大功能
+	FeatureUpscale AIFeature = "upscale"
 )
 
 // ==================== 提供商能力声明 ====================
@@ -42,6 +50,19 @@ type ProviderCapabilities struct {
 	RemoveBackground bool `json:"removeBackground"`
 	// ReferenceImage 是否支持参考图像
 	ReferenceImage bool `json:"referenceImage"`
+	// Inpaint 是否支持蒙版局部重绘
+	Inpaint bool `json:"inpaint"`
+	// ExtendImage 是否支持图像扩展（外绘）
+	ExtendImage bool `json:"extendImage"`
+	// Upscale 是否支持原生图像放大（不支持时服务层使用本地 Lanczos 重采样）
+	Upscale bool `json:"upscale"`
+
+	// AspectRatios 图像生成支持的宽高比，如 ["1:1", "16:9"]（为空表示不限制）
+	AspectRatios []string `json:"aspectRatios,omitempty"`
+	// ImageSizes 图像生成支持的尺寸等级，如 ["1K", "2K"]（为空表示不限制）
+	ImageSizes []string `json:"imageSizes,omitempty"`
+	// MaxImageCount 单次请求支持的最大候选图像数量（为 0 时使用 MaxImageCount）
+	MaxImageCount int `json:"maxImageCount,omitempty"`
 }
 
 // IsSupported 检查指定功能是否支持
@@ -59,9 +80,62 @@ func (c ProviderCapabilities) IsSupported(feature AIFeature) bool {
 		return c.RemoveBackground
 	case FeatureReferenceImage:
 		return c.ReferenceImage
+	case FeatureInpaint:
+		return c.Inpaint
+	case FeatureExtendImage:
+		return c.ExtendImage
+	case FeatureUpscale:
+		return c.Upscale
+	default:
+		return false
+	}
+}
+
+// SetSupported 设置指定功能是否支持，未知功能返回 false
+func (c *ProviderCapabilities) SetSupported(feature AIFeature, supported bool) bool {
+	switch feature {
+	case FeatureGenerateImage:
+		c.GenerateImage = supported
+	case FeatureEditImage:
+		c.EditImage = supported
+	case FeatureEnhancePrompt:
+		c.EnhancePrompt = supported
+	case FeatureBlendImages:
+		c.BlendImages = supported
+	case FeatureRemoveBackground:
+		c.RemoveBackground = supported
+	case FeatureReferenceImage:
+		c.ReferenceImage = supported
+	case FeatureInpaint:
+		c.Inpaint = supported
+	case FeatureExtendImage:
+		c.ExtendImage = supported
+	case FeatureUpscale:
+		c.Upscale = supported
 	default:
 		return false
 	}
+	return true
+}
+
+// ==================== 结果类型 ====================
+
+// MaxImageCount 单次生成请求允许的最大候选图像数量
+const MaxImageCount = 4
+
+// ImageResult 图像生成结果
+// 一次请求可以返回多张候选图像，供前端以网格形式挑选
+type ImageResult struct {
+	// Images base64 编码的图像数据列表（含 data URI 前缀）
+	Images []string `json:"images"`
+}
+
+// First 返回第一张候选图像
+func (r *ImageResult) First() string {
+	if r == nil || len(r.Images) == 0 {
+		return ""
+	}
+	return r.Images[0]
 }
 
 // ==================== AI 提供商接口 ====================
@@ -77,9 +151,9 @@ type AIProvider interface {
 	//   - ctx: 上下文
 	//   - params: 图像生成参数
 	// 返回：
-	//   - base64 编码的图像数据（含 data URI 前缀）
+	//   - 图像生成结果（包含 params.Count 张候选图像）
 	//   - 错误信息
-	GenerateImage(ctx context.Context, params types.GenerateImageParams) (string, error)
+	GenerateImage(ctx context.Context, params types.GenerateImageParams) (*ImageResult, error)
 
 	// EditImage 编辑图像
 	// 参数：
@@ -123,3 +197,90 @@ type AIProvider interface {
 	// 在提供商不再使用时调用，用于释放连接、清理缓存等
 	Close() error
 }
+
+// ImageUpscaler 原生图像放大接口（可选）
+// 声明 Upscale 能力的提供商需要实现此接口
+type ImageUpscaler interface {
+	// UpscaleImage 放大图像
+	// 返回：
+	//   - base64 编码的图像数据（含 data URI 前缀）
+	//   - 错误信息
+	UpscaleImage(ctx context.Context, params types.UpscaleImageParams) (string, error)
+}
+
+// ModelInfo 提供商可用的模型
+type ModelInfo struct {
+	ID   string `json:"id"`   // 配置时使用的模型标识
+	Name string `json:"name"` // 显示名称
+}
+
+// ModelLister 模型列表接口（可选）
+// 可以查询服务端可用模型的提供商实现此接口，用于设置界面中的模型选择
+type ModelLister interface {
+	// ListModels 列出可用模型
+	ListModels(ctx context.Context) ([]ModelInfo, error)
+}
+
+// ==================== 辅助函数 ====================
+
+// normalizeImageCount 规范化候选图像数量，限制在 [1, MaxImageCount] 范围内
+func normalizeImageCount(count int) int {
+	if count < 1 {
+		return 1
+	}
+	if count > MaxImageCount {
+		return MaxImageCount
+	}
+	return count
+}
+
+// generateConcurrently 并发执行 count 次单图生成请求，并汇总所有图像
+// 用于不支持单次请求返回多张图像的接口
+// 只要有一次请求成功即返回已获得的图像，全部失败时返回第一个错误
+func generateConcurrently(ctx context.Context, count int, generate func(ctx context.Context) ([]string, error)) (*ImageResult, error) {
+	count = normalizeImageCount(count)
+	if count == 1 {
+		images, err := generate(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return &ImageResult{Images: images}, nil
+	}
+
+	results := make([][]string, count)
+	errs := make([]error, count)
+
+	var wg sync.WaitGroup
+	for i := 0; i < count; i++ {
+		wg.Add(1)
+		go func(index int) {
+			defer wg.Done()
+			results[index], errs[index] = generate(ctx)
+		}(i)
+	}
+	wg.Wait()
+
+	var images []string
+	var firstErr error
+	for i := 0; i < count; i++ {
+		if errs[i] != nil {
+			if firstErr == nil {
+				firstErr = errs[i]
+			}
+			continue
+		}
+		images = append(images, results[i]...)
+	}
+
+	if len(images) == 0 {
+		if firstErr == nil {
+			firstErr = fmt.Errorf("no image data returned")
+		}
+		return nil, firstErr
+	}
+
+	if len(images) > count {
+		images = images[:count]
+	}
+	return &ImageResult{Images: images}, nil
+}
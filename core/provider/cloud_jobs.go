@@ -0,0 +1,286 @@
+package provider
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ==================== Cloud 异步任务 ====================
+
+// 异步任务协议：
+//   - 请求带有 "Prefer: respond-async" 头，服务端可以直接返回结果（同步），
+//     也可以返回 202 和 {"jobId": "..."}（异步）
+//   - 客户端优先订阅 SSE /jobs/{id}/events，不可用时轮询 GET /jobs/{id}
+//   - 任务状态：{"status": "queued|running|succeeded|failed|cancelled", "progress": 0.4,
+//     "step": 8, "totalSteps": 20, "message": "...", "previewImage": "data:...", "result": {...}, "error": "..."}
+//   - SSE 事件名为 progress / completed / failed，数据为同样格式的任务状态（completed 事件的数据也可以直接是结果）
+//   - 操作取消时发送 DELETE /jobs/{id}（尽力而为）
+
+const (
+	defaultCloudPollInterval = 2 * time.Second
+	maxCloudPollInterval     = 30 * time.Second
+)
+
+// cloudJob 云服务返回的异步任务
+type cloudJob struct {
+	ID           string
+	StatusURL    string
+	EventsURL    string
+	PollInterval time.Duration
+}
+
+// parseCloudJob 判断响应是否为异步任务
+// 响应包含 jobId 且没有结果字段时视为异步任务，否则视为同步结果
+func (p *CloudProvider) parseCloudJob(statusCode int, response map[string]interface{}) (*cloudJob, bool) {
+	jobID, _ := response["jobId"].(string)
+	if jobID == "" {
+		return nil, false
+	}
+	if statusCode != http.StatusAccepted && hasCloudResult(response) {
+		return nil, false
+	}
+
+	job := &cloudJob{
+		ID:           jobID,
+		StatusURL:    p.resolveCloudURL(stringField(response, "statusUrl"), "jobs/"+url.PathEscape(jobID)),
+		EventsURL:    p.resolveCloudURL(stringField(response, "eventsUrl"), "jobs/"+url.PathEscape(jobID)+"/events"),
+		PollInterval: defaultCloudPollInterval,
+	}
+	if ms, ok := response["pollIntervalMs"].(float64); ok && ms > 0 {
+		job.PollInterval = min(time.Duration(ms)*time.Millisecond, maxCloudPollInterval)
+	}
+	return job, true
+}
+
+// waitForCloudJob 等待异步任务完成，返回任务结果
+// 优先使用 SSE 事件流，事件流不可用或中断时改为轮询
+func (p *CloudProvider) waitForCloudJob(ctx context.Context, job *cloudJob) (map[string]interface{}, error) {
+	fmt.Printf("[CloudProvider] Waiting for async job %s\n", job.ID)
+	tracker := newProgressTracker(ctx)
+
+	result, done, err := p.streamCloudJob(ctx, job, tracker)
+	if !done && err == nil && ctx.Err() == nil {
+		result, err = p.pollCloudJob(ctx, job, tracker)
+	}
+	if err != nil && ctx.Err() != nil {
+		p.cancelCloudJob(job)
+		return nil, ctx.Err()
+	}
+	return result, err
+}
+
+// streamCloudJob 订阅任务事件流
+// 返回 done=false 且 err=nil 表示事件流不可用或在任务完成前中断，调用方应改为轮询
+func (p *CloudProvider) streamCloudJob(ctx context.Context, job *cloudJob, tracker *progressTracker) (map[string]interface{}, bool, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", job.EventsURL, nil)
+	if err != nil {
+		return nil, false, nil
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	p.authorize(req)
+
+	resp, err := p.streamClient.Do(req)
+	if err != nil {
+		return nil, false, nil
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK || !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+		return nil, false, nil
+	}
+
+	reader := bufio.NewReader(resp.Body)
+	eventName := ""
+	var data strings.Builder
+	for {
+		line, err := reader.ReadString('\n')
+		if len(line) > 0 {
+			tracker.addBytes(len(line))
+		}
+		line = strings.TrimRight(line, "\r\n")
+
+		switch {
+		case line == "" && data.Len() > 0:
+			// 空行表示一个事件结束
+			var status map[string]interface{}
+			if jsonErr := json.Unmarshal([]byte(data.String()), &status); jsonErr == nil {
+				if result, done, statusErr := handleCloudJobStatus(eventName, status, tracker); done || statusErr != nil {
+					return result, true, statusErr
+				}
+			}
+			eventName = ""
+			data.Reset()
+		case strings.HasPrefix(line, "event:"):
+			eventName = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			if data.Len() > 0 {
+				data.WriteByte('\n')
+			}
+			data.WriteString(strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+		}
+
+		if err != nil {
+			if err != io.EOF && ctx.Err() == nil {
+				fmt.Printf("[CloudProvider] Job event stream interrupted, falling back to polling: %v\n", err)
+			}
+			return nil, false, nil
+		}
+	}
+}
+
+// pollCloudJob 轮询任务状态直到任务结束
+func (p *CloudProvider) pollCloudJob(ctx context.Context, job *cloudJob, tracker *progressTracker) (map[string]interface{}, error) {
+	interval := job.PollInterval
+	for {
+		req, err := http.NewRequestWithContext(ctx, "GET", job.StatusURL, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create request: %w", err)
+		}
+		p.authorize(req)
+
+		resp, err := p.httpClient.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("failed to poll cloud job %s: %w", job.ID, err)
+		}
+		bodyBytes, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read job status: %w", err)
+		}
+		if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusAccepted {
+			return nil, fmt.Errorf("cloud job %s status returned %d: %s", job.ID, resp.StatusCode, truncateString(string(bodyBytes), 500))
+		}
+		tracker.addBytes(len(bodyBytes))
+
+		var status map[string]interface{}
+		if err := json.Unmarshal(bodyBytes, &status); err != nil {
+			return nil, fmt.Errorf("failed to parse job status: %w", err)
+		}
+		if result, done, err := handleCloudJobStatus("", status, tracker); done || err != nil {
+			return result, err
+		}
+
+		// 服务端可以通过 Retry-After 调整轮询间隔
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+			interval = min(time.Duration(seconds)*time.Second, maxCloudPollInterval)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
+
+// handleCloudJobStatus 处理一次任务状态，转发进度并判断任务是否结束
+// eventName 为 SSE 事件名（轮询时为空），优先于状态中的 status 字段
+func handleCloudJobStatus(eventName string, status map[string]interface{}, tracker *progressTracker) (map[string]interface{}, bool, error) {
+	state := strings.ToLower(stringField(status, "status"))
+	switch strings.ToLower(eventName) {
+	case "completed", "succeeded", "done", "result":
+		state = "succeeded"
+	case "failed", "error":
+		state = "failed"
+	case "cancelled", "canceled":
+		state = "cancelled"
+	}
+	// 完成事件的数据可以直接是结果（没有 status 字段）
+	if state == "" && hasCloudResult(status) {
+		state = "succeeded"
+	}
+
+	switch state {
+	case "succeeded", "completed", "done":
+		if result, ok := status["result"].(map[string]interface{}); ok {
+			return result, true, nil
+		}
+		return status, true, nil
+	case "failed", "error":
+		return nil, true, fmt.Errorf("cloud job failed: %s", cloudJobError(status))
+	case "cancelled", "canceled":
+		return nil, true, fmt.Errorf("cloud job was cancelled by the server")
+	}
+
+	// 排队或执行中：转发进度
+	var update ProgressUpdate
+	if progress, ok := status["progress"].(float64); ok {
+		// 兼容百分比形式
+		if progress > 1 {
+			progress /= 100
+		}
+		update.Progress = progress
+	}
+	if step, ok := status["step"].(float64); ok {
+		update.Step = int(step)
+	}
+	if totalSteps, ok := status["totalSteps"].(float64); ok {
+		update.TotalSteps = int(totalSteps)
+	}
+	update.Text = stringField(status, "message")
+	update.PartialImage = stringField(status, "previewImage")
+	if index, ok := status["previewIndex"].(float64); ok {
+		update.PartialIndex = int(index)
+	}
+	if update != (ProgressUpdate{}) {
+		tracker.report(update)
+	}
+	return nil, false, nil
+}
+
+// cancelCloudJob 取消服务端任务（尽力而为）
+func (p *CloudProvider) cancelCloudJob(job *cloudJob) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "DELETE", job.StatusURL, nil)
+	if err != nil {
+		return
+	}
+	p.authorize(req)
+	if resp, err := p.httpClient.Do(req); err == nil {
+		resp.Body.Close()
+	}
+}
+
+// ==================== 辅助函数 ====================
+
+// hasCloudResult 判断响应中是否包含结果字段
+func hasCloudResult(response map[string]interface{}) bool {
+	for _, key := range []string{"images", "image", "imageData", "text", "prompt", "result"} {
+		if _, ok := response[key]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// cloudJobError 提取任务失败原因
+func cloudJobError(status map[string]interface{}) string {
+	switch e := status["error"].(type) {
+	case string:
+		return e
+	case map[string]interface{}:
+		if message := stringField(e, "message"); message != "" {
+			return message
+		}
+	}
+	if message := stringField(status, "message"); message != "" {
+		return message
+	}
+	return "unknown error"
+}
+
+// stringField 读取 JSON 对象中的字符串字段
+func stringField(data map[string]interface{}, key string) string {
+	value, _ := data[key].(string)
+	return value
+}
@@ -0,0 +1,176 @@
+package provider
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"indraw/core/types"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/textproto"
+	"strings"
+)
+
+// ==================== Cloud 二进制传输 ====================
+
+// 二进制传输协议（通过 /capabilities 协商）：
+//   - 服务端声明 "multipart": true 时，图像总大小超过阈值的请求以 multipart/form-data 发送：
+//     "metadata" 部分为去掉图像字段后的 JSON 参数，每张图像作为一个文件部分，
+//     部分名称为对应的 JSON 字段名（如 imageData、mask、images，数组按顺序重复同一名称）
+//   - 服务端声明 "binaryResponses": true 时，请求的 Accept 头包含 image/*，
+//     服务端可以直接返回图像二进制数据（Content-Type: image/*）代替 JSON，模型名称可以通过 X-Model 头返回
+
+// cloudMultipartThreshold 使用 multipart 请求的图像数据大小阈值（base64 字节数），较小的请求仍使用 JSON
+const cloudMultipartThreshold = 512 << 10
+
+// cloudTransport 与服务端协商的传输方式
+type cloudTransport struct {
+	Multipart       bool `json:"multipart"`       // 服务端接受 multipart/form-data 请求
+	BinaryResponses bool `json:"binaryResponses"` // 服务端可以返回 image/* 二进制响应
+}
+
+// cloudFile multipart 请求中的一个图像部分
+type cloudFile struct {
+	field   string
+	dataURL string
+}
+
+// splitCloudImages 将请求参数拆分为不含图像的元数据和图像列表
+// 仅处理包含图像的请求类型，其他请求返回原参数和空列表；调用方的参数（包括图像切片）不会被修改
+func splitCloudImages(requestData interface{}) (interface{}, []cloudFile) {
+	var files []cloudFile
+	take := func(field string, value *string) {
+		if *value != "" {
+			files = append(files, cloudFile{field: field, dataURL: *value})
+			*value = ""
+		}
+	}
+
+	switch params := requestData.(type) {
+	case types.GenerateImageParams:
+		take("referenceImage", &params.ReferenceImage)
+		take("sketchImage", &params.SketchImage)
+		return params, files
+	case types.EditImageParams:
+		take("imageData", &params.ImageData)
+		take("mask", &params.Mask)
+		return params, files
+	case types.UpscaleImageParams:
+		take("imageData", &params.ImageData)
+		return params, files
+	case types.MultiImageEditParams:
+		// 复制切片，避免清空调用方的图像
+		params.Images = append([]string(nil), params.Images...)
+		for i := range params.Images {
+			take("images", &params.Images[i])
+		}
+		params.Images = nil
+		return params, files
+	default:
+		return requestData, nil
+	}
+}
+
+// cloudFilesSize 返回图像数据的总大小
+func cloudFilesSize(files []cloudFile) int {
+	size := 0
+	for _, file := range files {
+		size += len(file.dataURL)
+	}
+	return size
+}
+
+// newCloudMultipartBody 创建 multipart 请求体
+// 图像边解码边写入，不会在内存中再保存一份完整的请求体；
+// 返回的函数每次调用都会生成新的请求体，用于重试时重放
+func newCloudMultipartBody(metadata interface{}, files []cloudFile) (func() (io.ReadCloser, error), string, error) {
+	metadataJSON, err := json.Marshal(metadata)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	// 固定分隔符，保证重放的请求体与 Content-Type 一致
+	boundary := multipart.NewWriter(io.Discard).Boundary()
+
+	newBody := func() (io.ReadCloser, error) {
+		reader, writer := io.Pipe()
+		go func() {
+			writer.CloseWithError(writeCloudMultipart(writer, boundary, metadataJSON, files))
+		}()
+		return reader, nil
+	}
+
+	return newBody, "multipart/form-data; boundary=" + boundary, nil
+}
+
+// writeCloudMultipart 写入 multipart 请求体
+func writeCloudMultipart(w io.Writer, boundary string, metadataJSON []byte, files []cloudFile) error {
+	mw := multipart.NewWriter(w)
+	if err := mw.SetBoundary(boundary); err != nil {
+		return err
+	}
+
+	header := make(textproto.MIMEHeader)
+	header.Set("Content-Disposition", `form-data; name="metadata"`)
+	header.Set("Content-Type", "application/json")
+	part, err := mw.CreatePart(header)
+	if err != nil {
+		return err
+	}
+	if _, err := part.Write(metadataJSON); err != nil {
+		return err
+	}
+
+	for i, file := range files {
+		mimeType, encoded := splitDataURL(file.dataURL)
+		ext := imageExtension(mimeType)
+
+		header := make(textproto.MIMEHeader)
+		header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"; filename="%s-%d%s"`, file.field, file.field, i, ext))
+		header.Set("Content-Type", mimeType)
+		part, err := mw.CreatePart(header)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(part, base64.NewDecoder(base64.StdEncoding, strings.NewReader(encoded))); err != nil {
+			return fmt.Errorf("failed to decode image %s: %w", file.field, err)
+		}
+	}
+
+	return mw.Close()
+}
+
+// splitDataURL 拆分 data URL，返回 MIME 类型和 base64 数据（纯 base64 时类型按 PNG 处理）
+func splitDataURL(dataURL string) (string, string) {
+	if !strings.HasPrefix(dataURL, "data:") {
+		return "image/png", dataURL
+	}
+	header, encoded, ok := strings.Cut(dataURL, ",")
+	if !ok {
+		return "image/png", dataURL
+	}
+	mimeType, _, _ := strings.Cut(strings.TrimPrefix(header, "data:"), ";")
+	if mimeType == "" {
+		mimeType = "image/png"
+	}
+	return mimeType, encoded
+}
+
+// imageExtension 返回图像 MIME 类型对应的文件扩展名
+func imageExtension(mimeType string) string {
+	switch mimeType {
+	case "image/png":
+		return ".png"
+	case "image/jpeg":
+		return ".jpg"
+	case "image/webp":
+		return ".webp"
+	case "image/gif":
+		return ".gif"
+	}
+	if exts, _ := mime.ExtensionsByType(mimeType); len(exts) > 0 {
+		return exts[0]
+	}
+	return ".bin"
+}
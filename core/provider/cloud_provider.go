@@ -3,18 +3,23 @@ package provider
 import (
 	"bytes"
 	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"indraw/core/apperr"
 	"indraw/core/types"
 	"io"
 	"net/http"
+	"net/url"
 	"strings"
+	"sync"
 	"time"
 )
 
 // ==================== Cloud 能力声明 ====================
 
-// cloudCapabilities Cloud 提供商的功能支持矩阵
+// cloudCapabilities Cloud 提供商的默认功能支持矩阵（服务端未实现 /capabilities 接口时使用）
 var cloudCapabilities = ProviderCapabilities{
 	GenerateImage:    true,
 	EditImage:        true,
@@ -22,6 +27,9 @@ var cloudCapabilities = ProviderCapabilities{
 	BlendImages:      true,
 	RemoveBackground: true,
 	ReferenceImage:   true,
+	Inpaint:          true,
+	ExtendImage:      true,
+	Upscale:          true,
 }
 
 // ==================== CloudProvider 实现 ====================
@@ -29,29 +37,48 @@ var cloudCapabilities = ProviderCapabilities{
 // CloudProvider 云 AI 提供商
 // 通过 HTTP 调用配置的云服务端点，直接转发参数
 type CloudProvider struct {
-	ctx         context.Context
-	endpointURL string
-	httpClient  *http.Client
-	settings    types.AISettings
+	ctx          context.Context
+	endpointURL  string
+	httpClient   *http.Client
+	streamClient *http.Client // 用于异步任务事件流（长连接，不设置整体超时）
+	settings     types.AISettings
+	imageFetcher *remoteImageFetcher // 下载服务端返回的远程图像链接
+
+	discoverMu sync.Mutex // 串行化能力发现，避免并发请求重复查询
+	capsMu     sync.RWMutex
+	caps       ProviderCapabilities // 缓存的服务端能力声明
+	transport  cloudTransport       // 与服务端协商的传输方式
+	discovered bool                 // 已获得服务端能力声明（或已确认服务端未实现该接口）
+	retryAt    time.Time            // 能力发现失败后，在此时间之前不再重新查询
 }
 
 // NewCloudProvider 创建云提供商实例
+// 服务端能力声明在后台查询（见 ensureCapabilities），首次使用时若查询尚未完成则等待其结果
 func NewCloudProvider(ctx context.Context, settings types.AISettings) (*CloudProvider, error) {
 	if settings.CloudEndpointURL == "" {
 		return nil, fmt.Errorf("cloud endpoint URL not configured")
 	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
 
-	// 创建 HTTP 客户端，设置合理的超时时间
-	httpClient := &http.Client{
-		Timeout: 5 * time.Minute, // 图像生成可能需要较长时间
+	// 创建带重试的 HTTP 客户端，设置合理的超时时间（图像生成可能需要较长时间）
+	httpClient := newHTTPClient(settings, 5*time.Minute)
+
+	p := &CloudProvider{
+		ctx:          ctx,
+		endpointURL:  settings.CloudEndpointURL,
+		httpClient:   httpClient,
+		streamClient: newHTTPClient(settings, 0),
+		settings:     settings,
+		imageFetcher: newRemoteImageFetcher(settings, settings.CloudEndpointURL),
+		caps:         cloudCapabilities,
 	}
+	p.imageFetcher.authorize = p.authorize
+
+	go p.ensureCapabilities(ctx)
 
-	return &CloudProvider{
-		ctx:         ctx,
-		endpointURL: settings.CloudEndpointURL,
-		httpClient:  httpClient,
-		settings:    settings,
-	}, nil
+	return p, nil
 }
 
 // Name 返回提供商名称
@@ -60,11 +87,17 @@ func (p *CloudProvider) Name() string {
 }
 
 // GetCapabilities 返回提供商支持的功能
+// 返回服务端 /capabilities 接口声明的能力，服务端未实现该接口（或暂时无法查询）时返回默认的全功能矩阵
 func (p *CloudProvider) GetCapabilities() ProviderCapabilities {
-	return cloudCapabilities
+	p.ensureCapabilities(p.ctx)
+	p.capsMu.RLock()
+	defer p.capsMu.RUnlock()
+	return p.caps
 }
 
 // CheckAvailability 检测服务可用性
+// 调用轻量的 /capabilities 接口，同时刷新缓存的能力声明；
+// 服务端未实现该接口（404）时视为旧版服务，仅确认服务可以访问
 func (p *CloudProvider) CheckAvailability(ctx context.Context) (bool, error) {
 	if p.endpointURL == "" {
 		return false, fmt.Errorf("cloud endpoint URL not configured")
@@ -74,73 +107,88 @@ func (p *CloudProvider) CheckAvailability(ctx context.Context) (bool, error) {
 	testCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
-	// 尝试调用一个简单的 API 来检测服务是否可用
-	// 使用 enhancePrompt 端点进行测试（通常是最轻量的）
-	testRequest := map[string]string{
-		"prompt": "test",
+	if _, err := p.refreshCapabilities(testCtx); err != nil && !errors.Is(err, errCloudCapabilitiesNotFound) {
+		return false, err
 	}
+	return true, nil
+}
 
-	requestBody, err := json.Marshal(testRequest)
-	if err != nil {
-		return false, fmt.Errorf("failed to marshal test request: %w", err)
+// Close 清理资源
+func (p *CloudProvider) Close() error {
+	if p.httpClient != nil {
+		p.httpClient.CloseIdleConnections()
 	}
-
-	// 构建测试 URL（尝试 enhancePrompt 端点）
-	baseURL := strings.TrimSuffix(p.endpointURL, "/")
-	var url string
-	if strings.Contains(baseURL, "/enhancePrompt") {
-		url = baseURL
-	} else {
-		url = fmt.Sprintf("%s/enhancePrompt", baseURL)
+	if p.streamClient != nil {
+		p.streamClient.CloseIdleConnections()
 	}
+	return nil
+}
 
-	// 创建 HTTP 请求
-	req, err := http.NewRequestWithContext(testCtx, "POST", url, bytes.NewBuffer(requestBody))
-	if err != nil {
-		return false, fmt.Errorf("failed to create request: %w", err)
+// ==================== API 方法实现 ====================
+
+// GenerateImage 生成图像
+// 候选图像数量通过请求中的 count 字段透传给云服务
+func (p *CloudProvider) GenerateImage(ctx context.Context, params types.GenerateImageParams) (*ImageResult, error) {
+	caps := p.GetCapabilities()
+	if err := checkCloudOption("aspect ratio", params.AspectRatio, caps.AspectRatios); err != nil {
+		return nil, err
+	}
+	if err := checkCloudOption("image size", params.ImageSize, caps.ImageSizes); err != nil {
+		return nil, err
 	}
 
-	// 设置请求头
-	req.Header.Set("Content-Type", "application/json")
+	params.Count = normalizeImageCount(params.Count)
+	if caps.MaxImageCount > 0 && params.Count > caps.MaxImageCount {
+		params.Count = caps.MaxImageCount
+	}
 
-	// 如果配置了 Token，添加到 Authorization 头
-	if p.settings.CloudToken != "" {
-		req.Header.Set("Authorization", "Bearer "+p.settings.CloudToken)
+	var err error
+	if params.ReferenceImage != "" {
+		if params.ReferenceImage, err = normalizeInputDataURL(params.ReferenceImage, p.inputPolicy()); err != nil {
+			return nil, fmt.Errorf("invalid reference image: %w", err)
+		}
+	}
+	if params.SketchImage != "" {
+		if params.SketchImage, err = normalizeInputDataURL(params.SketchImage, p.inputPolicy()); err != nil {
+			return nil, fmt.Errorf("invalid sketch image: %w", err)
+		}
 	}
 
-	// 发送请求
-	resp, err := p.httpClient.Do(req)
+	response, err := p.doCloudRequest(ctx, "generateImage", params)
 	if err != nil {
-		return false, fmt.Errorf("cloud service unavailable: %w", err)
+		return nil, err
 	}
-	defer resp.Body.Close()
 
-	// 检查 HTTP 状态码
-	if resp.StatusCode != http.StatusOK {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		return false, fmt.Errorf("cloud service returned status %d: %s", resp.StatusCode, string(bodyBytes))
+	images, err := extractCloudImages(response)
+	p.reportCloudUsage(ctx, response, len(images))
+	if err != nil {
+		return nil, err
 	}
 
-	return true, nil
-}
-
-// Close 清理资源
-func (p *CloudProvider) Close() error {
-	if p.httpClient != nil {
-		p.httpClient.CloseIdleConnections()
+	// 服务端返回图像链接时在本地下载
+	for i, image := range images {
+		images[i] = p.cloudImageRef(image)
 	}
-	return nil
-}
-
-// ==================== API 方法实现 ====================
-
-// GenerateImage 生成图像
-func (p *CloudProvider) GenerateImage(ctx context.Context, params types.GenerateImageParams) (string, error) {
-	return p.callCloudAPI(ctx, "generateImage", params)
+	if images, err = p.imageFetcher.resolveAll(ctx, images); err != nil {
+		return nil, err
+	}
+	return &ImageResult{Images: images}, nil
 }
 
 // EditImage 编辑图像
+// 原图被缩小时蒙版同步缩放，保证两者尺寸一致
 func (p *CloudProvider) EditImage(ctx context.Context, params types.EditImageParams) (string, error) {
+	image, err := normalizeInputImage(params.ImageData, p.inputPolicy())
+	if err != nil {
+		return "", err
+	}
+	if params.Mask != "" {
+		if params.Mask, err = scaleMaskDataURL(params.Mask, image.Width, image.Height); err != nil {
+			return "", err
+		}
+	}
+	params.ImageData = image.DataURL()
+
 	return p.callCloudAPI(ctx, "editImage", params)
 }
 
@@ -149,9 +197,32 @@ func (p *CloudProvider) EditMultiImages(ctx context.Context, params types.MultiI
 	if len(params.Images) < 2 {
 		return "", fmt.Errorf("at least 2 images are required")
 	}
+
+	images := make([]string, len(params.Images))
+	for i, img := range params.Images {
+		dataURL, err := normalizeInputDataURL(img, p.inputPolicy())
+		if err != nil {
+			return "", fmt.Errorf("invalid image %d: %w", i+1, err)
+		}
+		images[i] = dataURL
+	}
+	params.Images = images
+
 	return p.callCloudAPI(ctx, "editMultiImages", params)
 }
 
+// UpscaleImage 放大图像
+func (p *CloudProvider) UpscaleImage(ctx context.Context, params types.UpscaleImageParams) (string, error) {
+	// 放大保留原始分辨率，只做格式转换和元数据去除
+	dataURL, err := normalizeInputDataURL(params.ImageData, p.inputPolicy().withoutDownscale())
+	if err != nil {
+		return "", err
+	}
+	params.ImageData = dataURL
+
+	return p.callCloudAPI(ctx, "upscaleImage", params)
+}
+
 // EnhancePrompt 增强提示词
 func (p *CloudProvider) EnhancePrompt(ctx context.Context, prompt string) (string, error) {
 	// 将 prompt 包装成简单的 JSON 结构
@@ -161,70 +232,164 @@ func (p *CloudProvider) EnhancePrompt(ctx context.Context, prompt string) (strin
 	return p.callCloudAPI(ctx, "enhancePrompt", request)
 }
 
-// ==================== 辅助函数 ====================
+// inputPolicy 返回应用用户配置后的输入图像要求
+func (p *CloudProvider) inputPolicy() imageInputPolicy {
+	return cloudInputPolicy.withSettings(p.settings)
+}
 
-// callCloudAPI 调用云服务 API，直接转发参数
-func (p *CloudProvider) callCloudAPI(ctx context.Context, endpoint string, requestData interface{}) (string, error) {
-	// 构建完整的 URL
-	baseURL := strings.TrimSuffix(p.endpointURL, "/")
-	var url string
-	if strings.Contains(baseURL, "/generateImage") || strings.Contains(baseURL, "/editImage") ||
-		strings.Contains(baseURL, "/enhancePrompt") || strings.Contains(baseURL, "/editMultiImages") {
-		// 端点URL已经包含操作路径，直接使用
-		url = baseURL
-	} else {
-		// 附加操作路径
-		url = fmt.Sprintf("%s/%s", baseURL, endpoint)
-	}
-
-	// 序列化请求数据
-	requestBody, err := json.Marshal(requestData)
-	if err != nil {
-		return "", fmt.Errorf("failed to marshal request: %w", err)
+// ==================== 能力发现 ====================
+
+// errCloudCapabilitiesNotFound 服务端未实现 /capabilities 接口
+var errCloudCapabilitiesNotFound = errors.New("cloud service does not provide /capabilities")
+
+// cloudCapabilitiesResponse /capabilities 接口的响应
+// 功能可以通过 features 声明（功能名数组，或功能名到布尔值的对象），
+// 也可以直接使用与 ProviderCapabilities 相同的布尔字段
+type cloudCapabilitiesResponse struct {
+	ProviderCapabilities
+	cloudTransport
+	Features json.RawMessage `json:"features,omitempty"`
+}
+
+// cloudDiscoveryRetryInterval 能力发现失败后重新查询的间隔
+// 服务端不可用时避免每次使用都等待查询超时
+const cloudDiscoveryRetryInterval = 30 * time.Second
+
+// ensureCapabilities 尚未获得能力声明时查询服务端（创建时在后台调用，使用时确认已完成）
+// 服务端未实现 /capabilities 接口（404/405）时视为旧版服务，使用默认的全功能矩阵且不再查询；
+// 其他失败（网络错误、5xx 等）使用默认矩阵，间隔 cloudDiscoveryRetryInterval 后再重新查询
+func (p *CloudProvider) ensureCapabilities(ctx context.Context) {
+	if p.capabilitiesSettled() {
+		return
 	}
 
-	// 创建 HTTP 请求
-	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(requestBody))
-	if err != nil {
-		return "", fmt.Errorf("failed to create request: %w", err)
+	p.discoverMu.Lock()
+	defer p.discoverMu.Unlock()
+
+	// 双重检查，等待期间其他请求可能已完成查询
+	if p.capabilitiesSettled() {
+		return
 	}
 
-	// 设置请求头
-	req.Header.Set("Content-Type", "application/json")
+	discoverCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	if _, err := p.refreshCapabilities(discoverCtx); err != nil {
+		if errors.Is(err, errCloudCapabilitiesNotFound) {
+			fmt.Printf("[CloudProvider] Capability discovery not supported, assuming all features\n")
+		} else {
+			fmt.Printf("[CloudProvider] Capability discovery failed, will retry in %v: %v\n", cloudDiscoveryRetryInterval, err)
+			p.capsMu.Lock()
+			p.retryAt = time.Now().Add(cloudDiscoveryRetryInterval)
+			p.capsMu.Unlock()
+		}
+	}
+}
 
-	// 如果配置了 Token，添加到 Authorization 头
-	if p.settings.CloudToken != "" {
-		req.Header.Set("Authorization", "Bearer "+p.settings.CloudToken)
+// capabilitiesSettled 判断是否无需查询能力声明：已获得声明，或上次查询失败后仍在重试间隔内
+func (p *CloudProvider) capabilitiesSettled() bool {
+	p.capsMu.RLock()
+	defer p.capsMu.RUnlock()
+	return p.discovered || time.Now().Before(p.retryAt)
+}
+
+// refreshCapabilities 查询服务端能力声明并更新缓存
+// 服务端未实现该接口时缓存默认的全功能矩阵并返回 errCloudCapabilitiesNotFound；其他失败时缓存保持不变
+func (p *CloudProvider) refreshCapabilities(ctx context.Context) (ProviderCapabilities, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", p.serviceBaseURL()+"/capabilities", nil)
+	if err != nil {
+		return ProviderCapabilities{}, fmt.Errorf("failed to create request: %w", err)
 	}
+	req.Header.Set("Accept", "application/json")
+	p.authorize(req)
 
-	// 发送请求
 	resp, err := p.httpClient.Do(req)
 	if err != nil {
-		return "", fmt.Errorf("failed to send request: %w", err)
+		return ProviderCapabilities{}, fmt.Errorf("cloud service unavailable: %w", err)
 	}
 	defer resp.Body.Close()
 
-	// 检查 HTTP 状态码
+	bodyBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return ProviderCapabilities{}, fmt.Errorf("failed to read response: %w", err)
+	}
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusMethodNotAllowed {
+		p.capsMu.Lock()
+		p.caps = cloudCapabilities
+		p.transport = cloudTransport{}
+		p.discovered = true
+		p.capsMu.Unlock()
+		return ProviderCapabilities{}, errCloudCapabilitiesNotFound
+	}
 	if resp.StatusCode != http.StatusOK {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		return "", fmt.Errorf("cloud API returned status %d: %s", resp.StatusCode, string(bodyBytes))
+		return ProviderCapabilities{}, fmt.Errorf("cloud service returned status %d: %s", resp.StatusCode, truncateString(string(bodyBytes), 500))
 	}
 
-	// 读取响应
-	bodyBytes, err := io.ReadAll(resp.Body)
+	caps, transport, err := parseCloudCapabilities(bodyBytes)
 	if err != nil {
-		return "", fmt.Errorf("failed to read response: %w", err)
+		return ProviderCapabilities{}, err
 	}
 
-	// 解析响应
-	var response map[string]interface{}
-	if err := json.Unmarshal(bodyBytes, &response); err != nil {
-		return "", fmt.Errorf("failed to parse response: %w", err)
+	p.capsMu.Lock()
+	p.caps = caps
+	p.transport = transport
+	p.discovered = true
+	p.capsMu.Unlock()
+	return caps, nil
+}
+
+// parseCloudCapabilities 解析能力声明和传输方式
+func parseCloudCapabilities(data []byte) (ProviderCapabilities, cloudTransport, error) {
+	var response cloudCapabilitiesResponse
+	if err := json.Unmarshal(data, &response); err != nil {
+		return ProviderCapabilities{}, cloudTransport{}, fmt.Errorf("failed to parse capabilities: %w", err)
+	}
+
+	caps := response.ProviderCapabilities
+	if len(response.Features) > 0 {
+		var list []string
+		var flags map[string]bool
+		switch {
+		case json.Unmarshal(response.Features, &list) == nil:
+			for _, feature := range list {
+				caps.SetSupported(AIFeature(feature), true)
+			}
+		case json.Unmarshal(response.Features, &flags) == nil:
+			for feature, supported := range flags {
+				caps.SetSupported(AIFeature(feature), supported)
+			}
+		default:
+			return ProviderCapabilities{}, cloudTransport{}, fmt.Errorf("invalid capabilities: 'features' must be an array or object")
+		}
+	}
+	return caps, response.cloudTransport, nil
+}
+
+// checkCloudOption 检查参数是否在服务端声明的可选值中（未声明时不限制）
+func checkCloudOption(name, value string, supported []string) error {
+	if value == "" || len(supported) == 0 {
+		return nil
+	}
+	for _, option := range supported {
+		if strings.EqualFold(option, value) {
+			return nil
+		}
+	}
+	return apperr.New(apperr.CodeUnsupportedFeature, "cloud service does not support %s %s (supported: %s)", name, value, strings.Join(supported, ", "))
+}
+
+// ==================== 辅助函数 ====================
+
+// callCloudAPI 调用云服务 API，直接转发参数
+func (p *CloudProvider) callCloudAPI(ctx context.Context, endpoint string, requestData interface{}) (string, error) {
+	response, err := p.doCloudRequest(ctx, endpoint, requestData)
+	if err != nil {
+		return "", err
 	}
 
 	// 根据端点类型提取结果
 	switch endpoint {
 	case "enhancePrompt":
+		p.reportCloudUsage(ctx, response, 0)
 		// 增强提示词返回文本
 		if text, ok := response["text"].(string); ok {
 			return text, nil
@@ -235,12 +400,241 @@ func (p *CloudProvider) callCloudAPI(ctx context.Context, endpoint string, reque
 		return "", fmt.Errorf("invalid response format: expected 'text' or 'prompt' field")
 	default:
 		// 图像操作返回图像数据（data URI 格式）
-		if imageData, ok := response["image"].(string); ok {
-			return imageData, nil
+		images, err := extractCloudImages(response)
+		p.reportCloudUsage(ctx, response, len(images))
+		if err != nil {
+			return "", err
+		}
+		// 服务端返回图像链接时在本地下载
+		return p.imageFetcher.resolve(ctx, p.cloudImageRef(images[0]))
+	}
+}
+
+// reportCloudUsage 上报云服务响应中的用量
+// 优先使用响应中的 'usage' 对象，未返回图像数量时按实际返回的图像计数
+func (p *CloudProvider) reportCloudUsage(ctx context.Context, response map[string]interface{}, images int) {
+	var usage Usage
+	if data, ok := response["usage"].(map[string]interface{}); ok {
+		usage = usageFromMap(data)
+	}
+	if usage.Model == "" {
+		usage.Model, _ = response["model"].(string)
+	}
+	if usage.Images == 0 {
+		usage.Images = images
+	}
+	usage.Provider = p.Name()
+	reportUsage(ctx, usage)
+}
+
+// extractCloudImages 从云服务响应中提取图像数据
+// 支持 'images' 数组（多张候选图像）以及 'image' / 'imageData' 单图字段
+func extractCloudImages(response map[string]interface{}) ([]string, error) {
+	if list, ok := response["images"].([]interface{}); ok {
+		var images []string
+		for _, item := range list {
+			if imageData, ok := item.(string); ok && imageData != "" {
+				images = append(images, imageData)
+			}
+		}
+		if len(images) > 0 {
+			return images, nil
+		}
+	}
+	if imageData, ok := response["image"].(string); ok {
+		return []string{imageData}, nil
+	}
+	if imageData, ok := response["imageData"].(string); ok {
+		return []string{imageData}, nil
+	}
+	return nil, fmt.Errorf("invalid response format: expected 'images', 'image' or 'imageData' field")
+}
+
+// cloudOperations 云服务的操作路径
+var cloudOperations = []string{"generateImage", "editImage", "enhancePrompt", "editMultiImages", "upscaleImage"}
+
+// operationURL 构建操作的完整 URL
+// 端点 URL 已经包含操作路径时直接使用，否则附加操作路径
+func (p *CloudProvider) operationURL(endpoint string) string {
+	baseURL := strings.TrimSuffix(p.endpointURL, "/")
+	if cloudOperationIndex(baseURL) >= 0 {
+		return baseURL
+	}
+	return fmt.Sprintf("%s/%s", baseURL, endpoint)
+}
+
+// serviceBaseURL 返回云服务的根 URL（去掉端点 URL 中的操作路径）
+func (p *CloudProvider) serviceBaseURL() string {
+	baseURL := strings.TrimSuffix(p.endpointURL, "/")
+	if index := cloudOperationIndex(baseURL); index >= 0 {
+		return baseURL[:index]
+	}
+	return baseURL
+}
+
+// cloudOperationIndex 返回端点 URL 路径中第一个操作路径段（如 "/generateImage"）的位置，不包含时返回 -1
+// 只匹配完整的路径段，主机名或 "/generateImages" 之类的路径段不会被误判为操作路径
+func cloudOperationIndex(baseURL string) int {
+	pathStart := 0
+	if i := strings.Index(baseURL, "://"); i >= 0 {
+		j := strings.IndexAny(baseURL[i+3:], "/?#")
+		if j < 0 {
+			return -1
+		}
+		pathStart = i + 3 + j
+	}
+
+	for i := pathStart; i < len(baseURL); i++ {
+		if baseURL[i] == '?' || baseURL[i] == '#' {
+			break
+		}
+		if baseURL[i] != '/' {
+			continue
+		}
+		segment := baseURL[i+1:]
+		if end := strings.IndexAny(segment, "/?#"); end >= 0 {
+			segment = segment[:end]
+		}
+		for _, operation := range cloudOperations {
+			if segment == operation {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// resolveCloudURL 解析服务端返回的 URL（可以是相对路径），为空时使用根 URL 下的默认路径
+func (p *CloudProvider) resolveCloudURL(ref, defaultPath string) string {
+	base, err := url.Parse(p.serviceBaseURL() + "/")
+	if err != nil {
+		return p.serviceBaseURL() + "/" + defaultPath
+	}
+	if ref == "" {
+		ref = defaultPath
+	}
+	resolved, err := base.Parse(ref)
+	if err != nil {
+		return p.serviceBaseURL() + "/" + defaultPath
+	}
+	return resolved.String()
+}
+
+// cloudImageRef 将服务端返回的相对图像路径（如 "/results/x.png"）解析为完整 URL，其他图像数据原样返回
+func (p *CloudProvider) cloudImageRef(image string) string {
+	if isRemoteImageURL(image) || strings.HasPrefix(image, "data:") || looksLikeBase64Image(image) {
+		return image
+	}
+	// base64 数据不包含 "."，以 "/" 开头的 JPEG 数据已在上面排除
+	if (strings.HasPrefix(image, "/") || strings.Contains(image, ".")) &&
+		len(image) <= 2048 && !strings.ContainsAny(image, " \t\r\n") {
+		return p.resolveCloudURL(image, "")
+	}
+	return image
+}
+
+// authorize 如果配置了 Token，添加到 Authorization 头
+func (p *CloudProvider) authorize(req *http.Request) {
+	if p.settings.CloudToken != "" {
+		req.Header.Set("Authorization", "Bearer "+p.settings.CloudToken)
+	}
+}
+
+// newCloudRequest 创建云服务请求
+// 服务端支持 multipart 且图像数据超过阈值时以 multipart/form-data 发送原始图像，否则使用 JSON
+func (p *CloudProvider) newCloudRequest(ctx context.Context, endpoint string, requestData interface{}) (*http.Request, error) {
+	p.ensureCapabilities(ctx)
+	p.capsMu.RLock()
+	transport := p.transport
+	p.capsMu.RUnlock()
+
+	var req *http.Request
+	if transport.Multipart {
+		if metadata, files := splitCloudImages(requestData); cloudFilesSize(files) >= cloudMultipartThreshold {
+			newBody, contentType, err := newCloudMultipartBody(metadata, files)
+			if err != nil {
+				return nil, err
+			}
+			body, _ := newBody()
+			req, err = http.NewRequestWithContext(ctx, "POST", p.operationURL(endpoint), body)
+			if err != nil {
+				body.Close()
+				return nil, fmt.Errorf("failed to create request: %w", err)
+			}
+			req.GetBody = newBody
+			req.Header.Set("Content-Type", contentType)
+		}
+	}
+	if req == nil {
+		// 序列化请求数据
+		requestBody, err := json.Marshal(requestData)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal request: %w", err)
 		}
-		if imageData, ok := response["imageData"].(string); ok {
-			return imageData, nil
+		req, err = http.NewRequestWithContext(ctx, "POST", p.operationURL(endpoint), bytes.NewReader(requestBody))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create request: %w", err)
 		}
-		return "", fmt.Errorf("invalid response format: expected 'image' or 'imageData' field")
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	if transport.BinaryResponses {
+		req.Header.Set("Accept", "application/json, image/*")
+	}
+	// 声明支持异步任务（不支持的服务端会忽略并同步返回结果）
+	req.Header.Set("Prefer", "respond-async")
+	p.authorize(req)
+	return req, nil
+}
+
+// doCloudRequest 发送云服务请求并解析响应
+// 服务端以异步任务响应时等待任务完成并返回任务结果；
+// 二进制图像响应转换为 {"image": dataURL}，与 JSON 响应使用相同的结果提取逻辑
+func (p *CloudProvider) doCloudRequest(ctx context.Context, endpoint string, requestData interface{}) (map[string]interface{}, error) {
+	req, err := p.newCloudRequest(ctx, endpoint, requestData)
+	if err != nil {
+		return nil, err
+	}
+
+	// 发送请求
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// 检查 HTTP 状态码
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusAccepted {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("cloud API returned status %d: %s", resp.StatusCode, string(bodyBytes))
+	}
+
+	// 读取响应
+	bodyBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	// 二进制图像响应
+	if contentType := resp.Header.Get("Content-Type"); strings.HasPrefix(contentType, "image/") {
+		mimeType, _, _ := strings.Cut(contentType, ";")
+		response := map[string]interface{}{
+			"image": fmt.Sprintf("data:%s;base64,%s", strings.TrimSpace(mimeType), base64.StdEncoding.EncodeToString(bodyBytes)),
+		}
+		if model := resp.Header.Get("X-Model"); model != "" {
+			response["model"] = model
+		}
+		return response, nil
+	}
+
+	// 解析响应
+	var response map[string]interface{}
+	if err := json.Unmarshal(bodyBytes, &response); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	if job, ok := p.parseCloudJob(resp.StatusCode, response); ok {
+		return p.waitForCloudJob(ctx, job)
 	}
+	return response, nil
 }
@@ -0,0 +1,356 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"image"
+	"image/color"
+	"image/png"
+	"indraw/core/types"
+	"math/rand/v2"
+	"net/http"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestCloudOperationURL(t *testing.T) {
+	tests := []struct {
+		name        string
+		endpoint    string
+		operation   string
+		wantURL     string
+		wantBaseURL string
+	}{
+		{
+			name:        "service root",
+			endpoint:    "https://api.example.com",
+			operation:   "generateImage",
+			wantURL:     "https://api.example.com/generateImage",
+			wantBaseURL: "https://api.example.com",
+		},
+		{
+			name:        "service root with trailing slash",
+			endpoint:    "https://api.example.com/v1/",
+			operation:   "editImage",
+			wantURL:     "https://api.example.com/v1/editImage",
+			wantBaseURL: "https://api.example.com/v1",
+		},
+		{
+			name:        "endpoint already contains the operation",
+			endpoint:    "https://api.example.com/v1/generateImage",
+			operation:   "generateImage",
+			wantURL:     "https://api.example.com/v1/generateImage",
+			wantBaseURL: "https://api.example.com/v1",
+		},
+		{
+			name:        "endpoint contains another operation",
+			endpoint:    "https://api.example.com/v1/editImage/",
+			operation:   "enhancePrompt",
+			wantURL:     "https://api.example.com/v1/editImage",
+			wantBaseURL: "https://api.example.com/v1",
+		},
+		{
+			name:        "operation followed by query",
+			endpoint:    "https://api.example.com/generateImage?key=abc",
+			operation:   "generateImage",
+			wantURL:     "https://api.example.com/generateImage?key=abc",
+			wantBaseURL: "https://api.example.com",
+		},
+		{
+			name:        "path segment that only starts with an operation name",
+			endpoint:    "https://api.example.com/generateImages",
+			operation:   "enhancePrompt",
+			wantURL:     "https://api.example.com/generateImages/enhancePrompt",
+			wantBaseURL: "https://api.example.com/generateImages",
+		},
+		{
+			name:        "host named like an operation",
+			endpoint:    "http://editImage:8080",
+			operation:   "editImage",
+			wantURL:     "http://editImage:8080/editImage",
+			wantBaseURL: "http://editImage:8080",
+		},
+		{
+			name:        "host without port named like an operation",
+			endpoint:    "http://generateImage/api",
+			operation:   "generateImage",
+			wantURL:     "http://generateImage/api/generateImage",
+			wantBaseURL: "http://generateImage/api",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &CloudProvider{endpointURL: tt.endpoint}
+			if got := p.operationURL(tt.operation); got != tt.wantURL {
+				t.Errorf("operationURL(%q) = %q, want %q", tt.operation, got, tt.wantURL)
+			}
+			if got := p.serviceBaseURL(); got != tt.wantBaseURL {
+				t.Errorf("serviceBaseURL() = %q, want %q", got, tt.wantBaseURL)
+			}
+		})
+	}
+}
+
+func TestCloudResolveURL(t *testing.T) {
+	p := &CloudProvider{endpointURL: "https://api.example.com/v1/generateImage"}
+	tests := []struct {
+		ref, defaultPath, want string
+	}{
+		{"", "jobs/42", "https://api.example.com/v1/jobs/42"},
+		{"jobs/42/events", "jobs/42", "https://api.example.com/v1/jobs/42/events"},
+		{"/status/42", "jobs/42", "https://api.example.com/status/42"},
+		{"https://jobs.example.com/42", "jobs/42", "https://jobs.example.com/42"},
+	}
+
+	for _, tt := range tests {
+		if got := p.resolveCloudURL(tt.ref, tt.defaultPath); got != tt.want {
+			t.Errorf("resolveCloudURL(%q, %q) = %q, want %q", tt.ref, tt.defaultPath, got, tt.want)
+		}
+	}
+}
+
+func TestCloudCapabilityDiscovery(t *testing.T) {
+	newProvider := func(t *testing.T, server *cloudStandIn) *CloudProvider {
+		t.Helper()
+		settings := testSettings()
+		settings.CloudEndpointURL = server.URL
+		p, err := NewCloudProvider(context.Background(), settings)
+		if err != nil {
+			t.Fatalf("NewCloudProvider() error = %v", err)
+		}
+		return p
+	}
+
+	t.Run("discovery starts in the background", func(t *testing.T) {
+		server := newCloudStandIn(t)
+		server.capabilities = map[string]interface{}{"features": []string{"generateImage"}}
+		p := newProvider(t, server)
+
+		deadline := time.Now().Add(5 * time.Second)
+		for len(server.requestsTo("/capabilities")) == 0 {
+			if time.Now().After(deadline) {
+				t.Fatal("NewCloudProvider() did not start capability discovery")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		if caps := p.GetCapabilities(); caps.EditImage || !caps.GenerateImage {
+			t.Errorf("GetCapabilities() = %+v, want the declared features", caps)
+		}
+		if n := len(server.requestsTo("/capabilities")); n != 1 {
+			t.Errorf("queried /capabilities %d times, want 1", n)
+		}
+	})
+
+	t.Run("failure is retried after the backoff", func(t *testing.T) {
+		server := newCloudStandIn(t)
+		server.capabilities = map[string]interface{}{"features": []string{"generateImage"}}
+		server.fail(http.StatusInternalServerError, `{"error": "unavailable"}`)
+		p := newProvider(t, server)
+
+		if caps := p.GetCapabilities(); !caps.EditImage {
+			t.Error("GetCapabilities() after a failed discovery should assume all features")
+		}
+		server.fail(0, "")
+		if caps := p.GetCapabilities(); !caps.EditImage {
+			t.Error("GetCapabilities() within the backoff should keep assuming all features")
+		}
+		if n := len(server.requestsTo("/capabilities")); n != 1 {
+			t.Errorf("queried /capabilities %d times within the backoff, want 1", n)
+		}
+
+		// 重试间隔已过
+		p.capsMu.Lock()
+		p.retryAt = time.Now().Add(-time.Second)
+		p.capsMu.Unlock()
+		if caps := p.GetCapabilities(); caps.EditImage || !caps.GenerateImage {
+			t.Errorf("GetCapabilities() = %+v, want the declared features after discovery succeeds", caps)
+		}
+		p.GetCapabilities()
+		if n := len(server.requestsTo("/capabilities")); n != 2 {
+			t.Errorf("queried /capabilities %d times, want 2 (failure, then success)", n)
+		}
+	})
+
+	t.Run("legacy service without capabilities", func(t *testing.T) {
+		server := newCloudStandIn(t)
+		p := newProvider(t, server)
+
+		if caps := p.GetCapabilities(); !reflect.DeepEqual(caps, cloudCapabilities) {
+			t.Errorf("GetCapabilities() = %+v, want all features", caps)
+		}
+		if _, err := p.EnhancePrompt(context.Background(), "a cat"); err != nil {
+			t.Fatalf("EnhancePrompt() error = %v", err)
+		}
+		if n := len(server.requestsTo("/capabilities")); n != 1 {
+			t.Errorf("queried /capabilities %d times, want 1 (404 is not retried)", n)
+		}
+	})
+}
+
+func TestCloudImageRef(t *testing.T) {
+	p := &CloudProvider{endpointURL: "https://api.example.com/v1/generateImage"}
+	jpeg := "/9j/4AAQSkZJRgABAQAAAQABAAD"
+	tests := []struct {
+		image, want string
+	}{
+		{"/results/x.png", "https://api.example.com/results/x.png"},
+		{"results/x.png", "https://api.example.com/v1/results/x.png"},
+		{"./results/x.png", "https://api.example.com/v1/results/x.png"},
+		{"https://cdn.example.com/x.png", "https://cdn.example.com/x.png"},
+		{"data:image/png;base64,AA", "data:image/png;base64,AA"},
+		{jpeg, jpeg},
+	}
+
+	for _, tt := range tests {
+		if got := p.cloudImageRef(tt.image); got != tt.want {
+			t.Errorf("cloudImageRef(%q) = %q, want %q", tt.image, got, tt.want)
+		}
+	}
+}
+
+func TestExtractCloudImages(t *testing.T) {
+	tests := []struct {
+		name     string
+		response map[string]interface{}
+		want     int
+		wantErr  bool
+	}{
+		{"images array", map[string]interface{}{"images": []interface{}{"data:image/png;base64,AA", "data:image/png;base64,BB"}}, 2, false},
+		{"empty entries skipped", map[string]interface{}{"images": []interface{}{"", "data:image/png;base64,BB"}}, 1, false},
+		{"single image", map[string]interface{}{"image": "data:image/png;base64,AA"}, 1, false},
+		{"imageData", map[string]interface{}{"imageData": "data:image/png;base64,AA"}, 1, false},
+		{"empty images array falls back to image", map[string]interface{}{"images": []interface{}{}, "image": "data:image/png;base64,AA"}, 1, false},
+		{"no image", map[string]interface{}{"text": "hello"}, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			images, err := extractCloudImages(tt.response)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("extractCloudImages() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(images) != tt.want {
+				t.Errorf("extractCloudImages() returned %d images, want %d", len(images), tt.want)
+			}
+		})
+	}
+}
+
+func TestCloudTransport(t *testing.T) {
+	small := testImageDataURL(t, 64, 64)
+	large := noisyImageDataURL(t, 420, 420)
+	negotiated := map[string]interface{}{
+		"features":        []string{"generateImage", "editImage", "blendImages", "upscale"},
+		"multipart":       true,
+		"binaryResponses": true,
+	}
+
+	newProvider := func(t *testing.T, capabilities map[string]interface{}) (*CloudProvider, *cloudStandIn) {
+		t.Helper()
+		server := newCloudStandIn(t)
+		server.capabilities = capabilities
+		settings := testSettings()
+		settings.CloudEndpointURL = server.URL
+		p, err := NewCloudProvider(context.Background(), settings)
+		if err != nil {
+			t.Fatalf("NewCloudProvider() error = %v", err)
+		}
+		return p, server
+	}
+
+	t.Run("small images use JSON", func(t *testing.T) {
+		p, server := newProvider(t, negotiated)
+		images := []string{small, small}
+		if _, err := p.EditMultiImages(context.Background(), types.MultiImageEditParams{Images: images, Prompt: "blend"}); err != nil {
+			t.Fatalf("EditMultiImages() error = %v", err)
+		}
+		uploads := server.uploadsFor("editMultiImages")
+		if len(uploads) != 1 || uploads[0].Multipart || len(uploads[0].Images["images"]) != 2 {
+			t.Fatalf("uploads = %+v, want one JSON request with 2 images", uploads)
+		}
+		if !slices.Equal(images, []string{small, small}) {
+			t.Error("EditMultiImages() modified the caller's images")
+		}
+	})
+
+	t.Run("large images use multipart", func(t *testing.T) {
+		p, server := newProvider(t, negotiated)
+		if _, err := p.EditMultiImages(context.Background(), types.MultiImageEditParams{Images: []string{large, large}, Prompt: "blend"}); err != nil {
+			t.Fatalf("EditMultiImages() error = %v", err)
+		}
+		uploads := server.uploadsFor("editMultiImages")
+		if len(uploads) != 1 || !uploads[0].Multipart || len(uploads[0].Images["images"]) != 2 {
+			t.Fatalf("uploads = %+v, want one multipart request with 2 images", uploads)
+		}
+		for _, dataURL := range uploads[0].Images["images"] {
+			if bounds := decodeTestImage(t, dataURL).Bounds(); bounds.Dx() != 420 || bounds.Dy() != 420 {
+				t.Errorf("uploaded image is %dx%d, want 420x420", bounds.Dx(), bounds.Dy())
+			}
+		}
+	})
+
+	t.Run("edit with mask uses multipart", func(t *testing.T) {
+		p, server := newProvider(t, negotiated)
+		if _, err := p.EditImage(context.Background(), types.EditImageParams{ImageData: large, Mask: testImageDataURL(t, 420, 420), Prompt: "edit"}); err != nil {
+			t.Fatalf("EditImage() error = %v", err)
+		}
+		uploads := server.uploadsFor("editImage")
+		if len(uploads) != 1 || !uploads[0].Multipart || len(uploads[0].Images["imageData"]) != 1 || len(uploads[0].Images["mask"]) != 1 {
+			t.Fatalf("uploads = %+v, want one multipart request with imageData and mask", uploads)
+		}
+	})
+
+	t.Run("multipart not negotiated", func(t *testing.T) {
+		p, server := newProvider(t, map[string]interface{}{"features": []string{"blendImages"}})
+		if _, err := p.EditMultiImages(context.Background(), types.MultiImageEditParams{Images: []string{large, large}, Prompt: "blend"}); err != nil {
+			t.Fatalf("EditMultiImages() error = %v", err)
+		}
+		uploads := server.uploadsFor("editMultiImages")
+		if len(uploads) != 1 || uploads[0].Multipart || len(uploads[0].Images["images"]) != 2 {
+			t.Fatalf("uploads = %+v, want one JSON request with 2 images", uploads)
+		}
+	})
+
+	t.Run("binary response", func(t *testing.T) {
+		p, server := newProvider(t, negotiated)
+		var model string
+		ctx := WithUsageReporter(context.Background(), func(usage Usage) { model = usage.Model })
+		result, err := p.UpscaleImage(ctx, types.UpscaleImageParams{ImageData: small, Scale: 2})
+		if err != nil {
+			t.Fatalf("UpscaleImage() error = %v", err)
+		}
+		decodeTestImage(t, result)
+		if model != "cloud-test-binary" {
+			t.Errorf("usage model = %q, want the X-Model header", model)
+		}
+		accept := server.requestsTo("/upscaleImage")[0].Header.Get("Accept")
+		if accept != "application/json, image/*" {
+			t.Errorf("Accept = %q, want binary responses to be accepted", accept)
+		}
+	})
+}
+
+// noisyImageDataURL 返回随机噪声 PNG 的 data URL（几乎无法压缩，用于超过 multipart 阈值）
+func noisyImageDataURL(t *testing.T, width, height int) string {
+	t.Helper()
+	rng := rand.New(rand.NewPCG(1, 2))
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{R: uint8(rng.IntN(256)), G: uint8(rng.IntN(256)), B: uint8(rng.IntN(256)), A: 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("failed to encode test image: %v", err)
+	}
+	dataURL := "data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes())
+	if len(dataURL) < cloudMultipartThreshold/2+1 {
+		t.Fatalf("noisy test image is only %d bytes", len(dataURL))
+	}
+	return dataURL
+}
@@ -0,0 +1,789 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"indraw/core/apperr"
+	"indraw/core/types"
+	"io"
+	"math/rand/v2"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// defaultComfyUIURL ComfyUI 默认服务地址
+const defaultComfyUIURL = "http://127.0.0.1:8188"
+
+// comfyUIPollInterval WebSocket 不可用时轮询执行结果的间隔
+const comfyUIPollInterval = time.Second
+
+// ComfyUI 工作流模板的键（与功能名称对应）
+const (
+	comfyUIWorkflowGenerate = "generateImage"
+	comfyUIWorkflowEdit     = "editImage"
+	comfyUIWorkflowInpaint  = "inpaint"
+	comfyUIWorkflowBlend    = "blendImages"
+	comfyUIWorkflowUpscale  = "upscale"
+)
+
+// ==================== ComfyUIProvider 实现 ====================
+
+// ComfyUIProvider 本地 ComfyUI 提供商
+// 将用户提供的 API 格式工作流模板中的占位符替换为请求参数后提交到 /prompt，
+// 输入图像通过 /upload/image 上传，执行进度通过 WebSocket 获取，结果图像通过 /view 下载
+type ComfyUIProvider struct {
+	ctx        context.Context
+	baseURL    string
+	httpClient *http.Client
+	workflows  map[string]string
+	settings   types.AISettings
+	seq        uint64
+}
+
+// NewComfyUIProvider 创建 ComfyUI 提供商实例
+func NewComfyUIProvider(ctx context.Context, settings types.AISettings) (*ComfyUIProvider, error) {
+	workflows := make(map[string]string)
+	for key, workflow := range settings.ComfyUIWorkflows {
+		if strings.TrimSpace(workflow) == "" {
+			continue
+		}
+		// 提前校验模板格式，避免调用时才发现配置错误
+		var parsed map[string]interface{}
+		if err := json.Unmarshal([]byte(workflow), &parsed); err != nil {
+			return nil, fmt.Errorf("invalid ComfyUI workflow template %q: %w", key, err)
+		}
+		workflows[key] = workflow
+	}
+	if len(workflows) == 0 {
+		return nil, fmt.Errorf("no ComfyUI workflow templates configured")
+	}
+
+	baseURL := strings.TrimSuffix(settings.ComfyUIURL, "/")
+	if baseURL == "" {
+		baseURL = defaultComfyUIURL
+	}
+
+	return &ComfyUIProvider{
+		ctx:        ctx,
+		baseURL:    baseURL,
+		httpClient: newHTTPClient(settings, 0),
+		workflows:  workflows,
+		settings:   settings,
+	}, nil
+}
+
+// Name 返回提供商名称
+func (p *ComfyUIProvider) Name() string {
+	return "comfyui"
+}
+
+// GetCapabilities 返回提供商支持的功能
+// 能力由已配置的工作流模板决定
+func (p *ComfyUIProvider) GetCapabilities() ProviderCapabilities {
+	_, hasGenerate := p.workflows[comfyUIWorkflowGenerate]
+	_, hasEdit := p.workflows[comfyUIWorkflowEdit]
+	_, hasInpaint := p.workflows[comfyUIWorkflowInpaint]
+	_, hasBlend := p.workflows[comfyUIWorkflowBlend]
+	_, hasUpscale := p.workflows[comfyUIWorkflowUpscale]
+
+	return ProviderCapabilities{
+		GenerateImage:    hasGenerate,
+		EditImage:        hasEdit || hasInpaint,
+		EnhancePrompt:    false,
+		BlendImages:      hasBlend,
+		RemoveBackground: hasEdit,
+		ReferenceImage:   hasGenerate && strings.Contains(p.workflows[comfyUIWorkflowGenerate], "{{reference_image}}"),
+		Inpaint:          hasInpaint,
+		ExtendImage:      hasInpaint,
+		Upscale:          hasUpscale,
+	}
+}
+
+// CheckAvailability 检测服务可用性
+func (p *ComfyUIProvider) CheckAvailability(ctx context.Context) (bool, error) {
+	testCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(testCtx, "GET", p.baseURL+"/system_stats", nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("ComfyUI server unavailable: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return false, fmt.Errorf("ComfyUI server returned status %d: %s", resp.StatusCode, truncateString(string(bodyBytes), 200))
+	}
+
+	return true, nil
+}
+
+// Close 清理资源
+func (p *ComfyUIProvider) Close() error {
+	if p.httpClient != nil {
+		p.httpClient.CloseIdleConnections()
+	}
+	return nil
+}
+
+// ==================== API 方法实现 ====================
+
+// GenerateImage 生成图像
+// 多张候选图像通过使用不同随机种子的并发提交实现
+func (p *ComfyUIProvider) GenerateImage(ctx context.Context, params types.GenerateImageParams) (*ImageResult, error) {
+	template, ok := p.workflows[comfyUIWorkflowGenerate]
+	if !ok {
+		return nil, fmt.Errorf("no ComfyUI workflow configured for image generation")
+	}
+
+	width, height := generationSize(params.ImageSize, params.AspectRatio)
+
+	// 输入图像只规范化一次，每个并发请求各自上传
+	inputs := map[string]inputImage{}
+	for key, data := range map[string]string{"reference_image": params.ReferenceImage, "sketch_image": params.SketchImage} {
+		if data == "" {
+			continue
+		}
+		image, err := normalizeInputImage(data, p.inputPolicy())
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", strings.ReplaceAll(key, "_", " "), err)
+		}
+		inputs[key] = image
+	}
+
+	return generateConcurrently(ctx, params.Count, func(ctx context.Context) ([]string, error) {
+		values := map[string]interface{}{
+			"prompt": params.Prompt,
+			"width":  width,
+			"height": height,
+			"seed":   comfyUISeed(),
+		}
+		for key, image := range inputs {
+			name, err := p.uploadImage(ctx, image)
+			if err != nil {
+				return nil, err
+			}
+			values[key] = name
+		}
+
+		return p.runWorkflow(ctx, template, values)
+	})
+}
+
+// EditImage 编辑图像
+// 有蒙版时使用 inpaint 模板，{{mask}} 为黑白蒙版图像（白色为重绘区域）
+func (p *ComfyUIProvider) EditImage(ctx context.Context, params types.EditImageParams) (string, error) {
+	key := comfyUIWorkflowEdit
+	if params.Mask != "" {
+		key = comfyUIWorkflowInpaint
+	}
+	template, ok := p.workflows[key]
+	if !ok {
+		return "", fmt.Errorf("no ComfyUI workflow configured for %s", key)
+	}
+
+	image, err := normalizeInputImage(params.ImageData, p.inputPolicy())
+	if err != nil {
+		return "", err
+	}
+	width, height := image.Width, image.Height
+
+	imageName, err := p.uploadImage(ctx, image)
+	if err != nil {
+		return "", err
+	}
+
+	values := map[string]interface{}{
+		"prompt": params.Prompt,
+		"width":  width,
+		"height": height,
+		"seed":   comfyUISeed(),
+		"image":  imageName,
+	}
+
+	if params.Mask != "" {
+		maskData, err := buildMaskPreviewPNG(params.Mask, width, height)
+		if err != nil {
+			return "", err
+		}
+		maskName, err := p.uploadImage(ctx, inputImage{Data: maskData, MIMEType: mimePNG, Width: width, Height: height})
+		if err != nil {
+			return "", err
+		}
+		values["mask"] = maskName
+	}
+
+	images, err := p.runWorkflow(ctx, template, values)
+	if err != nil {
+		return "", err
+	}
+	return images[0], nil
+}
+
+// EditMultiImages 多图编辑/融合
+// 模板中通过 {{image_1}}、{{image_2}}… 引用各输入图像，{{image}} 等同于第一张
+func (p *ComfyUIProvider) EditMultiImages(ctx context.Context, params types.MultiImageEditParams) (string, error) {
+	if len(params.Images) < 2 {
+		return "", fmt.Errorf("at least 2 images are required")
+	}
+	template, ok := p.workflows[comfyUIWorkflowBlend]
+	if !ok {
+		return "", fmt.Errorf("no ComfyUI workflow configured for image blending")
+	}
+
+	images := make([]inputImage, len(params.Images))
+	for i, img := range params.Images {
+		image, err := normalizeInputImage(img, p.inputPolicy())
+		if err != nil {
+			return "", fmt.Errorf("invalid image %d: %w", i+1, err)
+		}
+		images[i] = image
+	}
+
+	values := map[string]interface{}{
+		"prompt": params.Prompt,
+		"width":  images[0].Width,
+		"height": images[0].Height,
+		"seed":   comfyUISeed(),
+	}
+	for i, image := range images {
+		name, err := p.uploadImage(ctx, image)
+		if err != nil {
+			return "", fmt.Errorf("failed to upload image %d: %w", i+1, err)
+		}
+		values[fmt.Sprintf("image_%d", i+1)] = name
+		if i == 0 {
+			values["image"] = name
+		}
+	}
+
+	results, err := p.runWorkflow(ctx, template, values)
+	if err != nil {
+		return "", err
+	}
+	return results[0], nil
+}
+
+// UpscaleImage 放大图像
+func (p *ComfyUIProvider) UpscaleImage(ctx context.Context, params types.UpscaleImageParams) (string, error) {
+	template, ok := p.workflows[comfyUIWorkflowUpscale]
+	if !ok {
+		return "", fmt.Errorf("no ComfyUI workflow configured for upscaling")
+	}
+
+	// 放大保留原始分辨率，只做格式转换和元数据去除
+	image, err := normalizeInputImage(params.ImageData, p.inputPolicy().withoutDownscale())
+	if err != nil {
+		return "", err
+	}
+
+	imageName, err := p.uploadImage(ctx, image)
+	if err != nil {
+		return "", err
+	}
+
+	images, err := p.runWorkflow(ctx, template, map[string]interface{}{
+		"image":  imageName,
+		"scale":  params.Scale,
+		"width":  image.Width * params.Scale,
+		"height": image.Height * params.Scale,
+		"seed":   comfyUISeed(),
+	})
+	if err != nil {
+		return "", err
+	}
+	return images[0], nil
+}
+
+// EnhancePrompt 增强提示词（ComfyUI 不支持）
+func (p *ComfyUIProvider) EnhancePrompt(ctx context.Context, prompt string) (string, error) {
+	return "", apperr.New(apperr.CodeUnsupportedFeature, "aiProvider comfyui does not support prompt enhancement")
+}
+
+// ==================== 工作流执行 ====================
+
+// runWorkflow 填充模板并执行工作流，返回所有输出图像
+func (p *ComfyUIProvider) runWorkflow(ctx context.Context, template string, values map[string]interface{}) ([]string, error) {
+	workflow, err := fillComfyUITemplate(template, values)
+	if err != nil {
+		return nil, err
+	}
+
+	// 每次执行使用独立的 client_id：ComfyUI 按 client_id 保存连接，相同的 ID 会互相覆盖
+	clientID := fmt.Sprintf("indraw-%d-%d", time.Now().UnixNano(), atomic.AddUint64(&p.seq, 1))
+
+	// 先连接 WebSocket 再提交，避免错过执行事件；连接失败时回退为轮询
+	conn, _, wsErr := websocket.DefaultDialer.DialContext(ctx, p.websocketURL(clientID), nil)
+	if wsErr != nil {
+		fmt.Printf("[ComfyUI] WebSocket unavailable, falling back to polling: %v\n", wsErr)
+	} else {
+		defer conn.Close()
+	}
+
+	promptID, err := p.queuePrompt(ctx, workflow, clientID)
+	if err != nil {
+		return nil, err
+	}
+
+	if conn != nil {
+		err = p.waitViaWebsocket(ctx, conn, promptID)
+	} else {
+		err = p.waitViaPolling(ctx, promptID)
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			p.cancelPrompt(promptID)
+		}
+		return nil, err
+	}
+
+	images, err := p.fetchOutputs(ctx, promptID)
+	if err != nil {
+		return nil, err
+	}
+	reportUsage(ctx, Usage{Provider: p.Name(), Model: "comfyui", Images: len(images)})
+	return images, nil
+}
+
+// queuePrompt 提交工作流到 /prompt，返回 prompt_id
+func (p *ComfyUIProvider) queuePrompt(ctx context.Context, workflow map[string]interface{}, clientID string) (string, error) {
+	body, err := json.Marshal(map[string]interface{}{
+		"prompt":    workflow,
+		"client_id": clientID,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal workflow: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", p.baseURL+"/prompt", bytes.NewReader(body))
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to submit ComfyUI workflow: %w", err)
+	}
+	defer resp.Body.Close()
+
+	bodyBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to read response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("ComfyUI rejected workflow (status %d): %s", resp.StatusCode, truncateString(string(bodyBytes), 500))
+	}
+
+	var result struct {
+		PromptID   string                 `json:"prompt_id"`
+		NodeErrors map[string]interface{} `json:"node_errors"`
+	}
+	if err := json.Unmarshal(bodyBytes, &result); err != nil {
+		return "", fmt.Errorf("failed to parse response: %w", err)
+	}
+	if len(result.NodeErrors) > 0 {
+		return "", fmt.Errorf("ComfyUI workflow has node errors: %s", truncateString(string(bodyBytes), 500))
+	}
+	if result.PromptID == "" {
+		return "", fmt.Errorf("ComfyUI did not return a prompt id")
+	}
+	return result.PromptID, nil
+}
+
+// comfyUIMessage WebSocket 文本消息
+type comfyUIMessage struct {
+	Type string `json:"type"`
+	Data struct {
+		PromptID         string  `json:"prompt_id"`
+		Node             *string `json:"node"`
+		Value            int     `json:"value"`
+		Max              int     `json:"max"`
+		ExceptionMessage string  `json:"exception_message"`
+		ExceptionType    string  `json:"exception_type"`
+		NodeType         string  `json:"node_type"`
+	} `json:"data"`
+}
+
+// waitViaWebsocket 通过 WebSocket 跟踪执行进度，直到工作流执行完成
+func (p *ComfyUIProvider) waitViaWebsocket(ctx context.Context, conn *websocket.Conn, promptID string) error {
+	tracker := newProgressTracker(ctx)
+
+	// 上下文取消时关闭连接，使阻塞的读取立即返回
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	executing := false // 是否已开始执行本次提交（用于归属二进制预览图像）
+	previewIndex := 0
+	for {
+		messageType, data, err := conn.ReadMessage()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			// 连接中断时改为轮询
+			fmt.Printf("[ComfyUI] WebSocket closed, falling back to polling: %v\n", err)
+			return p.waitViaPolling(ctx, promptID)
+		}
+		tracker.addBytes(len(data))
+
+		if messageType == websocket.BinaryMessage {
+			// 二进制消息：4 字节事件类型 + 4 字节图像格式 + 图像数据，事件类型 1 为采样预览图
+			if executing && len(data) > 8 && binary.BigEndian.Uint32(data[0:4]) == 1 {
+				mimeType := "image/jpeg"
+				if binary.BigEndian.Uint32(data[4:8]) == 2 {
+					mimeType = "image/png"
+				}
+				tracker.partialImage(fmt.Sprintf("data:%s;base64,%s", mimeType, base64.StdEncoding.EncodeToString(data[8:])), previewIndex)
+				previewIndex++
+			}
+			continue
+		}
+
+		var message comfyUIMessage
+		if err := json.Unmarshal(data, &message); err != nil {
+			continue
+		}
+		if message.Data.PromptID != "" && message.Data.PromptID != promptID {
+			continue
+		}
+
+		switch message.Type {
+		case "execution_start":
+			executing = true
+		case "progress":
+			executing = true
+			tracker.steps(message.Data.Value, message.Data.Max)
+		case "executing":
+			if message.Data.PromptID == promptID {
+				executing = true
+				// node 为 null 表示整个工作流执行完毕
+				if message.Data.Node == nil {
+					return nil
+				}
+				tracker.text(fmt.Sprintf("executing node %s", *message.Data.Node))
+			}
+		case "execution_success":
+			return nil
+		case "execution_error":
+			return fmt.Errorf("ComfyUI execution error in %s: %s %s",
+				message.Data.NodeType, message.Data.ExceptionType, message.Data.ExceptionMessage)
+		case "execution_interrupted":
+			return fmt.Errorf("ComfyUI execution interrupted")
+		}
+	}
+}
+
+// waitViaPolling 轮询 /history/{prompt_id}，直到工作流执行完成
+func (p *ComfyUIProvider) waitViaPolling(ctx context.Context, promptID string) error {
+	ticker := time.NewTicker(comfyUIPollInterval)
+	defer ticker.Stop()
+
+	for {
+		history, err := p.getHistory(ctx, promptID)
+		if err != nil {
+			return err
+		}
+		if history != nil {
+			if history.Status.StatusStr == "error" {
+				return fmt.Errorf("ComfyUI execution failed")
+			}
+			if history.Status.Completed || len(history.Outputs) > 0 {
+				return nil
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// comfyUIImageRef ComfyUI 输出图像引用
+type comfyUIImageRef struct {
+	Filename  string `json:"filename"`
+	Subfolder string `json:"subfolder"`
+	Type      string `json:"type"`
+}
+
+// comfyUIHistory /history/{prompt_id} 中的单条执行记录
+type comfyUIHistory struct {
+	Outputs map[string]struct {
+		Images []comfyUIImageRef `json:"images"`
+	} `json:"outputs"`
+	Status struct {
+		StatusStr string `json:"status_str"`
+		Completed bool   `json:"completed"`
+	} `json:"status"`
+}
+
+// getHistory 获取执行记录，尚未完成时返回 nil
+func (p *ComfyUIProvider) getHistory(ctx context.Context, promptID string) (*comfyUIHistory, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", p.baseURL+"/history/"+url.PathEscape(promptID), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch ComfyUI history: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("ComfyUI history returned status %d: %s", resp.StatusCode, truncateString(string(bodyBytes), 200))
+	}
+
+	var histories map[string]*comfyUIHistory
+	if err := json.NewDecoder(resp.Body).Decode(&histories); err != nil {
+		return nil, fmt.Errorf("failed to parse ComfyUI history: %w", err)
+	}
+	return histories[promptID], nil
+}
+
+// fetchOutputs 下载工作流的输出图像
+// 优先使用保存节点（type=output）的结果，没有时使用预览节点（type=temp）的结果
+func (p *ComfyUIProvider) fetchOutputs(ctx context.Context, promptID string) ([]string, error) {
+	history, err := p.getHistory(ctx, promptID)
+	if err != nil {
+		return nil, err
+	}
+	if history == nil {
+		return nil, fmt.Errorf("ComfyUI history not found for prompt %s", promptID)
+	}
+
+	var saved, temp []comfyUIImageRef
+	for _, output := range history.Outputs {
+		for _, ref := range output.Images {
+			if ref.Type == "output" {
+				saved = append(saved, ref)
+			} else {
+				temp = append(temp, ref)
+			}
+		}
+	}
+	refs := saved
+	if len(refs) == 0 {
+		refs = temp
+	}
+	if len(refs) == 0 {
+		return nil, fmt.Errorf("ComfyUI workflow produced no images")
+	}
+
+	images := make([]string, 0, len(refs))
+	for _, ref := range refs {
+		image, err := p.viewImage(ctx, ref)
+		if err != nil {
+			return nil, err
+		}
+		images = append(images, image)
+	}
+	return images, nil
+}
+
+// viewImage 通过 /view 下载图像，返回 data URL
+func (p *ComfyUIProvider) viewImage(ctx context.Context, ref comfyUIImageRef) (string, error) {
+	query := url.Values{}
+	query.Set("filename", ref.Filename)
+	query.Set("subfolder", ref.Subfolder)
+	query.Set("type", ref.Type)
+
+	req, err := http.NewRequestWithContext(ctx, "GET", p.baseURL+"/view?"+query.Encode(), nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to download ComfyUI output: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("ComfyUI /view returned status %d for %s", resp.StatusCode, ref.Filename)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to read ComfyUI output: %w", err)
+	}
+
+	mimeType := resp.Header.Get("Content-Type")
+	if !strings.HasPrefix(mimeType, "image/") {
+		mimeType = http.DetectContentType(data)
+	}
+	return fmt.Sprintf("data:%s;base64,%s", mimeType, base64.StdEncoding.EncodeToString(data)), nil
+}
+
+// inputPolicy 返回应用用户配置后的输入图像要求
+func (p *ComfyUIProvider) inputPolicy() imageInputPolicy {
+	return comfyUIInputPolicy.withSettings(p.settings)
+}
+
+// uploadImage 通过 /upload/image 上传已规范化的输入图像，返回可在 LoadImage 节点中引用的文件名
+func (p *ComfyUIProvider) uploadImage(ctx context.Context, image inputImage) (string, error) {
+	ext := ".png"
+	if image.MIMEType == mimeJPEG {
+		ext = ".jpg"
+	}
+	seq := atomic.AddUint64(&p.seq, 1)
+	filename := fmt.Sprintf("indraw_%d_%d%s", time.Now().UnixMilli(), seq, ext)
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, err := writer.CreateFormFile("image", filename)
+	if err != nil {
+		return "", fmt.Errorf("failed to build upload request: %w", err)
+	}
+	if _, err := part.Write(image.Data); err != nil {
+		return "", fmt.Errorf("failed to build upload request: %w", err)
+	}
+	writer.WriteField("type", "input")
+	writer.WriteField("overwrite", "true")
+	if err := writer.Close(); err != nil {
+		return "", fmt.Errorf("failed to build upload request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", p.baseURL+"/upload/image", bytes.NewReader(body.Bytes()))
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to upload image to ComfyUI: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return "", fmt.Errorf("ComfyUI upload returned status %d: %s", resp.StatusCode, truncateString(string(bodyBytes), 200))
+	}
+
+	var result struct {
+		Name      string `json:"name"`
+		Subfolder string `json:"subfolder"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("failed to parse upload response: %w", err)
+	}
+	if result.Subfolder != "" {
+		return result.Subfolder + "/" + result.Name, nil
+	}
+	return result.Name, nil
+}
+
+// cancelPrompt 取消已提交的工作流：从队列中删除并中断执行（尽力而为）
+func (p *ComfyUIProvider) cancelPrompt(promptID string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	post := func(path string, payload interface{}) {
+		body, _ := json.Marshal(payload)
+		req, err := http.NewRequestWithContext(ctx, "POST", p.baseURL+path, bytes.NewReader(body))
+		if err != nil {
+			return
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if resp, err := p.httpClient.Do(req); err == nil {
+			resp.Body.Close()
+		}
+	}
+
+	post("/queue", map[string]interface{}{"delete": []string{promptID}})
+	post("/interrupt", map[string]interface{}{"prompt_id": promptID})
+}
+
+// websocketURL 构建 WebSocket 地址
+func (p *ComfyUIProvider) websocketURL(clientID string) string {
+	wsURL := p.baseURL
+	if strings.HasPrefix(wsURL, "https://") {
+		wsURL = "wss://" + strings.TrimPrefix(wsURL, "https://")
+	} else {
+		wsURL = "ws://" + strings.TrimPrefix(wsURL, "http://")
+	}
+	return wsURL + "/ws?clientId=" + url.QueryEscape(clientID)
+}
+
+// ==================== 模板处理 ====================
+
+// fillComfyUITemplate 解析工作流模板并替换占位符
+// 整个字符串恰好是一个占位符时替换为对应类型的值（如数字），否则在字符串内做文本替换
+func fillComfyUITemplate(template string, values map[string]interface{}) (map[string]interface{}, error) {
+	var workflow map[string]interface{}
+	if err := json.Unmarshal([]byte(template), &workflow); err != nil {
+		return nil, fmt.Errorf("invalid ComfyUI workflow template: %w", err)
+	}
+
+	filled, ok := fillComfyUIValue(workflow, values).(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid ComfyUI workflow template")
+	}
+	return filled, nil
+}
+
+// comfyUIPlaceholderPattern 工作流模板中的占位符，如 {{prompt}}、{{ image_1 }}
+var comfyUIPlaceholderPattern = regexp.MustCompile(`\{\{\s*(\w+)\s*\}\}`)
+
+// fillComfyUIValue 递归替换占位符
+// 整个字符串只有一个占位符时替换为原始类型的值（数字保持为数字）；
+// 否则在一次扫描中替换所有占位符，替换进来的文本（如提示词中的 "{{"）不会被再次替换；未知占位符保持不变
+func fillComfyUIValue(value interface{}, values map[string]interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, item := range v {
+			v[key] = fillComfyUIValue(item, values)
+		}
+		return v
+	case []interface{}:
+		for i, item := range v {
+			v[i] = fillComfyUIValue(item, values)
+		}
+		return v
+	case string:
+		if !strings.Contains(v, "{{") {
+			return v
+		}
+		trimmed := strings.TrimSpace(v)
+		if match := comfyUIPlaceholderPattern.FindStringSubmatchIndex(trimmed); match != nil && match[0] == 0 && match[1] == len(trimmed) {
+			if replacement, ok := values[trimmed[match[2]:match[3]]]; ok {
+				return replacement
+			}
+			return v
+		}
+		return comfyUIPlaceholderPattern.ReplaceAllStringFunc(v, func(placeholder string) string {
+			name := comfyUIPlaceholderPattern.FindStringSubmatch(placeholder)[1]
+			if replacement, ok := values[name]; ok {
+				return fmt.Sprint(replacement)
+			}
+			return placeholder
+		})
+	default:
+		return v
+	}
+}
+
+// comfyUISeed 生成随机种子（限制在 JSON 数字可精确表示的范围内）
+func comfyUISeed() int64 {
+	return rand.Int64N(1 << 50)
+}
@@ -0,0 +1,36 @@
+package provider
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFillComfyUIValue(t *testing.T) {
+	values := map[string]interface{}{
+		"prompt": "a sign that says {{seed}}",
+		"seed":   int64(42),
+		"width":  1024,
+	}
+
+	tests := []struct {
+		name  string
+		value interface{}
+		want  interface{}
+	}{
+		{"whole value keeps the type", "{{seed}}", int64(42)},
+		{"whole value with spaces", " {{ width }} ", 1024},
+		{"embedded placeholders", "{{width}}x{{ width }}", "1024x1024"},
+		{"substituted text is not rescanned", "{{prompt}}", "a sign that says {{seed}}"},
+		{"substituted text inside a string is not rescanned", "text: {{prompt}}", "text: a sign that says {{seed}}"},
+		{"unknown placeholder is kept", "{{negative}} and {{seed}}", "{{negative}} and 42"},
+		{"nested values", map[string]interface{}{"inputs": []interface{}{"{{seed}}", "size {{width}}"}},
+			map[string]interface{}{"inputs": []interface{}{int64(42), "size 1024"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := fillComfyUIValue(tt.value, values); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("fillComfyUIValue(%v) = %#v, want %#v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
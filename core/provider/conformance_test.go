@@ -0,0 +1,549 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"image"
+	"image/color"
+	"image/png"
+	"indraw/core/apperr"
+	"indraw/core/types"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// ==================== 提供商一致性测试 ====================
+
+// conformanceTarget 一致性测试的被测对象
+type conformanceTarget struct {
+	provider AIProvider
+	// server 上游替身服务（不访问网络的提供商为 nil）
+	server *standIn
+	// breakUpstream 使之后的上游请求全部失败（429），用于错误路径测试
+	breakUpstream func()
+	// authHeader / authValue 每个上游请求都应携带的认证头（为空时不检查）
+	authHeader string
+	authValue  string
+	// previews 生成图像时是否应通过进度回调上报预览图像
+	previews bool
+}
+
+// rateLimitBody 错误路径测试中替身服务返回的 429 响应体（兼容 OpenAI 和 Gemini 的错误格式）
+const rateLimitBody = `{"error": {"code": 429, "message": "quota exceeded", "status": "RESOURCE_EXHAUSTED", "type": "rate_limit_error"}}`
+
+// upstreamTarget 创建使用替身服务的被测对象
+func upstreamTarget(t *testing.T, server *standIn, provider AIProvider, err error) conformanceTarget {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("failed to create provider: %v", err)
+	}
+	return conformanceTarget{
+		provider:      provider,
+		server:        server,
+		breakUpstream: func() { server.fail(http.StatusTooManyRequests, rateLimitBody) },
+	}
+}
+
+// openAITarget 创建使用 OpenAI 替身服务的被测对象
+// mode 为图像模式（为空时根据模型名称自动判断），stream 同时开启文本和图像的流式请求，
+// configure 用于调整其他设置；默认检查所有上游请求都携带 API Key
+func openAITarget(t *testing.T, server *openAIStandIn, mode string, stream bool, configure ...func(settings *types.AISettings)) conformanceTarget {
+	t.Helper()
+	settings := testSettings()
+	settings.OpenAIAPIKey = "test-key"
+	settings.OpenAIBaseURL = server.URL + "/v1"
+	settings.OpenAITextModel = "gpt-4o-mini"
+	settings.OpenAIImageModel = server.imageModel
+	settings.OpenAIImageMode = mode
+	settings.OpenAITextStream = stream
+	settings.OpenAIImageStream = stream
+	for _, fn := range configure {
+		fn(&settings)
+	}
+	provider, err := NewOpenAIProvider(context.Background(), settings)
+	target := upstreamTarget(t, server.standIn, provider, err)
+	target.authHeader, target.authValue = "Authorization", "Bearer test-key"
+	return target
+}
+
+// conformanceCases 所有提供商及其主要工作模式
+var conformanceCases = []struct {
+	name  string
+	setup func(t *testing.T) conformanceTarget
+}{
+	{
+		name: "openai/chat",
+		setup: func(t *testing.T) conformanceTarget {
+			return openAITarget(t, newOpenAIStandIn(t, "gpt-4o-image"), types.OpenAIImageModeChat, false)
+		},
+	},
+	{
+		name: "openai/chat-stream-markdown",
+		setup: func(t *testing.T) conformanceTarget {
+			server := newOpenAIStandIn(t, "gpt-4o-image")
+			server.chatImageFormat = "markdown"
+			return openAITarget(t, server, types.OpenAIImageModeChat, true)
+		},
+	},
+	{
+		name: "openai/chat-remote-url",
+		setup: func(t *testing.T) conformanceTarget {
+			// 中继服务返回图像链接，需要在本地下载为图像数据（下载请求不携带 API Key）
+			server := newOpenAIStandIn(t, "gpt-4o-image")
+			server.chatImageFormat = "url"
+			target := openAITarget(t, server, types.OpenAIImageModeChat, false)
+			target.authHeader = ""
+			return target
+		},
+	},
+	{
+		name: "openai/chat-base64",
+		setup: func(t *testing.T) conformanceTarget {
+			server := newOpenAIStandIn(t, "gpt-4o-image")
+			server.chatImageFormat = "base64"
+			return openAITarget(t, server, types.OpenAIImageModeChat, false)
+		},
+	},
+	{
+		name: "openai/images",
+		setup: func(t *testing.T) conformanceTarget {
+			// 图像 API 使用独立的 API Key，文本请求和图像请求的认证头不同
+			target := openAITarget(t, newOpenAIStandIn(t, "gpt-image-1"), "", false, func(settings *types.AISettings) {
+				settings.OpenAIImageAPIKey = "test-image-key"
+			})
+			target.authHeader = ""
+			return target
+		},
+	},
+	{
+		name: "openai/images-stream",
+		setup: func(t *testing.T) conformanceTarget {
+			target := openAITarget(t, newOpenAIStandIn(t, "gpt-image-1"), types.OpenAIImageModeImageAPI, true)
+			target.previews = true
+			return target
+		},
+	},
+	{
+		name: "gemini",
+		setup: func(t *testing.T) conformanceTarget {
+			server := newGeminiStandIn(t, "gemini-image")
+			// Gemini SDK 通过环境变量覆盖 API 地址
+			t.Setenv("GOOGLE_GEMINI_BASE_URL", server.URL)
+			settings := testSettings()
+			settings.APIKey = "test-key"
+			settings.TextModel = "gemini-text"
+			settings.ImageModel = "gemini-image"
+			provider, err := NewGeminiProvider(context.Background(), settings)
+			target := upstreamTarget(t, server.standIn, provider, err)
+			target.authHeader, target.authValue = "X-Goog-Api-Key", "test-key"
+			return target
+		},
+	},
+	{
+		name: "gemini/stream",
+		setup: func(t *testing.T) conformanceTarget {
+			server := newGeminiStandIn(t, "gemini-image")
+			t.Setenv("GOOGLE_GEMINI_BASE_URL", server.URL)
+			settings := testSettings()
+			settings.APIKey = "test-key"
+			settings.TextModel = "gemini-text"
+			settings.ImageModel = "gemini-image"
+			settings.GeminiStream = true
+			provider, err := NewGeminiProvider(context.Background(), settings)
+			target := upstreamTarget(t, server.standIn, provider, err)
+			target.authHeader, target.authValue = "X-Goog-Api-Key", "test-key"
+			target.previews = true
+			return target
+		},
+	},
+	{
+		name: "cloud",
+		setup: func(t *testing.T) conformanceTarget {
+			server := newCloudStandIn(t)
+			server.capabilities = map[string]interface{}{
+				"features": []string{"generateImage", "editImage", "enhancePrompt", "blendImages", "inpaint", "upscale"},
+			}
+			settings := testSettings()
+			settings.CloudEndpointURL = server.URL + "/"
+			settings.CloudToken = "test-token"
+			provider, err := NewCloudProvider(context.Background(), settings)
+			target := upstreamTarget(t, server.standIn, provider, err)
+			target.authHeader, target.authValue = "Authorization", "Bearer test-token"
+			return target
+		},
+	},
+	{
+		name: "cloud/async-legacy",
+		setup: func(t *testing.T) conformanceTarget {
+			// 未实现 /capabilities 的旧版服务，结果通过异步任务返回
+			server := newCloudStandIn(t)
+			server.async = true
+			settings := testSettings()
+			settings.CloudEndpointURL = server.URL
+			provider, err := NewCloudProvider(context.Background(), settings)
+			return upstreamTarget(t, server.standIn, provider, err)
+		},
+	},
+	{
+		name: "cloud/remote-url",
+		setup: func(t *testing.T) conformanceTarget {
+			// 服务端返回相对路径的图像链接，下载时同样携带认证信息
+			server := newCloudStandIn(t)
+			server.imageURLs = true
+			settings := testSettings()
+			settings.CloudEndpointURL = server.URL
+			settings.CloudToken = "test-token"
+			provider, err := NewCloudProvider(context.Background(), settings)
+			target := upstreamTarget(t, server.standIn, provider, err)
+			target.authHeader, target.authValue = "Authorization", "Bearer test-token"
+			return target
+		},
+	},
+	{
+		name: "ollama",
+		setup: func(t *testing.T) conformanceTarget {
+			server := newOllamaStandIn(t)
+			settings := testSettings()
+			settings.OllamaURL = server.URL
+			provider, err := NewOllamaProvider(context.Background(), settings)
+			return upstreamTarget(t, server, provider, err)
+		},
+	},
+	{
+		name: "sdwebui",
+		setup: func(t *testing.T) conformanceTarget {
+			server := newSDWebUIStandIn(t)
+			settings := testSettings()
+			settings.SDWebUIURL = server.URL
+			provider, err := NewSDWebUIProvider(context.Background(), settings)
+			return upstreamTarget(t, server, provider, err)
+		},
+	},
+	{
+		name: "comfyui",
+		setup: func(t *testing.T) conformanceTarget {
+			server := newComfyUIStandIn(t)
+			settings := testSettings()
+			settings.ComfyUIURL = server.URL
+			settings.ComfyUIWorkflows = map[string]string{
+				comfyUIWorkflowGenerate: testComfyUIWorkflow,
+				comfyUIWorkflowEdit:     testComfyUIWorkflow,
+				comfyUIWorkflowInpaint:  testComfyUIWorkflow,
+				comfyUIWorkflowBlend:    testComfyUIWorkflow,
+				comfyUIWorkflowUpscale:  testComfyUIWorkflow,
+			}
+			provider, err := NewComfyUIProvider(context.Background(), settings)
+			return upstreamTarget(t, server, provider, err)
+		},
+	},
+	{
+		name: "mock",
+		setup: func(t *testing.T) conformanceTarget {
+			provider, err := NewMockProvider(context.Background(), testSettings())
+			if err != nil {
+				t.Fatalf("failed to create provider: %v", err)
+			}
+			return conformanceTarget{
+				provider:      provider,
+				breakUpstream: func() { provider.settings.MockFailure = mockFailureRateLimit },
+			}
+		},
+	},
+}
+
+// TestProviderConformance 对每个提供商执行相同的调用序列，检查：
+//   - 声明支持的功能可以正常调用，返回可解码的图像（或非空文本）并上报用量
+//   - 声明不支持的功能返回错误，而不是返回空结果
+//   - 上游服务失败时所有功能都返回包含状态码的错误
+func TestProviderConformance(t *testing.T) {
+	const prompt = "a lighthouse at dusk"
+
+	for _, tc := range conformanceCases {
+		t.Run(tc.name, func(t *testing.T) {
+			target := tc.setup(t)
+			p := target.provider
+			t.Cleanup(func() { p.Close() })
+			caps := p.GetCapabilities()
+			ctx := context.Background()
+
+			t.Run("availability", func(t *testing.T) {
+				available, err := p.CheckAvailability(ctx)
+				if err != nil || !available {
+					t.Fatalf("CheckAvailability() = %v, %v; want true, nil", available, err)
+				}
+			})
+
+			t.Run("generate", func(t *testing.T) {
+				recorder := &conformanceRecorder{}
+				result, err := p.GenerateImage(recorder.attach(ctx), types.GenerateImageParams{
+					Prompt:      prompt,
+					ImageSize:   "1K",
+					AspectRatio: "1:1",
+					Count:       2,
+				})
+				if !caps.GenerateImage {
+					expectUnsupported(t, "GenerateImage", err)
+					return
+				}
+				if err != nil {
+					t.Fatalf("GenerateImage() error: %v", err)
+				}
+				if len(result.Images) != 2 {
+					t.Fatalf("GenerateImage() returned %d images, want 2", len(result.Images))
+				}
+				for _, img := range result.Images {
+					decodeTestImage(t, img)
+					if recorder.sawPreviewOf(img) {
+						t.Errorf("final image was reported as a preview")
+					}
+				}
+				if images := recorder.usageImages(); images != 2 {
+					t.Errorf("reported usage for %d images, want 2", images)
+				}
+				if target.previews != recorder.sawPreview() {
+					t.Errorf("preview images reported = %v, want %v", recorder.sawPreview(), target.previews)
+				}
+			})
+
+			t.Run("edit", func(t *testing.T) {
+				recorder := &conformanceRecorder{}
+				result, err := p.EditImage(recorder.attach(ctx), types.EditImageParams{
+					ImageData: testImageDataURL(t, 64, 64),
+					Prompt:    "make it night",
+				})
+				if !caps.EditImage {
+					expectUnsupported(t, "EditImage", err)
+					return
+				}
+				if err != nil {
+					t.Fatalf("EditImage() error: %v", err)
+				}
+				decodeTestImage(t, result)
+				if images := recorder.usageImages(); images != 1 {
+					t.Errorf("reported usage for %d images, want 1", images)
+				}
+			})
+
+			t.Run("inpaint", func(t *testing.T) {
+				if !caps.Inpaint {
+					t.Skip("inpainting not supported")
+				}
+				result, err := p.EditImage(ctx, types.EditImageParams{
+					ImageData: testImageDataURL(t, 64, 64),
+					Prompt:    "add a boat",
+					Mask:      testMaskDataURL(t, 64, 64),
+				})
+				if err != nil {
+					t.Fatalf("EditImage() with mask error: %v", err)
+				}
+				decodeTestImage(t, result)
+			})
+
+			t.Run("multi-edit", func(t *testing.T) {
+				images := []string{testImageDataURL(t, 64, 64), testImageDataURL(t, 32, 32)}
+				result, err := p.EditMultiImages(ctx, types.MultiImageEditParams{Images: images, Prompt: "blend them"})
+				if !caps.BlendImages {
+					expectUnsupported(t, "EditMultiImages", err)
+					return
+				}
+				if err != nil {
+					t.Fatalf("EditMultiImages() error: %v", err)
+				}
+				decodeTestImage(t, result)
+
+				// 少于两张图像时所有提供商都应拒绝请求
+				if _, err := p.EditMultiImages(ctx, types.MultiImageEditParams{Images: images[:1], Prompt: "blend them"}); err == nil {
+					t.Errorf("EditMultiImages() with a single image succeeded, want error")
+				}
+			})
+
+			t.Run("upscale", func(t *testing.T) {
+				if !caps.Upscale {
+					t.Skip("native upscaling not supported")
+				}
+				upscaler, ok := p.(ImageUpscaler)
+				if !ok {
+					t.Fatalf("provider declares Upscale but does not implement ImageUpscaler")
+				}
+				result, err := upscaler.UpscaleImage(ctx, types.UpscaleImageParams{ImageData: testImageDataURL(t, 64, 64), Scale: 2})
+				if err != nil {
+					t.Fatalf("UpscaleImage() error: %v", err)
+				}
+				decodeTestImage(t, result)
+			})
+
+			t.Run("enhance", func(t *testing.T) {
+				recorder := &conformanceRecorder{}
+				enhanced, err := p.EnhancePrompt(recorder.attach(ctx), prompt)
+				if !caps.EnhancePrompt {
+					expectUnsupported(t, "EnhancePrompt", err)
+					return
+				}
+				if err != nil {
+					t.Fatalf("EnhancePrompt() error: %v", err)
+				}
+				if strings.TrimSpace(enhanced) == "" || enhanced == prompt {
+					t.Errorf("EnhancePrompt() = %q, want an enhanced prompt", enhanced)
+				}
+				if !recorder.sawTokens() {
+					t.Errorf("no token usage reported")
+				}
+			})
+
+			if target.server != nil && target.authHeader != "" {
+				t.Run("auth", func(t *testing.T) {
+					target.server.mu.Lock()
+					defer target.server.mu.Unlock()
+					for _, req := range target.server.requests {
+						if got := req.Header.Get(target.authHeader); got != target.authValue {
+							t.Errorf("%s %s: %s = %q, want %q", req.Method, req.Path, target.authHeader, got, target.authValue)
+						}
+					}
+				})
+			}
+
+			// 错误路径放在最后：上游失败后不再恢复
+			t.Run("errors", func(t *testing.T) {
+				target.breakUpstream()
+
+				if target.server != nil {
+					if available, err := p.CheckAvailability(ctx); err == nil || available {
+						t.Errorf("CheckAvailability() = %v, %v; want false, error", available, err)
+					}
+				}
+
+				calls := []struct {
+					supported bool
+					name      string
+					call      func() error
+				}{
+					{caps.GenerateImage, "GenerateImage", func() error {
+						_, err := p.GenerateImage(ctx, types.GenerateImageParams{Prompt: prompt, Count: 2})
+						return err
+					}},
+					{caps.EditImage, "EditImage", func() error {
+						_, err := p.EditImage(ctx, types.EditImageParams{ImageData: testImageDataURL(t, 64, 64), Prompt: prompt})
+						return err
+					}},
+					{caps.BlendImages, "EditMultiImages", func() error {
+						_, err := p.EditMultiImages(ctx, types.MultiImageEditParams{
+							Images: []string{testImageDataURL(t, 64, 64), testImageDataURL(t, 64, 64)},
+							Prompt: prompt,
+						})
+						return err
+					}},
+					{caps.EnhancePrompt, "EnhancePrompt", func() error {
+						_, err := p.EnhancePrompt(ctx, prompt)
+						return err
+					}},
+				}
+				for _, c := range calls {
+					if !c.supported {
+						continue
+					}
+					err := c.call()
+					if err == nil {
+						t.Errorf("%s() succeeded against a failing upstream", c.name)
+						continue
+					}
+					if !strings.Contains(err.Error(), "429") {
+						t.Errorf("%s() error %q does not mention the upstream status", c.name, err)
+					}
+					if code := apperr.CodeOf(ClassifyError(err)); code != apperr.CodeQuotaExceeded {
+						t.Errorf("%s() error %q classified as %s, want %s", c.name, err, code, apperr.CodeQuotaExceeded)
+					}
+				}
+			})
+		})
+	}
+}
+
+// ==================== 辅助类型 ====================
+
+// conformanceRecorder 收集一次调用中上报的用量和进度
+type conformanceRecorder struct {
+	mu       sync.Mutex
+	usage    []Usage
+	progress []ProgressUpdate
+}
+
+// attach 在上下文中附加用量和进度回调
+func (r *conformanceRecorder) attach(ctx context.Context) context.Context {
+	ctx = WithUsageReporter(ctx, func(usage Usage) {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.usage = append(r.usage, usage)
+	})
+	return WithProgressReporter(ctx, func(update ProgressUpdate) {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.progress = append(r.progress, update)
+	})
+}
+
+// usageImages 返回上报的图像总数
+func (r *conformanceRecorder) usageImages() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	total := 0
+	for _, usage := range r.usage {
+		total += usage.Images
+	}
+	return total
+}
+
+// sawTokens 是否上报过 Token 用量
+func (r *conformanceRecorder) sawTokens() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, usage := range r.usage {
+		if usage.InputTokens > 0 || usage.OutputTokens > 0 || usage.TotalTokens > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// sawPreview 是否上报过预览图像
+func (r *conformanceRecorder) sawPreview() bool {
+	return r.sawPreviewOf("")
+}
+
+// sawPreviewOf 是否将指定图像作为预览上报过（image 为空时匹配任意预览图像）
+func (r *conformanceRecorder) sawPreviewOf(image string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, update := range r.progress {
+		if update.PartialImage != "" && (image == "" || update.PartialImage == image) {
+			return true
+		}
+	}
+	return false
+}
+
+// expectUnsupported 检查未声明支持的功能返回了错误
+func expectUnsupported(t *testing.T, method string, err error) {
+	t.Helper()
+	if err == nil {
+		t.Errorf("%s() succeeded although the capability is not declared", method)
+	}
+}
+
+// testMaskDataURL 返回画笔蒙版（左半部分不透明，为重绘区域）
+func testMaskDataURL(t *testing.T, width, height int) string {
+	t.Helper()
+	mask := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width/2; x++ {
+			mask.SetNRGBA(x, y, color.NRGBA{R: 255, A: 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, mask); err != nil {
+		t.Fatalf("failed to encode mask: %v", err)
+	}
+	return "data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes())
+}
@@ -0,0 +1,154 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"indraw/core/apperr"
+	"regexp"
+	"strconv"
+	"strings"
+
+	openai "github.com/sashabaranov/go-openai"
+	"google.golang.org/genai"
+)
+
+// ==================== 错误归类 ====================
+
+// statusPattern 匹配提供商错误信息中的 HTTP 状态码，如 "returned status 429"、"(status 401)"、"status code: 503"
+var statusPattern = regexp.MustCompile(`\bstatus(?: code)?:? \(?(\d{3})\b`)
+
+// ClassifyError 将提供商返回的错误归类为带错误代码的错误（见 apperr.Code）
+// 依次识别：已带代码的错误、模型拦截或拒绝（NoImageError）、取消和超时、
+// OpenAI / Gemini SDK 的 API 错误，以及自定义 HTTP 提供商错误信息中的状态码；
+// 错误信息保持不变，原始错误可通过 errors.As 取得
+func ClassifyError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var coded *apperr.Error
+	if errors.As(err, &coded) {
+		return apperr.From(err)
+	}
+
+	var noImage *NoImageError
+	if errors.As(err, &noImage) {
+		return classifyNoImageError(err, noImage)
+	}
+
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return apperr.From(err)
+	}
+
+	var openaiErr *openai.APIError
+	if errors.As(err, &openaiErr) {
+		code := codeForOpenAIError(openaiErr)
+		if code == "" {
+			code = apperr.CodeForStatus(openaiErr.HTTPStatusCode)
+		}
+		return withStatus(apperr.Wrap(code, err), openaiErr.HTTPStatusCode)
+	}
+
+	var requestErr *openai.RequestError
+	if errors.As(err, &requestErr) && requestErr.HTTPStatusCode > 0 {
+		return withStatus(apperr.Wrap(apperr.CodeForStatus(requestErr.HTTPStatusCode), err), requestErr.HTTPStatusCode)
+	}
+
+	if geminiErr, ok := asGeminiError(err); ok {
+		code := codeForGeminiError(geminiErr)
+		if code == "" {
+			code = apperr.CodeForStatus(geminiErr.Code)
+		}
+		return withStatus(apperr.Wrap(code, err), geminiErr.Code)
+	}
+
+	if match := statusPattern.FindStringSubmatch(err.Error()); match != nil {
+		status, _ := strconv.Atoi(match[1])
+		return withStatus(apperr.Wrap(apperr.CodeForStatus(status), err), status)
+	}
+
+	return apperr.From(err)
+}
+
+// classifyNoImageError 归类模型没有返回图像的错误：拦截和拒绝为 SAFETY_BLOCKED，
+// 附加信息中包含原因、原因代码和触发拦截的安全类别
+func classifyNoImageError(err error, result *NoImageError) error {
+	code := apperr.CodeUnknown
+	if result.Reason == NoImageBlocked || result.Reason == NoImageRefused {
+		code = apperr.CodeSafetyBlocked
+	}
+
+	coded := apperr.Wrap(code, err).With("reason", result.Reason)
+	if reasonCode := result.Code(); reasonCode != "" {
+		coded.With("reasonCode", reasonCode)
+	}
+	if categories := result.Categories(); len(categories) > 0 {
+		coded.With("categories", categories)
+	}
+	return coded
+}
+
+// codeForOpenAIError 根据 OpenAI 错误的 code / type 字段确定错误代码，无法确定时返回空字符串
+func codeForOpenAIError(e *openai.APIError) apperr.Code {
+	fields := []string{e.Type}
+	if code, ok := e.Code.(string); ok {
+		fields = append(fields, code)
+	}
+	if e.InnerError != nil {
+		fields = append(fields, e.InnerError.Code)
+	}
+
+	for _, field := range fields {
+		switch field {
+		case "invalid_api_key", "invalid_organization", "authentication_error", "permission_error":
+			return apperr.CodeAuthInvalid
+		case "insufficient_quota", "rate_limit_exceeded", "billing_hard_limit_reached":
+			return apperr.CodeQuotaExceeded
+		case "content_policy_violation", "content_filter", "moderation_blocked", "ResponsibleAIPolicyViolation":
+			return apperr.CodeSafetyBlocked
+		}
+	}
+	return ""
+}
+
+// asGeminiError 从错误链中取出 Gemini API 错误（SDK 以值类型返回）
+func asGeminiError(err error) (genai.APIError, bool) {
+	var value genai.APIError
+	if errors.As(err, &value) {
+		return value, true
+	}
+	var pointer *genai.APIError
+	if errors.As(err, &pointer) && pointer != nil {
+		return *pointer, true
+	}
+	return genai.APIError{}, false
+}
+
+// codeForGeminiError 根据 Gemini 错误的 status 字段确定错误代码，无法确定时返回空字符串
+// Gemini 对无效的 API 密钥返回 400 INVALID_ARGUMENT，需要按错误信息识别
+func codeForGeminiError(e genai.APIError) apperr.Code {
+	if strings.Contains(e.Message, "API_KEY_INVALID") || strings.Contains(e.Message, "API key not valid") {
+		return apperr.CodeAuthInvalid
+	}
+	switch e.Status {
+	case "UNAUTHENTICATED", "PERMISSION_DENIED":
+		return apperr.CodeAuthInvalid
+	case "RESOURCE_EXHAUSTED":
+		return apperr.CodeQuotaExceeded
+	case "INVALID_ARGUMENT", "FAILED_PRECONDITION", "NOT_FOUND", "OUT_OF_RANGE":
+		return apperr.CodeInvalidInput
+	case "UNAVAILABLE":
+		return apperr.CodeNetwork
+	case "DEADLINE_EXCEEDED":
+		return apperr.CodeTimeout
+	}
+	return ""
+}
+
+// withStatus 在附加信息中记录 HTTP 状态码
+func withStatus(e *apperr.Error, status int) *apperr.Error {
+	if status > 0 {
+		e.With("status", status)
+	}
+	return e
+}
@@ -0,0 +1,166 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"testing"
+
+	"indraw/core/apperr"
+	"indraw/core/types"
+
+	openai "github.com/sashabaranov/go-openai"
+	"google.golang.org/genai"
+)
+
+func TestClassifyError(t *testing.T) {
+	_, unsupportedErr := (&OllamaProvider{}).GenerateImage(context.Background(), types.GenerateImageParams{})
+
+	tests := []struct {
+		name       string
+		err        error
+		wantCode   apperr.Code
+		wantStatus int
+	}{
+		{
+			name:       "openai invalid key",
+			err:        &openai.APIError{HTTPStatusCode: 401, Code: "invalid_api_key", Message: "Incorrect API key provided"},
+			wantCode:   apperr.CodeAuthInvalid,
+			wantStatus: 401,
+		},
+		{
+			name:       "openai quota",
+			err:        fmt.Errorf("image generation failed: %w", &openai.APIError{HTTPStatusCode: 429, Code: "insufficient_quota", Type: "insufficient_quota"}),
+			wantCode:   apperr.CodeQuotaExceeded,
+			wantStatus: 429,
+		},
+		{
+			name:       "openai content policy",
+			err:        &openai.APIError{HTTPStatusCode: 400, Code: "content_policy_violation", Type: "invalid_request_error"},
+			wantCode:   apperr.CodeSafetyBlocked,
+			wantStatus: 400,
+		},
+		{
+			name:       "openai non-JSON error body",
+			err:        &openai.RequestError{HTTPStatusCode: 503, Err: errors.New("bad gateway")},
+			wantCode:   apperr.CodeNetwork,
+			wantStatus: 503,
+		},
+		{
+			name:       "gemini invalid key",
+			err:        genai.APIError{Code: 400, Status: "INVALID_ARGUMENT", Message: "API key not valid. Please pass a valid API key."},
+			wantCode:   apperr.CodeAuthInvalid,
+			wantStatus: 400,
+		},
+		{
+			name:       "gemini quota",
+			err:        fmt.Errorf("failed to generate image: %w", genai.APIError{Code: 429, Status: "RESOURCE_EXHAUSTED"}),
+			wantCode:   apperr.CodeQuotaExceeded,
+			wantStatus: 429,
+		},
+		{
+			name:       "gemini invalid argument",
+			err:        genai.APIError{Code: 400, Status: "INVALID_ARGUMENT", Message: "Unsupported MIME type"},
+			wantCode:   apperr.CodeInvalidInput,
+			wantStatus: 400,
+		},
+		{
+			name:       "custom provider status",
+			err:        errors.New("ComfyUI rejected workflow (status 401): unauthorized"),
+			wantCode:   apperr.CodeAuthInvalid,
+			wantStatus: 401,
+		},
+		{
+			name:       "custom provider gateway timeout",
+			err:        errors.New("cloud API returned status 504: upstream timed out"),
+			wantCode:   apperr.CodeTimeout,
+			wantStatus: 504,
+		},
+		{
+			name:     "safety block",
+			err:      &NoImageError{Reason: NoImageBlocked, FinishReason: "IMAGE_SAFETY"},
+			wantCode: apperr.CodeSafetyBlocked,
+		},
+		{
+			name:     "refusal",
+			err:      &NoImageError{Reason: NoImageRefused, Text: "I can't help with that."},
+			wantCode: apperr.CodeSafetyBlocked,
+		},
+		{
+			name:     "text only",
+			err:      &NoImageError{Reason: NoImageTextOnly, Text: "Here is a description."},
+			wantCode: apperr.CodeUnknown,
+		},
+		{
+			name:     "unsupported feature",
+			err:      unsupportedErr,
+			wantCode: apperr.CodeUnsupportedFeature,
+		},
+		{
+			name:     "cancelled",
+			err:      fmt.Errorf("request failed: %w", context.Canceled),
+			wantCode: apperr.CodeCancelled,
+		},
+		{
+			name:     "deadline",
+			err:      fmt.Errorf("request failed: %w", context.DeadlineExceeded),
+			wantCode: apperr.CodeTimeout,
+		},
+		{
+			name:     "connection refused",
+			err:      fmt.Errorf("request failed: %w", &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}),
+			wantCode: apperr.CodeNetwork,
+		},
+		{
+			name:     "unclassified",
+			err:      errors.New("something went wrong"),
+			wantCode: apperr.CodeUnknown,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := apperr.From(ClassifyError(tt.err))
+			if got.Code != tt.wantCode {
+				t.Errorf("code = %s, want %s", got.Code, tt.wantCode)
+			}
+			if got.Message != tt.err.Error() {
+				t.Errorf("message = %q, want the original %q", got.Message, tt.err.Error())
+			}
+			if status, _ := got.Details["status"].(int); status != tt.wantStatus {
+				t.Errorf("details.status = %v, want %d", got.Details["status"], tt.wantStatus)
+			}
+			// genai.APIError 含切片字段，不可比较
+			if reflect.TypeOf(tt.err).Comparable() && !errors.Is(got, tt.err) {
+				t.Error("original error is not in the error chain")
+			}
+		})
+	}
+
+	t.Run("serialized for the frontend", func(t *testing.T) {
+		err := fmt.Errorf("all providers failed (gemini, openai), last error: %w",
+			ClassifyError(&NoImageError{Reason: NoImageBlocked, BlockReason: "SAFETY", SafetyRatings: []SafetyRating{{Category: "HARM_CATEGORY_DANGEROUS_CONTENT", Blocked: true}}}))
+		data, jsonErr := json.Marshal(apperr.Format(err))
+		if jsonErr != nil {
+			t.Fatalf("json.Marshal() error = %v", jsonErr)
+		}
+
+		var got struct {
+			Code    string                 `json:"code"`
+			Message string                 `json:"message"`
+			Details map[string]interface{} `json:"details"`
+		}
+		if jsonErr := json.Unmarshal(data, &got); jsonErr != nil {
+			t.Fatalf("json.Unmarshal() error = %v", jsonErr)
+		}
+		if got.Code != "SAFETY_BLOCKED" || got.Message != err.Error() {
+			t.Errorf("serialized = %s, want code SAFETY_BLOCKED and the full message", data)
+		}
+		if got.Details["reasonCode"] != "SAFETY" || fmt.Sprint(got.Details["categories"]) != "[dangerous content]" {
+			t.Errorf("details = %v, want the block reason and categories", got.Details)
+		}
+	})
+}
@@ -4,15 +4,21 @@ import (
 	"context"
 	"encoding/base64"
 	"fmt"
+	"indraw/core/apperr"
 	"indraw/core/types"
+	"net/http"
 	"strings"
 	"time"
 
 	"cloud.google.com/go/auth"
 	"cloud.google.com/go/auth/credentials"
+	"cloud.google.com/go/auth/httptransport"
 	"google.golang.org/genai"
 )
 
+// geminiUpscaleModel Vertex AI 图像放大使用的 Imagen 模型
+const geminiUpscaleModel = "imagen-3.0-generate-002"
+
 // ==================== Gemini 能力声明 ====================
 
 // geminiCapabilities Gemini 提供商的功能支持矩阵
@@ -23,6 +29,9 @@ var geminiCapabilities = ProviderCapabilities{
 	BlendImages:      true,
 	RemoveBackground: true,
 	ReferenceImage:   true,
+	Inpaint:          true,
+	ExtendImage:      true,
+	Upscale:          false, // 仅 Vertex AI 后端支持，见 GetCapabilities
 }
 
 // ==================== GeminiProvider 实现 ====================
@@ -85,22 +94,40 @@ func createGeminiClient(ctx context.Context, settings types.AISettings) (*genai.
 			}
 		}
 
+		// 创建带认证和重试的 HTTP 客户端
+		quotaProjectID, qpErr := cre.QuotaProjectID(ctx)
+		if qpErr != nil {
+			return nil, fmt.Errorf("failed to get quota project ID: %w", qpErr)
+		}
+		httpClient, httpErr := httptransport.NewClient(&httptransport.Options{
+			Credentials: cre,
+			Headers: http.Header{
+				"X-Goog-User-Project": []string{quotaProjectID},
+			},
+			BaseRoundTripper: newRetryTransport(http.DefaultTransport, RetryPolicyFromSettings(settings)),
+		})
+		if httpErr != nil {
+			return nil, fmt.Errorf("failed to create HTTP client: %w", httpErr)
+		}
+
 		// 创建 Vertex AI 客户端
 		client, err = genai.NewClient(ctx, &genai.ClientConfig{
 			Project:     settings.VertexProject,
 			Location:    settings.VertexLocation,
 			Backend:     genai.BackendVertexAI,
 			Credentials: cre,
+			HTTPClient:  httpClient,
 		})
 	} else {
 		// Gemini API 模式
 		if settings.APIKey == "" {
-			return nil, fmt.Errorf("Gemini API key not configured")
+			return nil, apperr.New(apperr.CodeAuthInvalid, "Gemini API key not configured")
 		}
 
 		client, err = genai.NewClient(ctx, &genai.ClientConfig{
-			APIKey:  settings.APIKey,
-			Backend: genai.BackendGeminiAPI,
+			APIKey:     settings.APIKey,
+			Backend:    genai.BackendGeminiAPI,
+			HTTPClient: newHTTPClient(settings, 0),
 		})
 	}
 
@@ -118,7 +145,10 @@ func (p *GeminiProvider) Name() string {
 
 // GetCapabilities 返回提供商支持的功能
 func (p *GeminiProvider) GetCapabilities() ProviderCapabilities {
-	return geminiCapabilities
+	caps := geminiCapabilities
+	// Imagen 图像放大仅在 Vertex AI 后端可用
+	caps.Upscale = p.settings.UseVertexAI
+	return caps
 }
 
 // CheckAvailability 检测服务可用性
@@ -160,40 +190,26 @@ func (p *GeminiProvider) Close() error {
 }
 
 // GenerateImage 生成图像
-func (p *GeminiProvider) GenerateImage(ctx context.Context, params types.GenerateImageParams) (string, error) {
+func (p *GeminiProvider) GenerateImage(ctx context.Context, params types.GenerateImageParams) (*ImageResult, error) {
 	// 构建内容部分
 	parts := []*genai.Part{{Text: params.Prompt}}
 
 	// 如果有草图图像，添加到请求中（优先使用草图）
 	if params.SketchImage != "" {
-		imageData := extractBase64Data(params.SketchImage)
-		decodedData, err := base64.StdEncoding.DecodeString(imageData)
+		sketch, err := normalizeInputImage(params.SketchImage, p.inputPolicy())
 		if err != nil {
-			return "", fmt.Errorf("failed to decode sketch image: %w", err)
+			return nil, fmt.Errorf("invalid sketch image: %w", err)
 		}
-
-		parts = append(parts, &genai.Part{
-			InlineData: &genai.Blob{
-				MIMEType: "image/png",
-				Data:     decodedData,
-			},
-		})
+		parts = append(parts, inlineImagePart(sketch))
 	}
 
 	// 如果有参考图像，也添加到请求中（可以同时使用草图和参考图）
 	if params.ReferenceImage != "" {
-		imageData := extractBase64Data(params.ReferenceImage)
-		decodedData, err := base64.StdEncoding.DecodeString(imageData)
+		reference, err := normalizeInputImage(params.ReferenceImage, p.inputPolicy())
 		if err != nil {
-			return "", fmt.Errorf("failed to decode reference image: %w", err)
+			return nil, fmt.Errorf("invalid reference image: %w", err)
 		}
-
-		parts = append(parts, &genai.Part{
-			InlineData: &genai.Blob{
-				MIMEType: "image/png",
-				Data:     decodedData,
-			},
-		})
+		parts = append(parts, inlineImagePart(reference))
 	}
 
 	content := &genai.Content{
@@ -204,44 +220,56 @@ func (p *GeminiProvider) GenerateImage(ctx context.Context, params types.Generat
 	// 设置生成参数
 	temperature := float32(0.9)
 	topP := float32(0.95)
-
-	// 调用 Gemini API
-	response, err := p.client.Models.GenerateContent(ctx, p.settings.ImageModel,
-		[]*genai.Content{content},
-		&genai.GenerateContentConfig{
-			Temperature:        &temperature,
-			TopP:               &topP,
-			MaxOutputTokens:    32768,
-			ResponseModalities: []string{"text", "image"},
-			ImageConfig: &genai.ImageConfig{
-				ImageSize:   params.ImageSize,
-				AspectRatio: params.AspectRatio,
-			},
-		})
-
-	if err != nil {
-		return "", fmt.Errorf("gemini API error: %w", err)
+	config := &genai.GenerateContentConfig{
+		Temperature:        &temperature,
+		TopP:               &topP,
+		MaxOutputTokens:    32768,
+		ResponseModalities: []string{"text", "image"},
+		ImageConfig: &genai.ImageConfig{
+			ImageSize:   params.ImageSize,
+			AspectRatio: params.AspectRatio,
+		},
 	}
 
-	return extractImageFromGeminiResponse(response)
+	// Gemini 图像模型不支持 candidateCount > 1，多张候选图像通过并发请求实现
+	return generateConcurrently(ctx, params.Count, func(ctx context.Context) ([]string, error) {
+		response, err := p.generateImageContent(ctx, p.settings.ImageModel,
+			[]*genai.Content{content}, config)
+		if err != nil {
+			return nil, fmt.Errorf("gemini API error: %w", err)
+		}
+		return extractImagesFromGeminiResponse(response)
+	})
 }
 
 // EditImage 编辑图像
 func (p *GeminiProvider) EditImage(ctx context.Context, params types.EditImageParams) (string, error) {
-	// 解码图像数据
-	imageData := extractBase64Data(params.ImageData)
-	decodedData, err := base64.StdEncoding.DecodeString(imageData)
+	// 规范化输入图像
+	image, err := normalizeInputImage(params.ImageData, p.inputPolicy())
 	if err != nil {
-		return "", fmt.Errorf("failed to decode image: %w", err)
+		return "", err
 	}
 
 	// 构建编辑请求
 	parts := []*genai.Part{
 		{Text: params.Prompt},
-		{InlineData: &genai.Blob{
-			MIMEType: "image/png",
-			Data:     decodedData,
-		}},
+		inlineImagePart(image),
+	}
+
+	// 如果有蒙版，附加局部重绘说明和黑白蒙版图像（尺寸与规范化后的图像一致）
+	if params.Mask != "" {
+		maskData, err := buildMaskPreviewPNG(params.Mask, image.Width, image.Height)
+		if err != nil {
+			return "", err
+		}
+
+		parts[0].Text = buildInpaintPrompt(params.Prompt)
+		parts = append(parts, &genai.Part{
+			InlineData: &genai.Blob{
+				MIMEType: "image/png",
+				Data:     maskData,
+			},
+		})
 	}
 
 	content := &genai.Content{
@@ -254,7 +282,7 @@ func (p *GeminiProvider) EditImage(ctx context.Context, params types.EditImagePa
 	topP := float32(0.95)
 
 	// 调用 API
-	response, err := p.client.Models.GenerateContent(ctx, p.settings.ImageModel,
+	response, err := p.generateImageContent(ctx, p.settings.ImageModel,
 		[]*genai.Content{content},
 		&genai.GenerateContentConfig{
 			Temperature:        &temperature,
@@ -283,18 +311,11 @@ func (p *GeminiProvider) EditMultiImages(ctx context.Context, params types.Multi
 
 	// 添加所有图片
 	for i, img := range params.Images {
-		imageData := extractBase64Data(img)
-		decodedData, err := base64.StdEncoding.DecodeString(imageData)
+		image, err := normalizeInputImage(img, p.inputPolicy())
 		if err != nil {
-			return "", fmt.Errorf("failed to decode image %d: %w", i, err)
+			return "", fmt.Errorf("invalid image %d: %w", i, err)
 		}
-
-		parts = append(parts, &genai.Part{
-			InlineData: &genai.Blob{
-				MIMEType: "image/png",
-				Data:     decodedData,
-			},
-		})
+		parts = append(parts, inlineImagePart(image))
 	}
 
 	content := &genai.Content{
@@ -307,7 +328,7 @@ func (p *GeminiProvider) EditMultiImages(ctx context.Context, params types.Multi
 	topP := float32(0.95)
 
 	// 调用 API
-	response, err := p.client.Models.GenerateContent(ctx, p.settings.ImageModel,
+	response, err := p.generateImageContent(ctx, p.settings.ImageModel,
 		[]*genai.Content{content},
 		&genai.GenerateContentConfig{
 			Temperature:        &temperature,
@@ -323,6 +344,52 @@ func (p *GeminiProvider) EditMultiImages(ctx context.Context, params types.Multi
 	return extractImageFromGeminiResponse(response)
 }
 
+// UpscaleImage 放大图像（仅 Vertex AI 后端）
+func (p *GeminiProvider) UpscaleImage(ctx context.Context, params types.UpscaleImageParams) (string, error) {
+	if !p.settings.UseVertexAI {
+		return "", fmt.Errorf("image upscaling requires the Vertex AI backend")
+	}
+
+	// 放大保留原始分辨率，只做格式转换和元数据去除
+	input, err := normalizeInputImage(params.ImageData, p.inputPolicy().withoutDownscale())
+	if err != nil {
+		return "", err
+	}
+
+	response, err := p.client.Models.UpscaleImage(ctx, geminiUpscaleModel,
+		&genai.Image{ImageBytes: input.Data, MIMEType: input.MIMEType},
+		fmt.Sprintf("x%d", params.Scale),
+		&genai.UpscaleImageConfig{
+			OutputMIMEType: "image/png",
+		})
+	if err != nil {
+		return "", fmt.Errorf("Gemini upscale API error: %w", err)
+	}
+
+	if response == nil || len(response.GeneratedImages) == 0 || response.GeneratedImages[0].Image == nil {
+		return "", fmt.Errorf("no image data found in response")
+	}
+
+	reportUsage(ctx, Usage{Provider: p.Name(), Model: geminiUpscaleModel, Images: 1})
+
+	image := response.GeneratedImages[0].Image
+	mimeType := image.MIMEType
+	if mimeType == "" {
+		mimeType = "image/png"
+	}
+	return fmt.Sprintf("data:%s;base64,%s", mimeType, base64.StdEncoding.EncodeToString(image.ImageBytes)), nil
+}
+
+// inputPolicy 返回应用用户配置后的输入图像要求
+func (p *GeminiProvider) inputPolicy() imageInputPolicy {
+	return geminiInputPolicy.withSettings(p.settings)
+}
+
+// inlineImagePart 构建内联图像部分
+func inlineImagePart(image inputImage) *genai.Part {
+	return &genai.Part{InlineData: &genai.Blob{MIMEType: image.MIMEType, Data: image.Data}}
+}
+
 // EnhancePrompt 增强提示词
 func (p *GeminiProvider) EnhancePrompt(ctx context.Context, prompt string) (string, error) {
 	// 构建增强提示词的系统提示
@@ -352,6 +419,7 @@ func (p *GeminiProvider) EnhancePrompt(ctx context.Context, prompt string) (stri
 	if err != nil {
 		return "", fmt.Errorf("gemini prompt enhancement error: %w", err)
 	}
+	reportUsage(ctx, geminiUsage(p.settings.TextModel, response.UsageMetadata, 0))
 
 	// 提取增强后的文本
 	if len(response.Candidates) > 0 && response.Candidates[0].Content != nil && len(response.Candidates[0].Content.Parts) > 0 {
@@ -365,8 +433,141 @@ func (p *GeminiProvider) EnhancePrompt(ctx context.Context, prompt string) (stri
 	return prompt, nil
 }
 
+// ==================== 流式请求处理 ====================
+
+// generateImageContent 调用图像模型的 GenerateContent
+// 开启 GeminiStream 时使用流式接口并上报进度，否则使用普通请求；两种方式都会上报用量
+func (p *GeminiProvider) generateImageContent(ctx context.Context, model string, contents []*genai.Content, config *genai.GenerateContentConfig) (*genai.GenerateContentResponse, error) {
+	if p.settings.GeminiStream {
+		return p.generateContentStream(ctx, model, contents, config)
+	}
+
+	response, err := p.client.Models.GenerateContent(ctx, model, contents, config)
+	if err != nil {
+		return nil, err
+	}
+	reportGeminiUsage(ctx, model, response)
+	return response, nil
+}
+
+// generateContentStream 以流式方式调用 GenerateContent，并将所有分片合并为一个完整响应
+// 流式过程中上报接收字节数、文本说明和模型思考过程中的预览图像；
+// 最终结果图像不作为预览上报，思考过程的部分不会出现在合并后的响应中
+func (p *GeminiProvider) generateContentStream(ctx context.Context, model string, contents []*genai.Content, config *genai.GenerateContentConfig) (*genai.GenerateContentResponse, error) {
+	tracker := newProgressTracker(ctx)
+
+	var merged *genai.GenerateContentResponse
+	var candidate *genai.Candidate
+	var parts []*genai.Part
+	previewIndex := 0
+
+	for chunk, err := range p.client.Models.GenerateContentStream(ctx, model, contents, config) {
+		if err != nil {
+			return nil, err
+		}
+
+		if merged == nil {
+			merged = &genai.GenerateContentResponse{}
+			candidate = &genai.Candidate{}
+		}
+		// 元数据以最后出现的为准
+		if chunk.PromptFeedback != nil {
+			merged.PromptFeedback = chunk.PromptFeedback
+		}
+		if chunk.UsageMetadata != nil {
+			merged.UsageMetadata = chunk.UsageMetadata
+		}
+		if chunk.ModelVersion != "" {
+			merged.ModelVersion = chunk.ModelVersion
+		}
+		if chunk.ResponseID != "" {
+			merged.ResponseID = chunk.ResponseID
+		}
+
+		if len(chunk.Candidates) == 0 {
+			continue
+		}
+		chunkCandidate := chunk.Candidates[0]
+		if chunkCandidate.FinishReason != "" {
+			candidate.FinishReason = chunkCandidate.FinishReason
+			candidate.FinishMessage = chunkCandidate.FinishMessage
+		}
+		if len(chunkCandidate.SafetyRatings) > 0 {
+			candidate.SafetyRatings = chunkCandidate.SafetyRatings
+		}
+		if chunkCandidate.Content == nil {
+			continue
+		}
+
+		for _, part := range chunkCandidate.Content.Parts {
+			switch {
+			case part.InlineData != nil:
+				tracker.addBytes(len(part.InlineData.Data))
+				if part.Thought && strings.HasPrefix(part.InlineData.MIMEType, "image/") {
+					encoded := base64.StdEncoding.EncodeToString(part.InlineData.Data)
+					tracker.partialImage(fmt.Sprintf("data:%s;base64,%s", part.InlineData.MIMEType, encoded), previewIndex)
+					previewIndex++
+				}
+			case part.Text != "":
+				tracker.addBytes(len(part.Text))
+				tracker.text(part.Text)
+			}
+
+			if part.Thought {
+				continue
+			}
+
+			// 相邻的文本分片合并为一个部分
+			if part.Text != "" && part.InlineData == nil && len(parts) > 0 {
+				last := parts[len(parts)-1]
+				if last.Text != "" && last.InlineData == nil {
+					last.Text += part.Text
+					continue
+				}
+			}
+			parts = append(parts, part)
+		}
+	}
+
+	if merged == nil {
+		return nil, fmt.Errorf("no content generated")
+	}
+
+	candidate.Content = &genai.Content{Role: genai.RoleModel, Parts: parts}
+	merged.Candidates = []*genai.Candidate{candidate}
+	reportGeminiUsage(ctx, model, merged)
+	return merged, nil
+}
+
+// reportGeminiUsage 上报一次图像模型调用的用量（仅统计最终结果中的图像，不含思考过程的预览图像）
+func reportGeminiUsage(ctx context.Context, model string, response *genai.GenerateContentResponse) {
+	images := 0
+	for _, candidate := range response.Candidates {
+		if candidate.Content == nil {
+			continue
+		}
+		for _, part := range candidate.Content.Parts {
+			if !part.Thought && part.InlineData != nil && strings.HasPrefix(part.InlineData.MIMEType, "image/") {
+				images++
+			}
+		}
+	}
+	reportUsage(ctx, geminiUsage(model, response.UsageMetadata, images))
+}
+
 // ==================== 辅助函数 ====================
 
+// geminiUsage 将 Gemini 用量元数据转换为统一的用量结构
+func geminiUsage(model string, metadata *genai.GenerateContentResponseUsageMetadata, images int) Usage {
+	usage := Usage{Provider: "gemini", Model: model, Images: images}
+	if metadata != nil {
+		usage.InputTokens = int64(metadata.PromptTokenCount)
+		usage.OutputTokens = int64(metadata.CandidatesTokenCount) + int64(metadata.ThoughtsTokenCount)
+		usage.TotalTokens = int64(metadata.TotalTokenCount)
+	}
+	return usage
+}
+
 // extractBase64Data 从 data URL 中提取 base64 数据
 func extractBase64Data(dataURL string) string {
 	parts := strings.Split(dataURL, ",")
@@ -376,24 +577,107 @@ func extractBase64Data(dataURL string) string {
 	return dataURL
 }
 
-// extractImageFromGeminiResponse 从 Gemini 响应中提取图像数据
+// extractImageFromGeminiResponse 从 Gemini 响应中提取第一张图像数据
 func extractImageFromGeminiResponse(response *genai.GenerateContentResponse) (string, error) {
-	if response == nil || len(response.Candidates) == 0 {
-		return "", fmt.Errorf("no content generated")
+	images, err := extractImagesFromGeminiResponse(response)
+	if err != nil {
+		return "", err
 	}
+	return images[0], nil
+}
 
+// extractImagesFromGeminiResponse 从 Gemini 响应中提取所有图像数据
+// 没有图像时返回 *NoImageError，携带拦截原因、安全评级和模型返回的文本
+func extractImagesFromGeminiResponse(response *genai.GenerateContentResponse) ([]string, error) {
+	if response == nil {
+		return nil, &NoImageError{Reason: NoImageEmpty}
+	}
+
+	var images []string
 	for _, candidate := range response.Candidates {
 		if candidate.Content == nil {
 			continue
 		}
 
 		for _, part := range candidate.Content.Parts {
+			// 思考过程中的预览图像不是最终结果
+			if part.Thought {
+				continue
+			}
 			if part.InlineData != nil && strings.HasPrefix(part.InlineData.MIMEType, "image/") {
 				encoded := base64.StdEncoding.EncodeToString(part.InlineData.Data)
-				return fmt.Sprintf("data:%s;base64,%s", part.InlineData.MIMEType, encoded), nil
+				images = append(images, fmt.Sprintf("data:%s;base64,%s", part.InlineData.MIMEType, encoded))
 			}
 		}
 	}
 
-	return "", fmt.Errorf("no image data found in response")
+	if len(images) == 0 {
+		return nil, geminiNoImageError(response)
+	}
+
+	return images, nil
+}
+
+// geminiBlockedFinishReasons 表示生成内容被拦截的结束原因
+var geminiBlockedFinishReasons = map[string]bool{
+	string(genai.FinishReasonSafety):                 true,
+	string(genai.FinishReasonRecitation):             true,
+	string(genai.FinishReasonBlocklist):              true,
+	string(genai.FinishReasonProhibitedContent):      true,
+	string(genai.FinishReasonSPII):                   true,
+	string(genai.FinishReasonImageSafety):            true,
+	string(genai.FinishReasonImageProhibitedContent): true,
+	"IMAGE_RECITATION":                               true,
+}
+
+// geminiNoImageError 从没有图像的 Gemini 响应中提取结束原因、拦截原因、安全评级和文本
+func geminiNoImageError(response *genai.GenerateContentResponse) *NoImageError {
+	result := &NoImageError{}
+	if feedback := response.PromptFeedback; feedback != nil {
+		if feedback.BlockReason != "" && feedback.BlockReason != genai.BlockedReasonUnspecified {
+			result.BlockReason = string(feedback.BlockReason)
+			result.Message = feedback.BlockReasonMessage
+			result.SafetyRatings = geminiSafetyRatings(feedback.SafetyRatings)
+		}
+	}
+
+	var text strings.Builder
+	for _, candidate := range response.Candidates {
+		if result.FinishReason == "" && candidate.FinishReason != genai.FinishReasonUnspecified {
+			result.FinishReason = string(candidate.FinishReason)
+			if result.Message == "" {
+				result.Message = candidate.FinishMessage
+			}
+		}
+		if result.BlockReason == "" {
+			result.SafetyRatings = append(result.SafetyRatings, geminiSafetyRatings(candidate.SafetyRatings)...)
+		}
+		if candidate.Content == nil {
+			continue
+		}
+		for _, part := range candidate.Content.Parts {
+			if part.Text != "" && !part.Thought {
+				text.WriteString(part.Text)
+			}
+		}
+	}
+	result.Text = strings.TrimSpace(text.String())
+
+	return classifyNoImage(result, geminiBlockedFinishReasons)
+}
+
+// geminiSafetyRatings 转换 Gemini 安全评级
+func geminiSafetyRatings(ratings []*genai.SafetyRating) []SafetyRating {
+	var result []SafetyRating
+	for _, rating := range ratings {
+		if rating == nil {
+			continue
+		}
+		result = append(result, SafetyRating{
+			Category:    string(rating.Category),
+			Probability: string(rating.Probability),
+			Blocked:     rating.Blocked,
+		})
+	}
+	return result
 }
@@ -0,0 +1,77 @@
+package provider
+
+import (
+	"strings"
+	"testing"
+
+	"google.golang.org/genai"
+)
+
+func TestGeminiNoImageError(t *testing.T) {
+	tests := []struct {
+		name           string
+		response       *genai.GenerateContentResponse
+		wantReason     string
+		wantCode       string
+		wantCategories []string
+		wantText       string
+	}{
+		{
+			name: "prompt blocked",
+			response: &genai.GenerateContentResponse{PromptFeedback: &genai.GenerateContentResponsePromptFeedback{
+				BlockReason: genai.BlockedReasonSafety,
+				SafetyRatings: []*genai.SafetyRating{
+					{Category: genai.HarmCategoryHarassment, Probability: genai.HarmProbabilityLow},
+					{Category: genai.HarmCategoryDangerousContent, Probability: genai.HarmProbabilityHigh, Blocked: true},
+				},
+			}},
+			wantReason:     NoImageBlocked,
+			wantCode:       "SAFETY",
+			wantCategories: []string{"dangerous content"},
+		},
+		{
+			name: "output image blocked",
+			response: &genai.GenerateContentResponse{Candidates: []*genai.Candidate{{
+				FinishReason:  genai.FinishReasonImageSafety,
+				SafetyRatings: []*genai.SafetyRating{{Category: genai.HarmCategorySexuallyExplicit, Probability: genai.HarmProbabilityHigh}},
+			}}},
+			wantReason:     NoImageBlocked,
+			wantCode:       "IMAGE_SAFETY",
+			wantCategories: []string{"sexually explicit"},
+		},
+		{
+			name: "text-only reply",
+			response: &genai.GenerateContentResponse{Candidates: []*genai.Candidate{{
+				FinishReason: genai.FinishReasonStop,
+				Content: &genai.Content{Parts: []*genai.Part{
+					{Text: "thinking...", Thought: true},
+					{Text: "I can only describe images. "},
+				}},
+			}}},
+			wantReason: NoImageTextOnly,
+			wantCode:   "STOP",
+			wantText:   "I can only describe images.",
+		},
+		{
+			name:       "no candidates",
+			response:   &genai.GenerateContentResponse{},
+			wantReason: NoImageEmpty,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := extractImagesFromGeminiResponse(tt.response)
+			result, ok := err.(*NoImageError)
+			if !ok {
+				t.Fatalf("error = %#v, want *NoImageError", err)
+			}
+			if result.Reason != tt.wantReason || result.Code() != tt.wantCode || result.Text != tt.wantText {
+				t.Errorf("result = %+v, want reason %q, code %q, text %q", result, tt.wantReason, tt.wantCode, tt.wantText)
+			}
+			if got := strings.Join(result.Categories(), ","); got != strings.Join(tt.wantCategories, ",") {
+				t.Errorf("Categories() = %q, want %q", got, tt.wantCategories)
+			}
+		})
+	}
+}
@@ -0,0 +1,677 @@
+package provider
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"indraw/core/types"
+	"io"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// ==================== 测试替身服务 ====================
+
+// recordedRequest 替身服务收到的请求
+type recordedRequest struct {
+	Method string
+	Path   string
+	Header http.Header
+	Body   []byte
+}
+
+// standIn 模拟上游 API 的本地 HTTP 服务
+// 记录收到的所有请求；调用 fail 后所有请求都返回指定的错误响应，用于测试错误路径
+type standIn struct {
+	*httptest.Server
+
+	mu         sync.Mutex
+	requests   []recordedRequest
+	failStatus int
+	failBody   string
+}
+
+// newStandIn 启动替身服务，测试结束时自动关闭
+func newStandIn(t *testing.T, handler http.Handler) *standIn {
+	t.Helper()
+	s := &standIn{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		s.mu.Lock()
+		s.requests = append(s.requests, recordedRequest{Method: r.Method, Path: r.URL.Path, Header: r.Header.Clone(), Body: body})
+		status, failBody := s.failStatus, s.failBody
+		s.mu.Unlock()
+
+		if status != 0 {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(status)
+			io.WriteString(w, failBody)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// fail 使之后的所有请求返回指定的状态码和响应体
+func (s *standIn) fail(status int, body string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failStatus, s.failBody = status, body
+}
+
+// requestsTo 返回发送到指定路径的请求
+func (s *standIn) requestsTo(path string) []recordedRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var matched []recordedRequest
+	for _, req := range s.requests {
+		if req.Path == path {
+			matched = append(matched, req)
+		}
+	}
+	return matched
+}
+
+// ==================== OpenAI 替身 ====================
+
+// openAIStandIn OpenAI Chat / Images API 替身
+//   - /v1/chat/completions：请求图像模型时返回图像（格式由 chatImageFormat 决定），其他模型返回增强后的提示词；
+//     stream=true 时以 SSE 分片返回，最后一个分片携带用量；rejectStreamOptions 为 true 时拒绝带 stream_options 的请求（400）
+//   - /v1/images/generations：按 n 返回图像；stream=true 时依次返回预览图像和完成事件
+//   - /v1/images/edits：校验 multipart 中的 image 文件后返回一张图像
+//   - /files/{name}：chatImageFormat 为 "url" 时图像链接指向的文件
+type openAIStandIn struct {
+	*standIn
+	imageModel      string
+	chatImageFormat string // "data"（默认）、"markdown"、"base64"、"url"（markdown 中的图像链接）
+	image           []byte
+	preview         []byte // 流式图像生成的预览图像
+
+	rejectStreamOptions bool // 模拟不支持 stream_options 参数的中继服务
+}
+
+func newOpenAIStandIn(t *testing.T, imageModel string) *openAIStandIn {
+	o := &openAIStandIn{imageModel: imageModel, image: testPNG(t, 64, 64), preview: testPNG(t, 16, 16)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/chat/completions", o.chatCompletions)
+	mux.HandleFunc("POST /v1/images/generations", o.imageGenerations)
+	mux.HandleFunc("POST /v1/images/edits", o.imageEdits)
+	mux.HandleFunc("GET /files/{name}", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(o.image)
+	})
+	o.standIn = newStandIn(t, mux)
+	return o
+}
+
+func (o *openAIStandIn) chatCompletions(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Model         string          `json:"model"`
+		Stream        bool            `json:"stream"`
+		StreamOptions json.RawMessage `json:"stream_options"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, openAIError(err.Error()))
+		return
+	}
+	if o.rejectStreamOptions && req.StreamOptions != nil {
+		writeJSON(w, http.StatusBadRequest, openAIError("Unrecognized request argument supplied: stream_options"))
+		return
+	}
+
+	content := enhancedTestPrompt
+	if req.Model == o.imageModel {
+		encoded := base64.StdEncoding.EncodeToString(o.image)
+		switch o.chatImageFormat {
+		case "markdown":
+			content = "Here is your image:\n\n![generated image](data:image/png;base64," + encoded + ")"
+		case "base64":
+			content = encoded
+		case "url":
+			content = "![generated image](" + o.URL + "/files/generated.png)"
+		default:
+			content = "data:image/png;base64," + encoded
+		}
+	}
+	usage := map[string]int{"prompt_tokens": 12, "completion_tokens": 34, "total_tokens": 46}
+
+	if !req.Stream {
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"id":     "chatcmpl-test",
+			"object": "chat.completion",
+			"model":  req.Model,
+			"choices": []map[string]interface{}{{
+				"index":         0,
+				"message":       map[string]string{"role": "assistant", "content": content},
+				"finish_reason": "stop",
+			}},
+			"usage": usage,
+		})
+		return
+	}
+
+	// 按固定长度切分内容，使图像数据跨越多个分片
+	w.Header().Set("Content-Type", "text/event-stream")
+	for len(content) > 0 {
+		n := min(len(content), 100)
+		writeSSE(w, "", map[string]interface{}{
+			"id":      "chatcmpl-test",
+			"object":  "chat.completion.chunk",
+			"model":   req.Model,
+			"choices": []map[string]interface{}{{"index": 0, "delta": map[string]string{"content": content[:n]}}},
+		})
+		content = content[n:]
+	}
+	writeSSE(w, "", map[string]interface{}{
+		"id":      "chatcmpl-test",
+		"object":  "chat.completion.chunk",
+		"model":   req.Model,
+		"choices": []interface{}{},
+		"usage":   usage,
+	})
+	fmt.Fprint(w, "data: [DONE]\n\n")
+}
+
+func (o *openAIStandIn) imageGenerations(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Model  string `json:"model"`
+		N      int    `json:"n"`
+		Stream bool   `json:"stream"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, openAIError(err.Error()))
+		return
+	}
+	encoded := base64.StdEncoding.EncodeToString(o.image)
+	usage := map[string]int{"input_tokens": 10, "output_tokens": 100, "total_tokens": 110}
+
+	if req.Stream {
+		preview := base64.StdEncoding.EncodeToString(o.preview)
+		w.Header().Set("Content-Type", "text/event-stream")
+		writeSSE(w, "image_generation.partial_image", map[string]interface{}{
+			"type": "image_generation.partial_image", "b64_json": preview, "partial_image_index": 0,
+		})
+		writeSSE(w, "image_generation.completed", map[string]interface{}{
+			"type": "image_generation.completed", "b64_json": encoded, "usage": usage,
+		})
+		return
+	}
+
+	n := max(req.N, 1)
+	data := make([]map[string]string, n)
+	for i := range data {
+		data[i] = map[string]string{"b64_json": encoded}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"created": 1, "data": data, "usage": usage})
+}
+
+func (o *openAIStandIn) imageEdits(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		writeJSON(w, http.StatusBadRequest, openAIError(err.Error()))
+		return
+	}
+	if _, _, err := r.FormFile("image"); err != nil {
+		writeJSON(w, http.StatusBadRequest, openAIError("missing image file"))
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"created": 1,
+		"data":    []map[string]string{{"b64_json": base64.StdEncoding.EncodeToString(o.image)}},
+	})
+}
+
+// openAIError OpenAI 格式的错误响应
+func openAIError(message string) map[string]interface{} {
+	return map[string]interface{}{"error": map[string]string{"message": message, "type": "invalid_request_error"}}
+}
+
+// ==================== Gemini 替身 ====================
+
+// geminiStandIn Gemini API generateContent / streamGenerateContent 替身
+// 请求图像模型时返回一段文字说明、一张思考过程中的预览图像和一张 inlineData 结果图像，其他模型返回增强后的提示词；
+// 流式响应将文字说明、预览图像和结果图像分为三个 SSE 分片
+type geminiStandIn struct {
+	*standIn
+	imageModel string
+	image      []byte
+	thought    []byte // 思考过程中的预览图像
+}
+
+func newGeminiStandIn(t *testing.T, imageModel string) *geminiStandIn {
+	g := &geminiStandIn{imageModel: imageModel, image: testPNG(t, 64, 64), thought: testPNG(t, 16, 16)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1beta/models/{call}", g.models)
+	g.standIn = newStandIn(t, mux)
+	return g
+}
+
+func (g *geminiStandIn) models(w http.ResponseWriter, r *http.Request) {
+	model, method, _ := strings.Cut(r.PathValue("call"), ":")
+	usage := map[string]int{"promptTokenCount": 12, "candidatesTokenCount": 34, "totalTokenCount": 46}
+	response := func(parts []map[string]interface{}, final bool) map[string]interface{} {
+		candidate := map[string]interface{}{"index": 0, "content": map[string]interface{}{"role": "model", "parts": parts}}
+		response := map[string]interface{}{"candidates": []interface{}{candidate}, "modelVersion": model}
+		if final {
+			candidate["finishReason"] = "STOP"
+			response["usageMetadata"] = usage
+		}
+		return response
+	}
+
+	textPart := map[string]interface{}{"text": enhancedTestPrompt}
+	imagePart := map[string]interface{}{"inlineData": map[string]string{
+		"mimeType": "image/png",
+		"data":     base64.StdEncoding.EncodeToString(g.image),
+	}}
+	thoughtPart := map[string]interface{}{"thought": true, "inlineData": map[string]string{
+		"mimeType": "image/png",
+		"data":     base64.StdEncoding.EncodeToString(g.thought),
+	}}
+	if model == g.imageModel {
+		textPart = map[string]interface{}{"text": "Here is the image."}
+	}
+
+	switch method {
+	case "generateContent":
+		parts := []map[string]interface{}{textPart}
+		if model == g.imageModel {
+			parts = append(parts, thoughtPart, imagePart)
+		}
+		writeJSON(w, http.StatusOK, response(parts, true))
+	case "streamGenerateContent":
+		w.Header().Set("Content-Type", "text/event-stream")
+		if model != g.imageModel {
+			writeSSE(w, "", response([]map[string]interface{}{textPart}, true))
+			return
+		}
+		writeSSE(w, "", response([]map[string]interface{}{textPart}, false))
+		writeSSE(w, "", response([]map[string]interface{}{thoughtPart}, false))
+		writeSSE(w, "", response([]map[string]interface{}{imagePart}, true))
+	default:
+		writeJSON(w, http.StatusNotFound, map[string]interface{}{"error": map[string]interface{}{"code": 404, "message": "unknown method " + method, "status": "NOT_FOUND"}})
+	}
+}
+
+// ==================== Cloud 替身 ====================
+
+// cloudStandIn Cloud 服务替身
+//   - GET /capabilities：返回 capabilities（为 nil 时返回 404，模拟未实现该接口的旧版服务）
+//   - POST /{operation}：接受 JSON 或 multipart/form-data 请求，校验请求中的图像字段（必需的图像缺失或无法解码时返回 400）；
+//     图像操作返回 images（generateImage 按 count 返回多张），enhancePrompt 返回 text；
+//     capabilities 声明 binaryResponses 且请求接受 image/* 时，单图结果以 PNG 二进制返回；
+//     async 为 true 时返回 202 和任务 ID，任务状态通过 GET /jobs/{id} 轮询（事件流返回 404）
+//   - GET /files/{name}：imageURLs 为 true 时图像链接（相对路径）指向的文件
+type cloudStandIn struct {
+	*standIn
+	capabilities map[string]interface{}
+	async        bool
+	imageURLs    bool // 返回图像链接（相对路径 /files/{name}）而不是 data URI
+	image        []byte
+
+	uploadsMu sync.Mutex
+	uploads   []cloudUpload // 收到的图像操作请求
+
+	jobsMu sync.Mutex
+	jobs   map[string]map[string]interface{}
+}
+
+func newCloudStandIn(t *testing.T) *cloudStandIn {
+	c := &cloudStandIn{image: testPNG(t, 64, 64), jobs: make(map[string]map[string]interface{})}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /capabilities", c.getCapabilities)
+	mux.HandleFunc("GET /jobs/{id}", c.getJob)
+	mux.HandleFunc("GET /files/{name}", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(c.image)
+	})
+	mux.HandleFunc("POST /{operation}", c.operation)
+	c.standIn = newStandIn(t, mux)
+	return c
+}
+
+func (c *cloudStandIn) getCapabilities(w http.ResponseWriter, r *http.Request) {
+	if c.capabilities == nil {
+		http.NotFound(w, r)
+		return
+	}
+	writeJSON(w, http.StatusOK, c.capabilities)
+}
+
+// cloudUpload 替身收到的一次操作请求
+type cloudUpload struct {
+	Operation string
+	Multipart bool
+	Images    map[string][]string // 图像字段名到 data URL 列表（multipart 文件部分按同样的形式还原）
+}
+
+// cloudImageFields 各操作的图像字段及是否必需
+var cloudImageFields = map[string]map[string]bool{
+	"generateImage":   {"referenceImage": false, "sketchImage": false},
+	"editImage":       {"imageData": true, "mask": false},
+	"editMultiImages": {"images": true},
+	"upscaleImage":    {"imageData": true},
+}
+
+// uploadsFor 返回指定操作收到的请求
+func (c *cloudStandIn) uploadsFor(operation string) []cloudUpload {
+	c.uploadsMu.Lock()
+	defer c.uploadsMu.Unlock()
+	var matched []cloudUpload
+	for _, upload := range c.uploads {
+		if upload.Operation == operation {
+			matched = append(matched, upload)
+		}
+	}
+	return matched
+}
+
+// decodeCloudRequest 解析 JSON 或 multipart 请求，multipart 的文件部分还原为 data URL 合并到参数中
+func decodeCloudRequest(r *http.Request) (map[string]interface{}, bool, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "multipart/form-data" {
+		var req map[string]interface{}
+		err := json.NewDecoder(r.Body).Decode(&req)
+		return req, false, err
+	}
+
+	if err := r.ParseMultipartForm(64 << 20); err != nil {
+		return nil, true, err
+	}
+	var req map[string]interface{}
+	if err := json.Unmarshal([]byte(r.FormValue("metadata")), &req); err != nil {
+		return nil, true, fmt.Errorf("invalid metadata part: %w", err)
+	}
+	for field, headers := range r.MultipartForm.File {
+		var images []interface{}
+		for _, header := range headers {
+			file, err := header.Open()
+			if err != nil {
+				return nil, true, err
+			}
+			data, err := io.ReadAll(file)
+			file.Close()
+			if err != nil {
+				return nil, true, err
+			}
+			images = append(images, "data:"+header.Header.Get("Content-Type")+";base64,"+base64.StdEncoding.EncodeToString(data))
+		}
+		if field == "images" {
+			req[field] = images
+		} else {
+			req[field] = images[0]
+		}
+	}
+	return req, true, nil
+}
+
+// checkCloudImages 校验操作的图像字段，返回收到的图像
+func checkCloudImages(operation string, req map[string]interface{}) (map[string][]string, error) {
+	images := make(map[string][]string)
+	for field, required := range cloudImageFields[operation] {
+		var values []string
+		switch value := req[field].(type) {
+		case string:
+			values = []string{value}
+		case []interface{}:
+			for _, item := range value {
+				text, _ := item.(string)
+				values = append(values, text)
+			}
+		}
+		if len(values) == 0 && required {
+			return nil, fmt.Errorf("missing %s", field)
+		}
+		for i, value := range values {
+			data, err := base64.StdEncoding.DecodeString(extractBase64Data(value))
+			if err == nil {
+				_, _, err = image.DecodeConfig(bytes.NewReader(data))
+			}
+			if err != nil {
+				return nil, fmt.Errorf("%s[%d] is not a valid image: %v", field, i, err)
+			}
+		}
+		if len(values) > 0 {
+			images[field] = values
+		}
+	}
+	return images, nil
+}
+
+func (c *cloudStandIn) operation(w http.ResponseWriter, r *http.Request) {
+	operation := r.PathValue("operation")
+	req, isMultipart, err := decodeCloudRequest(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	images, err := checkCloudImages(operation, req)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	c.uploadsMu.Lock()
+	c.uploads = append(c.uploads, cloudUpload{Operation: operation, Multipart: isMultipart, Images: images})
+	c.uploadsMu.Unlock()
+
+	var result map[string]interface{}
+	dataURL := "data:image/png;base64," + base64.StdEncoding.EncodeToString(c.image)
+	if c.imageURLs {
+		dataURL = "/files/result.png"
+	}
+	switch operation {
+	case "generateImage":
+		count, _ := req["count"].(float64)
+		images := make([]string, max(int(count), 1))
+		for i := range images {
+			images[i] = dataURL
+		}
+		result = map[string]interface{}{"images": images, "model": "cloud-test"}
+	case "editImage", "editMultiImages", "upscaleImage":
+		result = map[string]interface{}{"image": dataURL, "model": "cloud-test"}
+	case "enhancePrompt":
+		result = map[string]interface{}{"text": enhancedTestPrompt, "usage": map[string]int{"inputTokens": 12, "outputTokens": 34}}
+	default:
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "unknown operation " + operation})
+		return
+	}
+
+	binary, _ := c.capabilities["binaryResponses"].(bool)
+	if _, single := result["image"]; single && binary && !c.async && !c.imageURLs && strings.Contains(r.Header.Get("Accept"), "image/") {
+		w.Header().Set("Content-Type", "image/png")
+		w.Header().Set("X-Model", "cloud-test-binary")
+		w.Write(c.image)
+		return
+	}
+	if !c.async {
+		writeJSON(w, http.StatusOK, result)
+		return
+	}
+	c.jobsMu.Lock()
+	jobID := fmt.Sprintf("job-%d", len(c.jobs)+1)
+	c.jobs[jobID] = result
+	c.jobsMu.Unlock()
+	writeJSON(w, http.StatusAccepted, map[string]interface{}{"jobId": jobID, "pollIntervalMs": 10})
+}
+
+func (c *cloudStandIn) getJob(w http.ResponseWriter, r *http.Request) {
+	c.jobsMu.Lock()
+	result, ok := c.jobs[r.PathValue("id")]
+	c.jobsMu.Unlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"status": "succeeded", "progress": 1, "result": result})
+}
+
+// ==================== 本地服务替身 ====================
+
+// newOllamaStandIn Ollama /api/tags 和流式 /api/chat 替身
+func newOllamaStandIn(t *testing.T) *standIn {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/tags", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]interface{}{"models": []map[string]string{{"name": "llama-test:latest", "model": "llama-test:latest"}}})
+	})
+	mux.HandleFunc("POST /api/chat", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		encoder := json.NewEncoder(w)
+		for _, word := range strings.SplitAfter(enhancedTestPrompt, " ") {
+			encoder.Encode(map[string]interface{}{"message": map[string]string{"role": "assistant", "content": word}, "done": false})
+		}
+		encoder.Encode(map[string]interface{}{"message": map[string]string{"role": "assistant", "content": ""}, "done": true, "prompt_eval_count": 12, "eval_count": 34})
+	})
+	return newStandIn(t, mux)
+}
+
+// newSDWebUIStandIn Stable Diffusion WebUI API 替身，txt2img / img2img 按 batch_size 返回图像
+func newSDWebUIStandIn(t *testing.T) *standIn {
+	encoded := base64.StdEncoding.EncodeToString(testPNG(t, 64, 64))
+	images := func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			BatchSize int `json:"batch_size"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		list := make([]string, max(req.BatchSize, 1))
+		for i := range list {
+			list[i] = encoded
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"images": list})
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /sdapi/v1/sd-models", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, []map[string]string{{"title": "sd-test.safetensors [abc123]", "model_name": "sd-test"}})
+	})
+	mux.HandleFunc("GET /sdapi/v1/progress", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]interface{}{"progress": 0.5, "state": map[string]int{"sampling_step": 10, "sampling_steps": 20}})
+	})
+	mux.HandleFunc("POST /sdapi/v1/txt2img", images)
+	mux.HandleFunc("POST /sdapi/v1/img2img", images)
+	mux.HandleFunc("POST /sdapi/v1/extra-single-image", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{"image": encoded})
+	})
+	mux.HandleFunc("POST /sdapi/v1/interrupt", func(w http.ResponseWriter, r *http.Request) {})
+	return newStandIn(t, mux)
+}
+
+// newComfyUIStandIn ComfyUI API 替身
+// 不提供 WebSocket（/ws 返回 404），提交的工作流立即完成，客户端通过 /history 轮询获取结果
+func newComfyUIStandIn(t *testing.T) *standIn {
+	image := testPNG(t, 64, 64)
+	var mu sync.Mutex
+	prompts := 0
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /system_stats", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]interface{}{"system": map[string]string{"comfyui_version": "test"}})
+	})
+	mux.HandleFunc("POST /upload/image", func(w http.ResponseWriter, r *http.Request) {
+		if _, header, err := r.FormFile("image"); err == nil {
+			writeJSON(w, http.StatusOK, map[string]string{"name": header.Filename, "subfolder": "", "type": "input"})
+			return
+		}
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "missing image"})
+	})
+	mux.HandleFunc("POST /prompt", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		prompts++
+		id := fmt.Sprintf("prompt-%d", prompts)
+		mu.Unlock()
+		writeJSON(w, http.StatusOK, map[string]interface{}{"prompt_id": id, "number": prompts, "node_errors": map[string]interface{}{}})
+	})
+	mux.HandleFunc("GET /history/{id}", func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		writeJSON(w, http.StatusOK, map[string]interface{}{id: map[string]interface{}{
+			"outputs": map[string]interface{}{"9": map[string]interface{}{"images": []map[string]string{{"filename": id + ".png", "subfolder": "", "type": "output"}}}},
+			"status":  map[string]interface{}{"status_str": "success", "completed": true},
+		}})
+	})
+	mux.HandleFunc("GET /view", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(image)
+	})
+	return newStandIn(t, mux)
+}
+
+// testComfyUIWorkflow 最小的 API 格式工作流模板
+const testComfyUIWorkflow = `{"3": {"class_type": "KSampler", "inputs": {"seed": "{{seed}}", "text": "{{prompt}}", "image": "{{image}}"}}, "9": {"class_type": "SaveImage", "inputs": {"images": ["3", 0]}}}`
+
+// ==================== 辅助函数 ====================
+
+// enhancedTestPrompt 替身服务返回的增强提示词
+const enhancedTestPrompt = "A detailed watercolor painting of a lighthouse at dusk, soft warm light"
+
+// testPNG 返回指定尺寸的 PNG 图像（水平渐变）
+func testPNG(t *testing.T, width, height int) []byte {
+	t.Helper()
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{R: uint8(x * 255 / width), G: 128, B: uint8(y * 255 / height), A: 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("failed to encode test image: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// testImageDataURL 返回指定尺寸的 PNG data URL
+func testImageDataURL(t *testing.T, width, height int) string {
+	t.Helper()
+	return "data:image/png;base64," + base64.StdEncoding.EncodeToString(testPNG(t, width, height))
+}
+
+// decodeTestImage 解码 data URL 中的图像，失败时测试失败
+func decodeTestImage(t *testing.T, dataURL string) image.Image {
+	t.Helper()
+	if !strings.HasPrefix(dataURL, "data:image/") {
+		t.Fatalf("expected an image data URL, got %q", truncateString(dataURL, 60))
+	}
+	data, err := base64.StdEncoding.DecodeString(extractBase64Data(dataURL))
+	if err != nil {
+		t.Fatalf("invalid base64 image data: %v", err)
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("failed to decode image: %v", err)
+	}
+	return img
+}
+
+// testSettings 返回关闭自动重试的 AI 设置，错误路径测试只发送一次请求
+func testSettings() types.AISettings {
+	return types.AISettings{RetryMaxAttempts: 1}
+}
+
+// writeJSON 写入 JSON 响应
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeSSE 写入一个 SSE 事件（event 为空时省略事件名）并立即发送
+func writeSSE(w http.ResponseWriter, event string, data interface{}) {
+	payload, _ := json.Marshal(data)
+	if event != "" {
+		fmt.Fprintf(w, "event: %s\n", event)
+	}
+	fmt.Fprintf(w, "data: %s\n\n", payload)
+	if flusher, ok := w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,530 @@
+package provider
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"indraw/core/types"
+	"net/http"
+
+	_ "golang.org/x/image/webp" // 注册 WebP 解码器
+)
+
+// ==================== 输入图像规范化 ====================
+
+// 输入图像上传到提供商之前统一经过以下处理：
+//   - 按文件头识别真实格式（data URL 中声明的 MIME 类型不可信）
+//   - 提供商不接受的格式转码为其首选格式
+//   - 超过最长边或字节数上限时缩小
+//   - 去除 EXIF / GPS / XMP 等元数据（JPEG 的方向标记先应用到像素上）
+//   - 按配置将透明通道合成到白色背景，或保留透明通道
+// 不需要缩放和转码的图像只去除元数据、不重新编码，避免有损格式再次压缩。
+// WebP 由 golang.org/x/image/webp 解码，与 PNG / JPEG 走相同的处理流程（只能转码为 PNG 或 JPEG）
+
+const (
+	mimePNG  = "image/png"
+	mimeJPEG = "image/jpeg"
+	mimeGIF  = "image/gif"
+	mimeWebP = "image/webp"
+)
+
+// inputJPEGQuality 转码为 JPEG 时使用的质量
+const inputJPEGQuality = 90
+
+// minInputDimension 为满足字节数上限逐步缩小时允许的最小边长
+const minInputDimension = 256
+
+// imageInputPolicy 提供商对输入图像的要求
+type imageInputPolicy struct {
+	Formats      []string // 接受的 MIME 类型，转码时使用其中第一个可以编码的格式（PNG 或 JPEG）
+	MaxDimension int      // 最长边上限（像素），为 0 时不限制
+	MaxBytes     int      // 单张图像的字节数上限，为 0 时不限制
+	FlattenAlpha bool     // 是否将透明通道合成到白色背景
+}
+
+// 各提供商的输入图像要求
+var (
+	// Gemini 内联图像支持 PNG / JPEG / WebP，单张图像建议不超过 7MB
+	geminiInputPolicy = imageInputPolicy{Formats: []string{mimePNG, mimeJPEG, mimeWebP}, MaxDimension: 3072, MaxBytes: 7 << 20}
+	// OpenAI Chat 图像输入支持 PNG / JPEG / WebP / GIF，单张不超过 20MB，高细节模式在服务端缩放到 2048 以内
+	openAIChatInputPolicy = imageInputPolicy{Formats: []string{mimePNG, mimeJPEG, mimeWebP, mimeGIF}, MaxDimension: 2048, MaxBytes: 20 << 20}
+	// OpenAI /images/edits 按最严格的 DALL-E 2 要求：PNG，不超过 4MB
+	openAIEditInputPolicy = imageInputPolicy{Formats: []string{mimePNG}, MaxDimension: 2048, MaxBytes: 4 << 20}
+	// Cloud 服务端转发给上游模型，使用通用格式
+	cloudInputPolicy = imageInputPolicy{Formats: []string{mimePNG, mimeJPEG, mimeWebP}, MaxDimension: 4096}
+	// 本地扩散模型服务，过大的图像会耗尽显存
+	sdwebuiInputPolicy = imageInputPolicy{Formats: []string{mimePNG, mimeJPEG}, MaxDimension: 2048}
+	comfyUIInputPolicy = imageInputPolicy{Formats: []string{mimePNG, mimeJPEG}, MaxDimension: 4096}
+	// Mock 在本地解码，只接受标准库可以解码的格式
+	mockInputPolicy = imageInputPolicy{Formats: []string{mimePNG, mimeJPEG, mimeGIF}}
+)
+
+// withSettings 应用用户配置
+// 最长边上限只能比提供商的默认值更小；透明通道按配置合成或保留
+func (policy imageInputPolicy) withSettings(settings types.AISettings) imageInputPolicy {
+	if limit := settings.InputImageMaxDimension; limit > 0 && (policy.MaxDimension == 0 || limit < policy.MaxDimension) {
+		policy.MaxDimension = limit
+	}
+	switch settings.InputImageAlpha {
+	case types.InputImageAlphaFlatten:
+		policy.FlattenAlpha = true
+	case types.InputImageAlphaPreserve:
+		policy.FlattenAlpha = false
+	}
+	return policy
+}
+
+// withoutDownscale 返回不限制尺寸的策略，用于放大等需要保留原始分辨率的操作
+func (policy imageInputPolicy) withoutDownscale() imageInputPolicy {
+	policy.MaxDimension = 0
+	policy.MaxBytes = 0
+	return policy
+}
+
+// accepts 判断是否接受指定格式
+func (policy imageInputPolicy) accepts(mimeType string) bool {
+	for _, format := range policy.Formats {
+		if format == mimeType {
+			return true
+		}
+	}
+	return false
+}
+
+// encodableFormat 返回转码使用的格式
+func (policy imageInputPolicy) encodableFormat() string {
+	for _, format := range policy.Formats {
+		if format == mimePNG || format == mimeJPEG {
+			return format
+		}
+	}
+	return mimePNG
+}
+
+// inputImage 规范化后的输入图像
+type inputImage struct {
+	Data     []byte
+	MIMEType string
+	Width    int
+	Height   int
+}
+
+// Base64 返回 base64 编码的图像数据
+func (img inputImage) Base64() string {
+	return base64.StdEncoding.EncodeToString(img.Data)
+}
+
+// DataURL 返回 data URL
+func (img inputImage) DataURL() string {
+	return fmt.Sprintf("data:%s;base64,%s", img.MIMEType, img.Base64())
+}
+
+// normalizeInputImage 按提供商的要求规范化输入图像（data URL 或纯 base64）
+func normalizeInputImage(input string, policy imageInputPolicy) (inputImage, error) {
+	data, err := base64.StdEncoding.DecodeString(extractBase64Data(input))
+	if err != nil {
+		return inputImage{}, fmt.Errorf("failed to decode base64 image: %w", err)
+	}
+
+	mimeType := sniffImageType(data)
+	if mimeType == "" {
+		return inputImage{}, fmt.Errorf("unsupported image format: %s", http.DetectContentType(data))
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return inputImage{}, fmt.Errorf("failed to decode image: %w", err)
+	}
+	orientation := 1
+	if mimeType == mimeJPEG {
+		orientation = jpegOrientation(data)
+	}
+
+	// 格式被接受、尺寸未超限且不需要旋转或合成背景时，只去除元数据
+	reencode := !policy.accepts(mimeType) ||
+		exceedsDimension(config.Width, config.Height, policy.MaxDimension) ||
+		orientation > 1 ||
+		(policy.FlattenAlpha && mimeType != mimeJPEG)
+	if !reencode {
+		stripped := stripImageMetadata(data, mimeType)
+		if policy.MaxBytes == 0 || len(stripped) <= policy.MaxBytes {
+			return inputImage{Data: stripped, MIMEType: mimeType, Width: config.Width, Height: config.Height}, nil
+		}
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return inputImage{}, fmt.Errorf("failed to decode image: %w", err)
+	}
+	return encodeInputImage(applyOrientation(img, orientation), mimeType, policy)
+}
+
+// normalizeInputDataURL 规范化输入图像并返回 data URL
+func normalizeInputDataURL(input string, policy imageInputPolicy) (string, error) {
+	img, err := normalizeInputImage(input, policy)
+	if err != nil {
+		return "", err
+	}
+	return img.DataURL(), nil
+}
+
+// encodeInputImage 按策略缩放并编码图像，超过字节数上限时逐步缩小
+func encodeInputImage(img image.Image, sourceType string, policy imageInputPolicy) (inputImage, error) {
+	target := sourceType
+	if (target != mimePNG && target != mimeJPEG) || !policy.accepts(target) {
+		target = policy.encodableFormat()
+	}
+	if policy.FlattenAlpha || target == mimeJPEG {
+		img = flattenAlpha(img)
+	}
+
+	bounds := img.Bounds()
+	width, height := fitDimension(bounds.Dx(), bounds.Dy(), policy.MaxDimension)
+	for {
+		scaled := img
+		if width != bounds.Dx() || height != bounds.Dy() {
+			scaled = ResizeLanczos(img, width, height)
+		}
+
+		data, err := encodeImage(scaled, target)
+		if err != nil {
+			return inputImage{}, err
+		}
+		if policy.MaxBytes == 0 || len(data) <= policy.MaxBytes {
+			return inputImage{Data: data, MIMEType: target, Width: width, Height: height}, nil
+		}
+
+		// 超过字节数上限：不透明的 PNG 优先改用 JPEG，否则缩小尺寸
+		if target == mimePNG && policy.accepts(mimeJPEG) && isOpaque(scaled) {
+			target = mimeJPEG
+			continue
+		}
+		if max(width, height) <= minInputDimension {
+			return inputImage{}, fmt.Errorf("image is too large to upload (%d bytes, limit %d bytes)", len(data), policy.MaxBytes)
+		}
+		width, height = max(1, width*3/4), max(1, height*3/4)
+	}
+}
+
+// encodeImage 按 MIME 类型编码图像
+func encodeImage(img image.Image, mimeType string) ([]byte, error) {
+	if mimeType != mimeJPEG {
+		return encodePNG(img)
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: inputJPEGQuality}); err != nil {
+		return nil, fmt.Errorf("failed to encode jpeg: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// sniffImageType 按文件头识别图像格式，不支持的格式返回空字符串
+func sniffImageType(data []byte) string {
+	switch mimeType := http.DetectContentType(data); mimeType {
+	case mimePNG, mimeJPEG, mimeGIF, mimeWebP:
+		return mimeType
+	}
+	return ""
+}
+
+// exceedsDimension 判断图像最长边是否超过上限（上限为 0 时不限制）
+func exceedsDimension(width, height, limit int) bool {
+	return limit > 0 && max(width, height) > limit
+}
+
+// fitDimension 等比缩小尺寸，使最长边不超过上限
+func fitDimension(width, height, limit int) (int, int) {
+	if !exceedsDimension(width, height, limit) {
+		return width, height
+	}
+	scale := float64(limit) / float64(max(width, height))
+	return max(1, int(float64(width)*scale+0.5)), max(1, int(float64(height)*scale+0.5))
+}
+
+// isOpaque 判断图像是否完全不透明
+func isOpaque(img image.Image) bool {
+	if o, ok := img.(interface{ Opaque() bool }); ok {
+		return o.Opaque()
+	}
+	return false
+}
+
+// flattenAlpha 将图像合成到白色背景上，去除透明通道
+func flattenAlpha(img image.Image) image.Image {
+	if isOpaque(img) {
+		return img
+	}
+	bounds := img.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(dst, dst.Bounds(), img, bounds.Min, draw.Over)
+	return dst
+}
+
+// scaleMaskDataURL 将蒙版缩放到指定尺寸（与规范化后的原图一致），尺寸相同时原样返回
+// 缩放后的蒙版为 alpha PNG（不透明区域为重绘区域）
+func scaleMaskDataURL(maskDataURL string, width, height int) (string, error) {
+	maskWidth, maskHeight, err := ImageDimensions(maskDataURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid mask: %w", err)
+	}
+	if maskWidth == width && maskHeight == height {
+		return maskDataURL, nil
+	}
+
+	alpha, err := decodeMask(maskDataURL, width, height)
+	if err != nil {
+		return "", err
+	}
+	mask := image.NewNRGBA(alpha.Bounds())
+	for i, value := range alpha.Pix {
+		mask.Pix[i*4], mask.Pix[i*4+1], mask.Pix[i*4+2], mask.Pix[i*4+3] = 0xff, 0xff, 0xff, value
+	}
+	return encodePNGDataURL(mask)
+}
+
+// ==================== 方向校正 ====================
+
+// applyOrientation 按 EXIF 方向值（1-8）旋转或翻转图像，使像素方向与显示方向一致
+func applyOrientation(img image.Image, orientation int) image.Image {
+	if orientation < 2 || orientation > 8 {
+		return img
+	}
+
+	bounds := img.Bounds()
+	src := image.NewNRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(src, src.Bounds(), img, bounds.Min, draw.Src)
+	w, h := src.Rect.Dx(), src.Rect.Dy()
+
+	dstW, dstH := w, h
+	if orientation >= 5 {
+		dstW, dstH = h, w
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, dstW, dstH))
+
+	for y := 0; y < dstH; y++ {
+		for x := 0; x < dstW; x++ {
+			// 目标像素对应的源像素
+			var sx, sy int
+			switch orientation {
+			case 2: // 水平翻转
+				sx, sy = w-1-x, y
+			case 3: // 旋转 180°
+				sx, sy = w-1-x, h-1-y
+			case 4: // 垂直翻转
+				sx, sy = x, h-1-y
+			case 5: // 沿主对角线翻转
+				sx, sy = y, x
+			case 6: // 顺时针旋转 90°
+				sx, sy = y, h-1-x
+			case 7: // 沿副对角线翻转
+				sx, sy = w-1-y, h-1-x
+			case 8: // 逆时针旋转 90°
+				sx, sy = w-1-y, x
+			}
+			copy(dst.Pix[dst.PixOffset(x, y):dst.PixOffset(x, y)+4], src.Pix[src.PixOffset(sx, sy):src.PixOffset(sx, sy)+4])
+		}
+	}
+	return dst
+}
+
+// ==================== 元数据去除 ====================
+
+// stripImageMetadata 无损去除图像中的元数据，无法解析时原样返回
+func stripImageMetadata(data []byte, mimeType string) []byte {
+	switch mimeType {
+	case mimeJPEG:
+		return stripJPEGMetadata(data)
+	case mimePNG:
+		return stripPNGMetadata(data)
+	case mimeWebP:
+		return stripWebPMetadata(data)
+	}
+	return data
+}
+
+// jpegSegment JPEG 文件头中的一个标记段
+type jpegSegment struct {
+	Marker  byte
+	Raw     []byte // 完整的标记段（含标记和长度）
+	Payload []byte
+}
+
+// parseJPEGHeader 解析 JPEG 文件头（SOI 之后、SOS 之前）的标记段，返回标记段和从 SOS 开始的剩余数据
+func parseJPEGHeader(data []byte) ([]jpegSegment, []byte, bool) {
+	if len(data) < 4 || data[0] != 0xff || data[1] != 0xd8 {
+		return nil, nil, false
+	}
+
+	var segments []jpegSegment
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xff {
+			return nil, nil, false
+		}
+		marker := data[i+1]
+		switch {
+		case marker == 0xff: // 填充字节
+			i++
+			continue
+		case marker == 0xda || marker == 0xd9: // SOS / EOI
+			return segments, data[i:], true
+		case marker == 0x01 || (marker >= 0xd0 && marker <= 0xd7): // 没有长度的独立标记
+			segments = append(segments, jpegSegment{Marker: marker, Raw: data[i : i+2]})
+			i += 2
+			continue
+		}
+
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		end := i + 2 + length
+		if length < 2 || end > len(data) {
+			return nil, nil, false
+		}
+		segments = append(segments, jpegSegment{Marker: marker, Raw: data[i:end], Payload: data[i+4 : end]})
+		i = end
+	}
+	return nil, nil, false
+}
+
+// stripJPEGMetadata 去除 JPEG 中的 EXIF、XMP、IPTC 和注释段
+// 保留 APP0（JFIF）、APP2 中的 ICC 色彩配置和 APP14（Adobe 色彩变换），它们影响颜色解码
+func stripJPEGMetadata(data []byte) []byte {
+	segments, scan, ok := parseJPEGHeader(data)
+	if !ok {
+		return data
+	}
+
+	out := make([]byte, 0, len(data))
+	out = append(out, 0xff, 0xd8)
+	for _, segment := range segments {
+		switch {
+		case segment.Marker == 0xfe: // COM
+			continue
+		case segment.Marker == 0xe2 && bytes.HasPrefix(segment.Payload, []byte("ICC_PROFILE\x00")):
+		case segment.Marker >= 0xe1 && segment.Marker <= 0xef && segment.Marker != 0xee:
+			continue
+		}
+		out = append(out, segment.Raw...)
+	}
+	return append(out, scan...)
+}
+
+// jpegOrientation 读取 JPEG EXIF 中的方向值，不存在时返回 1
+func jpegOrientation(data []byte) int {
+	segments, _, ok := parseJPEGHeader(data)
+	if !ok {
+		return 1
+	}
+	for _, segment := range segments {
+		if segment.Marker == 0xe1 && bytes.HasPrefix(segment.Payload, []byte("Exif\x00\x00")) {
+			return exifOrientation(segment.Payload[6:])
+		}
+	}
+	return 1
+}
+
+// exifOrientation 从 TIFF 格式的 EXIF 数据中读取第一个 IFD 的方向标签（0x0112）
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+
+	ifd := int(order.Uint32(tiff[4:8]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 1
+	}
+	count := int(order.Uint16(tiff[ifd:]))
+	for i := 0; i < count; i++ {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(tiff) {
+			return 1
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			if value := int(order.Uint16(tiff[entry+8:])); value >= 1 && value <= 8 {
+				return value
+			}
+			return 1
+		}
+	}
+	return 1
+}
+
+// stripPNGMetadata 去除 PNG 中的 EXIF、文本和时间戳块
+func stripPNGMetadata(data []byte) []byte {
+	const signature = "\x89PNG\r\n\x1a\n"
+	if len(data) < len(signature) || string(data[:len(signature)]) != signature {
+		return data
+	}
+
+	out := make([]byte, 0, len(data))
+	out = append(out, data[:len(signature)]...)
+	for i := len(signature); i < len(data); {
+		if i+12 > len(data) {
+			return data
+		}
+		end := i + 12 + int(binary.BigEndian.Uint32(data[i:]))
+		if end < i+12 || end > len(data) {
+			return data
+		}
+		switch string(data[i+4 : i+8]) {
+		case "eXIf", "tEXt", "zTXt", "iTXt", "tIME":
+		default:
+			out = append(out, data[i:end]...)
+		}
+		i = end
+	}
+	return out
+}
+
+// stripWebPMetadata 去除 WebP 中的 EXIF 和 XMP 块，并清除 VP8X 头中对应的标志位
+func stripWebPMetadata(data []byte) []byte {
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return data
+	}
+
+	out := make([]byte, 0, len(data))
+	out = append(out, data[:12]...)
+	removed := false
+	for i := 12; i < len(data); {
+		if i+8 > len(data) {
+			return data
+		}
+		size := int(binary.LittleEndian.Uint32(data[i+4:]))
+		end := i + 8 + size + size%2 // 块按偶数字节对齐
+		if end > len(data) {
+			if i+8+size != len(data) {
+				return data
+			}
+			end = len(data)
+		}
+		switch string(data[i : i+4]) {
+		case "EXIF", "XMP ":
+			removed = true
+		default:
+			out = append(out, data[i:end]...)
+		}
+		i = end
+	}
+	if !removed {
+		return data
+	}
+
+	if len(out) > 20 && string(out[12:16]) == "VP8X" {
+		out[20] &^= 0x08 | 0x04 // EXIF / XMP 标志
+	}
+	binary.LittleEndian.PutUint32(out[4:8], uint32(len(out)-8))
+	return out
+}
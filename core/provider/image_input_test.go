@@ -0,0 +1,49 @@
+package provider
+
+import "testing"
+
+// testWebPDataURL 75x100 的无损 WebP 图像（golang.org/x/image 测试数据 gopher-doc.1bpp.lossless.webp）
+const testWebPDataURL = "data:image/webp;base64,UklGRrIBAABXRUJQVlA4TKUBAAAvSsAYAA8w//M///MfeJAkbXvaSG7m8Q3GfYSBJekwQztm/IcZlgwnmWImn2BK7aFmBtnVir6q//8VOkFE/xm4baTIu8c48ArEo6+B3zFKYln3pqClSCKX0begFTAXFOLXHSyF8cCNcZEG4OywuA4KVVfJCiArU7GAgJI8+lJP/OKMT/fBAjevg1cYB7YVkFuWga2lyPi5I0HFy5YTpWIHg0RZpkniRVW9odHAKOwosWuOGdxIyn2OvaCDvhg/we6TwadPBPbqBV58MsLmMJ8yZnOWk8SRz4N+QoyPL+MnamzMvcE1rHNEr91F9GKZPVUcS9w7PhhH36suB9qPeYb/oLk6cuTiJ0wOK3m5h1cKjW6EVZCYMK7dxcKCBdgP9HkKr9gkAO2P8GKZGWVdIAatQa+1IDpt6qyorVwdy01xdW8Jkfk6xjEXmVQQ+HQdFr6OKhIN34dXWq0+0qr6EJSCeeVLH9+gvGTLyqM65PQ44ihzlTXxQKjKbAvshXgir7Lil9w4L2bvMycmjQcqXaMCO6BlY28i+FOLzbfI1vEqxAhotocAAA=="
+
+func TestNormalizeInputImageWebP(t *testing.T) {
+	flatten := geminiInputPolicy
+	flatten.FlattenAlpha = true
+	downscale := geminiInputPolicy
+	downscale.MaxDimension = 50
+
+	tests := []struct {
+		name          string
+		policy        imageInputPolicy
+		wantType      string
+		width, height int
+	}{
+		{"accepted as is", geminiInputPolicy, mimeWebP, 75, 100},
+		{"converted for PNG-only providers", openAIEditInputPolicy, mimePNG, 75, 100},
+		{"converted for local providers", sdwebuiInputPolicy, mimePNG, 75, 100},
+		{"downscaled above the dimension limit", downscale, mimePNG, 38, 50},
+		{"alpha flattened", flatten, mimePNG, 75, 100},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img, err := normalizeInputImage(testWebPDataURL, tt.policy)
+			if err != nil {
+				t.Fatalf("normalizeInputImage() error = %v", err)
+			}
+			if img.MIMEType != tt.wantType || img.Width != tt.width || img.Height != tt.height {
+				t.Errorf("normalizeInputImage() = %s %dx%d, want %s %dx%d", img.MIMEType, img.Width, img.Height, tt.wantType, tt.width, tt.height)
+			}
+			if tt.wantType != mimeWebP {
+				if bounds := decodeTestImage(t, img.DataURL()).Bounds(); bounds.Dx() != tt.width || bounds.Dy() != tt.height {
+					t.Errorf("encoded image is %dx%d, want %dx%d", bounds.Dx(), bounds.Dy(), tt.width, tt.height)
+				}
+			}
+		})
+	}
+
+	t.Run("dimensions", func(t *testing.T) {
+		if width, height, err := ImageDimensions(testWebPDataURL); err != nil || width != 75 || height != 100 {
+			t.Errorf("ImageDimensions() = %d, %d, %v; want 75, 100", width, height, err)
+		}
+	})
+}
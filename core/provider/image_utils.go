@@ -0,0 +1,390 @@
+package provider
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/gif"  // 注册 GIF 解码器
+	_ "image/jpeg" // 注册 JPEG 解码器
+	"image/png"
+	"strconv"
+	"strings"
+)
+
+// ==================== 图像编解码 ====================
+
+// decodeImageDataURL 解码 data URL（或纯 base64）图像
+func decodeImageDataURL(dataURL string) (image.Image, error) {
+	decoded, err := base64.StdEncoding.DecodeString(extractBase64Data(dataURL))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode base64 image: %w", err)
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(decoded))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %w", err)
+	}
+	return img, nil
+}
+
+// encodePNG 将图像编码为 PNG 字节
+func encodePNG(img image.Image) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, fmt.Errorf("failed to encode png: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// encodePNGDataURL 将图像编码为 PNG data URL
+func encodePNGDataURL(img image.Image) (string, error) {
+	data, err := encodePNG(img)
+	if err != nil {
+		return "", err
+	}
+	return "data:image/png;base64," + base64.StdEncoding.EncodeToString(data), nil
+}
+
+// ==================== 缩放 ====================
+
+// resizeBilinear 使用双线性插值将图像缩放到指定尺寸
+func resizeBilinear(src image.Image, width, height int) *image.NRGBA {
+	srcBounds := src.Bounds()
+	srcW, srcH := srcBounds.Dx(), srcBounds.Dy()
+	dst := image.NewNRGBA(image.Rect(0, 0, width, height))
+
+	if srcW == width && srcH == height {
+		draw.Draw(dst, dst.Bounds(), src, srcBounds.Min, draw.Src)
+		return dst
+	}
+
+	scaleX := float64(srcW) / float64(width)
+	scaleY := float64(srcH) / float64(height)
+
+	for y := 0; y < height; y++ {
+		fy := (float64(y)+0.5)*scaleY - 0.5
+		y0 := clampInt(int(fy), 0, srcH-1)
+		y1 := clampInt(y0+1, 0, srcH-1)
+		wy := clampFloat(fy-float64(y0), 0, 1)
+
+		for x := 0; x < width; x++ {
+			fx := (float64(x)+0.5)*scaleX - 0.5
+			x0 := clampInt(int(fx), 0, srcW-1)
+			x1 := clampInt(x0+1, 0, srcW-1)
+			wx := clampFloat(fx-float64(x0), 0, 1)
+
+			c00 := color.NRGBAModel.Convert(src.At(srcBounds.Min.X+x0, srcBounds.Min.Y+y0)).(color.NRGBA)
+			c10 := color.NRGBAModel.Convert(src.At(srcBounds.Min.X+x1, srcBounds.Min.Y+y0)).(color.NRGBA)
+			c01 := color.NRGBAModel.Convert(src.At(srcBounds.Min.X+x0, srcBounds.Min.Y+y1)).(color.NRGBA)
+			c11 := color.NRGBAModel.Convert(src.At(srcBounds.Min.X+x1, srcBounds.Min.Y+y1)).(color.NRGBA)
+
+			lerp := func(a, b, c, d uint8) uint8 {
+				top := float64(a)*(1-wx) + float64(b)*wx
+				bottom := float64(c)*(1-wx) + float64(d)*wx
+				return uint8(top*(1-wy) + bottom*wy + 0.5)
+			}
+
+			dst.SetNRGBA(x, y, color.NRGBA{
+				R: lerp(c00.R, c10.R, c01.R, c11.R),
+				G: lerp(c00.G, c10.G, c01.G, c11.G),
+				B: lerp(c00.B, c10.B, c01.B, c11.B),
+				A: lerp(c00.A, c10.A, c01.A, c11.A),
+			})
+		}
+	}
+
+	return dst
+}
+
+// clampInt 将整数限制在 [min, max] 范围内
+func clampInt(v, min, max int) int {
+	if v < min {
+		return min
+	}
+	if v > max {
+		return max
+	}
+	return v
+}
+
+// clampFloat 将浮点数限制在 [min, max] 范围内
+func clampFloat(v, min, max float64) float64 {
+	if v < min {
+		return min
+	}
+	if v > max {
+		return max
+	}
+	return v
+}
+
+// ==================== 蒙版处理 ====================
+
+// decodeMask 解码蒙版并缩放到指定尺寸，返回每个像素的重绘强度（0 保留原图，255 完全重绘）
+// 蒙版约定为画笔绘制的 alpha PNG：不透明区域为需要重绘的区域
+// 如果蒙版没有任何透明像素（黑白蒙版），则使用亮度作为重绘强度（白色为重绘区域）
+func decodeMask(maskDataURL string, width, height int) (*image.Alpha, error) {
+	maskImg, err := decodeImageDataURL(maskDataURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid mask: %w", err)
+	}
+
+	resized := resizeBilinear(maskImg, width, height)
+
+	useLuminance := true
+	for i := 3; i < len(resized.Pix); i += 4 {
+		if resized.Pix[i] != 0xff {
+			useLuminance = false
+			break
+		}
+	}
+
+	alpha := image.NewAlpha(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			c := resized.NRGBAAt(x, y)
+			value := c.A
+			if useLuminance {
+				gray := color.GrayModel.Convert(color.NRGBA{R: c.R, G: c.G, B: c.B, A: 0xff}).(color.Gray)
+				value = gray.Y
+			}
+			alpha.SetAlpha(x, y, color.Alpha{A: value})
+		}
+	}
+
+	return alpha, nil
+}
+
+// buildMaskPreviewPNG 将蒙版转换为黑白 PNG（白色为重绘区域），用于发送给多模态模型
+func buildMaskPreviewPNG(maskDataURL string, width, height int) ([]byte, error) {
+	alpha, err := decodeMask(maskDataURL, width, height)
+	if err != nil {
+		return nil, err
+	}
+
+	gray := image.NewGray(alpha.Bounds())
+	copy(gray.Pix, alpha.Pix)
+	return encodePNG(gray)
+}
+
+// buildOpenAIMaskPNG 将蒙版转换为 OpenAI /images/edits 要求的格式
+// OpenAI 约定透明区域为需要重绘的区域，与画笔蒙版相反
+func buildOpenAIMaskPNG(maskDataURL string, width, height int) ([]byte, error) {
+	alpha, err := decodeMask(maskDataURL, width, height)
+	if err != nil {
+		return nil, err
+	}
+
+	mask := image.NewNRGBA(alpha.Bounds())
+	for i, value := range alpha.Pix {
+		mask.Pix[i*4+3] = 0xff - value
+	}
+	return encodePNG(mask)
+}
+
+// buildInpaintPrompt 构建局部重绘提示词，说明蒙版的含义
+func buildInpaintPrompt(prompt string) string {
+	return fmt.Sprintf(
+		"Edit ONLY the masked region of the first image. The second image is a black-and-white mask of the same size: "+
+			"white pixels mark the region to change, black pixels must remain exactly as in the original. "+
+			"Blend the edit seamlessly with the surrounding content and keep the original size and framing. "+
+			"Instruction: %s", prompt)
+}
+
+// ImageDimensions 返回 data URL 图像的尺寸
+func ImageDimensions(dataURL string) (int, int, error) {
+	decoded, err := base64.StdEncoding.DecodeString(extractBase64Data(dataURL))
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to decode base64 image: %w", err)
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(decoded))
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to decode image: %w", err)
+	}
+	return config.Width, config.Height, nil
+}
+
+// CompositeMasked 将编辑结果按蒙版合成回原图
+// 蒙版以外的像素直接取自原图，保证未选中的区域与原图完全一致；
+// 编辑结果与原图尺寸不同时会先缩放到原图尺寸
+// 返回 PNG data URL
+func CompositeMasked(originalDataURL, editedDataURL, maskDataURL string) (string, error) {
+	original, err := decodeImageDataURL(originalDataURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid original image: %w", err)
+	}
+
+	edited, err := decodeImageDataURL(editedDataURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid edited image: %w", err)
+	}
+
+	bounds := original.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+
+	alpha, err := decodeMask(maskDataURL, width, height)
+	if err != nil {
+		return "", err
+	}
+
+	base := image.NewNRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(base, base.Bounds(), original, bounds.Min, draw.Src)
+	editedResized := resizeBilinear(edited, width, height)
+
+	for i := 0; i < len(alpha.Pix); i++ {
+		weight := uint32(alpha.Pix[i])
+		if weight == 0 {
+			continue
+		}
+		offset := i * 4
+		for c := 0; c < 4; c++ {
+			o := uint32(base.Pix[offset+c])
+			e := uint32(editedResized.Pix[offset+c])
+			base.Pix[offset+c] = uint8((o*(255-weight) + e*weight + 127) / 255)
+		}
+	}
+
+	return encodePNGDataURL(base)
+}
+
+// ==================== 图像扩展（外绘） ====================
+
+// MaxExtendDimension 扩展后画布允许的最大边长
+const MaxExtendDimension = 8192
+
+// ExtendPadding 图像四个方向的扩展像素
+type ExtendPadding struct {
+	Top    int `json:"top"`
+	Right  int `json:"right"`
+	Bottom int `json:"bottom"`
+	Left   int `json:"left"`
+}
+
+// IsZero 判断是否没有任何扩展
+func (p ExtendPadding) IsZero() bool {
+	return p.Top == 0 && p.Right == 0 && p.Bottom == 0 && p.Left == 0
+}
+
+// PaddingForAspectRatio 计算将图像扩展到目标宽高比所需的扩展像素（原图居中）
+// aspectRatio 格式为 "宽:高"，如 "16:9"
+func PaddingForAspectRatio(width, height int, aspectRatio string) (ExtendPadding, error) {
+	parts := strings.Split(aspectRatio, ":")
+	if len(parts) != 2 {
+		return ExtendPadding{}, fmt.Errorf("invalid aspect ratio: %s", aspectRatio)
+	}
+	ratioW, errW := strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+	ratioH, errH := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+	if errW != nil || errH != nil || ratioW <= 0 || ratioH <= 0 {
+		return ExtendPadding{}, fmt.Errorf("invalid aspect ratio: %s", aspectRatio)
+	}
+
+	target := ratioW / ratioH
+	current := float64(width) / float64(height)
+
+	var padding ExtendPadding
+	if current < target {
+		// 原图偏窄，左右扩展
+		extra := int(float64(height)*target+0.5) - width
+		padding.Left = extra / 2
+		padding.Right = extra - padding.Left
+	} else if current > target {
+		// 原图偏宽，上下扩展
+		extra := int(float64(width)/target+0.5) - height
+		padding.Top = extra / 2
+		padding.Bottom = extra - padding.Top
+	}
+	return padding, nil
+}
+
+// BuildExtendCanvas 构建扩展画布和对应的蒙版
+// 画布中原图位于扩展后的位置，扩展区域用原图边缘像素延伸填充，为模型提供上下文；
+// 蒙版中扩展区域为不透明（重绘区域），原图区域为透明
+// 返回画布和蒙版的 PNG data URL
+func BuildExtendCanvas(imageDataURL string, padding ExtendPadding) (canvasDataURL string, maskDataURL string, err error) {
+	if padding.Top < 0 || padding.Right < 0 || padding.Bottom < 0 || padding.Left < 0 {
+		return "", "", fmt.Errorf("padding must not be negative")
+	}
+
+	src, err := decodeImageDataURL(imageDataURL)
+	if err != nil {
+		return "", "", err
+	}
+
+	srcBounds := src.Bounds()
+	srcW, srcH := srcBounds.Dx(), srcBounds.Dy()
+	width := srcW + padding.Left + padding.Right
+	height := srcH + padding.Top + padding.Bottom
+	if width > MaxExtendDimension || height > MaxExtendDimension {
+		return "", "", fmt.Errorf("extended image %dx%d exceeds the maximum size %d", width, height, MaxExtendDimension)
+	}
+
+	canvas := image.NewNRGBA(image.Rect(0, 0, width, height))
+	mask := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		sy := clampInt(y-padding.Top, 0, srcH-1)
+		for x := 0; x < width; x++ {
+			sx := clampInt(x-padding.Left, 0, srcW-1)
+			canvas.Set(x, y, src.At(srcBounds.Min.X+sx, srcBounds.Min.Y+sy))
+
+			inside := x >= padding.Left && x < padding.Left+srcW && y >= padding.Top && y < padding.Top+srcH
+			if !inside {
+				mask.SetNRGBA(x, y, color.NRGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff})
+			}
+		}
+	}
+
+	canvasDataURL, err = encodePNGDataURL(canvas)
+	if err != nil {
+		return "", "", err
+	}
+	maskDataURL, err = encodePNGDataURL(mask)
+	if err != nil {
+		return "", "", err
+	}
+	return canvasDataURL, maskDataURL, nil
+}
+
+// BuildOutpaintPrompt 构建外绘提示词
+func BuildOutpaintPrompt(prompt string) string {
+	instruction := "Extend this image outward (outpainting). The central region is the original image and must stay unchanged; " +
+		"the border areas were filled by stretching the edge pixels and must be replaced with new content that continues " +
+		"the scene naturally, matching perspective, lighting, style and texture. Return the full extended image at the same size."
+	if prompt == "" {
+		return instruction
+	}
+	return fmt.Sprintf("%s Content for the extended areas: %s", instruction, prompt)
+}
+
+// ==================== 生成尺寸 ====================
+
+// generationSize 根据尺寸等级和宽高比计算本地扩散模型的生成尺寸（长边按等级，边长取 8 的倍数）
+func generationSize(imageSize, aspectRatio string) (int, int) {
+	longEdge := 1024
+	switch strings.ToUpper(imageSize) {
+	case "2K":
+		longEdge = 2048
+	case "4K":
+		longEdge = 4096
+	}
+
+	ratioW, ratioH := 1.0, 1.0
+	if w, h, ok := strings.Cut(aspectRatio, ":"); ok {
+		if fw, err := strconv.ParseFloat(w, 64); err == nil && fw > 0 {
+			if fh, err := strconv.ParseFloat(h, 64); err == nil && fh > 0 {
+				ratioW, ratioH = fw, fh
+			}
+		}
+	}
+
+	roundTo8 := func(v float64) int {
+		return max(8, int(v/8+0.5)*8)
+	}
+	if ratioW >= ratioH {
+		return longEdge, roundTo8(float64(longEdge) * ratioH / ratioW)
+	}
+	return roundTo8(float64(longEdge) * ratioW / ratioH), longEdge
+}
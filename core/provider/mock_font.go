@@ -0,0 +1,179 @@
+package provider
+
+import (
+	"image"
+	"image/color"
+	"strings"
+	"unicode"
+)
+
+// ==================== Mock 位图字体 ====================
+
+// 5x7 点阵字体，每个字形 7 行，每行低 5 位从高到低对应从左到右的像素。
+// 只包含大写字母、数字和常用标点，小写字母按大写绘制，其他字符（如中文）绘制为方框
+
+const (
+	glyphWidth   = 5
+	glyphHeight  = 7
+	glyphAdvance = glyphWidth + 1 // 字符间距 1 像素
+	lineAdvance  = glyphHeight + 3
+)
+
+// unknownGlyph 字体中不存在的字符
+var unknownGlyph = [glyphHeight]uint8{0b11111, 0b10001, 0b10001, 0b10001, 0b10001, 0b10001, 0b11111}
+
+// mockGlyphs 字形表
+var mockGlyphs = map[rune][glyphHeight]uint8{
+	' ':  {},
+	'A':  {0b01110, 0b10001, 0b10001, 0b11111, 0b10001, 0b10001, 0b10001},
+	'B':  {0b11110, 0b10001, 0b10001, 0b11110, 0b10001, 0b10001, 0b11110},
+	'C':  {0b01110, 0b10001, 0b10000, 0b10000, 0b10000, 0b10001, 0b01110},
+	'D':  {0b11110, 0b10001, 0b10001, 0b10001, 0b10001, 0b10001, 0b11110},
+	'E':  {0b11111, 0b10000, 0b10000, 0b11110, 0b10000, 0b10000, 0b11111},
+	'F':  {0b11111, 0b10000, 0b10000, 0b11110, 0b10000, 0b10000, 0b10000},
+	'G':  {0b01110, 0b10001, 0b10000, 0b10111, 0b10001, 0b10001, 0b01111},
+	'H':  {0b10001, 0b10001, 0b10001, 0b11111, 0b10001, 0b10001, 0b10001},
+	'I':  {0b01110, 0b00100, 0b00100, 0b00100, 0b00100, 0b00100, 0b01110},
+	'J':  {0b00111, 0b00010, 0b00010, 0b00010, 0b00010, 0b10010, 0b01100},
+	'K':  {0b10001, 0b10010, 0b10100, 0b11000, 0b10100, 0b10010, 0b10001},
+	'L':  {0b10000, 0b10000, 0b10000, 0b10000, 0b10000, 0b10000, 0b11111},
+	'M':  {0b10001, 0b11011, 0b10101, 0b10101, 0b10001, 0b10001, 0b10001},
+	'N':  {0b10001, 0b10001, 0b11001, 0b10101, 0b10011, 0b10001, 0b10001},
+	'O':  {0b01110, 0b10001, 0b10001, 0b10001, 0b10001, 0b10001, 0b01110},
+	'P':  {0b11110, 0b10001, 0b10001, 0b11110, 0b10000, 0b10000, 0b10000},
+	'Q':  {0b01110, 0b10001, 0b10001, 0b10001, 0b10101, 0b10010, 0b01101},
+	'R':  {0b11110, 0b10001, 0b10001, 0b11110, 0b10100, 0b10010, 0b10001},
+	'S':  {0b01111, 0b10000, 0b10000, 0b01110, 0b00001, 0b00001, 0b11110},
+	'T':  {0b11111, 0b00100, 0b00100, 0b00100, 0b00100, 0b00100, 0b00100},
+	'U':  {0b10001, 0b10001, 0b10001, 0b10001, 0b10001, 0b10001, 0b01110},
+	'V':  {0b10001, 0b10001, 0b10001, 0b10001, 0b10001, 0b01010, 0b00100},
+	'W':  {0b10001, 0b10001, 0b10001, 0b10101, 0b10101, 0b10101, 0b01010},
+	'X':  {0b10001, 0b10001, 0b01010, 0b00100, 0b01010, 0b10001, 0b10001},
+	'Y':  {0b10001, 0b10001, 0b01010, 0b00100, 0b00100, 0b00100, 0b00100},
+	'Z':  {0b11111, 0b00001, 0b00010, 0b00100, 0b01000, 0b10000, 0b11111},
+	'0':  {0b01110, 0b10001, 0b10011, 0b10101, 0b11001, 0b10001, 0b01110},
+	'1':  {0b00100, 0b01100, 0b00100, 0b00100, 0b00100, 0b00100, 0b01110},
+	'2':  {0b01110, 0b10001, 0b00001, 0b00010, 0b00100, 0b01000, 0b11111},
+	'3':  {0b11111, 0b00010, 0b00100, 0b00010, 0b00001, 0b10001, 0b01110},
+	'4':  {0b00010, 0b00110, 0b01010, 0b10010, 0b11111, 0b00010, 0b00010},
+	'5':  {0b11111, 0b10000, 0b11110, 0b00001, 0b00001, 0b10001, 0b01110},
+	'6':  {0b00110, 0b01000, 0b10000, 0b11110, 0b10001, 0b10001, 0b01110},
+	'7':  {0b11111, 0b00001, 0b00010, 0b00100, 0b01000, 0b01000, 0b01000},
+	'8':  {0b01110, 0b10001, 0b10001, 0b01110, 0b10001, 0b10001, 0b01110},
+	'9':  {0b01110, 0b10001, 0b10001, 0b01111, 0b00001, 0b00010, 0b01100},
+	'.':  {0b00000, 0b00000, 0b00000, 0b00000, 0b00000, 0b01100, 0b01100},
+	',':  {0b00000, 0b00000, 0b00000, 0b00000, 0b01100, 0b00100, 0b01000},
+	'!':  {0b00100, 0b00100, 0b00100, 0b00100, 0b00100, 0b00000, 0b00100},
+	'?':  {0b01110, 0b10001, 0b00001, 0b00010, 0b00100, 0b00000, 0b00100},
+	'-':  {0b00000, 0b00000, 0b00000, 0b11111, 0b00000, 0b00000, 0b00000},
+	':':  {0b00000, 0b01100, 0b01100, 0b00000, 0b01100, 0b01100, 0b00000},
+	';':  {0b00000, 0b01100, 0b01100, 0b00000, 0b01100, 0b00100, 0b01000},
+	'\'': {0b00100, 0b00100, 0b01000, 0b00000, 0b00000, 0b00000, 0b00000},
+	'"':  {0b01010, 0b01010, 0b00000, 0b00000, 0b00000, 0b00000, 0b00000},
+	'(':  {0b00010, 0b00100, 0b01000, 0b01000, 0b01000, 0b00100, 0b00010},
+	')':  {0b01000, 0b00100, 0b00010, 0b00010, 0b00010, 0b00100, 0b01000},
+	'/':  {0b00001, 0b00010, 0b00010, 0b00100, 0b01000, 0b01000, 0b10000},
+	'+':  {0b00000, 0b00100, 0b00100, 0b11111, 0b00100, 0b00100, 0b00000},
+	'=':  {0b00000, 0b00000, 0b11111, 0b00000, 0b11111, 0b00000, 0b00000},
+	'_':  {0b00000, 0b00000, 0b00000, 0b00000, 0b00000, 0b00000, 0b11111},
+	'*':  {0b00000, 0b00100, 0b10101, 0b01110, 0b10101, 0b00100, 0b00000},
+	'#':  {0b01010, 0b01010, 0b11111, 0b01010, 0b11111, 0b01010, 0b01010},
+	'%':  {0b11000, 0b11001, 0b00010, 0b00100, 0b01000, 0b10011, 0b00011},
+	'&':  {0b01100, 0b10010, 0b10100, 0b01000, 0b10101, 0b10010, 0b01101},
+}
+
+// glyphFor 返回字符对应的字形
+func glyphFor(r rune) [glyphHeight]uint8 {
+	if glyph, ok := mockGlyphs[unicode.ToUpper(r)]; ok {
+		return glyph
+	}
+	if unicode.IsSpace(r) {
+		return mockGlyphs[' ']
+	}
+	return unknownGlyph
+}
+
+// wrapText 按最大字符数折行，超过 maxLines 行时截断并以 "..." 结尾
+func wrapText(text string, maxChars, maxLines int) []string {
+	if maxChars < 1 || maxLines < 1 {
+		return nil
+	}
+
+	var lines []string
+	var line []rune
+	flush := func() {
+		lines = append(lines, string(line))
+		line = line[:0]
+	}
+	for _, word := range strings.Fields(text) {
+		runes := []rune(word)
+		// 超长的单词按字符拆分
+		for len(runes) > 0 {
+			if len(line) > 0 && len(line)+1+len(runes) > maxChars {
+				flush()
+			}
+			if len(line) > 0 {
+				line = append(line, ' ')
+			}
+			n := min(len(runes), maxChars-len(line))
+			line = append(line, runes[:n]...)
+			runes = runes[n:]
+			if len(runes) > 0 {
+				flush()
+			}
+		}
+	}
+	if len(line) > 0 {
+		flush()
+	}
+
+	if len(lines) > maxLines {
+		last := []rune(lines[maxLines-1])
+		if len(last) > maxChars-3 {
+			last = last[:max(0, maxChars-3)]
+		}
+		lines = append(lines[:maxLines-1], string(last)+"...")
+	}
+	return lines
+}
+
+// textWidth 返回一行文本按指定缩放倍数绘制后的宽度
+func textWidth(text string, scale int) int {
+	n := len([]rune(text))
+	if n == 0 {
+		return 0
+	}
+	return (n*glyphAdvance - 1) * scale
+}
+
+// drawText 以 (x, y) 为左上角绘制一行文本，每个点阵像素绘制为 scale x scale 的方块
+func drawText(img *image.NRGBA, text string, x, y, scale int, c color.NRGBA) {
+	for _, r := range text {
+		glyph := glyphFor(r)
+		for row := 0; row < glyphHeight; row++ {
+			for col := 0; col < glyphWidth; col++ {
+				if glyph[row]&(1<<(glyphWidth-1-col)) == 0 {
+					continue
+				}
+				fillRect(img, image.Rect(x+col*scale, y+row*scale, x+(col+1)*scale, y+(row+1)*scale), c)
+			}
+		}
+		x += glyphAdvance * scale
+	}
+}
+
+// fillRect 以 alpha 混合方式填充矩形（超出图像的部分被裁剪）
+func fillRect(img *image.NRGBA, rect image.Rectangle, c color.NRGBA) {
+	rect = rect.Intersect(img.Bounds())
+	a := uint32(c.A)
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			i := img.PixOffset(x, y)
+			pix := img.Pix[i : i+4 : i+4]
+			pix[0] = uint8((uint32(c.R)*a + uint32(pix[0])*(255-a)) / 255)
+			pix[1] = uint8((uint32(c.G)*a + uint32(pix[1])*(255-a)) / 255)
+			pix[2] = uint8((uint32(c.B)*a + uint32(pix[2])*(255-a)) / 255)
+			pix[3] = uint8(a + uint32(pix[3])*(255-a)/255)
+		}
+	}
+}
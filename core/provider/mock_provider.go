@@ -0,0 +1,355 @@
+package provider
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"indraw/core/types"
+	"math"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// 模拟失败类型
+const (
+	mockFailureRateLimit = "rate_limit"
+	mockFailureTimeout   = "timeout"
+	mockFailureSafety    = "safety"
+)
+
+// mockModel 模拟提供商报告的模型名称
+const mockModel = "mock"
+
+// mockEnhanceSuffix 提示词增强追加的固定描述
+const mockEnhanceSuffix = "highly detailed, soft cinematic lighting, balanced composition, rich color palette, sharp focus"
+
+// mockDirectivePattern 提示词中强制模拟失败的指令，如 "[mock:rate_limit]"、"[mock:timeout]"、"[mock:safety]"
+var mockDirectivePattern = regexp.MustCompile(`\[mock:(rate_limit|429|timeout|safety)\]`)
+
+// ==================== MockProvider 实现 ====================
+
+// MockProvider 模拟提供商（离线开发和测试用）
+// 不访问网络，根据提示词哈希生成确定性的图像：渐变背景（颜色和方向由哈希决定）上绘制提示词文字。
+// 可以通过设置模拟延迟和失败（限流、超时、安全拦截），也可以在提示词中使用 "[mock:...]" 指令强制失败
+type MockProvider struct {
+	ctx      context.Context
+	settings types.AISettings
+}
+
+// NewMockProvider 创建模拟提供商实例
+func NewMockProvider(ctx context.Context, settings types.AISettings) (*MockProvider, error) {
+	return &MockProvider{
+		ctx:      ctx,
+		settings: settings,
+	}, nil
+}
+
+// Name 返回提供商名称
+func (p *MockProvider) Name() string {
+	return "mock"
+}
+
+// GetCapabilities 返回提供商支持的功能（放大使用服务层的本地重采样）
+func (p *MockProvider) GetCapabilities() ProviderCapabilities {
+	return ProviderCapabilities{
+		GenerateImage:    true,
+		EditImage:        true,
+		EnhancePrompt:    true,
+		BlendImages:      true,
+		RemoveBackground: true,
+		ReferenceImage:   true,
+		Inpaint:          true,
+		ExtendImage:      true,
+	}
+}
+
+// CheckAvailability 检测服务可用性（总是可用）
+func (p *MockProvider) CheckAvailability(ctx context.Context) (bool, error) {
+	return true, nil
+}
+
+// Close 清理资源
+func (p *MockProvider) Close() error {
+	return nil
+}
+
+// ==================== API 方法实现 ====================
+
+// GenerateImage 生成图像
+// 尺寸按宽高比和尺寸等级计算，每张候选图像使用不同的种子
+func (p *MockProvider) GenerateImage(ctx context.Context, params types.GenerateImageParams) (*ImageResult, error) {
+	if err := p.simulate(ctx, params.Prompt); err != nil {
+		return nil, err
+	}
+
+	count := normalizeImageCount(params.Count)
+	width, height := generationSize(params.ImageSize, params.AspectRatio)
+
+	images := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		seed := mockSeed(params.Prompt, i)
+		img := image.NewNRGBA(image.Rect(0, 0, width, height))
+		paintMockGradient(img, seed)
+		drawMockCaption(img, params.Prompt, seed)
+
+		dataURL, err := encodePNGDataURL(img)
+		if err != nil {
+			return nil, err
+		}
+		images = append(images, dataURL)
+	}
+
+	reportUsage(ctx, Usage{Provider: p.Name(), Model: mockModel, Images: count})
+	return &ImageResult{Images: images}, nil
+}
+
+// EditImage 编辑图像
+// 在原图上叠加渐变（有蒙版时只叠加在重绘区域）并绘制提示词，输出尺寸与原图一致
+func (p *MockProvider) EditImage(ctx context.Context, params types.EditImageParams) (string, error) {
+	if err := p.simulate(ctx, params.Prompt); err != nil {
+		return "", err
+	}
+
+	src, err := p.decodeInput(params.ImageData)
+	if err != nil {
+		return "", err
+	}
+	bounds := src.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+
+	var mask *image.Alpha
+	if params.Mask != "" {
+		if mask, err = decodeMask(params.Mask, width, height); err != nil {
+			return "", err
+		}
+	}
+
+	seed := mockSeed(params.Prompt, 0)
+	img := overlayMockGradient(src, mask, seed)
+	drawMockCaption(img, params.Prompt, seed)
+
+	reportUsage(ctx, Usage{Provider: p.Name(), Model: mockModel, Images: 1})
+	return encodePNGDataURL(img)
+}
+
+// EditMultiImages 多图编辑/融合
+// 以第一张图像为底图叠加渐变并绘制提示词
+func (p *MockProvider) EditMultiImages(ctx context.Context, params types.MultiImageEditParams) (string, error) {
+	if len(params.Images) < 2 {
+		return "", fmt.Errorf("at least 2 images are required")
+	}
+	if err := p.simulate(ctx, params.Prompt); err != nil {
+		return "", err
+	}
+
+	src, err := p.decodeInput(params.Images[0])
+	if err != nil {
+		return "", err
+	}
+
+	seed := mockSeed(params.Prompt, len(params.Images))
+	img := overlayMockGradient(src, nil, seed)
+	drawMockCaption(img, params.Prompt, seed)
+
+	reportUsage(ctx, Usage{Provider: p.Name(), Model: mockModel, Images: 1})
+	return encodePNGDataURL(img)
+}
+
+// EnhancePrompt 增强提示词（在原提示词后追加固定描述）
+func (p *MockProvider) EnhancePrompt(ctx context.Context, prompt string) (string, error) {
+	if err := p.simulate(ctx, prompt); err != nil {
+		return "", err
+	}
+
+	enhanced := strings.TrimSpace(prompt) + ", " + mockEnhanceSuffix
+	newProgressTracker(ctx).text(enhanced)
+
+	reportUsage(ctx, Usage{
+		Provider:     p.Name(),
+		Model:        mockModel,
+		InputTokens:  int64(len(strings.Fields(prompt))),
+		OutputTokens: int64(len(strings.Fields(enhanced))),
+	})
+	return enhanced, nil
+}
+
+// ==================== 内部方法 ====================
+
+// decodeInput 规范化并解码输入图像，与真实提供商经过相同的处理
+func (p *MockProvider) decodeInput(data string) (image.Image, error) {
+	input, err := normalizeInputImage(data, mockInputPolicy.withSettings(p.settings))
+	if err != nil {
+		return nil, err
+	}
+	return decodeImageDataURL(input.DataURL())
+}
+
+// simulate 模拟请求延迟和失败
+// 延迟期间按步骤报告进度，操作取消时立即返回
+func (p *MockProvider) simulate(ctx context.Context, prompt string) error {
+	failure := p.failureFor(prompt)
+
+	if latency := time.Duration(p.settings.MockLatencyMs) * time.Millisecond; latency > 0 {
+		const steps = 10
+		tracker := newProgressTracker(ctx)
+		for step := 1; step <= steps; step++ {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(latency / steps):
+			}
+			tracker.steps(step, steps)
+		}
+	}
+
+	switch failure {
+	case mockFailureRateLimit:
+		return fmt.Errorf("mock API returned status 429: rate limit exceeded (simulated)")
+	case mockFailureTimeout:
+		return fmt.Errorf("mock request timed out (simulated): %w", context.DeadlineExceeded)
+	case mockFailureSafety:
+		return &NoImageError{
+			Reason:        NoImageBlocked,
+			FinishReason:  "SAFETY",
+			Message:       "simulated safety block",
+			SafetyRatings: []SafetyRating{{Category: "HARM_CATEGORY_DANGEROUS_CONTENT", Probability: "HIGH", Blocked: true}},
+		}
+	}
+	return nil
+}
+
+// failureFor 判断请求是否需要模拟失败，返回失败类型（不失败时为空）
+// 提示词中的指令优先；否则按设置的失败类型和概率决定，概率由提示词哈希确定，同一提示词的结果总是相同。
+// 未设置失败类型（MockFailure 为空）时从不失败；设置了失败类型时，概率为 1 或未设置（0）表示总是失败
+func (p *MockProvider) failureFor(prompt string) string {
+	if match := mockDirectivePattern.FindStringSubmatch(prompt); match != nil {
+		if match[1] == "429" {
+			return mockFailureRateLimit
+		}
+		return match[1]
+	}
+
+	// 未设置失败类型时从不失败（与概率无关）
+	failure := p.settings.MockFailure
+	if failure == "" {
+		return ""
+	}
+
+	// 设置了失败类型：概率为 1（或未设置概率）时总是失败，0 到 1 之间按概率失败
+	rate := p.settings.MockFailureRate
+	if rate <= 0 || rate >= 1 {
+		return failure
+	}
+
+	seed := mockSeed(prompt, -1)
+	if float64(binary.BigEndian.Uint32(seed[:4]))/math.MaxUint32 < rate {
+		return failure
+	}
+	return ""
+}
+
+// ==================== 图像绘制 ====================
+
+// mockSeed 根据提示词和序号计算种子
+func mockSeed(prompt string, index int) [sha256.Size]byte {
+	return sha256.Sum256([]byte(fmt.Sprintf("%s#%d", prompt, index)))
+}
+
+// paintMockGradient 绘制线性渐变，起止颜色和方向由种子决定
+func paintMockGradient(img *image.NRGBA, seed [sha256.Size]byte) {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+
+	from := color.NRGBA{R: seed[0], G: seed[1], B: seed[2], A: 0xff}
+	to := color.NRGBA{R: seed[3], G: seed[4], B: seed[5], A: 0xff}
+	angle := float64(seed[6]) / 256 * 2 * math.Pi
+	dx, dy := math.Cos(angle), math.Sin(angle)
+
+	// 投影到渐变方向并归一化到 [0, 1]
+	halfSpan := (math.Abs(dx)*float64(width) + math.Abs(dy)*float64(height)) / 2
+	cx, cy := float64(width)/2, float64(height)/2
+	lerp := func(a, b uint8, t float64) uint8 {
+		return uint8(float64(a) + (float64(b)-float64(a))*t + 0.5)
+	}
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			t := ((float64(x)-cx)*dx+(float64(y)-cy)*dy)/(2*halfSpan) + 0.5
+			t = clampFloat(t, 0, 1)
+			i := img.PixOffset(bounds.Min.X+x, bounds.Min.Y+y)
+			img.Pix[i] = lerp(from.R, to.R, t)
+			img.Pix[i+1] = lerp(from.G, to.G, t)
+			img.Pix[i+2] = lerp(from.B, to.B, t)
+			img.Pix[i+3] = 0xff
+		}
+	}
+}
+
+// overlayMockGradient 在原图上叠加渐变
+// 没有蒙版时以 50% 不透明度叠加整张图像，有蒙版时按蒙版强度叠加
+func overlayMockGradient(src image.Image, mask *image.Alpha, seed [sha256.Size]byte) *image.NRGBA {
+	bounds := src.Bounds()
+	img := image.NewNRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(img, img.Bounds(), src, bounds.Min, draw.Src)
+
+	gradient := image.NewNRGBA(img.Bounds())
+	paintMockGradient(gradient, seed)
+
+	for y := 0; y < img.Rect.Dy(); y++ {
+		for x := 0; x < img.Rect.Dx(); x++ {
+			weight := uint32(128)
+			if mask != nil {
+				weight = uint32(mask.AlphaAt(x, y).A)
+			}
+			i := img.PixOffset(x, y)
+			for c := 0; c < 3; c++ {
+				img.Pix[i+c] = uint8((uint32(gradient.Pix[i+c])*weight + uint32(img.Pix[i+c])*(255-weight)) / 255)
+			}
+			img.Pix[i+3] = uint8(weight + uint32(img.Pix[i+3])*(255-weight)/255)
+		}
+	}
+	return img
+}
+
+// drawMockCaption 在图像中部绘制提示词，左下角绘制尺寸和种子标记
+// 文字大小随图像宽度缩放，文字下方绘制半透明底色以保证可读性
+func drawMockCaption(img *image.NRGBA, prompt string, seed [sha256.Size]byte) {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+
+	caption := strings.TrimSpace(mockDirectivePattern.ReplaceAllString(prompt, ""))
+	if caption == "" {
+		caption = "(empty prompt)"
+	}
+
+	scale := max(1, width/(32*glyphAdvance))
+	margin := 2 * glyphAdvance * scale
+	maxChars := (width - 2*margin + scale) / (glyphAdvance * scale)
+	maxLines := max(1, height/2/(lineAdvance*scale))
+	lines := wrapText(caption, maxChars, maxLines)
+
+	white := color.NRGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff}
+	shade := color.NRGBA{A: 0x70}
+
+	blockHeight := len(lines)*lineAdvance*scale - (lineAdvance-glyphHeight)*scale
+	top := bounds.Min.Y + (height-blockHeight)/2
+	padding := 2 * scale
+	fillRect(img, image.Rect(bounds.Min.X, top-padding, bounds.Max.X, top+blockHeight+padding), shade)
+	for i, line := range lines {
+		x := bounds.Min.X + (width-textWidth(line, scale))/2
+		drawText(img, line, x, top+i*lineAdvance*scale, scale, white)
+	}
+
+	// 标记：MOCK 宽x高 #种子前 6 位
+	footer := fmt.Sprintf("MOCK %dX%d #%s", width, height, strings.ToUpper(hex.EncodeToString(seed[:3])))
+	footerScale := max(1, scale/2)
+	footerTop := bounds.Max.Y - glyphHeight*footerScale - 2*padding
+	fillRect(img, image.Rect(bounds.Min.X, footerTop-padding, bounds.Min.X+textWidth(footer, footerScale)+2*padding, bounds.Max.Y), shade)
+	drawText(img, footer, bounds.Min.X+padding, footerTop, footerScale, white)
+}
@@ -0,0 +1,58 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"indraw/core/types"
+	"testing"
+)
+
+func TestMockFailureFor(t *testing.T) {
+	tests := []struct {
+		name     string
+		failure  string
+		rate     float64
+		prompt   string
+		wantFail bool
+	}{
+		{"no failure configured", "", 0, "a cat", false},
+		{"rate without failure type never fails", "", 1, "a cat", false},
+		{"failure type without rate always fails", mockFailureSafety, 0, "a cat", true},
+		{"rate 1 always fails", mockFailureRateLimit, 1, "a cat", true},
+		{"directive overrides settings", "", 0, "a cat [mock:timeout]", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &MockProvider{settings: types.AISettings{AIConnectionSettings: types.AIConnectionSettings{MockFailure: tt.failure, MockFailureRate: tt.rate}}}
+			if got := p.failureFor(tt.prompt) != ""; got != tt.wantFail {
+				t.Errorf("failureFor(%q) fails = %v, want %v", tt.prompt, got, tt.wantFail)
+			}
+		})
+	}
+
+	t.Run("partial rate is deterministic per prompt", func(t *testing.T) {
+		p := &MockProvider{settings: types.AISettings{AIConnectionSettings: types.AIConnectionSettings{MockFailure: mockFailureRateLimit, MockFailureRate: 0.5}}}
+		failures := 0
+		for i := 0; i < 200; i++ {
+			prompt := fmt.Sprintf("prompt %d", i)
+			failure := p.failureFor(prompt)
+			if failure != p.failureFor(prompt) {
+				t.Fatalf("failureFor(%q) is not deterministic", prompt)
+			}
+			if failure != "" {
+				failures++
+			}
+		}
+		if failures < 60 || failures > 140 {
+			t.Errorf("%d of 200 prompts failed, want about half", failures)
+		}
+	})
+}
+
+func TestMockEditMultiImagesRequiresTwoImages(t *testing.T) {
+	p, _ := NewMockProvider(context.Background(), testSettings())
+	_, err := p.EditMultiImages(context.Background(), types.MultiImageEditParams{Images: []string{testImageDataURL(t, 32, 32)}, Prompt: "blend"})
+	if err == nil {
+		t.Error("EditMultiImages() with one image succeeded, want an error")
+	}
+}
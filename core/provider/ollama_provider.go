@@ -0,0 +1,290 @@
+package provider
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"indraw/core/apperr"
+	"indraw/core/types"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultOllamaURL Ollama 默认服务地址
+const defaultOllamaURL = "http://127.0.0.1:11434"
+
+// thinkBlockPattern 推理模型（如 deepseek-r1、qwen3）在正文前输出的思考过程
+var thinkBlockPattern = regexp.MustCompile(`(?s)<think>.*?</think>`)
+
+// ==================== OllamaProvider 实现 ====================
+
+// OllamaProvider Ollama 本地大模型提供商（仅文本）
+// 用于提示词增强，通过 /api/chat 调用本地模型，图像功能仍由主提供商处理
+type OllamaProvider struct {
+	ctx        context.Context
+	baseURL    string
+	httpClient *http.Client
+	settings   types.AISettings
+
+	modelMu sync.Mutex
+	model   string // 实际使用的模型（未配置时为第一个已安装的模型）
+}
+
+// NewOllamaProvider 创建 Ollama 提供商实例
+func NewOllamaProvider(ctx context.Context, settings types.AISettings) (*OllamaProvider, error) {
+	baseURL := strings.TrimSuffix(settings.OllamaURL, "/")
+	if baseURL == "" {
+		baseURL = defaultOllamaURL
+	}
+
+	return &OllamaProvider{
+		ctx:        ctx,
+		baseURL:    baseURL,
+		httpClient: newHTTPClient(settings, 0),
+		settings:   settings,
+		model:      settings.OllamaModel,
+	}, nil
+}
+
+// Name 返回提供商名称
+func (p *OllamaProvider) Name() string {
+	return "ollama"
+}
+
+// GetCapabilities 返回提供商支持的功能（仅提示词增强）
+func (p *OllamaProvider) GetCapabilities() ProviderCapabilities {
+	return ProviderCapabilities{
+		EnhancePrompt: true,
+	}
+}
+
+// CheckAvailability 检测服务可用性
+// 同时检查配置的模型是否已安装
+func (p *OllamaProvider) CheckAvailability(ctx context.Context) (bool, error) {
+	testCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	models, err := p.ListModels(testCtx)
+	if err != nil {
+		return false, err
+	}
+	if len(models) == 0 {
+		return false, fmt.Errorf("no models installed in Ollama: run `ollama pull <model>` first")
+	}
+
+	if p.settings.OllamaModel != "" && findOllamaModel(models, p.settings.OllamaModel) == "" {
+		return false, fmt.Errorf("model %s is not installed in Ollama", p.settings.OllamaModel)
+	}
+	return true, nil
+}
+
+// Close 清理资源
+func (p *OllamaProvider) Close() error {
+	if p.httpClient != nil {
+		p.httpClient.CloseIdleConnections()
+	}
+	return nil
+}
+
+// ListModels 列出已安装的模型
+func (p *OllamaProvider) ListModels(ctx context.Context) ([]ModelInfo, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", p.baseURL+"/api/tags", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("Ollama server unavailable: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("Ollama returned status %d: %s", resp.StatusCode, truncateString(string(bodyBytes), 200))
+	}
+
+	var tags struct {
+		Models []struct {
+			Name  string `json:"name"`
+			Model string `json:"model"`
+		} `json:"models"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tags); err != nil {
+		return nil, fmt.Errorf("failed to parse Ollama model list: %w", err)
+	}
+
+	models := make([]ModelInfo, 0, len(tags.Models))
+	for _, model := range tags.Models {
+		id := model.Model
+		if id == "" {
+			id = model.Name
+		}
+		models = append(models, ModelInfo{ID: id, Name: model.Name})
+	}
+	return models, nil
+}
+
+// ==================== API 方法实现 ====================
+
+// EnhancePrompt 增强提示词
+// 以流式方式调用 /api/chat，生成过程中报告文本进度
+func (p *OllamaProvider) EnhancePrompt(ctx context.Context, prompt string) (string, error) {
+	model, err := p.resolveModel(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"model": model,
+		"messages": []map[string]string{
+			{
+				"role": "system",
+				"content": "You are an expert AI art prompt engineer. Enhance prompts to be more detailed and effective for image generation. " +
+					"Add details about lighting, style, composition, and mood. Return ONLY the enhanced prompt without any explanation.",
+			},
+			{
+				"role":    "user",
+				"content": "Enhance this prompt: " + prompt,
+			},
+		},
+		"stream": true,
+		"options": map[string]interface{}{
+			"temperature": 0.7,
+			"num_predict": 500,
+		},
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", p.baseURL+"/api/chat", bytes.NewReader(body))
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("Ollama chat API error: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return "", fmt.Errorf("Ollama chat API returned status %d: %s", resp.StatusCode, truncateString(string(bodyBytes), 500))
+	}
+
+	// 流式响应为每行一个 JSON 对象，最后一个对象 done 为 true 并带有 Token 统计
+	tracker := newProgressTracker(ctx)
+	var content strings.Builder
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		tracker.addBytes(len(line))
+
+		var chunk struct {
+			Message struct {
+				Content string `json:"content"`
+			} `json:"message"`
+			Done            bool   `json:"done"`
+			Error           string `json:"error"`
+			PromptEvalCount int64  `json:"prompt_eval_count"`
+			EvalCount       int64  `json:"eval_count"`
+		}
+		if err := json.Unmarshal(line, &chunk); err != nil {
+			return "", fmt.Errorf("failed to parse Ollama stream: %w", err)
+		}
+		if chunk.Error != "" {
+			return "", fmt.Errorf("Ollama error: %s", chunk.Error)
+		}
+
+		content.WriteString(chunk.Message.Content)
+		tracker.text(chunk.Message.Content)
+
+		if chunk.Done {
+			reportUsage(ctx, Usage{
+				Provider:     p.Name(),
+				Model:        model,
+				InputTokens:  chunk.PromptEvalCount,
+				OutputTokens: chunk.EvalCount,
+			})
+			break
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", fmt.Errorf("failed to read Ollama stream: %w", err)
+	}
+
+	enhancedPrompt := strings.TrimSpace(thinkBlockPattern.ReplaceAllString(content.String(), ""))
+	if enhancedPrompt == "" {
+		return prompt, nil
+	}
+	return enhancedPrompt, nil
+}
+
+// GenerateImage 生成图像（Ollama 不支持）
+func (p *OllamaProvider) GenerateImage(ctx context.Context, params types.GenerateImageParams) (*ImageResult, error) {
+	return nil, apperr.New(apperr.CodeUnsupportedFeature, "aiProvider ollama does not support image generation")
+}
+
+// EditImage 编辑图像（Ollama 不支持）
+func (p *OllamaProvider) EditImage(ctx context.Context, params types.EditImageParams) (string, error) {
+	return "", apperr.New(apperr.CodeUnsupportedFeature, "aiProvider ollama does not support image editing")
+}
+
+// EditMultiImages 多图编辑/融合（Ollama 不支持）
+func (p *OllamaProvider) EditMultiImages(ctx context.Context, params types.MultiImageEditParams) (string, error) {
+	return "", apperr.New(apperr.CodeUnsupportedFeature, "aiProvider ollama does not support multi-image editing")
+}
+
+// ==================== 内部方法 ====================
+
+// resolveModel 返回要使用的模型，未配置时使用第一个已安装的模型
+func (p *OllamaProvider) resolveModel(ctx context.Context) (string, error) {
+	p.modelMu.Lock()
+	defer p.modelMu.Unlock()
+
+	if p.model != "" {
+		return p.model, nil
+	}
+
+	models, err := p.ListModels(ctx)
+	if err != nil {
+		return "", err
+	}
+	if len(models) == 0 {
+		return "", fmt.Errorf("no models installed in Ollama: run `ollama pull <model>` first")
+	}
+
+	p.model = models[0].ID
+	fmt.Printf("[Ollama] No model configured, using %s\n", p.model)
+	return p.model, nil
+}
+
+// findOllamaModel 在已安装的模型中查找配置的模型
+// 未指定标签时 Ollama 默认使用 latest，如 "llama3.2" 等同于 "llama3.2:latest"
+func findOllamaModel(models []ModelInfo, name string) string {
+	candidates := []string{name}
+	if !strings.Contains(name, ":") {
+		candidates = append(candidates, name+":latest")
+	}
+	for _, model := range models {
+		for _, candidate := range candidates {
+			if model.ID == candidate || model.Name == candidate {
+				return model.ID
+			}
+		}
+	}
+	return ""
+}
@@ -1,13 +1,20 @@
 package provider
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"indraw/core/apperr"
 	"indraw/core/types"
 	"io"
+	"math"
+	"net/http"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	openai "github.com/sashabaranov/go-openai"
@@ -23,6 +30,9 @@ var openaiImageAPICapabilities = ProviderCapabilities{
 	BlendImages:      false,
 	RemoveBackground: false,
 	ReferenceImage:   false,
+	Inpaint:          false,
+	ExtendImage:      false,
+	Upscale:          false,
 }
 
 // openaiChatCapabilities 使用 Chat API 时的功能支持矩阵（类似 Gemini）
@@ -33,6 +43,9 @@ var openaiChatCapabilities = ProviderCapabilities{
 	BlendImages:      true,
 	RemoveBackground: true,
 	ReferenceImage:   true,
+	Inpaint:          true,
+	ExtendImage:      true,
+	Upscale:          false,
 }
 
 // ==================== OpenAIProvider 实现 ====================
@@ -47,13 +60,24 @@ type OpenAIProvider struct {
 	imageClient *openai.Client // 用于图像相关的 API
 	settings    types.AISettings
 	imageMode   string // 实际使用的图像模式
+
+	// 图像 API 的原始连接配置，用于 SDK 尚不支持的流式图像生成
+	imageBaseURL    string
+	imageAPIKey     string
+	imageHTTPClient *http.Client
+
+	// 下载模型返回的远程图像链接（第三方中继常返回 URL 而不是图像数据）
+	imageFetcher *remoteImageFetcher
+
+	// 服务端拒绝 stream_options 参数（部分中继服务不支持），之后的流式请求不再携带
+	streamOptionsRejected atomic.Bool
 }
 
 // NewOpenAIProvider 创建 OpenAI 提供商实例
 func NewOpenAIProvider(ctx context.Context, settings types.AISettings) (*OpenAIProvider, error) {
 	apiKey := settings.OpenAIAPIKey
 	if apiKey == "" {
-		return nil, fmt.Errorf("OpenAI API key not configured")
+		return nil, apperr.New(apperr.CodeAuthInvalid, "OpenAI API key not configured")
 	}
 
 	// 创建 Chat 客户端（用于文本/聊天相关 API）
@@ -61,6 +85,7 @@ func NewOpenAIProvider(ctx context.Context, settings types.AISettings) (*OpenAIP
 	if settings.OpenAIBaseURL != "" {
 		chatConfig.BaseURL = settings.OpenAIBaseURL
 	}
+	chatConfig.HTTPClient = newHTTPClient(settings, 0)
 	chatClient := openai.NewClientWithConfig(chatConfig)
 
 	// 创建 Image 客户端（用于图像相关 API）
@@ -78,6 +103,8 @@ func NewOpenAIProvider(ctx context.Context, settings types.AISettings) (*OpenAIP
 		// 未配置独立 URL 时使用通用 Base URL
 		imageConfig.BaseURL = settings.OpenAIBaseURL
 	}
+	imageHTTPClient := newHTTPClient(settings, 0)
+	imageConfig.HTTPClient = imageHTTPClient
 	imageClient := openai.NewClientWithConfig(imageConfig)
 
 	// 确定图像模式
@@ -89,6 +116,12 @@ func NewOpenAIProvider(ctx context.Context, settings types.AISettings) (*OpenAIP
 		imageClient: imageClient,
 		settings:    settings,
 		imageMode:   imageMode,
+
+		imageBaseURL:    imageConfig.BaseURL,
+		imageAPIKey:     imageAPIKey,
+		imageHTTPClient: imageHTTPClient,
+
+		imageFetcher: newRemoteImageFetcher(settings, settings.OpenAIBaseURL, settings.OpenAIImageBaseURL),
 	}, nil
 }
 
@@ -127,7 +160,16 @@ func (p *OpenAIProvider) GetCapabilities() ProviderCapabilities {
 	if p.imageMode == types.OpenAIImageModeChat {
 		return openaiChatCapabilities
 	}
-	return openaiImageAPICapabilities
+
+	caps := openaiImageAPICapabilities
+	// 除 DALL-E 3 外（GPT Image 1、DALL-E 2），Image API 支持 /images/edits 及其蒙版字段
+	model := p.settings.OpenAIImageModel
+	if model != "" && !isDallE3Model(model) {
+		caps.EditImage = true
+		caps.Inpaint = true
+		caps.ExtendImage = true
+	}
+	return caps
 }
 
 // CheckAvailability 检测服务可用性
@@ -181,15 +223,22 @@ func (p *OpenAIProvider) Close() error {
 // ==================== 图像生成 ====================
 
 // GenerateImage 生成图像
-func (p *OpenAIProvider) GenerateImage(ctx context.Context, params types.GenerateImageParams) (string, error) {
+func (p *OpenAIProvider) GenerateImage(ctx context.Context, params types.GenerateImageParams) (*ImageResult, error) {
 	if p.imageMode == types.OpenAIImageModeChat {
-		return p.generateImageViaChat(ctx, params)
+		// Chat API 每次只返回一张图像，多张候选图像通过并发请求实现
+		return generateConcurrently(ctx, params.Count, func(ctx context.Context) ([]string, error) {
+			image, err := p.generateImageViaChat(ctx, params)
+			if err != nil {
+				return nil, err
+			}
+			return []string{image}, nil
+		})
 	}
 	return p.generateImageViaImageAPI(ctx, params)
 }
 
 // generateImageViaImageAPI 通过专用 Image API 生成图像
-func (p *OpenAIProvider) generateImageViaImageAPI(ctx context.Context, params types.GenerateImageParams) (string, error) {
+func (p *OpenAIProvider) generateImageViaImageAPI(ctx context.Context, params types.GenerateImageParams) (*ImageResult, error) {
 	// 映射图像尺寸
 	size := mapOpenAIImageSize(params.ImageSize, params.AspectRatio)
 
@@ -203,24 +252,62 @@ func (p *OpenAIProvider) generateImageViaImageAPI(ctx context.Context, params ty
 	req := openai.ImageRequest{
 		Prompt:         params.Prompt,
 		Model:          model,
-		N:              1,
+		N:              normalizeImageCount(params.Count),
 		Size:           size,
 		ResponseFormat: openai.CreateImageResponseFormatB64JSON,
 		Quality:        openai.CreateImageQualityHD,
 		Style:          openai.CreateImageStyleVivid,
 	}
 
+	// GPT Image 模型开启流式时逐张流式生成，以便接收预览图像
+	if p.settings.OpenAIImageStream && isGPTImageModel(model) {
+		count := req.N
+		req.N = 1
+		return generateConcurrently(ctx, count, func(ctx context.Context) ([]string, error) {
+			return p.createImagesStream(ctx, req)
+		})
+	}
+
+	// DALL-E 3 仅支持 n=1，多张候选图像通过并发请求实现
+	if isDallE3Model(model) && req.N > 1 {
+		count := req.N
+		req.N = 1
+		return generateConcurrently(ctx, count, func(ctx context.Context) ([]string, error) {
+			return p.createImages(ctx, req)
+		})
+	}
+
+	images, err := p.createImages(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return &ImageResult{Images: images}, nil
+}
+
+// createImages 调用 Image API 生成图像，返回所有图像数据
+func (p *OpenAIProvider) createImages(ctx context.Context, req openai.ImageRequest) ([]string, error) {
 	// 调用 Image API（使用 imageClient）
 	resp, err := p.imageClient.CreateImage(ctx, req)
 	if err != nil {
-		return "", fmt.Errorf("OpenAI image generation error: %w", err)
+		return nil, fmt.Errorf("OpenAI image generation error: %w", err)
 	}
 
-	if len(resp.Data) == 0 {
-		return "", fmt.Errorf("no image data returned from OpenAI")
+	// 部分中继服务忽略 response_format，只返回图像 URL
+	var images []string
+	for _, data := range resp.Data {
+		if data.B64JSON != "" {
+			images = append(images, "data:image/png;base64,"+data.B64JSON)
+		} else if data.URL != "" {
+			images = append(images, data.URL)
+		}
 	}
+	reportUsage(ctx, openaiImageUsage(req.Model, resp.Usage, len(images)))
 
-	return "data:image/png;base64," + resp.Data[0].B64JSON, nil
+	if len(images) == 0 {
+		return nil, fmt.Errorf("no image data returned from OpenAI")
+	}
+
+	return p.imageFetcher.resolveAll(ctx, images)
 }
 
 // generateImageViaChat 通过 Chat Completion API 生成图像
@@ -236,7 +323,7 @@ func (p *OpenAIProvider) generateImageViaChat(ctx context.Context, params types.
 
 	// 如果有草图图像，添加到请求中
 	if params.SketchImage != "" {
-		imageURL, err := buildImageURL(params.SketchImage)
+		imageURL, err := buildImageURL(params.SketchImage, p.chatInputPolicy())
 		if err != nil {
 			return "", fmt.Errorf("failed to process sketch image: %w", err)
 		}
@@ -251,7 +338,7 @@ func (p *OpenAIProvider) generateImageViaChat(ctx context.Context, params types.
 
 	// 如果有参考图像，添加到请求中
 	if params.ReferenceImage != "" {
-		imageURL, err := buildImageURL(params.ReferenceImage)
+		imageURL, err := buildImageURL(params.ReferenceImage, p.chatInputPolicy())
 		if err != nil {
 			return "", fmt.Errorf("failed to process reference image: %w", err)
 		}
@@ -282,24 +369,8 @@ func (p *OpenAIProvider) generateImageViaChat(ctx context.Context, params types.
 		MaxTokens: 131072,
 	}
 
-	// 根据配置决定是否使用流式请求（图像模型流式模式）
-	if p.settings.OpenAIImageStream {
-		content, err := p.createChatCompletionStream(ctx, p.imageClient, req)
-		if err != nil {
-			return "", err
-		}
-		// 从流式响应中提取图像
-		return extractImageFromChatContent(content)
-	}
-
 	// 调用图像 API（使用 imageClient，因为这是图像生成操作）
-	resp, err := p.imageClient.CreateChatCompletion(ctx, req)
-	if err != nil {
-		return "", fmt.Errorf("OpenAI chat completion error: %w", err)
-	}
-
-	// 从响应中提取图像
-	return extractImageFromChatResponse(resp)
+	return p.completeChatImage(ctx, req)
 }
 
 // ==================== 图像编辑 ====================
@@ -315,37 +386,53 @@ func (p *OpenAIProvider) EditImage(ctx context.Context, params types.EditImagePa
 // editImageViaImageAPI 通过专用 Image Edit API 编辑图像
 func (p *OpenAIProvider) editImageViaImageAPI(ctx context.Context, params types.EditImageParams) (string, error) {
 	// 检查模型是否支持编辑
-	model := strings.ToLower(p.settings.OpenAIImageModel)
-	if strings.Contains(model, "dall-e-3") || strings.Contains(model, "dalle-3") {
-		return "", fmt.Errorf("DALL-E 3 does not support image editing. Use 'chat' mode or switch to a different model")
+	if isDallE3Model(p.settings.OpenAIImageModel) {
+		return "", apperr.New(apperr.CodeUnsupportedFeature, "DALL-E 3 does not support image editing. Use 'chat' mode or switch to a different model")
 	}
 
-	// 解码图像数据
-	imageData := extractBase64Data(params.ImageData)
-	decodedData, err := base64.StdEncoding.DecodeString(imageData)
+	// 规范化输入图像（Image Edit API 只接受 PNG）
+	image, err := normalizeInputImage(params.ImageData, openAIEditInputPolicy.withSettings(p.settings))
 	if err != nil {
-		return "", fmt.Errorf("failed to decode image: %w", err)
+		return "", err
 	}
 
-	// 创建图像编辑请求
+	// 创建图像编辑请求（输出尺寸按画布宽高比选择，避免合成时拉伸变形）
 	req := openai.ImageEditRequest{
 		Prompt:         params.Prompt,
-		Image:          bytes.NewReader(decodedData),
+		Image:          bytes.NewReader(image.Data),
+		Model:          p.settings.OpenAIImageModel,
 		N:              1,
-		Size:           openai.CreateImageSize1024x1024,
+		Size:           openAIEditSize(p.settings.OpenAIImageModel, image.Width, image.Height),
 		ResponseFormat: openai.CreateImageResponseFormatB64JSON,
 	}
 
+	// 如果有蒙版，转换为 OpenAI 格式（透明区域为重绘区域），尺寸需与规范化后的原图一致
+	if params.Mask != "" {
+		maskData, err := buildOpenAIMaskPNG(params.Mask, image.Width, image.Height)
+		if err != nil {
+			return "", err
+		}
+		req.Mask = bytes.NewReader(maskData)
+	}
+
 	// 调用 Image API（使用 imageClient）
 	resp, err := p.imageClient.CreateEditImage(ctx, req)
 	if err != nil {
 		return "", fmt.Errorf("OpenAI image edit error: %w", err)
 	}
+	usageModel := req.Model
+	if usageModel == "" {
+		usageModel = openai.CreateImageModelDallE2 // 未指定模型时 API 默认使用 DALL-E 2
+	}
+	reportUsage(ctx, openaiImageUsage(usageModel, resp.Usage, len(resp.Data)))
 
 	if len(resp.Data) == 0 {
 		return "", fmt.Errorf("no image data returned from OpenAI")
 	}
 
+	if resp.Data[0].B64JSON == "" && resp.Data[0].URL != "" {
+		return p.imageFetcher.resolve(ctx, resp.Data[0].URL)
+	}
 	return "data:image/png;base64," + resp.Data[0].B64JSON, nil
 }
 
@@ -354,14 +441,18 @@ func (p *OpenAIProvider) editImageViaChat(ctx context.Context, params types.Edit
 	// 构建消息内容
 	var multiContent []openai.ChatMessagePart
 
-	// 添加编辑提示
+	// 添加编辑提示（有蒙版时附加局部重绘说明）
+	prompt := params.Prompt
+	if params.Mask != "" {
+		prompt = buildInpaintPrompt(params.Prompt)
+	}
 	multiContent = append(multiContent, openai.ChatMessagePart{
 		Type: openai.ChatMessagePartTypeText,
-		Text: params.Prompt,
+		Text: prompt,
 	})
 
 	// 添加要编辑的图像
-	imageURL, err := buildImageURL(params.ImageData)
+	imageURL, err := buildImageURL(params.ImageData, p.chatInputPolicy())
 	if err != nil {
 		return "", fmt.Errorf("failed to process image: %w", err)
 	}
@@ -373,6 +464,25 @@ func (p *OpenAIProvider) editImageViaChat(ctx context.Context, params types.Edit
 		},
 	})
 
+	// 添加黑白蒙版图像（尺寸与规范化后的原图一致）
+	if params.Mask != "" {
+		width, height, err := ImageDimensions(imageURL)
+		if err != nil {
+			return "", err
+		}
+		maskData, err := buildMaskPreviewPNG(params.Mask, width, height)
+		if err != nil {
+			return "", err
+		}
+		multiContent = append(multiContent, openai.ChatMessagePart{
+			Type: openai.ChatMessagePartTypeImageURL,
+			ImageURL: &openai.ChatMessageImageURL{
+				URL:    "data:image/png;base64," + base64.StdEncoding.EncodeToString(maskData),
+				Detail: openai.ImageURLDetailHigh,
+			},
+		})
+	}
+
 	// 确定使用的模型
 	model := p.settings.OpenAIImageModel
 	if model == "" {
@@ -391,23 +501,8 @@ func (p *OpenAIProvider) editImageViaChat(ctx context.Context, params types.Edit
 		MaxTokens: 4096,
 	}
 
-	// 根据配置决定是否使用流式请求（图像模型流式模式）
-	if p.settings.OpenAIImageStream {
-		content, err := p.createChatCompletionStream(ctx, p.imageClient, req)
-		if err != nil {
-			return "", err
-		}
-		// 从流式响应中提取图像
-		return extractImageFromChatContent(content)
-	}
-
 	// 调用图像 API（使用 imageClient，因为这是图像编辑操作）
-	resp, err := p.imageClient.CreateChatCompletion(ctx, req)
-	if err != nil {
-		return "", fmt.Errorf("OpenAI chat completion error: %w", err)
-	}
-
-	return extractImageFromChatResponse(resp)
+	return p.completeChatImage(ctx, req)
 }
 
 // ==================== 多图编辑 ====================
@@ -437,7 +532,7 @@ func (p *OpenAIProvider) editMultiImagesViaChat(ctx context.Context, params type
 
 	// 添加所有图片
 	for i, img := range params.Images {
-		imageURL, err := buildImageURL(img)
+		imageURL, err := buildImageURL(img, p.chatInputPolicy())
 		if err != nil {
 			return "", fmt.Errorf("failed to process image %d: %w", i, err)
 		}
@@ -468,23 +563,8 @@ func (p *OpenAIProvider) editMultiImagesViaChat(ctx context.Context, params type
 		MaxTokens: 4096,
 	}
 
-	// 根据配置决定是否使用流式请求（图像模型流式模式）
-	if p.settings.OpenAIImageStream {
-		content, err := p.createChatCompletionStream(ctx, p.imageClient, req)
-		if err != nil {
-			return "", err
-		}
-		// 从流式响应中提取图像
-		return extractImageFromChatContent(content)
-	}
-
 	// 调用图像 API（使用 imageClient，因为这是多图编辑操作）
-	resp, err := p.imageClient.CreateChatCompletion(ctx, req)
-	if err != nil {
-		return "", fmt.Errorf("OpenAI chat completion error: %w", err)
-	}
-
-	return extractImageFromChatResponse(resp)
+	return p.completeChatImage(ctx, req)
 }
 
 // ==================== 提示词增强 ====================
@@ -517,7 +597,12 @@ func (p *OpenAIProvider) EnhancePrompt(ctx context.Context, prompt string) (stri
 
 	// 根据配置决定是否使用流式请求
 	if p.settings.OpenAITextStream {
-		return p.createChatCompletionStream(ctx, p.chatClient, req)
+		resp, err := p.createChatCompletionStream(ctx, p.chatClient, req)
+		if err != nil {
+			return "", err
+		}
+		reportUsage(ctx, openaiChatUsage(model, resp.Usage, 0))
+		return resp.Choices[0].Message.Content, nil
 	}
 
 	// 调用 Chat API（使用 chatClient，因为这是文本处理操作）
@@ -525,6 +610,7 @@ func (p *OpenAIProvider) EnhancePrompt(ctx context.Context, prompt string) (stri
 	if err != nil {
 		return "", fmt.Errorf("OpenAI chat API error: %w", err)
 	}
+	reportUsage(ctx, openaiChatUsage(model, resp.Usage, 0))
 
 	if len(resp.Choices) == 0 {
 		return prompt, nil
@@ -555,20 +641,77 @@ func mapOpenAIImageSize(sizeLevel, aspectRatio string) string {
 	}
 }
 
-// buildImageURL 构建图像 URL（支持 base64 和 http URL）
-func buildImageURL(imageData string) (string, error) {
-	// 如果已经是 data URL，直接返回
-	if strings.HasPrefix(imageData, "data:") {
-		return imageData, nil
+// openAIEditSize 选择与画布宽高比最接近的图像编辑输出尺寸
+// GPT Image 模型支持横向、纵向和方形输出；DALL-E 2 及其他模型只支持方形
+func openAIEditSize(model string, width, height int) string {
+	if !isGPTImageModel(model) || width <= 0 || height <= 0 {
+		return openai.CreateImageSize1024x1024
+	}
+	sizes := []struct {
+		size  string
+		ratio float64
+	}{
+		{openai.CreateImageSize1024x1024, 1},
+		{openai.CreateImageSize1536x1024, 1.5},
+		{openai.CreateImageSize1024x1536, 1.0 / 1.5},
 	}
+	ratio := math.Log(float64(width) / float64(height))
+	best := sizes[0]
+	for _, candidate := range sizes[1:] {
+		if math.Abs(ratio-math.Log(candidate.ratio)) < math.Abs(ratio-math.Log(best.ratio)) {
+			best = candidate
+		}
+	}
+	return best.size
+}
 
-	// 如果是 http/https URL，直接返回
+// isDallE3Model 判断模型是否为 DALL-E 3（不支持图像编辑，且每次只能生成一张图像）
+func isDallE3Model(model string) bool {
+	model = strings.ToLower(model)
+	return strings.Contains(model, "dall-e-3") || strings.Contains(model, "dalle-3")
+}
+
+// openaiChatUsage 将 Chat API 用量转换为统一的用量结构
+func openaiChatUsage(model string, usage openai.Usage, images int) Usage {
+	return Usage{
+		Provider:     "openai",
+		Model:        model,
+		InputTokens:  int64(usage.PromptTokens),
+		OutputTokens: int64(usage.CompletionTokens),
+		TotalTokens:  int64(usage.TotalTokens),
+		Images:       images,
+	}
+}
+
+// openaiImageUsage 将 Image API 用量转换为统一的用量结构
+func openaiImageUsage(model string, usage openai.ImageResponseUsage, images int) Usage {
+	return Usage{
+		Provider:     "openai",
+		Model:        model,
+		InputTokens:  int64(usage.InputTokens),
+		OutputTokens: int64(usage.OutputTokens),
+		TotalTokens:  int64(usage.TotalTokens),
+		Images:       images,
+	}
+}
+
+// isGPTImageModel 检查模型是否为 GPT Image 系列（支持流式预览图像）
+func isGPTImageModel(model string) bool {
+	return strings.Contains(strings.ToLower(model), "gpt-image")
+}
+
+// chatInputPolicy 返回 Chat 模式下应用用户配置后的输入图像要求
+func (p *OpenAIProvider) chatInputPolicy() imageInputPolicy {
+	return openAIChatInputPolicy.withSettings(p.settings)
+}
+
+// buildImageURL 构建图像 URL
+// http/https URL 直接返回（由 OpenAI 下载），data URL 和纯 base64 数据按输入要求规范化
+func buildImageURL(imageData string, policy imageInputPolicy) (string, error) {
 	if strings.HasPrefix(imageData, "http://") || strings.HasPrefix(imageData, "https://") {
 		return imageData, nil
 	}
-
-	// 否则假设是纯 base64 数据，添加前缀
-	return "data:image/png;base64," + imageData, nil
+	return normalizeInputDataURL(imageData, policy)
 }
 
 // buildImageGenerationPrompt 构建图像生成提示
@@ -582,34 +725,56 @@ func buildImageGenerationPrompt(prompt, aspectRatio string) string {
 
 // extractImageFromChatResponse 从 Chat Completion 响应中提取图像
 // 注意：标准 OpenAI Chat API 不会返回图像，这个函数主要用于
-// 第三方多模态 API 的兼容处理
+// 第三方多模态 API 的兼容处理。没有图像时返回 *NoImageError，
+// 携带结束原因、拒绝说明、内容过滤结果和模型返回的文本
 func extractImageFromChatResponse(resp openai.ChatCompletionResponse) (string, error) {
 	if len(resp.Choices) == 0 {
 		return "", fmt.Errorf("no response from chat completion")
 	}
 
-	content := resp.Choices[0].Message.Content
-
-	// 尝试从响应中提取 base64 图像数据
 	// 一些第三方 API 可能在内容中返回 base64 编码的图像
-
-	// 检查是否是 base64 图像
-	if strings.HasPrefix(content, "data:image/") {
-		return content, nil
+	choice := resp.Choices[0]
+	image, err := extractImageFromChatContent(choice.Message.Content)
+	if err != nil {
+		return "", chatNoImageError(choice)
 	}
+	return image, nil
+}
 
-	// 检查内容是否看起来像 base64（无前缀）
-	if looksLikeBase64Image(content) {
-		return "data:image/png;base64," + content, nil
-	}
+// chatBlockedFinishReasons 表示内容被过滤的结束原因
+var chatBlockedFinishReasons = map[string]bool{
+	string(openai.FinishReasonContentFilter): true,
+}
 
-	// 尝试从 markdown 图片标记中提取
-	if imageURL := extractImageFromMarkdown(content); imageURL != "" {
-		return imageURL, nil
+// chatNoImageError 从没有图像的 Chat 响应中提取结束原因、拒绝说明、内容过滤结果和文本
+func chatNoImageError(choice openai.ChatCompletionChoice) *NoImageError {
+	result := &NoImageError{
+		FinishReason:  string(choice.FinishReason),
+		SafetyRatings: contentFilterRatings(choice.ContentFilterResults),
+		Text:          strings.TrimSpace(choice.Message.Content),
+	}
+	if refusal := strings.TrimSpace(choice.Message.Refusal); refusal != "" {
+		result.Reason = NoImageRefused
+		result.Text = refusal
 	}
+	return classifyNoImage(result, chatBlockedFinishReasons)
+}
 
-	// 如果响应只是文本，返回错误
-	return "", fmt.Errorf("chat response does not contain image data. Response: %s", truncateString(content, 200))
+// contentFilterRatings 将内容过滤结果（Azure OpenAI 及兼容服务）转换为安全评级
+func contentFilterRatings(results openai.ContentFilterResults) []SafetyRating {
+	var ratings []SafetyRating
+	add := func(category, severity string, filtered bool) {
+		if filtered || (severity != "" && severity != "safe") {
+			ratings = append(ratings, SafetyRating{Category: category, Probability: severity, Blocked: filtered})
+		}
+	}
+	add("hate", results.Hate.Severity, results.Hate.Filtered)
+	add("self_harm", results.SelfHarm.Severity, results.SelfHarm.Filtered)
+	add("sexual", results.Sexual.Severity, results.Sexual.Filtered)
+	add("violence", results.Violence.Severity, results.Violence.Filtered)
+	add("jailbreak", "", results.JailBreak.Filtered)
+	add("profanity", "", results.Profanity.Filtered)
+	return ratings
 }
 
 // looksLikeBase64Image 检查字符串是否看起来像 base64 编码的图像
@@ -654,18 +819,73 @@ func truncateString(s string, maxLen int) string {
 
 // ==================== 流式请求处理 ====================
 
+// completeChatImage 调用 Chat Completion API 并从响应中提取图像
+// 根据配置决定是否使用流式请求（图像模型流式模式），并上报本次调用的用量
+func (p *OpenAIProvider) completeChatImage(ctx context.Context, req openai.ChatCompletionRequest) (string, error) {
+	var resp openai.ChatCompletionResponse
+	var err error
+
+	if p.settings.OpenAIImageStream {
+		resp, err = p.createChatCompletionStream(ctx, p.imageClient, req)
+		if err != nil {
+			return "", err
+		}
+	} else {
+		resp, err = p.imageClient.CreateChatCompletion(ctx, req)
+		if err != nil {
+			return "", fmt.Errorf("OpenAI chat completion error: %w", err)
+		}
+	}
+
+	// 从响应中提取图像
+	image, err := extractImageFromChatResponse(resp)
+	images := 0
+	if err == nil {
+		images = 1
+	}
+	reportUsage(ctx, openaiChatUsage(req.Model, resp.Usage, images))
+	if err != nil {
+		return "", err
+	}
+
+	// 模型返回图像链接时在本地下载，保证返回的始终是图像数据
+	return p.imageFetcher.resolve(ctx, image)
+}
+
 // createChatCompletionStream 创建流式 Chat Completion 请求并收集完整响应
 // 用于支持仅提供流式接口的第三方 OpenAI 中继服务
-func (p *OpenAIProvider) createChatCompletionStream(ctx context.Context, client *openai.Client, req openai.ChatCompletionRequest) (string, error) {
+// 分片合并为只有一个选项的非流式响应：内容和拒绝说明拼接，结束原因和内容过滤结果以最后出现的为准，
+// 用量取最后一个分片携带的值（服务端不支持时为零值）
+func (p *OpenAIProvider) createChatCompletionStream(ctx context.Context, client *openai.Client, req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+	// 请求在最后一个分片中返回用量
+	if !p.streamOptionsRejected.Load() {
+		req.StreamOptions = &openai.StreamOptions{IncludeUsage: true}
+	}
+
 	// 创建流式请求
 	stream, err := client.CreateChatCompletionStream(ctx, req)
+	if err != nil && req.StreamOptions != nil && openaiStatusCode(err) == http.StatusBadRequest {
+		// 不认识 stream_options 的中继服务返回 400，去掉该参数重试一次
+		fmt.Printf("[OpenAI] Stream request rejected (%v), retrying without stream_options\n", err)
+		req.StreamOptions = nil
+		stream, err = client.CreateChatCompletionStream(ctx, req)
+		if err == nil {
+			p.streamOptionsRejected.Store(true)
+		}
+	}
 	if err != nil {
-		return "", fmt.Errorf("failed to create chat completion stream: %w", err)
+		return openai.ChatCompletionResponse{}, fmt.Errorf("failed to create chat completion stream: %w", err)
 	}
 	defer stream.Close()
 
-	// 收集流式响应内容
-	var fullContent strings.Builder
+	// 收集流式响应内容，同时上报接收进度
+	// 图像数据开始之前的文本作为模型的文字说明上报
+	tracker := newProgressTracker(ctx)
+	inImage := false
+	tail := ""
+	var usage openai.Usage
+	var fullContent, refusal strings.Builder
+	choice := openai.ChatCompletionChoice{Message: openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant}}
 	for {
 		response, err := stream.Recv()
 		if err == io.EOF {
@@ -673,24 +893,170 @@ func (p *OpenAIProvider) createChatCompletionStream(ctx context.Context, client
 			break
 		}
 		if err != nil {
-			return "", fmt.Errorf("stream receive error: %w", err)
+			return openai.ChatCompletionResponse{}, fmt.Errorf("stream receive error: %w", err)
+		}
+
+		if response.Usage != nil {
+			usage = *response.Usage
 		}
 
 		// 提取增量内容
 		if len(response.Choices) > 0 {
-			delta := response.Choices[0].Delta.Content
+			chunk := response.Choices[0]
+			if chunk.FinishReason != "" {
+				choice.FinishReason = chunk.FinishReason
+			}
+			if chunk.ContentFilterResults != (openai.ContentFilterResults{}) {
+				choice.ContentFilterResults = chunk.ContentFilterResults
+			}
+			refusal.WriteString(chunk.Delta.Refusal)
+
+			delta := chunk.Delta.Content
 			if delta != "" {
 				fullContent.WriteString(delta)
+				tracker.addBytes(len(delta))
+				if !inImage {
+					// 只检查增量及其之前的少量字符，避免图像标记跨分片时漏判
+					window := tail + delta
+					inImage = strings.Contains(window, "data:image/") || strings.Contains(window, "![") ||
+						looksLikeBase64Image(window)
+					if !inImage {
+						tracker.text(delta)
+					}
+					tail = window[max(0, len(window)-16):]
+				}
+			}
+		}
+	}
+
+	choice.Message.Content = fullContent.String()
+	choice.Message.Refusal = refusal.String()
+	return openai.ChatCompletionResponse{Choices: []openai.ChatCompletionChoice{choice}, Usage: usage}, nil
+}
+
+// openaiStatusCode 返回 OpenAI SDK 错误中的 HTTP 状态码，不是 HTTP 错误时返回 0
+func openaiStatusCode(err error) int {
+	var apiErr *openai.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.HTTPStatusCode
+	}
+	var requestErr *openai.RequestError
+	if errors.As(err, &requestErr) {
+		return requestErr.HTTPStatusCode
+	}
+	return 0
+}
+
+// openaiPartialImageCount 流式图像生成请求的预览图像数量
+const openaiPartialImageCount = 2
+
+// openaiImageStreamEvent Image API 流式响应中的事件
+type openaiImageStreamEvent struct {
+	Type              string                    `json:"type"`
+	B64JSON           string                    `json:"b64_json"`
+	PartialImageIndex int                       `json:"partial_image_index"`
+	OutputFormat      string                    `json:"output_format"`
+	Usage             openai.ImageResponseUsage `json:"usage"`
+	Error             *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// createImagesStream 通过流式 Image API 生成图像（仅 GPT Image 模型）
+// 预览图像（partial_images）通过进度回调上报，最终图像作为返回值
+func (p *OpenAIProvider) createImagesStream(ctx context.Context, req openai.ImageRequest) ([]string, error) {
+	payload := map[string]interface{}{
+		"model":          req.Model,
+		"prompt":         req.Prompt,
+		"n":              req.N,
+		"stream":         true,
+		"partial_images": openaiPartialImageCount,
+	}
+	if req.Size != "" {
+		payload["size"] = req.Size
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	url := strings.TrimSuffix(p.imageBaseURL, "/") + "/images/generations"
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Accept", "text/event-stream")
+	httpReq.Header.Set("Authorization", "Bearer "+p.imageAPIKey)
+
+	resp, err := p.imageHTTPClient.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("OpenAI image generation error: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("OpenAI image generation error: status %d: %s", resp.StatusCode, truncateString(string(bodyBytes), 500))
+	}
+
+	// 逐行解析 SSE，data 行包含完整的 JSON 事件
+	tracker := newProgressTracker(ctx)
+	reader := bufio.NewReader(resp.Body)
+	var images []string
+	for {
+		line, err := reader.ReadString('\n')
+		tracker.addBytes(len(line))
+
+		line = strings.TrimSpace(line)
+		if data, ok := strings.CutPrefix(line, "data:"); ok {
+			data = strings.TrimSpace(data)
+			if data != "" && data != "[DONE]" {
+				var event openaiImageStreamEvent
+				if jsonErr := json.Unmarshal([]byte(data), &event); jsonErr != nil {
+					return nil, fmt.Errorf("failed to parse image stream event: %w", jsonErr)
+				}
+				if event.Error != nil {
+					return nil, fmt.Errorf("OpenAI image generation error: %s", event.Error.Message)
+				}
+
+				mimeType := "image/png"
+				if event.OutputFormat != "" {
+					mimeType = "image/" + event.OutputFormat
+				}
+				switch event.Type {
+				case "image_generation.partial_image":
+					tracker.partialImage(fmt.Sprintf("data:%s;base64,%s", mimeType, event.B64JSON), event.PartialImageIndex)
+				case "image_generation.completed":
+					if event.B64JSON != "" {
+						images = append(images, fmt.Sprintf("data:%s;base64,%s", mimeType, event.B64JSON))
+					}
+					reportUsage(ctx, openaiImageUsage(req.Model, event.Usage, 1))
+				}
 			}
 		}
+
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("stream receive error: %w", err)
+		}
 	}
 
-	return fullContent.String(), nil
+	if len(images) == 0 {
+		return nil, fmt.Errorf("no image data returned from OpenAI")
+	}
+	return images, nil
 }
 
 // extractImageFromChatContent 从 Chat Completion 的文本内容中提取图像
-// 用于处理流式响应返回的文本内容
+// 支持 data URL、无前缀的 base64 图像和 markdown 图片标记，流式和非流式响应共用
 func extractImageFromChatContent(content string) (string, error) {
+	// 部分中继服务会在内容前后附加换行
+	content = strings.TrimSpace(content)
+
 	// 检查是否是 base64 图像
 	if strings.HasPrefix(content, "data:image/") {
 		return content, nil
@@ -706,6 +1072,6 @@ func extractImageFromChatContent(content string) (string, error) {
 		return imageURL, nil
 	}
 
-	// 如果响应只是文本，返回错误
-	return "", fmt.Errorf("chat response does not contain image data. Response: %s", truncateString(content, 200))
+	// 如果响应只是文本，返回包含文本的结构化结果
+	return "", classifyNoImage(&NoImageError{Text: content}, nil)
 }
@@ -0,0 +1,250 @@
+package provider
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// pngBase64 / jpegBase64 base64 编码的 PNG / JPEG 文件头
+const (
+	pngBase64  = "iVBORw0KGgoAAAANSUhEUgAAAAEAAAABCAYAAAAfFcSJAAAADUlEQVR42mP8z8BQDwAEhQGAhKmMIQAAAABJRU5ErkJggg=="
+	jpegBase64 = "/9j/4AAQSkZJRgABAQAAAQABAAD"
+)
+
+func TestExtractImageFromChatResponse(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+		wantErr bool
+	}{
+		{
+			name:    "data URL",
+			content: "data:image/png;base64," + pngBase64,
+			want:    "data:image/png;base64," + pngBase64,
+		},
+		{
+			name:    "data URL with surrounding whitespace",
+			content: "\n\ndata:image/webp;base64,UklGR\n",
+			want:    "data:image/webp;base64,UklGR",
+		},
+		{
+			name:    "bare PNG base64",
+			content: pngBase64,
+			want:    "data:image/png;base64," + pngBase64,
+		},
+		{
+			name:    "bare JPEG base64 with trailing newline",
+			content: jpegBase64 + "\n",
+			want:    "data:image/png;base64," + jpegBase64,
+		},
+		{
+			name:    "markdown data URL",
+			content: "Here you go:\n\n![result](data:image/png;base64," + pngBase64 + ")\n\nEnjoy!",
+			want:    "data:image/png;base64," + pngBase64,
+		},
+		{
+			name:    "markdown remote URL",
+			content: "![image](https://cdn.example.com/out/1.png)",
+			want:    "https://cdn.example.com/out/1.png",
+		},
+		{
+			name:    "text only",
+			content: "I'm sorry, I can't generate that image.",
+			wantErr: true,
+		},
+		{
+			name:    "empty content",
+			content: "",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := openai.ChatCompletionResponse{Choices: []openai.ChatCompletionChoice{{
+				Message: openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: tt.content},
+			}}}
+
+			got, err := extractImageFromChatResponse(resp)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("extractImageFromChatResponse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("extractImageFromChatResponse() = %q, want %q", got, tt.want)
+			}
+
+			// 流式响应使用相同的提取逻辑
+			streamed, err := extractImageFromChatContent(tt.content)
+			if (err != nil) != tt.wantErr || streamed != got {
+				t.Errorf("extractImageFromChatContent() = %q, %v; want %q", streamed, err, got)
+			}
+		})
+	}
+
+	t.Run("no choices", func(t *testing.T) {
+		if _, err := extractImageFromChatResponse(openai.ChatCompletionResponse{}); err == nil {
+			t.Errorf("extractImageFromChatResponse() with no choices succeeded, want error")
+		}
+	})
+
+	t.Run("text-only error includes truncated response", func(t *testing.T) {
+		_, err := extractImageFromChatContent(strings.Repeat("x", 500))
+		if err == nil || !strings.Contains(err.Error(), strings.Repeat("x", 200)+"...") {
+			t.Errorf("error = %v, want the response truncated to 200 characters", err)
+		}
+	})
+}
+
+func TestExtractImageFromMarkdown(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{"simple", "![alt](https://example.com/a.png)", "https://example.com/a.png"},
+		{"empty alt", "![](data:image/png;base64,AAAA)", "data:image/png;base64,AAAA"},
+		{"surrounded by text", "before ![cat](https://example.com/cat.jpg) after", "https://example.com/cat.jpg"},
+		{"first of several images", "![a](https://example.com/1.png) ![b](https://example.com/2.png)", "https://example.com/1.png"},
+		{"after a plain link", "[docs](https://example.com/docs) ![img](https://example.com/img.png)", "https://example.com/img.png"},
+		{"plain link only", "[docs](https://example.com/docs)", ""},
+		{"unclosed URL", "![alt](https://example.com/a.png", ""},
+		{"missing URL", "![alt] text", ""},
+		{"no markdown", "just text", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := extractImageFromMarkdown(tt.content); got != tt.want {
+				t.Errorf("extractImageFromMarkdown(%q) = %q, want %q", tt.content, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDetermineImageMode(t *testing.T) {
+	tests := []struct {
+		mode  string
+		model string
+		want  string
+	}{
+		{"", "dall-e-3", "image_api"},
+		{"", "gpt-image-1", "image_api"},
+		{"auto", "DALLE-2", "image_api"},
+		{"", "gpt-4o", "chat"},
+		{"", "", "chat"},
+		{"chat", "gpt-image-1", "chat"},
+		{"image_api", "gemini-2.5-flash-image", "image_api"},
+	}
+
+	for _, tt := range tests {
+		settings := testSettings()
+		settings.OpenAIImageMode = tt.mode
+		settings.OpenAIImageModel = tt.model
+		if got := determineImageMode(settings); got != tt.want {
+			t.Errorf("determineImageMode(mode=%q, model=%q) = %q, want %q", tt.mode, tt.model, got, tt.want)
+		}
+	}
+}
+
+func TestOpenAIEditSize(t *testing.T) {
+	tests := []struct {
+		model         string
+		width, height int
+		want          string
+	}{
+		{"gpt-image-1", 1000, 1000, "1024x1024"},
+		{"gpt-image-1", 1920, 1080, "1536x1024"},
+		{"gpt-image-1", 1080, 1920, "1024x1536"},
+		{"gpt-image-1", 1100, 1000, "1024x1024"},
+		{"GPT-Image-1", 1500, 1000, "1536x1024"},
+		{"dall-e-2", 1920, 1080, "1024x1024"},
+		{"", 1080, 1920, "1024x1024"},
+	}
+
+	for _, tt := range tests {
+		if got := openAIEditSize(tt.model, tt.width, tt.height); got != tt.want {
+			t.Errorf("openAIEditSize(%q, %d, %d) = %q, want %q", tt.model, tt.width, tt.height, got, tt.want)
+		}
+	}
+}
+
+func TestChatNoImageError(t *testing.T) {
+	tests := []struct {
+		name           string
+		choice         openai.ChatCompletionChoice
+		wantReason     string
+		wantCategories []string
+		wantText       string
+	}{
+		{
+			name:       "text-only reply",
+			choice:     openai.ChatCompletionChoice{Message: openai.ChatCompletionMessage{Content: " I can describe it instead. "}, FinishReason: openai.FinishReasonStop},
+			wantReason: NoImageTextOnly,
+			wantText:   "I can describe it instead.",
+		},
+		{
+			name:       "refusal",
+			choice:     openai.ChatCompletionChoice{Message: openai.ChatCompletionMessage{Refusal: "I can't help with that."}},
+			wantReason: NoImageRefused,
+			wantText:   "I can't help with that.",
+		},
+		{
+			name: "content filter",
+			choice: openai.ChatCompletionChoice{
+				FinishReason: openai.FinishReasonContentFilter,
+				ContentFilterResults: openai.ContentFilterResults{
+					Violence: openai.Violence{Filtered: true, Severity: "high"},
+					Hate:     openai.Hate{Severity: "safe"},
+				},
+			},
+			wantReason:     NoImageBlocked,
+			wantCategories: []string{"violence"},
+		},
+		{
+			name:       "empty",
+			choice:     openai.ChatCompletionChoice{FinishReason: openai.FinishReasonLength},
+			wantReason: NoImageEmpty,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := extractImageFromChatResponse(openai.ChatCompletionResponse{Choices: []openai.ChatCompletionChoice{tt.choice}})
+			result, ok := err.(*NoImageError)
+			if !ok {
+				t.Fatalf("error = %#v, want *NoImageError", err)
+			}
+			if result.Reason != tt.wantReason || result.Text != tt.wantText || result.FinishReason != string(tt.choice.FinishReason) {
+				t.Errorf("result = %+v, want reason %q, text %q", result, tt.wantReason, tt.wantText)
+			}
+			if got := strings.Join(result.Categories(), ","); got != strings.Join(tt.wantCategories, ",") {
+				t.Errorf("Categories() = %q, want %q", got, tt.wantCategories)
+			}
+		})
+	}
+}
+
+func TestOpenAIStreamWithoutStreamOptions(t *testing.T) {
+	server := newOpenAIStandIn(t, "relay-image-model")
+	server.rejectStreamOptions = true
+	p := openAITarget(t, server, "chat", true).provider
+
+	for i := 0; i < 2; i++ {
+		result, err := p.EnhancePrompt(context.Background(), "a cat")
+		if err != nil {
+			t.Fatalf("EnhancePrompt() call %d error = %v", i+1, err)
+		}
+		if result != enhancedTestPrompt {
+			t.Errorf("EnhancePrompt() = %q, want %q", result, enhancedTestPrompt)
+		}
+	}
+
+	// 第一次调用被拒绝后去掉 stream_options 重试，之后的调用直接不带该参数
+	if got := len(server.requestsTo("/v1/chat/completions")); got != 3 {
+		t.Errorf("server received %d requests, want 3", got)
+	}
+}
@@ -0,0 +1,112 @@
+package provider
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ==================== 生成进度 ====================
+
+// progressInterval 仅包含字节数的进度更新的最小上报间隔
+const progressInterval = 200 * time.Millisecond
+
+// ProgressUpdate 流式生成过程中的一次进度更新
+type ProgressUpdate struct {
+	BytesReceived int64   `json:"bytesReceived"`          // 当前请求已接收的响应字节数
+	Text          string  `json:"text,omitempty"`         // 模型输出的文本说明（增量）
+	PartialImage  string  `json:"partialImage,omitempty"` // 预览图像（data URL），模型提供时才有
+	PartialIndex  int     `json:"partialIndex,omitempty"` // 预览图像序号
+	Step          int     `json:"step,omitempty"`         // 当前采样步数（本地扩散模型服务提供）
+	TotalSteps    int     `json:"totalSteps,omitempty"`   // 总采样步数
+	Progress      float64 `json:"progress,omitempty"`     // 总体进度（0-1），服务端提供时才有
+}
+
+// ProgressReporter 进度回调
+type ProgressReporter func(update ProgressUpdate)
+
+// progressReporterKey 上下文中进度回调的键
+type progressReporterKey struct{}
+
+// WithProgressReporter 在上下文中附加进度回调
+// 提供商使用流式接口时会通过回调报告接收字节数、文本说明和预览图像；
+// 最终结果仍通过提供商方法的返回值返回
+func WithProgressReporter(ctx context.Context, reporter ProgressReporter) context.Context {
+	return context.WithValue(ctx, progressReporterKey{}, reporter)
+}
+
+// reportProgress 调用上下文中的进度回调（如果存在）
+func reportProgress(ctx context.Context, update ProgressUpdate) {
+	if reporter, ok := ctx.Value(progressReporterKey{}).(ProgressReporter); ok && reporter != nil {
+		reporter(update)
+	}
+}
+
+// progressTracker 单个流式请求的进度跟踪器
+// 累计接收字节数，并限制纯字节进度的上报频率，文本和预览图像总是立即上报
+type progressTracker struct {
+	ctx        context.Context
+	mu         sync.Mutex
+	bytes      int64
+	lastReport time.Time
+}
+
+// newProgressTracker 创建进度跟踪器
+func newProgressTracker(ctx context.Context) *progressTracker {
+	return &progressTracker{ctx: ctx}
+}
+
+// addBytes 累计接收字节数，按间隔上报
+func (t *progressTracker) addBytes(n int) {
+	t.mu.Lock()
+	t.bytes += int64(n)
+	if time.Since(t.lastReport) < progressInterval {
+		t.mu.Unlock()
+		return
+	}
+	t.lastReport = time.Now()
+	update := ProgressUpdate{BytesReceived: t.bytes}
+	t.mu.Unlock()
+
+	reportProgress(t.ctx, update)
+}
+
+// text 上报模型输出的文本说明
+func (t *progressTracker) text(text string) {
+	if strings.TrimSpace(text) == "" {
+		return
+	}
+	t.mu.Lock()
+	update := ProgressUpdate{BytesReceived: t.bytes, Text: text}
+	t.mu.Unlock()
+
+	reportProgress(t.ctx, update)
+}
+
+// partialImage 上报预览图像
+func (t *progressTracker) partialImage(dataURL string, index int) {
+	t.mu.Lock()
+	update := ProgressUpdate{BytesReceived: t.bytes, PartialImage: dataURL, PartialIndex: index}
+	t.mu.Unlock()
+
+	reportProgress(t.ctx, update)
+}
+
+// steps 上报采样步数进度
+func (t *progressTracker) steps(step, total int) {
+	t.mu.Lock()
+	update := ProgressUpdate{BytesReceived: t.bytes, Step: step, TotalSteps: total}
+	t.mu.Unlock()
+
+	reportProgress(t.ctx, update)
+}
+
+// report 上报一次完整的进度更新（用于服务端一次返回多项进度信息的情况）
+func (t *progressTracker) report(update ProgressUpdate) {
+	t.mu.Lock()
+	update.BytesReceived = t.bytes
+	t.mu.Unlock()
+
+	reportProgress(t.ctx, update)
+}
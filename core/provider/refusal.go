@@ -0,0 +1,101 @@
+package provider
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ==================== 无图像结果 ====================
+
+// 模型没有返回图像的原因
+const (
+	NoImageBlocked  = "blocked"   // 提示词或生成内容被安全策略拦截
+	NoImageRefused  = "refused"   // 模型明确拒绝（如 OpenAI 的 refusal 字段）
+	NoImageTextOnly = "text_only" // 模型只返回了文本
+	NoImageEmpty    = "empty"     // 响应中既没有图像也没有文本
+)
+
+// SafetyRating 安全评级
+type SafetyRating struct {
+	Category    string `json:"category"`              // 如 HARM_CATEGORY_DANGEROUS_CONTENT、violence
+	Probability string `json:"probability,omitempty"` // 概率或严重程度，如 HIGH、medium
+	Blocked     bool   `json:"blocked,omitempty"`     // 是否因该类别被拦截
+}
+
+// NoImageError 模型没有返回图像时的结构化结果
+// 携带结束原因、拦截原因、安全评级以及模型返回的文本，服务层据此生成可读的错误信息
+type NoImageError struct {
+	Reason        string         `json:"reason"`                  // 见 NoImage* 常量
+	FinishReason  string         `json:"finishReason,omitempty"`  // 结束原因，如 SAFETY、IMAGE_SAFETY、content_filter
+	BlockReason   string         `json:"blockReason,omitempty"`   // 提示词被拦截的原因（Gemini promptFeedback）
+	Message       string         `json:"message,omitempty"`       // 提供商给出的说明
+	SafetyRatings []SafetyRating `json:"safetyRatings,omitempty"` // 安全评级
+	Text          string         `json:"text,omitempty"`          // 模型返回的文本（拒绝说明或文字回复）
+}
+
+// Error 实现 error 接口
+func (e *NoImageError) Error() string {
+	switch e.Reason {
+	case NoImageBlocked:
+		return fmt.Sprintf("response blocked by safety filters (%s)", e.Code())
+	case NoImageRefused:
+		return "model refused the request: " + truncateString(e.Text, 200)
+	case NoImageTextOnly:
+		return "response does not contain image data. Response: " + truncateString(e.Text, 200)
+	}
+	if e.FinishReason != "" {
+		return fmt.Sprintf("no image data found in response (finish reason: %s)", e.FinishReason)
+	}
+	return "no image data found in response"
+}
+
+// Code 返回原因代码：优先使用提示词拦截原因，其次是结束原因；被拦截但没有原因代码时返回 SAFETY
+func (e *NoImageError) Code() string {
+	if e.BlockReason != "" {
+		return e.BlockReason
+	}
+	if e.FinishReason != "" || e.Reason != NoImageBlocked {
+		return e.FinishReason
+	}
+	return "SAFETY"
+}
+
+// Categories 返回触发拦截的安全类别（可读名称，如 "dangerous content"）
+// 没有明确标记拦截的评级时，返回概率为 HIGH 的类别
+func (e *NoImageError) Categories() []string {
+	var blocked, high []string
+	for _, rating := range e.SafetyRatings {
+		name := strings.TrimPrefix(strings.ToUpper(rating.Category), "HARM_CATEGORY_")
+		name = strings.ToLower(strings.ReplaceAll(name, "_", " "))
+		switch {
+		case rating.Blocked:
+			blocked = append(blocked, name)
+		case strings.EqualFold(rating.Probability, "HIGH"):
+			high = append(high, name)
+		}
+	}
+	if len(blocked) > 0 {
+		return blocked
+	}
+	return high
+}
+
+// classifyNoImage 根据已填充的字段确定原因：有拦截原因或安全评级标记拦截时为 blocked，
+// 否则按是否有文本区分 text_only 和 empty（refused 由调用方在有明确拒绝信息时设置）
+func classifyNoImage(e *NoImageError, blockedFinishReasons map[string]bool) *NoImageError {
+	switch {
+	case e.BlockReason != "" || blockedFinishReasons[e.FinishReason]:
+		e.Reason = NoImageBlocked
+	case e.Reason != "":
+	case e.Text != "":
+		e.Reason = NoImageTextOnly
+	default:
+		e.Reason = NoImageEmpty
+	}
+	for _, rating := range e.SafetyRatings {
+		if rating.Blocked {
+			e.Reason = NoImageBlocked
+		}
+	}
+	return e
+}
@@ -0,0 +1,212 @@
+package provider
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"indraw/core/types"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ==================== 远程图像下载 ====================
+
+// 聊天模式模型（尤其是第三方中继）和云服务可能返回图像 URL 而不是图像数据。
+// 这类链接通常很快过期、离线时无法访问，并且会因跨域污染前端画布，
+// 因此在 Go 端下载后统一以 data URI 返回，下游代码只会拿到本地图像数据。
+// 云服务可以返回相对路径（按服务地址解析），与服务同源的链接下载时携带认证信息
+
+const (
+	defaultRemoteImageMaxSizeMB = 20
+	defaultRemoteImageTimeout   = 60 * time.Second
+)
+
+// remoteImageFetcher 下载提供商返回的远程图像
+// 链接由模型或中继服务提供，不可信：连接本机或内网地址会被拒绝（提供商配置的服务地址和代理除外），
+// 避免借助下载访问本地服务。检查在建立连接时针对实际连接的 IP 进行，
+// 因此重定向和 DNS 重绑定（检查时解析到公网、连接时解析到内网）同样会被拒绝
+type remoteImageFetcher struct {
+	client  *http.Client
+	maxSize int64
+	origins []*url.URL // 提供商配置的服务地址，这些地址上的链接允许位于本机或内网
+
+	// trustedAddrs 允许位于本机或内网的连接地址（host:port）：服务地址与代理地址
+	trustedAddrs map[string]bool
+
+	// authorize 为与服务地址同源的链接附加认证信息（为 nil 时不附加）
+	authorize func(req *http.Request)
+}
+
+// newRemoteImageFetcher 根据 AI 设置创建远程图像下载器
+// origins 为提供商配置的服务地址；配置了代理地址时使用该代理，否则使用系统代理环境变量（HTTP_PROXY / HTTPS_PROXY）
+func newRemoteImageFetcher(settings types.AISettings, origins ...string) *remoteImageFetcher {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if settings.RemoteImageProxyURL != "" {
+		if proxyURL, err := url.Parse(settings.RemoteImageProxyURL); err == nil {
+			transport.Proxy = http.ProxyURL(proxyURL)
+		} else {
+			fmt.Printf("[RemoteImage] Invalid proxy URL %q, using system proxy: %v\n", settings.RemoteImageProxyURL, err)
+		}
+	}
+
+	timeout := defaultRemoteImageTimeout
+	if settings.RemoteImageTimeoutSec > 0 {
+		timeout = time.Duration(settings.RemoteImageTimeoutSec) * time.Second
+	}
+	maxSizeMB := defaultRemoteImageMaxSizeMB
+	if settings.RemoteImageMaxSizeMB > 0 {
+		maxSizeMB = settings.RemoteImageMaxSizeMB
+	}
+
+	f := &remoteImageFetcher{
+		maxSize:      int64(maxSizeMB) << 20,
+		trustedAddrs: make(map[string]bool),
+	}
+	for _, origin := range origins {
+		if u, err := url.Parse(origin); err == nil && u.Host != "" {
+			f.origins = append(f.origins, u)
+			f.trustedAddrs[dialAddress(u)] = true
+		}
+	}
+	// 通过代理下载时连接的是代理，由代理解析图像链接的主机名
+	if transport.Proxy != nil {
+		for _, target := range []string{"http://example.com", "https://example.com"} {
+			req, _ := http.NewRequest("GET", target, nil)
+			if proxyURL, err := transport.Proxy(req); err == nil && proxyURL != nil {
+				f.trustedAddrs[dialAddress(proxyURL)] = true
+			}
+		}
+	}
+
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	guarded := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second, Control: rejectPrivateAddress}
+	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if f.trustedAddrs[strings.ToLower(addr)] {
+			return dialer.DialContext(ctx, network, addr)
+		}
+		return guarded.DialContext(ctx, network, addr)
+	}
+
+	f.client = &http.Client{
+		Transport: newRetryTransport(transport, RetryPolicyFromSettings(settings)),
+		Timeout:   timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 10 {
+				return fmt.Errorf("stopped after 10 redirects")
+			}
+			return nil
+		},
+	}
+	return f
+}
+
+// isRemoteImageURL 判断是否为需要下载的 http/https 图像链接
+func isRemoteImageURL(image string) bool {
+	return strings.HasPrefix(image, "http://") || strings.HasPrefix(image, "https://")
+}
+
+// isTrustedOrigin 判断链接是否与提供商配置的服务地址同源
+func (f *remoteImageFetcher) isTrustedOrigin(u *url.URL) bool {
+	for _, origin := range f.origins {
+		if strings.EqualFold(origin.Scheme, u.Scheme) && strings.EqualFold(origin.Host, u.Host) {
+			return true
+		}
+	}
+	return false
+}
+
+// dialAddress 返回连接 URL 所用的 host:port（省略端口时按协议补全）
+func dialAddress(u *url.URL) string {
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if strings.EqualFold(u.Scheme, "https") {
+			port = "443"
+		}
+	}
+	return strings.ToLower(net.JoinHostPort(u.Hostname(), port))
+}
+
+// rejectPrivateAddress 拒绝连接本机、内网或链路本地地址
+// 作为 net.Dialer 的 Control 钩子，address 为域名解析后实际连接的 IP
+func rejectPrivateAddress(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsUnspecified() {
+		return fmt.Errorf("refusing to download image from private network address %s", host)
+	}
+	return nil
+}
+
+// resolve 将远程图像链接下载为 data URI，其他图像数据原样返回
+func (f *remoteImageFetcher) resolve(ctx context.Context, image string) (string, error) {
+	if !isRemoteImageURL(image) {
+		return image, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", image, nil)
+	if err != nil {
+		return "", fmt.Errorf("invalid image URL: %w", err)
+	}
+	if f.authorize != nil && f.isTrustedOrigin(req.URL) {
+		f.authorize(req)
+	}
+	req.Header.Set("Accept", "image/*")
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to download image: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to download image: status %d", resp.StatusCode)
+	}
+	if resp.ContentLength > f.maxSize {
+		return "", fmt.Errorf("remote image is too large (%d bytes, limit %d bytes)", resp.ContentLength, f.maxSize)
+	}
+
+	// 声明的类型必须是图像或通用二进制类型，实际内容再按文件头校验
+	if declared := resp.Header.Get("Content-Type"); declared != "" {
+		mediaType, _, _ := mime.ParseMediaType(declared)
+		if !strings.HasPrefix(mediaType, "image/") && mediaType != "application/octet-stream" && mediaType != "binary/octet-stream" {
+			return "", fmt.Errorf("remote URL did not return an image (Content-Type: %s)", declared)
+		}
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, f.maxSize+1))
+	if err != nil {
+		return "", fmt.Errorf("failed to download image: %w", err)
+	}
+	if int64(len(data)) > f.maxSize {
+		return "", fmt.Errorf("remote image is too large (limit %d bytes)", f.maxSize)
+	}
+
+	mimeType := http.DetectContentType(data)
+	if !strings.HasPrefix(mimeType, "image/") {
+		return "", fmt.Errorf("remote URL did not return an image (detected %s)", mimeType)
+	}
+	return fmt.Sprintf("data:%s;base64,%s", mimeType, base64.StdEncoding.EncodeToString(data)), nil
+}
+
+// resolveAll 下载列表中的所有远程图像链接
+func (f *remoteImageFetcher) resolveAll(ctx context.Context, images []string) ([]string, error) {
+	resolved := make([]string, len(images))
+	for i, image := range images {
+		data, err := f.resolve(ctx, image)
+		if err != nil {
+			return nil, err
+		}
+		resolved[i] = data
+	}
+	return resolved, nil
+}
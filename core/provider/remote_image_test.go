@@ -0,0 +1,155 @@
+package provider
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"indraw/core/types"
+)
+
+func TestRemoteImageFetcher(t *testing.T) {
+	png := testPNG(t, 8, 8)
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /image.png", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(png)
+	})
+	mux.HandleFunc("GET /octet", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write(png)
+	})
+	mux.HandleFunc("GET /page.html", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<html>expired</html>"))
+	})
+	mux.HandleFunc("GET /fake.png", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte("not an image"))
+	})
+	mux.HandleFunc("GET /large.png", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(append(png, make([]byte, 2<<20)...))
+	})
+	server := newStandIn(t, mux)
+
+	settings := testSettings()
+	settings.RemoteImageMaxSizeMB = 1
+	// 替身服务位于本机，作为提供商配置的服务地址才允许下载
+	fetcher := newRemoteImageFetcher(settings, server.URL)
+
+	tests := []struct {
+		name    string
+		image   string
+		wantErr string
+	}{
+		{name: "image", image: server.URL + "/image.png"},
+		{name: "generic binary type", image: server.URL + "/octet"},
+		{name: "html page", image: server.URL + "/page.html", wantErr: "did not return an image"},
+		{name: "mislabelled content", image: server.URL + "/fake.png", wantErr: "did not return an image"},
+		{name: "over size limit", image: server.URL + "/large.png", wantErr: "too large"},
+		{name: "not found", image: server.URL + "/missing.png", wantErr: "status 404"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := fetcher.resolve(context.Background(), tt.image)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("resolve() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("resolve() error = %v", err)
+			}
+			if !strings.HasPrefix(got, "data:image/png;base64,") {
+				t.Fatalf("resolve() = %.40q, want a PNG data URI", got)
+			}
+			decodeTestImage(t, got)
+		})
+	}
+
+	t.Run("data URI unchanged", func(t *testing.T) {
+		dataURL := testImageDataURL(t, 4, 4)
+		if got, err := fetcher.resolve(context.Background(), dataURL); err != nil || got != dataURL {
+			t.Errorf("resolve(data URI) = %.40q, %v; want the input unchanged", got, err)
+		}
+	})
+
+	t.Run("private network refused", func(t *testing.T) {
+		untrusted := newRemoteImageFetcher(settings)
+		for _, image := range []string{server.URL + "/image.png", "http://localhost:1/image.png", "http://169.254.169.254/latest"} {
+			if _, err := untrusted.resolve(context.Background(), image); err == nil || !strings.Contains(err.Error(), "private network") {
+				t.Errorf("resolve(%q) error = %v, want the private address refused", image, err)
+			}
+		}
+	})
+
+	t.Run("host name checked at connection time", func(t *testing.T) {
+		// 主机名在建立连接时解析，检查的是实际连接的地址，不会在检查后被重新解析到内网
+		untrusted := newRemoteImageFetcher(settings)
+		image := strings.Replace(server.URL, "127.0.0.1", "localhost", 1) + "/image.png"
+		before := len(server.requestsTo("/image.png"))
+		if _, err := untrusted.resolve(context.Background(), image); err == nil || !strings.Contains(err.Error(), "private network") {
+			t.Errorf("resolve(%q) error = %v, want the private address refused", image, err)
+		}
+		if n := len(server.requestsTo("/image.png")) - before; n != 0 {
+			t.Errorf("private address received %d requests, want 0", n)
+		}
+	})
+
+	t.Run("redirect to private network refused", func(t *testing.T) {
+		internal := newStandIn(t, mux)
+		mux.HandleFunc("GET /redirect", func(w http.ResponseWriter, r *http.Request) {
+			http.Redirect(w, r, internal.URL+"/image.png", http.StatusFound)
+		})
+		if _, err := fetcher.resolve(context.Background(), server.URL+"/redirect"); err == nil || !strings.Contains(err.Error(), "private network") {
+			t.Errorf("resolve() error = %v, want the redirect target refused", err)
+		}
+		if n := len(internal.requestsTo("/image.png")); n != 0 {
+			t.Errorf("redirect target received %d requests, want 0", n)
+		}
+	})
+
+	t.Run("proxy", func(t *testing.T) {
+		// 代理收到绝对 URL 形式的请求
+		var proxied string
+		proxy := newStandIn(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			proxied = r.URL.String()
+			w.Header().Set("Content-Type", "image/png")
+			w.Write(png)
+		}))
+		fetcher := newRemoteImageFetcher(types.AISettings{RemoteImageProxyURL: proxy.URL})
+		if _, err := fetcher.resolve(context.Background(), "http://images.example.test/out.png"); err != nil {
+			t.Fatalf("resolve() through proxy error = %v", err)
+		}
+		if proxied != "http://images.example.test/out.png" {
+			t.Errorf("proxy received %q, want the absolute image URL", proxied)
+		}
+	})
+
+	t.Run("same-origin links are authorized", func(t *testing.T) {
+		authorization := make(map[string]string)
+		proxy := newStandIn(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			authorization[r.URL.Host] = r.Header.Get("Authorization")
+			w.Header().Set("Content-Type", "image/png")
+			w.Write(png)
+		}))
+		fetcher := newRemoteImageFetcher(types.AISettings{RemoteImageProxyURL: proxy.URL}, "http://cloud.example.test/v1")
+		fetcher.authorize = func(req *http.Request) { req.Header.Set("Authorization", "Bearer test-token") }
+
+		for _, image := range []string{"http://cloud.example.test/results/x.png", "http://cdn.example.test/x.png"} {
+			if _, err := fetcher.resolve(context.Background(), image); err != nil {
+				t.Fatalf("resolve(%q) error = %v", image, err)
+			}
+		}
+		if got := authorization["cloud.example.test"]; got != "Bearer test-token" {
+			t.Errorf("same-origin Authorization = %q, want the token", got)
+		}
+		if got := authorization["cdn.example.test"]; got != "" {
+			t.Errorf("cross-origin Authorization = %q, want none", got)
+		}
+	})
+}
@@ -0,0 +1,208 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+)
+
+// ==================== Lanczos 重采样 ====================
+
+// lanczosRadius Lanczos 核的半径（Lanczos3）
+const lanczosRadius = 3.0
+
+// MaxUpscaleDimension 放大后图像允许的最大边长
+const MaxUpscaleDimension = 16384
+
+// MaxUpscalePixels 放大后图像允许的最大像素数（64 MP，RGBA 结果约 256 MB）
+// 单独限制边长时 16384x16384 的结果需要 1 GB 内存，因此同时限制总像素数
+const MaxUpscalePixels = 8192 * 8192
+
+// lanczosKernel Lanczos 核函数
+func lanczosKernel(x float64) float64 {
+	if x == 0 {
+		return 1
+	}
+	if x <= -lanczosRadius || x >= lanczosRadius {
+		return 0
+	}
+	px := math.Pi * x
+	return lanczosRadius * math.Sin(px) * math.Sin(px/lanczosRadius) / (px * px)
+}
+
+// resampleBandRows 每个处理带包含的输出行数
+const resampleBandRows = 64
+
+// resampleWeights 一个输出坐标对应的输入像素范围及权重
+type resampleWeights struct {
+	start   int
+	weights []float32
+}
+
+// end 返回权重覆盖的最后一个输入坐标
+func (w resampleWeights) end() int {
+	return w.start + len(w.weights) - 1
+}
+
+// computeResampleWeights 预计算一维重采样权重
+// 缩小时按比例扩大核的支撑范围，起到抗锯齿作用
+func computeResampleWeights(srcSize, dstSize int) []resampleWeights {
+	scale := float64(srcSize) / float64(dstSize)
+	filterScale := math.Max(scale, 1)
+	support := lanczosRadius * filterScale
+
+	result := make([]resampleWeights, dstSize)
+	for i := 0; i < dstSize; i++ {
+		center := (float64(i)+0.5)*scale - 0.5
+		start := int(math.Ceil(center - support))
+		end := int(math.Floor(center + support))
+
+		raw := make([]float64, 0, end-start+1)
+		var sum float64
+		for j := start; j <= end; j++ {
+			w := lanczosKernel((float64(j) - center) / filterScale)
+			raw = append(raw, w)
+			sum += w
+		}
+		weights := make([]float32, len(raw))
+		for k, w := range raw {
+			if sum != 0 {
+				w /= sum
+			}
+			weights[k] = float32(w)
+		}
+		result[i] = resampleWeights{start: start, weights: weights}
+	}
+	return result
+}
+
+// ResizeLanczos 使用 Lanczos3 滤波器将图像缩放到指定尺寸
+// 纯 Go 实现，结果是确定性的；在预乘 alpha 空间中滤波，避免透明边缘出现色晕
+func ResizeLanczos(src image.Image, width, height int) *image.NRGBA {
+	dst, _ := resizeLanczos(context.Background(), src, width, height)
+	return dst
+}
+
+// resizeLanczos 按输出行分带执行 Lanczos 缩放
+// 每带只对所需的源图行做水平重采样，中间缓冲区与图像宽度成正比，不随图像高度增长；
+// 每带开始前检查上下文是否已取消，完成后上报总体进度
+func resizeLanczos(ctx context.Context, src image.Image, width, height int) (*image.NRGBA, error) {
+	bounds := src.Bounds()
+	srcW, srcH := bounds.Dx(), bounds.Dy()
+
+	xWeights := computeResampleWeights(srcW, width)
+	yWeights := computeResampleWeights(srcH, height)
+	dst := image.NewNRGBA(image.Rect(0, 0, width, height))
+
+	srcRow := make([]float32, srcW*4)
+	var horizontal []float32
+
+	for y0 := 0; y0 < height; y0 += resampleBandRows {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		y1 := min(y0+resampleBandRows, height)
+
+		// 本带输出行用到的源图行范围（权重起点随输出行单调递增）
+		first := clampInt(yWeights[y0].start, 0, srcH-1)
+		last := clampInt(yWeights[y1-1].end(), 0, srcH-1)
+		rowStride := width * 4
+		if need := (last - first + 1) * rowStride; cap(horizontal) < need {
+			horizontal = make([]float32, need)
+		}
+
+		// 水平方向重采样
+		for sy := first; sy <= last; sy++ {
+			loadPremultipliedRow(src, bounds.Min.X, bounds.Min.Y+sy, srcRow)
+			out := horizontal[(sy-first)*rowStride : (sy-first+1)*rowStride]
+			for x := 0; x < width; x++ {
+				w := xWeights[x]
+				var r, g, b, a float32
+				for k, weight := range w.weights {
+					offset := clampInt(w.start+k, 0, srcW-1) * 4
+					r += srcRow[offset] * weight
+					g += srcRow[offset+1] * weight
+					b += srcRow[offset+2] * weight
+					a += srcRow[offset+3] * weight
+				}
+				out[x*4] = r
+				out[x*4+1] = g
+				out[x*4+2] = b
+				out[x*4+3] = a
+			}
+		}
+
+		// 垂直方向重采样，并还原为非预乘 alpha
+		for y := y0; y < y1; y++ {
+			w := yWeights[y]
+			for x := 0; x < width; x++ {
+				var r, g, b, a float32
+				for k, weight := range w.weights {
+					sy := clampInt(w.start+k, 0, srcH-1)
+					offset := (sy-first)*rowStride + x*4
+					r += horizontal[offset] * weight
+					g += horizontal[offset+1] * weight
+					b += horizontal[offset+2] * weight
+					a += horizontal[offset+3] * weight
+				}
+				dst.SetNRGBA(x, y, unpremultiply(r, g, b, a))
+			}
+		}
+
+		reportProgress(ctx, ProgressUpdate{Progress: float64(y1) / float64(height)})
+	}
+
+	return dst, nil
+}
+
+// loadPremultipliedRow 将源图像的一行转换为预乘 alpha 的浮点像素
+func loadPremultipliedRow(src image.Image, minX, y int, row []float32) {
+	for x := 0; x < len(row)/4; x++ {
+		c := color.NRGBAModel.Convert(src.At(minX+x, y)).(color.NRGBA)
+		a := float32(c.A) / 255
+		row[x*4] = float32(c.R) * a
+		row[x*4+1] = float32(c.G) * a
+		row[x*4+2] = float32(c.B) * a
+		row[x*4+3] = float32(c.A)
+	}
+}
+
+// unpremultiply 将预乘 alpha 的浮点像素还原为 8 位非预乘颜色
+func unpremultiply(r, g, b, a float32) color.NRGBA {
+	alpha := clampFloat(float64(a), 0, 255)
+	var out color.NRGBA
+	out.A = uint8(alpha + 0.5)
+	if out.A > 0 {
+		factor := 255 / alpha
+		out.R = uint8(clampFloat(float64(r)*factor, 0, 255) + 0.5)
+		out.G = uint8(clampFloat(float64(g)*factor, 0, 255) + 0.5)
+		out.B = uint8(clampFloat(float64(b)*factor, 0, 255) + 0.5)
+	}
+	return out
+}
+
+// UpscaleLanczos 使用 Lanczos 重采样将图像放大指定倍数
+// 用于不支持原生放大的提供商，返回 PNG data URL；上下文取消时中止，进度通过上下文中的进度回调上报
+func UpscaleLanczos(ctx context.Context, imageDataURL string, scale int) (string, error) {
+	src, err := decodeImageDataURL(imageDataURL)
+	if err != nil {
+		return "", err
+	}
+
+	bounds := src.Bounds()
+	width, height := bounds.Dx()*scale, bounds.Dy()*scale
+	if width > MaxUpscaleDimension || height > MaxUpscaleDimension {
+		return "", fmt.Errorf("upscaled image %dx%d exceeds the maximum size %d", width, height, MaxUpscaleDimension)
+	}
+	if width*height > MaxUpscalePixels {
+		return "", fmt.Errorf("upscaled image %dx%d exceeds the maximum of %d pixels", width, height, MaxUpscalePixels)
+	}
+
+	dst, err := resizeLanczos(ctx, src, width, height)
+	if err != nil {
+		return "", err
+	}
+	return encodePNGDataURL(dst)
+}
@@ -0,0 +1,67 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+)
+
+func TestUpscaleLanczos(t *testing.T) {
+	t.Run("solid color is preserved across bands", func(t *testing.T) {
+		// 高度超过一个处理带，检查带边界处没有接缝
+		src := image.NewNRGBA(image.Rect(0, 0, 20, 50))
+		for i := 0; i < len(src.Pix); i += 4 {
+			copy(src.Pix[i:], []uint8{200, 100, 50, 255})
+		}
+		dst := ResizeLanczos(src, 80, 200)
+		for y := 0; y < 200; y++ {
+			for x := 0; x < 80; x++ {
+				if c := dst.NRGBAAt(x, y); c != (color.NRGBA{R: 200, G: 100, B: 50, A: 255}) {
+					t.Fatalf("pixel (%d, %d) = %v, want the source color", x, y, c)
+				}
+			}
+		}
+	})
+
+	t.Run("progress reaches completion", func(t *testing.T) {
+		var last float64
+		ctx := WithProgressReporter(context.Background(), func(update ProgressUpdate) {
+			last = update.Progress
+		})
+		result, err := UpscaleLanczos(ctx, testImageDataURL(t, 40, 40), 4)
+		if err != nil {
+			t.Fatalf("UpscaleLanczos() error = %v", err)
+		}
+		if bounds := decodeTestImage(t, result).Bounds(); bounds.Dx() != 160 || bounds.Dy() != 160 {
+			t.Errorf("upscaled size = %dx%d, want 160x160", bounds.Dx(), bounds.Dy())
+		}
+		if last != 1 {
+			t.Errorf("last progress = %v, want 1", last)
+		}
+	})
+
+	t.Run("cancelled context stops resampling", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		if _, err := UpscaleLanczos(ctx, testImageDataURL(t, 40, 40), 2); !errors.Is(err, context.Canceled) {
+			t.Errorf("UpscaleLanczos() error = %v, want context.Canceled", err)
+		}
+	})
+
+	t.Run("oversized result is rejected", func(t *testing.T) {
+		if _, err := UpscaleLanczos(context.Background(), testImageDataURL(t, MaxUpscaleDimension/2+1, 1), 2); err == nil {
+			t.Error("UpscaleLanczos() succeeded, want an error for a result above the maximum size")
+		}
+	})
+
+	t.Run("result above the pixel limit is rejected", func(t *testing.T) {
+		// 每条边都在限制以内，总像素数超过限制
+		_, err := UpscaleLanczos(context.Background(), testImageDataURL(t, MaxUpscaleDimension/4, MaxUpscalePixels/MaxUpscaleDimension/4+1), 4)
+		if err == nil || !strings.Contains(err.Error(), "pixels") {
+			t.Errorf("UpscaleLanczos() error = %v, want the pixel limit exceeded", err)
+		}
+	})
+}
@@ -0,0 +1,267 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"indraw/core/types"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"strconv"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// ==================== 重试策略 ====================
+
+// RetryPolicy 瞬时故障的重试策略
+type RetryPolicy struct {
+	MaxAttempts int           // 最大尝试次数（包含首次请求），1 表示不重试
+	BaseDelay   time.Duration // 首次重试的基础延迟，之后按指数增长
+	MaxDelay    time.Duration // 单次重试等待的上限；Retry-After 超过该值时放弃重试
+}
+
+// DefaultRetryPolicy 默认重试策略
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 3,
+	BaseDelay:   time.Second,
+	MaxDelay:    30 * time.Second,
+}
+
+// RetryPolicyFromSettings 根据 AI 设置构建重试策略
+func RetryPolicyFromSettings(settings types.AISettings) RetryPolicy {
+	policy := DefaultRetryPolicy
+	if settings.RetryMaxAttempts > 0 {
+		policy.MaxAttempts = settings.RetryMaxAttempts
+	}
+	if settings.RetryBaseDelayMs > 0 {
+		policy.BaseDelay = time.Duration(settings.RetryBaseDelayMs) * time.Millisecond
+	}
+	return policy
+}
+
+// backoff 计算第 retry 次重试（从 1 开始）的等待时间
+// 指数退避并加入随机抖动（取 [d/2, d] 区间），避免多个请求同时重试
+func (p RetryPolicy) backoff(retry int) time.Duration {
+	delay := p.BaseDelay << (retry - 1)
+	if delay <= 0 || delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	half := int64(delay / 2)
+	if half <= 0 {
+		return delay
+	}
+	return time.Duration(half + rand.Int64N(half+1))
+}
+
+// ==================== 重试通知 ====================
+
+// RetryInfo 一次重试的信息
+type RetryInfo struct {
+	Attempt     int           // 即将进行的尝试序号（从 2 开始）
+	MaxAttempts int           // 最大尝试次数
+	Delay       time.Duration // 重试前的等待时间
+	Reason      string        // 触发重试的原因，如 "HTTP 429"
+}
+
+// RetryNotifier 重试通知回调
+type RetryNotifier func(info RetryInfo)
+
+// retryNotifierKey 上下文中重试通知回调的键
+type retryNotifierKey struct{}
+
+// WithRetryNotifier 在上下文中附加重试通知回调
+// 使用该上下文发起的提供商请求在重试前会调用回调，用于向前端报告重试进度
+func WithRetryNotifier(ctx context.Context, notifier RetryNotifier) context.Context {
+	return context.WithValue(ctx, retryNotifierKey{}, notifier)
+}
+
+// notifyRetry 调用上下文中的重试通知回调（如果存在）
+func notifyRetry(ctx context.Context, info RetryInfo) {
+	if notifier, ok := ctx.Value(retryNotifierKey{}).(RetryNotifier); ok && notifier != nil {
+		notifier(info)
+	}
+}
+
+// ==================== 重试传输层 ====================
+
+// retryTransport 带重试的 HTTP 传输层
+// 对 429/5xx 响应及连接重置等瞬时网络错误按策略重试（非幂等请求的限制见 retryReason），并遵循 Retry-After 响应头
+type retryTransport struct {
+	base   http.RoundTripper
+	policy RetryPolicy
+}
+
+// newRetryTransport 包装传输层，为其增加重试能力
+func newRetryTransport(base http.RoundTripper, policy RetryPolicy) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	if policy.MaxAttempts <= 1 {
+		return base
+	}
+	return &retryTransport{base: base, policy: policy}
+}
+
+// newHTTPClient 创建带重试能力的 HTTP 客户端
+// timeout 为 0 时不设置整体超时（由调用方的 context 控制）
+func newHTTPClient(settings types.AISettings, timeout time.Duration) *http.Client {
+	return &http.Client{
+		Transport: newRetryTransport(http.DefaultTransport, RetryPolicyFromSettings(settings)),
+		Timeout:   timeout,
+	}
+}
+
+// RoundTrip 执行请求，失败时按策略重试
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	idempotent := isIdempotentRequest(req)
+
+	for attempt := 1; ; attempt++ {
+		attemptReq := req
+		if attempt > 1 {
+			// 重新获取请求体；无法重放请求体时不再重试
+			var err error
+			attemptReq, err = rewindRequest(req)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		// 记录请求是否已完整发送，发送前失败的请求没有到达服务端，可以安全重试
+		var written atomic.Bool
+		attemptReq = attemptReq.WithContext(httptrace.WithClientTrace(attemptReq.Context(), &httptrace.ClientTrace{
+			WroteRequest: func(info httptrace.WroteRequestInfo) {
+				if info.Err == nil {
+					written.Store(true)
+				}
+			},
+		}))
+
+		resp, err := t.base.RoundTrip(attemptReq)
+
+		reason, retryable := retryReason(ctx, resp, err, idempotent, written.Load())
+		if !retryable || attempt >= t.policy.MaxAttempts || !canRewind(req) {
+			return resp, err
+		}
+
+		delay := t.policy.backoff(attempt)
+		if resp != nil {
+			if retryAfter, ok := parseRetryAfter(resp.Header.Get("Retry-After")); ok {
+				if retryAfter > t.policy.MaxDelay {
+					// 服务端要求等待的时间过长，直接返回原始响应
+					return resp, err
+				}
+				delay = retryAfter
+			}
+			// 丢弃本次响应，释放连接
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+			resp.Body.Close()
+		}
+
+		fmt.Printf("[Retry] %s %s failed (%s), retrying %d/%d in %v\n",
+			req.Method, req.URL.Path, reason, attempt+1, t.policy.MaxAttempts, delay)
+		notifyRetry(ctx, RetryInfo{
+			Attempt:     attempt + 1,
+			MaxAttempts: t.policy.MaxAttempts,
+			Delay:       delay,
+			Reason:      reason,
+		})
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// canRewind 检查请求体是否可以重放
+func canRewind(req *http.Request) bool {
+	return req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+}
+
+// rewindRequest 复制请求并重新获取请求体
+func rewindRequest(req *http.Request) (*http.Request, error) {
+	clone := req.Clone(req.Context())
+	if req.Body == nil || req.Body == http.NoBody {
+		return clone, nil
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, fmt.Errorf("failed to rewind request body: %w", err)
+	}
+	clone.Body = body
+	return clone, nil
+}
+
+// isIdempotentRequest 判断请求是否可以安全地重复发送
+// GET 等幂等方法，或带 Idempotency-Key 头（服务端据此去重）的请求
+func isIdempotentRequest(req *http.Request) bool {
+	switch req.Method {
+	case "", http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return req.Header.Get("Idempotency-Key") != "" || req.Header.Get("X-Idempotency-Key") != ""
+}
+
+// retryReason 判断请求结果是否可重试，并返回可读的原因
+// 幂等请求对 429/5xx 和瞬时网络错误重试；非幂等请求（如生成图像的 POST）可能已在服务端执行并计费，
+// 只在服务端明确未处理（429/503）或请求尚未发送完成（written 为 false）时重试，避免重复生成
+func retryReason(ctx context.Context, resp *http.Response, err error, idempotent, written bool) (string, bool) {
+	// 调用方取消或超时的请求不重试
+	if ctx.Err() != nil {
+		return "", false
+	}
+
+	if err != nil {
+		if isTransientNetworkError(err) && (idempotent || !written) {
+			return err.Error(), true
+		}
+		return "", false
+	}
+
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		return fmt.Sprintf("HTTP %d", resp.StatusCode), true
+	case http.StatusInternalServerError, http.StatusBadGateway, http.StatusGatewayTimeout:
+		if idempotent {
+			return fmt.Sprintf("HTTP %d", resp.StatusCode), true
+		}
+	}
+	return "", false
+}
+
+// isTransientNetworkError 判断是否为可重试的瞬时网络错误
+func isTransientNetworkError(err error) bool {
+	if errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.EPIPE) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// parseRetryAfter 解析 Retry-After 响应头（秒数或 HTTP 日期）
+func parseRetryAfter(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		delay := time.Until(date)
+		if delay < 0 {
+			delay = 0
+		}
+		return delay, true
+	}
+	return 0, false
+}
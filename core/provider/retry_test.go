@@ -0,0 +1,68 @@
+package provider
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetryTransport(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 10 * time.Millisecond}
+
+	tests := []struct {
+		name         string
+		method       string
+		header       string // Idempotency-Key 头
+		status       int    // 为 0 时读取请求后直接断开连接
+		wantAttempts int32
+	}{
+		{"GET retried on 500", http.MethodGet, "", http.StatusInternalServerError, 3},
+		{"POST retried on 429", http.MethodPost, "", http.StatusTooManyRequests, 3},
+		{"POST retried on 503", http.MethodPost, "", http.StatusServiceUnavailable, 3},
+		{"POST not retried on 500", http.MethodPost, "", http.StatusInternalServerError, 1},
+		{"POST not retried on 502", http.MethodPost, "", http.StatusBadGateway, 1},
+		{"POST with idempotency key retried on 502", http.MethodPost, "key-1", http.StatusBadGateway, 3},
+		{"POST not retried after the request was sent", http.MethodPost, "", 0, 1},
+		{"GET retried after the connection dropped", http.MethodGet, "", 0, 3},
+		{"POST not retried on 400", http.MethodPost, "", http.StatusBadRequest, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var attempts atomic.Int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				attempts.Add(1)
+				if tt.status == 0 {
+					// 模拟服务端收到请求后断开连接（响应丢失）
+					conn, _, err := w.(http.Hijacker).Hijack()
+					if err == nil {
+						conn.Close()
+					}
+					return
+				}
+				w.WriteHeader(tt.status)
+			}))
+			defer server.Close()
+
+			client := &http.Client{Transport: newRetryTransport(http.DefaultTransport, policy)}
+			req, err := http.NewRequest(tt.method, server.URL, strings.NewReader(`{"prompt": "a cat"}`))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.header != "" {
+				req.Header.Set("Idempotency-Key", tt.header)
+			}
+
+			resp, err := client.Do(req)
+			if err == nil {
+				resp.Body.Close()
+			}
+			if got := attempts.Load(); got != tt.wantAttempts {
+				t.Errorf("server received %d attempts, want %d", got, tt.wantAttempts)
+			}
+		})
+	}
+}
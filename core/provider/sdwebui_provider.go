@@ -0,0 +1,442 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"indraw/core/apperr"
+	"indraw/core/types"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// defaultSDWebUIURL Stable Diffusion WebUI 默认服务地址
+const defaultSDWebUIURL = "http://127.0.0.1:7860"
+
+// sdWebUIProgressInterval 查询生成进度的间隔
+const sdWebUIProgressInterval = time.Second
+
+const (
+	defaultSDWebUIDenoisingStrength = 0.75
+	defaultSDWebUIUpscaler          = "R-ESRGAN 4x+"
+)
+
+// ==================== SDWebUIProvider 实现 ====================
+
+// SDWebUIProvider Stable Diffusion WebUI（AUTOMATIC1111 / Forge）提供商
+// 文生图使用 /sdapi/v1/txt2img，图生图和局部重绘使用 /sdapi/v1/img2img，
+// 生成过程中轮询 /sdapi/v1/progress 报告采样步数和预览图像
+type SDWebUIProvider struct {
+	ctx        context.Context
+	baseURL    string
+	httpClient *http.Client
+	settings   types.AISettings
+}
+
+// NewSDWebUIProvider 创建 Stable Diffusion WebUI 提供商实例
+func NewSDWebUIProvider(ctx context.Context, settings types.AISettings) (*SDWebUIProvider, error) {
+	baseURL := strings.TrimSuffix(settings.SDWebUIURL, "/")
+	if baseURL == "" {
+		baseURL = defaultSDWebUIURL
+	}
+
+	return &SDWebUIProvider{
+		ctx:        ctx,
+		baseURL:    baseURL,
+		httpClient: newHTTPClient(settings, 0),
+		settings:   settings,
+	}, nil
+}
+
+// Name 返回提供商名称
+func (p *SDWebUIProvider) Name() string {
+	return "sdwebui"
+}
+
+// GetCapabilities 返回提供商支持的功能
+func (p *SDWebUIProvider) GetCapabilities() ProviderCapabilities {
+	return ProviderCapabilities{
+		GenerateImage:    true,
+		EditImage:        true,
+		EnhancePrompt:    false,
+		BlendImages:      false,
+		RemoveBackground: false,
+		ReferenceImage:   true, // 参考图像作为图生图的初始图像
+		Inpaint:          true,
+		ExtendImage:      true,
+		Upscale:          true,
+	}
+}
+
+// CheckAvailability 检测服务可用性
+// 通过模型列表接口检测，同时确认 WebUI 已启用 API
+func (p *SDWebUIProvider) CheckAvailability(ctx context.Context) (bool, error) {
+	testCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	if _, err := p.ListModels(testCtx); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Close 清理资源
+func (p *SDWebUIProvider) Close() error {
+	if p.httpClient != nil {
+		p.httpClient.CloseIdleConnections()
+	}
+	return nil
+}
+
+// ListModels 列出服务端可用的模型检查点
+func (p *SDWebUIProvider) ListModels(ctx context.Context) ([]ModelInfo, error) {
+	var models []struct {
+		Title     string `json:"title"`
+		ModelName string `json:"model_name"`
+	}
+	if err := p.doRequest(ctx, "GET", "/sdapi/v1/sd-models", nil, &models); err != nil {
+		return nil, err
+	}
+
+	result := make([]ModelInfo, 0, len(models))
+	for _, model := range models {
+		result = append(result, ModelInfo{ID: model.Title, Name: model.ModelName})
+	}
+	return result, nil
+}
+
+// ==================== API 方法实现 ====================
+
+// GenerateImage 生成图像
+// 多张候选图像通过 batch_size 在一次请求中生成；
+// 提供草图或参考图像时改用图生图，以该图像作为初始图像
+func (p *SDWebUIProvider) GenerateImage(ctx context.Context, params types.GenerateImageParams) (*ImageResult, error) {
+	count := normalizeImageCount(params.Count)
+	width, height := generationSize(params.ImageSize, params.AspectRatio)
+
+	payload := p.basePayload(params.Prompt, width, height, count)
+
+	endpoint := "/sdapi/v1/txt2img"
+	initImage := params.SketchImage
+	if initImage == "" {
+		initImage = params.ReferenceImage
+	}
+	if initImage != "" {
+		image, err := normalizeInputImage(initImage, p.inputPolicy())
+		if err != nil {
+			return nil, err
+		}
+		endpoint = "/sdapi/v1/img2img"
+		payload["init_images"] = []string{image.Base64()}
+		payload["denoising_strength"] = p.denoisingStrength()
+		payload["resize_mode"] = 1 // 裁剪并缩放到目标尺寸
+	}
+
+	images, err := p.generate(ctx, endpoint, payload, count)
+	if err != nil {
+		return nil, err
+	}
+	return &ImageResult{Images: images}, nil
+}
+
+// EditImage 编辑图像
+// 有蒙版时进行局部重绘（白色为重绘区域），否则对整张图像做图生图
+func (p *SDWebUIProvider) EditImage(ctx context.Context, params types.EditImageParams) (string, error) {
+	image, err := normalizeInputImage(params.ImageData, p.inputPolicy())
+	if err != nil {
+		return "", err
+	}
+	width, height := image.Width, image.Height
+
+	payload := p.basePayload(params.Prompt, width, height, 1)
+	payload["init_images"] = []string{image.Base64()}
+	payload["denoising_strength"] = p.denoisingStrength()
+	payload["resize_mode"] = 0
+
+	if params.Mask != "" {
+		maskData, err := buildMaskPreviewPNG(params.Mask, width, height)
+		if err != nil {
+			return "", err
+		}
+		payload["mask"] = base64.StdEncoding.EncodeToString(maskData)
+		payload["mask_blur"] = 4
+		payload["inpainting_fill"] = 1 // 以原图内容作为重绘起点
+		payload["inpaint_full_res"] = false
+		payload["inpainting_mask_invert"] = 0
+	}
+
+	images, err := p.generate(ctx, "/sdapi/v1/img2img", payload, 1)
+	if err != nil {
+		return "", err
+	}
+	return images[0], nil
+}
+
+// EditMultiImages 多图编辑/融合（Stable Diffusion WebUI 不支持）
+func (p *SDWebUIProvider) EditMultiImages(ctx context.Context, params types.MultiImageEditParams) (string, error) {
+	return "", apperr.New(apperr.CodeUnsupportedFeature, "aiProvider sdwebui does not support multi-image editing")
+}
+
+// EnhancePrompt 增强提示词（Stable Diffusion WebUI 不支持）
+func (p *SDWebUIProvider) EnhancePrompt(ctx context.Context, prompt string) (string, error) {
+	return "", apperr.New(apperr.CodeUnsupportedFeature, "aiProvider sdwebui does not support prompt enhancement")
+}
+
+// UpscaleImage 使用 WebUI 的放大算法放大图像
+func (p *SDWebUIProvider) UpscaleImage(ctx context.Context, params types.UpscaleImageParams) (string, error) {
+	upscaler := p.settings.SDWebUIUpscaler
+	if upscaler == "" {
+		upscaler = defaultSDWebUIUpscaler
+	}
+
+	// 放大保留原始分辨率，只做格式转换和元数据去除
+	image, err := normalizeInputImage(params.ImageData, p.inputPolicy().withoutDownscale())
+	if err != nil {
+		return "", err
+	}
+
+	payload := map[string]interface{}{
+		"image":               image.Base64(),
+		"resize_mode":         0,
+		"upscaling_resize":    params.Scale,
+		"upscaler_1":          upscaler,
+		"show_extras_results": true,
+	}
+
+	var response struct {
+		Image string `json:"image"`
+	}
+	if err := p.doRequest(ctx, "POST", "/sdapi/v1/extra-single-image", payload, &response); err != nil {
+		p.interruptIfCancelled(ctx)
+		return "", err
+	}
+	if response.Image == "" {
+		return "", fmt.Errorf("no image in response")
+	}
+
+	reportUsage(ctx, Usage{Provider: p.Name(), Model: upscaler, Images: 1})
+	return sdImageDataURL(response.Image), nil
+}
+
+// ==================== 内部方法 ====================
+
+// basePayload 构建 txt2img / img2img 通用参数
+// 采样器、步数、CFG 未配置时不传，由服务端使用默认值
+func (p *SDWebUIProvider) basePayload(prompt string, width, height, count int) map[string]interface{} {
+	payload := map[string]interface{}{
+		"prompt":     prompt,
+		"width":      width,
+		"height":     height,
+		"seed":       -1,
+		"batch_size": count,
+		"n_iter":     1,
+	}
+	if p.settings.SDWebUISampler != "" {
+		payload["sampler_name"] = p.settings.SDWebUISampler
+	}
+	if p.settings.SDWebUISteps > 0 {
+		payload["steps"] = p.settings.SDWebUISteps
+	}
+	if p.settings.SDWebUICFGScale > 0 {
+		payload["cfg_scale"] = p.settings.SDWebUICFGScale
+	}
+	if p.settings.SDWebUICheckpoint != "" {
+		payload["override_settings"] = map[string]interface{}{
+			"sd_model_checkpoint": p.settings.SDWebUICheckpoint,
+		}
+	}
+	return payload
+}
+
+// inputPolicy 返回应用用户配置后的输入图像要求
+func (p *SDWebUIProvider) inputPolicy() imageInputPolicy {
+	return sdwebuiInputPolicy.withSettings(p.settings)
+}
+
+// denoisingStrength 返回图生图重绘幅度
+func (p *SDWebUIProvider) denoisingStrength() float64 {
+	if p.settings.SDWebUIDenoisingStrength > 0 && p.settings.SDWebUIDenoisingStrength <= 1 {
+		return p.settings.SDWebUIDenoisingStrength
+	}
+	return defaultSDWebUIDenoisingStrength
+}
+
+// modelName 返回用于用量统计的模型名称
+func (p *SDWebUIProvider) modelName() string {
+	if p.settings.SDWebUICheckpoint != "" {
+		return p.settings.SDWebUICheckpoint
+	}
+	return "default"
+}
+
+// generate 调用生成接口，生成期间轮询进度，返回前 count 张图像
+// 启用 ControlNet 等扩展时响应中可能附带额外的图像，这些图像会被忽略
+func (p *SDWebUIProvider) generate(ctx context.Context, endpoint string, payload map[string]interface{}, count int) ([]string, error) {
+	stopProgress := p.watchProgress(ctx)
+	defer stopProgress()
+
+	var response struct {
+		Images []string `json:"images"`
+	}
+	if err := p.doRequest(ctx, "POST", endpoint, payload, &response); err != nil {
+		p.interruptIfCancelled(ctx)
+		return nil, err
+	}
+	if len(response.Images) == 0 {
+		return nil, fmt.Errorf("no image in response")
+	}
+	if len(response.Images) > count {
+		response.Images = response.Images[:count]
+	}
+
+	images := make([]string, len(response.Images))
+	for i, image := range response.Images {
+		images[i] = sdImageDataURL(image)
+	}
+
+	reportUsage(ctx, Usage{Provider: p.Name(), Model: p.modelName(), Images: len(images)})
+	return images, nil
+}
+
+// watchProgress 在后台轮询 /sdapi/v1/progress，报告采样步数和预览图像，返回停止函数
+// WebUI 同一时间只执行一个任务，进度即为当前请求的进度
+func (p *SDWebUIProvider) watchProgress(ctx context.Context) func() {
+	watchCtx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		tracker := newProgressTracker(ctx)
+		ticker := time.NewTicker(sdWebUIProgressInterval)
+		defer ticker.Stop()
+
+		lastImage := ""
+		previewIndex := 0
+		for {
+			select {
+			case <-watchCtx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			var progress struct {
+				State struct {
+					SamplingStep  int `json:"sampling_step"`
+					SamplingSteps int `json:"sampling_steps"`
+				} `json:"state"`
+				CurrentImage string `json:"current_image"`
+			}
+			pollCtx, pollCancel := context.WithTimeout(watchCtx, 5*time.Second)
+			err := p.doRequest(pollCtx, "GET", "/sdapi/v1/progress?skip_current_image=false", nil, &progress)
+			pollCancel()
+			if err != nil {
+				continue
+			}
+
+			if progress.State.SamplingSteps > 0 {
+				tracker.steps(progress.State.SamplingStep, progress.State.SamplingSteps)
+			}
+			if progress.CurrentImage != "" && progress.CurrentImage != lastImage {
+				lastImage = progress.CurrentImage
+				tracker.partialImage(sdImageDataURL(progress.CurrentImage), previewIndex)
+				previewIndex++
+			}
+		}
+	}()
+
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+// interruptIfCancelled 请求被取消时通知服务端中断当前任务（尽力而为）
+func (p *SDWebUIProvider) interruptIfCancelled(ctx context.Context) {
+	if ctx.Err() == nil {
+		return
+	}
+
+	interruptCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := p.doRequest(interruptCtx, "POST", "/sdapi/v1/interrupt", nil, nil); err != nil {
+		fmt.Printf("[SDWebUI] Failed to interrupt generation: %v\n", err)
+	}
+}
+
+// doRequest 发送 API 请求并解析 JSON 响应
+func (p *SDWebUIProvider) doRequest(ctx context.Context, method, path string, payload interface{}, out interface{}) error {
+	var body io.Reader
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return fmt.Errorf("failed to marshal request: %w", err)
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, p.baseURL+path, body)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to call Stable Diffusion WebUI: %w", err)
+	}
+	defer resp.Body.Close()
+
+	bodyBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		// WebUI 的错误响应格式为 {"error": "...", "detail": "...", "errors": "..."}
+		var apiErr struct {
+			Error  string `json:"error"`
+			Detail string `json:"detail"`
+			Errors string `json:"errors"`
+		}
+		if json.Unmarshal(bodyBytes, &apiErr) == nil {
+			message := strings.TrimSpace(strings.Join([]string{apiErr.Error, apiErr.Detail, apiErr.Errors}, " "))
+			if message != "" {
+				return fmt.Errorf("Stable Diffusion WebUI error (status %d): %s", resp.StatusCode, truncateString(message, 500))
+			}
+		}
+		if resp.StatusCode == http.StatusNotFound {
+			return fmt.Errorf("Stable Diffusion WebUI API not found: start the WebUI with --api")
+		}
+		return fmt.Errorf("Stable Diffusion WebUI returned status %d: %s", resp.StatusCode, truncateString(string(bodyBytes), 500))
+	}
+
+	if out == nil {
+		return nil
+	}
+	if err := json.Unmarshal(bodyBytes, out); err != nil {
+		return fmt.Errorf("failed to parse response: %w", err)
+	}
+	return nil
+}
+
+// sdImageDataURL 将 WebUI 返回的纯 base64 图像转换为 data URL
+func sdImageDataURL(encoded string) string {
+	if strings.HasPrefix(encoded, "data:") {
+		return encoded
+	}
+
+	mimeType := "image/png"
+	if header, err := base64.StdEncoding.DecodeString(encoded[:min(len(encoded), 24)]); err == nil {
+		if detected := http.DetectContentType(header); strings.HasPrefix(detected, "image/") {
+			mimeType = detected
+		}
+	}
+	return fmt.Sprintf("data:%s;base64,%s", mimeType, encoded)
+}
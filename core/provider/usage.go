@@ -0,0 +1,64 @@
+package provider
+
+import "context"
+
+// ==================== 用量统计 ====================
+
+// Usage 一次提供商 API 调用的用量
+type Usage struct {
+	Provider     string // 提供商名称
+	Model        string // 使用的模型
+	InputTokens  int64  // 输入 Token 数
+	OutputTokens int64  // 输出 Token 数
+	TotalTokens  int64  // 总 Token 数（提供商未返回时为输入与输出之和）
+	Images       int    // 生成的图像数量
+}
+
+// UsageReporter 用量回调
+type UsageReporter func(usage Usage)
+
+// usageReporterKey 上下文中用量回调的键
+type usageReporterKey struct{}
+
+// WithUsageReporter 在上下文中附加用量回调
+// 提供商每完成一次 API 调用都会通过回调报告用量（包括调用成功但结果不可用的情况，因为这类调用同样计费）
+func WithUsageReporter(ctx context.Context, reporter UsageReporter) context.Context {
+	return context.WithValue(ctx, usageReporterKey{}, reporter)
+}
+
+// reportUsage 调用上下文中的用量回调（如果存在）
+func reportUsage(ctx context.Context, usage Usage) {
+	if usage.TotalTokens == 0 {
+		usage.TotalTokens = usage.InputTokens + usage.OutputTokens
+	}
+	if usage.TotalTokens == 0 && usage.Images == 0 {
+		return
+	}
+	if reporter, ok := ctx.Value(usageReporterKey{}).(UsageReporter); ok && reporter != nil {
+		reporter(usage)
+	}
+}
+
+// usageFromMap 从 JSON 对象中解析用量字段
+// 兼容常见命名：inputTokens / input_tokens / prompt_tokens / promptTokenCount 等
+func usageFromMap(data map[string]interface{}) Usage {
+	lookup := func(keys ...string) int64 {
+		for _, key := range keys {
+			if value, ok := data[key].(float64); ok {
+				return int64(value)
+			}
+		}
+		return 0
+	}
+
+	usage := Usage{
+		InputTokens:  lookup("inputTokens", "input_tokens", "promptTokens", "prompt_tokens", "promptTokenCount"),
+		OutputTokens: lookup("outputTokens", "output_tokens", "completionTokens", "completion_tokens", "candidatesTokenCount"),
+		TotalTokens:  lookup("totalTokens", "total_tokens", "totalTokenCount"),
+		Images:       int(lookup("images", "imageCount", "image_count")),
+	}
+	if model, ok := data["model"].(string); ok {
+		usage.Model = model
+	}
+	return usage
+}
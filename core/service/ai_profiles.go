@@ -0,0 +1,195 @@
+package service
+
+import (
+	"fmt"
+	"indraw/core/types"
+	"reflect"
+	"sort"
+)
+
+// ==================== 提供商配置档案 ====================
+
+// defaultAIProfileID 旧版配置迁移生成的默认档案 ID
+const defaultAIProfileID = "default"
+
+// aiProviderTypes 支持的提供商类型
+var aiProviderTypes = []string{"gemini", "openai", "cloud", "comfyui", "sdwebui", "ollama", "mock"}
+
+// isAIProviderType 判断名称是否为支持的提供商类型
+func isAIProviderType(name string) bool {
+	for _, providerType := range aiProviderTypes {
+		if providerType == name {
+			return true
+		}
+	}
+	return false
+}
+
+// migrateAIProfiles 将旧版扁平配置迁移为配置档案，返回是否发生了迁移
+// 当前提供商迁移为 "default" 档案；旧版配置中所有提供商共用同一组连接配置，
+// 回退提供商、功能路由和提示词增强提供商引用的其他类型各迁移为一个 ID 与类型相同的档案
+func migrateAIProfiles(ai *types.AISettings) bool {
+	if len(ai.Profiles) > 0 {
+		return false
+	}
+
+	primary := ai.Provider
+	if primary == "" {
+		primary = "gemini"
+	}
+	ai.Profiles = []types.AIProfile{{
+		ID:                   defaultAIProfileID,
+		Name:                 "默认",
+		Type:                 primary,
+		AIConnectionSettings: ai.AIConnectionSettings,
+	}}
+	ai.ActiveProfile = defaultAIProfileID
+
+	references := append([]string{ai.PromptProvider}, ai.FallbackProviders...)
+	for _, feature := range sortedKeys(ai.FeatureRoutes) {
+		references = append(references, ai.FeatureRoutes[feature])
+	}
+	for _, name := range references {
+		if !isAIProviderType(name) || name == primary || findAIProfile(ai.Profiles, name) >= 0 {
+			continue
+		}
+		ai.Profiles = append(ai.Profiles, types.AIProfile{
+			ID:                   name,
+			Name:                 name,
+			Type:                 name,
+			AIConnectionSettings: ai.AIConnectionSettings,
+		})
+	}
+	return true
+}
+
+// findAIProfile 按 ID 查找档案，返回索引，不存在时返回 -1
+func findAIProfile(profiles []types.AIProfile, id string) int {
+	for i, profile := range profiles {
+		if profile.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// activeAIProfileID 返回当前档案 ID
+// 当前档案不存在时使用第一个档案；未迁移的旧版配置返回当前提供商类型
+func activeAIProfileID(ai types.AISettings) string {
+	if findAIProfile(ai.Profiles, ai.ActiveProfile) >= 0 {
+		return ai.ActiveProfile
+	}
+	if len(ai.Profiles) > 0 {
+		return ai.Profiles[0].ID
+	}
+	return ai.Provider
+}
+
+// resolveAIProfile 解析档案引用
+// 依次按档案 ID、提供商类型（该类型的第一个档案）查找，名称为空时返回当前档案；
+// 未迁移的旧版配置中，提供商类型解析为使用扁平连接配置的临时档案
+func resolveAIProfile(ai types.AISettings, name string) (types.AIProfile, bool) {
+	if name == "" {
+		name = activeAIProfileID(ai)
+	}
+	if i := findAIProfile(ai.Profiles, name); i >= 0 {
+		return ai.Profiles[i], true
+	}
+	for _, profile := range ai.Profiles {
+		if profile.Type == name {
+			return profile, true
+		}
+	}
+	if len(ai.Profiles) == 0 && isAIProviderType(name) {
+		return types.AIProfile{ID: name, Name: name, Type: name, AIConnectionSettings: ai.AIConnectionSettings}, true
+	}
+	return types.AIProfile{}, false
+}
+
+// aiSettingsForProfile 返回使用指定档案连接配置的 AI 设置，用于创建提供商
+func aiSettingsForProfile(ai types.AISettings, profile types.AIProfile) types.AISettings {
+	ai.Provider = profile.Type
+	ai.AIConnectionSettings = profile.AIConnectionSettings
+	return ai
+}
+
+// applyActiveAIProfile 将当前档案的类型和连接配置同步到扁平字段，兼容只读取扁平字段的设置界面
+func applyActiveAIProfile(ai *types.AISettings) {
+	if len(ai.Profiles) == 0 {
+		return
+	}
+	i := findAIProfile(ai.Profiles, ai.ActiveProfile)
+	if i < 0 {
+		i = 0
+		ai.ActiveProfile = ai.Profiles[0].ID
+	}
+	ai.Provider = ai.Profiles[i].Type
+	ai.AIConnectionSettings = ai.Profiles[i].AIConnectionSettings
+}
+
+// mergeAIProfiles 合并待保存的设置与已保存的档案
+// stored 为已保存的设置（扁平字段为当前档案的副本）。
+// 待保存设置中不包含档案时（旧版设置界面）保留已保存的档案，并按提供商选项切换到对应类型的档案
+// （不存在时以切换前的连接配置新建，避免丢失已填写的凭据、地址和模型）；
+// 扁平连接配置中与已保存的当前档案不同的字段，视为对当前档案的修改
+func mergeAIProfiles(incoming *types.AISettings, stored types.AISettings) {
+	if len(incoming.Profiles) == 0 {
+		if len(stored.Profiles) == 0 {
+			return
+		}
+		incoming.Profiles = append([]types.AIProfile(nil), stored.Profiles...)
+		incoming.ActiveProfile = activeAIProfileID(stored)
+
+		i := findAIProfile(incoming.Profiles, incoming.ActiveProfile)
+		if incoming.Provider != "" && (i < 0 || incoming.Profiles[i].Type != incoming.Provider) {
+			if profile, ok := resolveAIProfile(*incoming, incoming.Provider); ok {
+				incoming.ActiveProfile = profile.ID
+			} else if isAIProviderType(incoming.Provider) {
+				id := uniqueAIProfileID(incoming.Profiles, incoming.Provider)
+				incoming.Profiles = append(incoming.Profiles, types.AIProfile{
+					ID:                   id,
+					Name:                 incoming.Provider,
+					Type:                 incoming.Provider,
+					AIConnectionSettings: stored.AIConnectionSettings,
+				})
+				incoming.ActiveProfile = id
+			}
+		}
+	}
+
+	if i := findAIProfile(incoming.Profiles, activeAIProfileID(*incoming)); i >= 0 {
+		applyConnectionEdits(&incoming.Profiles[i].AIConnectionSettings, incoming.AIConnectionSettings, stored.AIConnectionSettings)
+	}
+	applyActiveAIProfile(incoming)
+}
+
+// applyConnectionEdits 将 edited 中与 base 不同的字段写入 target，其余字段保持不变
+func applyConnectionEdits(target *types.AIConnectionSettings, edited, base types.AIConnectionSettings) {
+	targetValue := reflect.ValueOf(target).Elem()
+	editedValue := reflect.ValueOf(edited)
+	baseValue := reflect.ValueOf(base)
+	for i := 0; i < editedValue.NumField(); i++ {
+		if !reflect.DeepEqual(editedValue.Field(i).Interface(), baseValue.Field(i).Interface()) {
+			targetValue.Field(i).Set(editedValue.Field(i))
+		}
+	}
+}
+
+// uniqueAIProfileID 生成不与已有档案重复的 ID，如 "openai"、"openai-2"
+func uniqueAIProfileID(profiles []types.AIProfile, base string) string {
+	id := base
+	for n := 2; findAIProfile(profiles, id) >= 0; n++ {
+		id = fmt.Sprintf("%s-%d", base, n)
+	}
+	return id
+}
+
+// sortedKeys 返回按字母排序的键，保证迁移结果稳定
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,186 @@
+package service
+
+import (
+	"indraw/core/types"
+	"testing"
+)
+
+// legacyAISettings 返回旧版扁平配置（所有提供商共用一组连接配置）
+func legacyAISettings() types.AISettings {
+	return types.AISettings{
+		Provider: "gemini",
+		AIConnectionSettings: types.AIConnectionSettings{
+			APIKey:           "gemini-key",
+			ImageModel:       "gemini-image",
+			OpenAIAPIKey:     "openai-key",
+			OpenAIBaseURL:    "https://relay.example.com/v1",
+			OpenAIImageModel: "gpt-image-1",
+		},
+		FallbackProviders: []string{"openai", "unknown"},
+		FeatureRoutes:     map[string]string{"removeBackground": "cloud", "upscale": "gemini"},
+		PromptProvider:    "ollama",
+	}
+}
+
+func TestMigrateAIProfiles(t *testing.T) {
+	ai := legacyAISettings()
+	if !migrateAIProfiles(&ai) {
+		t.Fatal("migrateAIProfiles() = false, want migration of legacy settings")
+	}
+
+	wantIDs := []string{"default", "ollama", "openai", "cloud"}
+	if len(ai.Profiles) != len(wantIDs) {
+		t.Fatalf("migrated %d profiles, want %v", len(ai.Profiles), wantIDs)
+	}
+	for i, id := range wantIDs {
+		profile := ai.Profiles[i]
+		if profile.ID != id {
+			t.Errorf("profile %d ID = %q, want %q", i, profile.ID, id)
+		}
+		if profile.OpenAIAPIKey != "openai-key" || profile.APIKey != "gemini-key" {
+			t.Errorf("profile %q lost the legacy connection settings: %+v", profile.ID, profile.AIConnectionSettings)
+		}
+	}
+	if ai.Profiles[0].Type != "gemini" || ai.ActiveProfile != defaultAIProfileID {
+		t.Errorf("default profile = %+v, active = %q", ai.Profiles[0], ai.ActiveProfile)
+	}
+
+	if migrateAIProfiles(&ai) {
+		t.Error("migrateAIProfiles() migrated settings that already have profiles")
+	}
+}
+
+func TestResolveAIProfile(t *testing.T) {
+	ai := types.AISettings{
+		Profiles: []types.AIProfile{
+			{ID: "default", Type: "gemini"},
+			{ID: "relay", Type: "openai"},
+			{ID: "openai-2", Type: "openai"},
+		},
+		ActiveProfile: "relay",
+	}
+
+	tests := []struct {
+		name   string
+		ref    string
+		wantID string
+		wantOK bool
+	}{
+		{"empty reference uses the active profile", "", "relay", true},
+		{"profile ID", "openai-2", "openai-2", true},
+		{"provider type uses the first profile of that type", "openai", "relay", true},
+		{"unknown reference", "cloud", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			profile, ok := resolveAIProfile(ai, tt.ref)
+			if ok != tt.wantOK || profile.ID != tt.wantID {
+				t.Errorf("resolveAIProfile(%q) = %q, %v, want %q, %v", tt.ref, profile.ID, ok, tt.wantID, tt.wantOK)
+			}
+		})
+	}
+
+	t.Run("missing active profile falls back to the first profile", func(t *testing.T) {
+		ai := ai
+		ai.ActiveProfile = "deleted"
+		if profile, _ := resolveAIProfile(ai, ""); profile.ID != "default" {
+			t.Errorf("resolveAIProfile(\"\") = %q, want default", profile.ID)
+		}
+	})
+
+	t.Run("legacy settings resolve provider types", func(t *testing.T) {
+		legacy := legacyAISettings()
+		profile, ok := resolveAIProfile(legacy, "openai")
+		if !ok || profile.Type != "openai" || profile.OpenAIAPIKey != "openai-key" {
+			t.Errorf("resolveAIProfile(openai) = %+v, %v", profile, ok)
+		}
+	})
+}
+
+func TestMergeAIProfiles(t *testing.T) {
+	// storedSettings 返回迁移后已保存的设置（扁平字段为当前档案的副本）
+	storedSettings := func() types.AISettings {
+		ai := legacyAISettings()
+		ai.FallbackProviders = nil
+		ai.FeatureRoutes = nil
+		ai.PromptProvider = ""
+		migrateAIProfiles(&ai)
+		applyActiveAIProfile(&ai)
+		return ai
+	}
+
+	t.Run("switching provider keeps the stored connection", func(t *testing.T) {
+		stored := storedSettings()
+		incoming := stored
+		incoming.Profiles = nil
+		incoming.Provider = "openai"
+
+		mergeAIProfiles(&incoming, stored)
+
+		if incoming.ActiveProfile != "openai" || incoming.Provider != "openai" {
+			t.Fatalf("active = %q, provider = %q, want the new openai profile", incoming.ActiveProfile, incoming.Provider)
+		}
+		profile := incoming.Profiles[findAIProfile(incoming.Profiles, "openai")]
+		if profile.OpenAIAPIKey != "openai-key" || profile.OpenAIBaseURL != "https://relay.example.com/v1" || profile.OpenAIImageModel != "gpt-image-1" {
+			t.Errorf("new profile lost the stored OpenAI settings: %+v", profile.AIConnectionSettings)
+		}
+		if incoming.OpenAIAPIKey != "openai-key" {
+			t.Errorf("flat OpenAI key = %q, want the new profile's key", incoming.OpenAIAPIKey)
+		}
+	})
+
+	t.Run("switching to an existing profile applies only edited fields", func(t *testing.T) {
+		stored := storedSettings()
+		stored.Profiles = append(stored.Profiles, types.AIProfile{
+			ID:   "relay",
+			Type: "openai",
+			AIConnectionSettings: types.AIConnectionSettings{
+				OpenAIAPIKey:     "relay-key",
+				OpenAIImageModel: "relay-model",
+			},
+		})
+		incoming := stored
+		incoming.Profiles = nil
+		incoming.Provider = "openai"
+		incoming.OpenAIImageModel = "dall-e-3"
+
+		mergeAIProfiles(&incoming, stored)
+
+		if incoming.ActiveProfile != "relay" {
+			t.Fatalf("active = %q, want relay", incoming.ActiveProfile)
+		}
+		if incoming.OpenAIAPIKey != "relay-key" || incoming.OpenAIImageModel != "dall-e-3" {
+			t.Errorf("relay profile = %+v, want its key with the edited model", incoming.AIConnectionSettings)
+		}
+	})
+
+	t.Run("flat edits are written to the active profile", func(t *testing.T) {
+		stored := storedSettings()
+		incoming := stored
+		incoming.Profiles = nil
+		incoming.ImageModel = "gemini-image-2"
+
+		mergeAIProfiles(&incoming, stored)
+
+		profile := incoming.Profiles[findAIProfile(incoming.Profiles, defaultAIProfileID)]
+		if profile.ImageModel != "gemini-image-2" || profile.APIKey != "gemini-key" {
+			t.Errorf("default profile = %+v, want the edited model and the stored key", profile.AIConnectionSettings)
+		}
+		if stored.Profiles[0].ImageModel != "gemini-image" {
+			t.Error("mergeAIProfiles() modified the stored profiles")
+		}
+	})
+
+	t.Run("incoming profiles are kept", func(t *testing.T) {
+		stored := storedSettings()
+		incoming := stored
+		incoming.Profiles = []types.AIProfile{{ID: "only", Type: "cloud"}}
+		incoming.ActiveProfile = "only"
+
+		mergeAIProfiles(&incoming, stored)
+
+		if len(incoming.Profiles) != 1 || incoming.Provider != "cloud" {
+			t.Errorf("profiles = %+v, provider = %q, want the incoming profile", incoming.Profiles, incoming.Provider)
+		}
+	})
+}
@@ -3,10 +3,18 @@ package service
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"indraw/core/apperr"
 	"indraw/core/provider"
 	"indraw/core/types"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/wailsapp/wails/v2/pkg/runtime"
 )
 
 // ==================== AIService 提供商管理器 ====================
@@ -15,19 +23,32 @@ import (
 // 管理多个 AI 提供商，根据配置动态选择提供商
 // 保持现有的公共接口签名不变，内部委托给具体提供商
 type AIService struct {
-	ctx           context.Context
-	configService *ConfigService
+	ctx            context.Context
+	configService  *ConfigService
+	historyService *HistoryService
+	usageService   *UsageService
+	cache          *resultCache
 
 	// 提供商管理
 	providers map[string]provider.AIProvider
 	mu        sync.RWMutex
+
+	// 进行中的 AI 操作（操作 ID -> 取消函数），用于支持取消请求
+	operations   map[string]context.CancelFunc
+	operationMu  sync.Mutex
+	operationSeq uint64
 }
 
 // NewAIService 创建 AI 服务实例
-func NewAIService(configService *ConfigService) *AIService {
+// historyService 和 usageService 可为 nil，此时不记录生成历史和用量
+func NewAIService(configService *ConfigService, historyService *HistoryService, usageService *UsageService) *AIService {
 	return &AIService{
-		configService: configService,
-		providers:     make(map[string]provider.AIProvider),
+		configService:  configService,
+		historyService: historyService,
+		usageService:   usageService,
+		cache:          newResultCache(),
+		providers:      make(map[string]provider.AIProvider),
+		operations:     make(map[string]context.CancelFunc),
 	}
 }
 
@@ -46,10 +67,18 @@ func (a *AIService) RegisterProvider(name string, provider provider.AIProvider)
 }
 
 // GetProvider 获取提供商
+// name 可以是配置档案 ID 或提供商类型（解析为该类型的第一个档案），提供商按档案缓存；
 // 如果提供商不存在，会尝试根据配置创建
 func (a *AIService) GetProvider(name string) (provider.AIProvider, error) {
+	key := name
+	if aiSettings, err := a.loadAISettings(); err == nil {
+		if profile, ok := resolveAIProfile(aiSettings, name); ok {
+			key = profile.ID
+		}
+	}
+
 	a.mu.RLock()
-	aiProvider, ok := a.providers[name]
+	aiProvider, ok := a.providers[key]
 	a.mu.RUnlock()
 
 	if ok {
@@ -57,7 +86,7 @@ func (a *AIService) GetProvider(name string) (provider.AIProvider, error) {
 	}
 
 	// 提供商不存在，尝试创建
-	return a.createProvider(name)
+	return a.createProvider(key)
 }
 
 // GetProviderCapabilities 获取提供商能力
@@ -71,25 +100,45 @@ func (a *AIService) GetProviderCapabilities(providerName string) (*provider.Prov
 }
 
 // CheckProviderAvailability 检测提供商可用性
-func (a *AIService) CheckProviderAvailability(providerName string) (bool, string, error) {
+// 不可用时返回带错误代码的原因（如 AUTH_INVALID、NETWORK）
+func (a *AIService) CheckProviderAvailability(providerName string) (bool, *apperr.Error, error) {
 	aiProvider, err := a.GetProvider(providerName)
 	if err != nil {
-		return false, "", fmt.Errorf("failed to get provider: %w", err)
+		return false, nil, fmt.Errorf("failed to get provider: %w", err)
 	}
 
 	available, err := aiProvider.CheckAvailability(a.ctx)
 	if err != nil {
-		return false, err.Error(), nil
+		return false, apperr.From(provider.ClassifyError(err)), nil
 	}
 
 	if !available {
-		return false, "服务不可用", nil
+		return false, apperr.New(apperr.CodeNetwork, "service unavailable"), nil
 	}
 
-	return true, "", nil
+	return true, nil, nil
 }
 
-// createProvider 创建提供商（内部方法）
+// ListProviderModels 列出提供商的可用模型
+// 仅支持可以查询服务端模型列表的提供商（如 sdwebui、ollama）
+func (a *AIService) ListProviderModels(providerName string) ([]provider.ModelInfo, error) {
+	aiProvider, err := a.GetProvider(providerName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get provider: %w", err)
+	}
+
+	lister, ok := aiProvider.(provider.ModelLister)
+	if !ok {
+		return nil, apperr.New(apperr.CodeUnsupportedFeature, "aiProvider %s does not support listing models", providerName)
+	}
+	models, err := lister.ListModels(a.ctx)
+	if err != nil {
+		return nil, provider.ClassifyError(err)
+	}
+	return models, nil
+}
+
+// createProvider 根据配置档案创建提供商（内部方法）
 func (a *AIService) createProvider(name string) (provider.AIProvider, error) {
 	// 加载配置
 	aiSettings, err := a.loadAISettings()
@@ -97,6 +146,12 @@ func (a *AIService) createProvider(name string) (provider.AIProvider, error) {
 		return nil, err
 	}
 
+	profile, ok := resolveAIProfile(aiSettings, name)
+	if !ok {
+		return nil, apperr.New(apperr.CodeInvalidInput, "unsupported AI provider: %s", name)
+	}
+	aiSettings = aiSettingsForProfile(aiSettings, profile)
+
 	a.mu.Lock()
 	defer a.mu.Unlock()
 
@@ -107,21 +162,30 @@ func (a *AIService) createProvider(name string) (provider.AIProvider, error) {
 
 	var aiProvider provider.AIProvider
 
-	switch name {
+	switch profile.Type {
 	case "gemini":
 		aiProvider, err = provider.NewGeminiProvider(a.ctx, aiSettings)
 	case "openai":
 		aiProvider, err = provider.NewOpenAIProvider(a.ctx, aiSettings)
 	case "cloud":
 		aiProvider, err = provider.NewCloudProvider(a.ctx, aiSettings)
+	case "comfyui":
+		aiProvider, err = provider.NewComfyUIProvider(a.ctx, aiSettings)
+	case "sdwebui":
+		aiProvider, err = provider.NewSDWebUIProvider(a.ctx, aiSettings)
+	case "ollama":
+		aiProvider, err = provider.NewOllamaProvider(a.ctx, aiSettings)
+	case "mock":
+		aiProvider, err = provider.NewMockProvider(a.ctx, aiSettings)
 	default:
-		return nil, fmt.Errorf("unsupported AI provider: %s", name)
+		return nil, apperr.New(apperr.CodeInvalidInput, "unsupported AI provider: %s", profile.Type)
 	}
 
 	if err != nil {
 		return nil, err
 	}
 
+	fmt.Printf("[AIService] Created %s provider for profile %s\n", profile.Type, profile.ID)
 	a.providers[name] = aiProvider
 	return aiProvider, nil
 }
@@ -141,13 +205,73 @@ func (a *AIService) loadAISettings() (types.AISettings, error) {
 	return settings.AI, nil
 }
 
-// getCurrentProvider 获取当前配置的提供商（内部方法）
-func (a *AIService) getCurrentProvider() (provider.AIProvider, error) {
+// primaryProviderName 获取功能的首选提供商（内部方法）
+// 按功能路由表查找，需要多个功能时（如带蒙版的编辑需要 editImage 和 inpaint）更具体的功能（靠后的）优先；
+// 提示词增强未配置路由时使用 PromptProvider；其余情况使用当前档案
+func primaryProviderName(aiSettings types.AISettings, features ...provider.AIFeature) string {
+	for i := len(features) - 1; i >= 0; i-- {
+		if name := aiSettings.FeatureRoutes[string(features[i])]; name != "" {
+			return name
+		}
+	}
+	for _, feature := range features {
+		if feature == provider.FeatureEnhancePrompt && aiSettings.PromptProvider != "" {
+			return aiSettings.PromptProvider
+		}
+	}
+	return activeAIProfileID(aiSettings)
+}
+
+// validateFeatureRoutes 检查功能路由表（内部函数）
+// 键必须是已知的功能，目标必须是已有的档案 ID 或支持的提供商类型（为空表示使用默认提供商）
+func validateFeatureRoutes(aiSettings types.AISettings) error {
+	features := make([]string, 0, len(aiSettings.FeatureRoutes))
+	for feature := range aiSettings.FeatureRoutes {
+		features = append(features, feature)
+	}
+	sort.Strings(features)
+
+	for _, feature := range features {
+		if _, ok := featureDescriptions[provider.AIFeature(feature)]; !ok {
+			return apperr.New(apperr.CodeInvalidInput, "unknown feature in featureRoutes: %s", feature)
+		}
+		target := aiSettings.FeatureRoutes[feature]
+		if target == "" || isAIProviderType(target) {
+			continue
+		}
+		if _, ok := resolveAIProfile(aiSettings, target); !ok {
+			return apperr.New(apperr.CodeInvalidInput, "featureRoutes.%s refers to unknown provider or profile: %s", feature, target)
+		}
+	}
+	return nil
+}
+
+// resolveProviderChain 获取功能的提供商调用链（内部方法）
+// 第一个为该功能路由的首选提供商，其后为当前档案和按顺序配置的回退提供商。
+// 提供商类型会解析为对应的档案 ID，解析到同一档案的引用只保留第一个
+func (a *AIService) resolveProviderChain(features ...provider.AIFeature) ([]string, error) {
 	aiSettings, err := a.loadAISettings()
 	if err != nil {
 		return nil, err
 	}
-	return a.GetProvider(aiSettings.Provider)
+
+	names := append([]string{primaryProviderName(aiSettings, features...), activeAIProfileID(aiSettings)}, aiSettings.FallbackProviders...)
+	var chain []string
+	seen := make(map[string]bool)
+	for _, name := range names {
+		if name == "" {
+			continue
+		}
+		if profile, ok := resolveAIProfile(aiSettings, name); ok {
+			name = profile.ID
+		}
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		chain = append(chain, name)
+	}
+	return chain, nil
 }
 
 // ReloadProviders 重新加载所有提供商（配置变更时调用）
@@ -172,86 +296,607 @@ func (a *AIService) ReloadProviders() error {
 	return lastErr
 }
 
+// ReloadProvider 重新加载单个档案的提供商（档案变更时调用）
+func (a *AIService) ReloadProvider(name string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	aiProvider, ok := a.providers[name]
+	if !ok {
+		return nil
+	}
+	delete(a.providers, name)
+
+	if err := aiProvider.Close(); err != nil {
+		return fmt.Errorf("failed to close provider %s: %w", name, err)
+	}
+	return nil
+}
+
 // Close 关闭所有提供商，释放资源
 func (a *AIService) Close() error {
+	a.cancelAllOperations()
 	return a.ReloadProviders()
 }
 
+// ==================== 操作取消管理 ====================
+
+// beginOperation 为一次 AI 调用创建独立的操作 ID 和子上下文
+// requestedID 为客户端在参数中指定的操作 ID（见 requestedOperationID），为空时自动生成；
+// 客户端指定 ID 后无需等待事件即可取消操作，指定的 ID 与进行中的操作重复时返回错误
+// 返回的 finish 函数必须在调用结束时执行，用于释放上下文并注销操作
+// 操作开始时发送 "ai-operation-started" 事件（操作 ID、功能名称），前端据此获取可取消的操作 ID
+// 返回的上下文携带重试通知和进度回调，分别发送 "ai-retry" 和 "ai-progress" 事件，并携带用量回调
+func (a *AIService) beginOperation(feature provider.AIFeature, requestedID string) (ctx context.Context, operationID string, finish func(), err error) {
+	parent := a.ctx
+	if parent == nil {
+		parent = context.Background()
+	}
+
+	operationID = requestedID
+	if operationID == "" {
+		seq := atomic.AddUint64(&a.operationSeq, 1)
+		operationID = fmt.Sprintf("ai-%d-%d", time.Now().UnixMilli(), seq)
+	}
+
+	ctx, cancel := context.WithCancel(parent)
+	a.operationMu.Lock()
+	if _, exists := a.operations[operationID]; exists {
+		a.operationMu.Unlock()
+		cancel()
+		return nil, "", nil, invalidRequest("AI operation %s is already in progress", operationID)
+	}
+	a.operations[operationID] = cancel
+	a.operationMu.Unlock()
+
+	a.emitEvent("ai-operation-started", operationID, string(feature))
+
+	// 提供商请求遇到瞬时故障重试时发送 "ai-retry" 事件（操作 ID、尝试序号、最大尝试次数、等待毫秒数、原因）
+	ctx = provider.WithRetryNotifier(ctx, func(info provider.RetryInfo) {
+		a.emitEvent("ai-retry", operationID, info.Attempt, info.MaxAttempts, info.Delay.Milliseconds(), info.Reason)
+	})
+
+	// 提供商流式接收结果时发送 "ai-progress" 事件（操作 ID、进度：接收字节数、文本说明、预览图像）
+	ctx = provider.WithProgressReporter(ctx, func(update provider.ProgressUpdate) {
+		a.emitEvent("ai-progress", operationID, update)
+	})
+
+	// 提供商每次 API 调用完成后记录用量
+	ctx = provider.WithUsageReporter(ctx, func(usage provider.Usage) {
+		if a.usageService == nil {
+			return
+		}
+		if err := a.usageService.Record(usage); err != nil {
+			fmt.Printf("[AIService] Warning: failed to record usage: %v\n", err)
+		}
+	})
+
+	finish = func() {
+		a.operationMu.Lock()
+		delete(a.operations, operationID)
+		a.operationMu.Unlock()
+		cancel()
+	}
+	return ctx, operationID, finish, nil
+}
+
+// requestedOperationID 读取参数 JSON 中客户端指定的操作 ID（"operationId" 字段，内部方法）
+// 操作 ID 不属于调用参数，不参与缓存键和历史记录
+func requestedOperationID(paramsJSON string) string {
+	var request struct {
+		OperationID string `json:"operationId"`
+	}
+	if err := json.Unmarshal([]byte(paramsJSON), &request); err != nil {
+		return ""
+	}
+	return strings.TrimSpace(request.OperationID)
+}
+
+// CancelOperation 取消指定的 AI 操作
+// 取消成功后发送 "ai-operation-cancelled" 事件
+func (a *AIService) CancelOperation(operationID string) error {
+	a.operationMu.Lock()
+	cancel, ok := a.operations[operationID]
+	if ok {
+		delete(a.operations, operationID)
+	}
+	a.operationMu.Unlock()
+
+	if !ok {
+		return fmt.Errorf("AI operation not found or already finished: %s", operationID)
+	}
+
+	cancel()
+	a.emitEvent("ai-operation-cancelled", operationID)
+	return nil
+}
+
+// cancelAllOperations 取消所有进行中的 AI 操作（内部方法）
+func (a *AIService) cancelAllOperations() {
+	a.operationMu.Lock()
+	operations := a.operations
+	a.operations = make(map[string]context.CancelFunc)
+	a.operationMu.Unlock()
+
+	for operationID, cancel := range operations {
+		cancel()
+		a.emitEvent("ai-operation-cancelled", operationID)
+	}
+}
+
+// wrapOperationError 包装操作错误，操作被取消时返回明确的取消错误（内部方法）
+func wrapOperationError(ctx context.Context, operationID string, err error) error {
+	if err == nil {
+		return nil
+	}
+	if errors.Is(ctx.Err(), context.Canceled) {
+		return apperr.New(apperr.CodeCancelled, "AI operation %s cancelled", operationID)
+	}
+	return err
+}
+
+// emitEvent 向前端发送事件（内部方法）
+func (a *AIService) emitEvent(eventName string, data ...interface{}) {
+	if a.ctx != nil {
+		runtime.EventsEmit(a.ctx, eventName, data...)
+	}
+}
+
+// ==================== 提供商回退链 ====================
+
+// featureDescriptions 功能的可读描述，用于错误信息
+var featureDescriptions = map[provider.AIFeature]string{
+	provider.FeatureGenerateImage:    "image generation",
+	provider.FeatureEditImage:        "image editing",
+	provider.FeatureEnhancePrompt:    "prompt enhancement",
+	provider.FeatureBlendImages:      "image blending",
+	provider.FeatureRemoveBackground: "background removal",
+	provider.FeatureReferenceImage:   "reference image",
+	provider.FeatureInpaint:          "mask-based inpainting",
+	provider.FeatureExtendImage:      "image extension",
+	provider.FeatureUpscale:          "image upscaling",
+}
+
+// unsupportedFeatureError 构建功能不支持的错误（内部函数）
+func unsupportedFeatureError(providerName string, feature provider.AIFeature) error {
+	desc, ok := featureDescriptions[feature]
+	if !ok {
+		desc = string(feature)
+	}
+	return apperr.New(apperr.CodeUnsupportedFeature, "aiProvider %s does not support %s", providerName, desc)
+}
+
+// noImageError 模型没有返回图像时面向用户的错误
+// 错误信息按原因区分（拦截、拒绝、仅文本），同时保留提供商返回的结构化结果
+type noImageError struct {
+	result *provider.NoImageError
+}
+
+// Error 实现 error 接口
+func (e *noImageError) Error() string {
+	return describeNoImage(e.result)
+}
+
+// Unwrap 返回提供商的结构化结果
+func (e *noImageError) Unwrap() error {
+	return e.result
+}
+
+// describeNoImage 将模型没有返回图像的结构化结果转换为可读的错误信息（内部函数）
+// 如 "blocked: SAFETY – dangerous content"、"refused: I can't help with that"
+func describeNoImage(result *provider.NoImageError) string {
+	switch result.Reason {
+	case provider.NoImageBlocked:
+		message := "blocked: " + result.Code()
+		if categories := result.Categories(); len(categories) > 0 {
+			message += " – " + strings.Join(categories, ", ")
+		} else if result.Message != "" {
+			message += " – " + result.Message
+		}
+		return message
+	case provider.NoImageRefused:
+		return "refused: " + truncateText(result.Text, 200)
+	case provider.NoImageTextOnly:
+		return "no image returned, the model replied: " + truncateText(result.Text, 200)
+	}
+	if result.FinishReason != "" {
+		return fmt.Sprintf("no image returned (finish reason: %s)", result.FinishReason)
+	}
+	return "no image returned"
+}
+
+// describeProviderError 将提供商错误转换为面向用户的错误（内部函数）
+func describeProviderError(err error) error {
+	var result *provider.NoImageError
+	if errors.As(err, &result) {
+		return &noImageError{result: result}
+	}
+	return err
+}
+
+// requestError AIService 自身参数校验失败的错误（INVALID_INPUT）
+// 请求本身无效，换用其他提供商不会得到不同的结果，因此终止回退链；
+// 提供商返回的 INVALID_INPUT（如 HTTP 400/404、模型名称错误、不支持的图像格式）不属于此类，会继续尝试下一个提供商
+type requestError struct {
+	err *apperr.Error
+}
+
+func (e *requestError) Error() string {
+	return e.err.Error()
+}
+
+func (e *requestError) Unwrap() error {
+	return e.err
+}
+
+// invalidRequest 创建参数校验错误（见 requestError）
+func invalidRequest(format string, args ...interface{}) error {
+	return &requestError{err: apperr.New(apperr.CodeInvalidInput, format, args...)}
+}
+
+// isFinalProviderError 判断错误是否应当终止回退链（内部函数）
+// 模型拦截（NoImageBlocked）、拒绝（NoImageRefused）和 AIService 自身的参数校验错误（requestError）
+func isFinalProviderError(err error) bool {
+	var result *provider.NoImageError
+	if errors.As(err, &result) && (result.Reason == provider.NoImageBlocked || result.Reason == provider.NoImageRefused) {
+		return true
+	}
+	var invalid *requestError
+	return errors.As(err, &invalid)
+}
+
+// truncateText 截断过长的文本（内部函数）
+func truncateText(text string, maxLen int) string {
+	if runes := []rune(text); len(runes) > maxLen {
+		return string(runes[:maxLen]) + "..."
+	}
+	return text
+}
+
+// callWithFallback 按提供商调用链依次尝试处理请求（内部方法）
+// features 为本次请求需要的全部功能，任一功能不支持的提供商会被直接跳过而不会被调用；
+// 未配置（创建失败）的回退提供商同样会被跳过。
+// 提供商调用失败时尝试下一个提供商并发送 "ai-provider-fallback" 事件（操作 ID、失败的提供商、错误信息），
+// 被拦截、被拒绝或请求参数无效时直接返回该错误（见 isFinalProviderError）；
+// 调用成功后发送 "ai-operation-provider" 事件（操作 ID、实际处理请求的提供商）。
+// 模型拦截、拒绝或只返回文本时，错误信息转换为可读形式（见 describeNoImage）；
+// 调用错误按提供商 SDK 的错误归类为带错误代码的错误（见 provider.ClassifyError）
+func (a *AIService) callWithFallback(ctx context.Context, operationID string, features []provider.AIFeature, call func(aiProvider provider.AIProvider) error) (provider.AIProvider, error) {
+	chain, err := a.resolveProviderChain(features...)
+	if err != nil {
+		return nil, err
+	}
+
+	var skipErr error // 第一个被跳过的原因（未配置或不支持）
+	var callErr error // 最后一次调用失败的错误
+	var failed []string
+
+	for _, name := range chain {
+		aiProvider, err := a.GetProvider(name)
+		if err != nil {
+			if skipErr == nil {
+				skipErr = err
+			}
+			continue
+		}
+
+		caps := aiProvider.GetCapabilities()
+		supported := true
+		for _, feature := range features {
+			if !caps.IsSupported(feature) {
+				if skipErr == nil {
+					skipErr = unsupportedFeatureError(aiProvider.Name(), feature)
+				}
+				supported = false
+				break
+			}
+		}
+		if !supported {
+			continue
+		}
+
+		if len(failed) > 0 {
+			fmt.Printf("[AIService] Falling back to provider %s after failure of %v\n", name, failed)
+		}
+
+		callErr = provider.ClassifyError(describeProviderError(call(aiProvider)))
+		if callErr == nil {
+			a.emitEvent("ai-operation-provider", operationID, aiProvider.Name())
+			return aiProvider, nil
+		}
+
+		// 操作被取消时不再尝试其他提供商
+		if ctx.Err() != nil {
+			return nil, wrapOperationError(ctx, operationID, callErr)
+		}
+
+		// 内容被拦截、模型拒绝或输入无效时，换用其他提供商不会得到不同的结果，直接返回可读的错误
+		if isFinalProviderError(callErr) {
+			return nil, callErr
+		}
+
+		failed = append(failed, name)
+		a.emitEvent("ai-provider-fallback", operationID, aiProvider.Name(), callErr.Error())
+	}
+
+	switch {
+	case len(failed) == 1:
+		return nil, callErr
+	case len(failed) > 1:
+		return nil, fmt.Errorf("all providers failed (%s), last error: %w", strings.Join(failed, ", "), callErr)
+	case skipErr != nil:
+		return nil, skipErr
+	default:
+		return nil, apperr.New(apperr.CodeInvalidInput, "no AI provider configured")
+	}
+}
+
 // ==================== 公共 API 方法 ====================
 
 // GenerateImage 生成图像
-// 返回 base64 编码的图像数据
+// 返回 base64 编码的图像数据（多张候选图像时返回第一张）
 func (a *AIService) GenerateImage(paramsJSON string) (string, error) {
+	response, err := a.generateImages(paramsJSON)
+	if err != nil {
+		return "", err
+	}
+	return response.Images[0], nil
+}
+
+// GenerateImages 生成多张候选图像
+// 候选数量由参数中的 count 字段指定，返回 base64 编码的图像数据列表
+func (a *AIService) GenerateImages(paramsJSON string) ([]string, error) {
+	response, err := a.generateImages(paramsJSON)
+	if err != nil {
+		return nil, err
+	}
+	return response.Images, nil
+}
+
+// GenerateImagesWithMeta 生成图像并返回结果元数据（提供商、是否命中缓存）
+func (a *AIService) GenerateImagesWithMeta(paramsJSON string) (*types.AIResponse, error) {
+	return a.generateImages(paramsJSON)
+}
+
+// generateImages 生成图像（内部方法）
+func (a *AIService) generateImages(paramsJSON string) (*types.AIResponse, error) {
 	var params types.GenerateImageParams
 	if err := json.Unmarshal([]byte(paramsJSON), &params); err != nil {
-		return "", fmt.Errorf("invalid parameters: %w", err)
+		return nil, invalidRequest("invalid parameters: %w", err)
 	}
 
-	// 获取当前提供商
-	aiProvider, err := a.getCurrentProvider()
-	if err != nil {
-		return "", err
+	// 查询结果缓存
+	policy := a.resolveCachePolicy(provider.FeatureGenerateImage, params, params.BypassCache)
+	if response := a.lookupCache(policy); response != nil {
+		return response, nil
 	}
 
-	// 检查功能支持
-	caps := aiProvider.GetCapabilities()
-	if !caps.GenerateImage {
-		return "", fmt.Errorf("aiProvider %s does not support image generation", aiProvider.Name())
+	// 需要的功能：图像生成，有参考图像时还需要参考图像支持
+	features := []provider.AIFeature{provider.FeatureGenerateImage}
+	if params.ReferenceImage != "" {
+		features = append(features, provider.FeatureReferenceImage)
 	}
 
-	// 如果有参考图像，检查是否支持
-	if params.ReferenceImage != "" && !caps.ReferenceImage {
-		return "", fmt.Errorf("aiProvider %s does not support reference image", aiProvider.Name())
+	ctx, operationID, finish, err := a.beginOperation(provider.FeatureGenerateImage, requestedOperationID(paramsJSON))
+	if err != nil {
+		return nil, err
 	}
+	defer finish()
 
-	// 委托给提供商
-	return aiProvider.GenerateImage(a.ctx, params)
+	started := time.Now()
+	var result *provider.ImageResult
+	aiProvider, err := a.callWithFallback(ctx, operationID, features, func(aiProvider provider.AIProvider) error {
+		var err error
+		result, err = aiProvider.GenerateImage(ctx, params)
+		if err != nil {
+			return err
+		}
+		if len(result.Images) == 0 {
+			return fmt.Errorf("aiProvider %s returned no image", aiProvider.Name())
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, wrapOperationError(ctx, operationID, err)
+	}
+
+	a.recordHistory(provider.FeatureGenerateImage, params.Prompt, params, aiProvider, started, result.Images)
+	return a.storeCache(policy, aiProvider, result.Images, ""), nil
 }
 
 // EditImage 编辑图像
 func (a *AIService) EditImage(paramsJSON string) (string, error) {
+	response, err := a.editImage(paramsJSON)
+	if err != nil {
+		return "", err
+	}
+	return response.Images[0], nil
+}
+
+// EditImageWithMeta 编辑图像并返回结果元数据（提供商、是否命中缓存）
+func (a *AIService) EditImageWithMeta(paramsJSON string) (*types.AIResponse, error) {
+	return a.editImage(paramsJSON)
+}
+
+// editImage 编辑图像（内部方法）
+func (a *AIService) editImage(paramsJSON string) (*types.AIResponse, error) {
 	var params types.EditImageParams
 	if err := json.Unmarshal([]byte(paramsJSON), &params); err != nil {
-		return "", fmt.Errorf("invalid parameters: %w", err)
+		return nil, invalidRequest("invalid parameters: %w", err)
 	}
 
-	// 获取当前提供商
-	aiProvider, err := a.getCurrentProvider()
+	// 查询结果缓存
+	policy := a.resolveCachePolicy(provider.FeatureEditImage, params, params.BypassCache)
+	if response := a.lookupCache(policy); response != nil {
+		return response, nil
+	}
+
+	// 需要的功能：图像编辑，有蒙版时还需要局部重绘支持
+	features := []provider.AIFeature{provider.FeatureEditImage}
+	if params.Mask != "" {
+		features = append(features, provider.FeatureInpaint)
+	}
+
+	ctx, operationID, finish, err := a.beginOperation(provider.FeatureEditImage, requestedOperationID(paramsJSON))
 	if err != nil {
-		return "", err
+		return nil, err
 	}
+	defer finish()
 
-	// 检查功能支持
-	caps := aiProvider.GetCapabilities()
-	if !caps.EditImage {
-		return "", fmt.Errorf("aiProvider %s does not support image editing", aiProvider.Name())
+	started := time.Now()
+	var result string
+	aiProvider, err := a.callWithFallback(ctx, operationID, features, func(aiProvider provider.AIProvider) error {
+		var err error
+		result, err = aiProvider.EditImage(ctx, params)
+		return err
+	})
+	if err != nil {
+		return nil, wrapOperationError(ctx, operationID, err)
 	}
 
-	// 委托给提供商
-	return aiProvider.EditImage(a.ctx, params)
+	// 局部重绘：蒙版以外的像素从原图合成回去，保证未选中区域与原图完全一致
+	if params.Mask != "" {
+		result, err = provider.CompositeMasked(params.ImageData, result, params.Mask)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	a.recordHistory(provider.FeatureEditImage, params.Prompt, params, aiProvider, started, []string{result})
+	return a.storeCache(policy, aiProvider, []string{result}, ""), nil
 }
 
 // RemoveBackground 移除背景
 func (a *AIService) RemoveBackground(imageData string) (string, error) {
-	// 获取当前提供商
-	aiProvider, err := a.getCurrentProvider()
+	// 使用图像编辑功能实现背景移除
+	params := types.EditImageParams{
+		ImageData: imageData,
+		Prompt:    "Remove the background from this image. Keep the main subject intact with high quality. Return the image with transparent background.",
+	}
+
+	ctx, operationID, finish, err := a.beginOperation(provider.FeatureRemoveBackground, "")
 	if err != nil {
 		return "", err
 	}
+	defer finish()
 
-	// 检查功能支持
-	caps := aiProvider.GetCapabilities()
-	if !caps.RemoveBackground {
-		return "", fmt.Errorf("aiProvider %s does not support background removal", aiProvider.Name())
+	var result string
+	_, err = a.callWithFallback(ctx, operationID, []provider.AIFeature{provider.FeatureRemoveBackground}, func(aiProvider provider.AIProvider) error {
+		var err error
+		result, err = aiProvider.EditImage(ctx, params)
+		return err
+	})
+	return result, wrapOperationError(ctx, operationID, err)
+}
+
+// ExtendImage 图像扩展（外绘）
+// 构建扩展画布后通过提供商的编辑接口补全扩展区域，返回完整的扩展图像
+func (a *AIService) ExtendImage(paramsJSON string) (string, error) {
+	var params types.ExtendImageParams
+	if err := json.Unmarshal([]byte(paramsJSON), &params); err != nil {
+		return "", invalidRequest("invalid parameters: %w", err)
 	}
 
-	// 使用图像编辑功能实现背景移除
-	params := types.EditImageParams{
-		ImageData: imageData,
-		Prompt:    "Remove the background from this image. Keep the main subject intact with high quality. Return the image with transparent background.",
+	if params.ImageData == "" {
+		return "", invalidRequest("image data is required")
+	}
+
+	// 计算扩展像素：优先使用指定的四边扩展，否则根据目标宽高比计算
+	padding := provider.ExtendPadding{
+		Top:    params.Top,
+		Right:  params.Right,
+		Bottom: params.Bottom,
+		Left:   params.Left,
+	}
+	if padding.IsZero() && params.AspectRatio != "" {
+		width, height, err := provider.ImageDimensions(params.ImageData)
+		if err != nil {
+			return "", err
+		}
+		padding, err = provider.PaddingForAspectRatio(width, height, params.AspectRatio)
+		if err != nil {
+			return "", err
+		}
+	}
+	if padding.IsZero() {
+		return "", invalidRequest("no extension specified: set padding or a different aspect ratio")
 	}
 
-	return aiProvider.EditImage(a.ctx, params)
+	// 构建扩展画布和蒙版
+	canvas, mask, err := provider.BuildExtendCanvas(params.ImageData, padding)
+	if err != nil {
+		return "", err
+	}
+
+	ctx, operationID, finish, err := a.beginOperation(provider.FeatureExtendImage, requestedOperationID(paramsJSON))
+	if err != nil {
+		return "", err
+	}
+	defer finish()
+
+	var result string
+	_, err = a.callWithFallback(ctx, operationID, []provider.AIFeature{provider.FeatureExtendImage}, func(aiProvider provider.AIProvider) error {
+		editParams := types.EditImageParams{
+			ImageData: canvas,
+			Prompt:    provider.BuildOutpaintPrompt(params.Prompt),
+		}
+		// 支持局部重绘的提供商直接传入蒙版
+		if aiProvider.GetCapabilities().Inpaint {
+			editParams.Mask = mask
+		}
+
+		var err error
+		result, err = aiProvider.EditImage(ctx, editParams)
+		return err
+	})
+	if err != nil {
+		return "", wrapOperationError(ctx, operationID, err)
+	}
+
+	// 原图区域从画布合成回去，保证原图内容完全不变
+	return provider.CompositeMasked(canvas, result, mask)
+}
+
+// UpscaleImage 放大图像
+// 调用链中有支持原生放大的提供商时使用提供商接口，否则使用本地 Lanczos 重采样
+func (a *AIService) UpscaleImage(paramsJSON string) (string, error) {
+	var params types.UpscaleImageParams
+	if err := json.Unmarshal([]byte(paramsJSON), &params); err != nil {
+		return "", invalidRequest("invalid parameters: %w", err)
+	}
+
+	if params.ImageData == "" {
+		return "", invalidRequest("image data is required")
+	}
+	if params.Scale != 2 && params.Scale != 4 {
+		return "", invalidRequest("unsupported upscale factor: %d (expected 2 or 4)", params.Scale)
+	}
+
+	ctx, operationID, finish, err := a.beginOperation(provider.FeatureUpscale, requestedOperationID(paramsJSON))
+	if err != nil {
+		return "", err
+	}
+	defer finish()
+
+	var result string
+	_, err = a.callWithFallback(ctx, operationID, []provider.AIFeature{provider.FeatureUpscale}, func(aiProvider provider.AIProvider) error {
+		upscaler, ok := aiProvider.(provider.ImageUpscaler)
+		if !ok {
+			return unsupportedFeatureError(aiProvider.Name(), provider.FeatureUpscale)
+		}
+
+		var err error
+		result, err = upscaler.UpscaleImage(ctx, params)
+		return err
+	})
+	if err == nil || ctx.Err() != nil {
+		return result, wrapOperationError(ctx, operationID, err)
+	}
+
+	// 没有支持原生放大的提供商或原生放大失败时，回退到本地 Lanczos 重采样，同样可以取消并上报进度
+	fmt.Printf("[AIService] Native upscale unavailable, using Lanczos resampling: %v\n", err)
+	result, err = provider.UpscaleLanczos(ctx, params.ImageData, params.Scale)
+	return result, wrapOperationError(ctx, operationID, err)
 }
 
 // BlendImages 多图融合
@@ -259,33 +904,34 @@ func (a *AIService) RemoveBackground(imageData string) (string, error) {
 func (a *AIService) BlendImages(paramsJSON string) (string, error) {
 	var params types.BlendImagesParams
 	if err := json.Unmarshal([]byte(paramsJSON), &params); err != nil {
-		return "", fmt.Errorf("invalid parameters: %w", err)
+		return "", invalidRequest("invalid parameters: %w", err)
 	}
 
 	// 验证图片数量
 	if len(params.Images) < 2 {
-		return "", fmt.Errorf("at least 2 images are required for blending")
+		return "", invalidRequest("at least 2 images are required for blending")
 	}
 
-	// 获取当前提供商
-	aiProvider, err := a.getCurrentProvider()
+	// 构建融合风格描述
+	styleDesc := getBlendStyleDescription(params.Style)
+
+	ctx, operationID, finish, err := a.beginOperation(provider.FeatureBlendImages, requestedOperationID(paramsJSON))
 	if err != nil {
 		return "", err
 	}
-
-	// 检查功能支持
-	caps := aiProvider.GetCapabilities()
-	if !caps.BlendImages {
-		return "", fmt.Errorf("aiProvider %s does not support image blending", aiProvider.Name())
-	}
-
-	// 构建融合风格描述
-	styleDesc := getBlendStyleDescription(params.Style)
+	defer finish()
 
 	// 从第一张图片开始，逐步与后续图片融合
+	started := time.Now()
 	currentResult := params.Images[0]
+	var lastProvider provider.AIProvider
 
 	for i := 1; i < len(params.Images); i++ {
+		// 每一步开始前检查操作是否已被取消
+		if ctx.Err() != nil {
+			return "", wrapOperationError(ctx, operationID, ctx.Err())
+		}
+
 		// 构建融合提示词
 		var fullPrompt string
 		if i == len(params.Images)-1 && params.Prompt != "" {
@@ -309,17 +955,319 @@ func (a *AIService) BlendImages(paramsJSON string) (string, error) {
 			Prompt: fullPrompt,
 		}
 
-		result, err := aiProvider.EditMultiImages(a.ctx, editParams)
+		var result string
+		aiProvider, err := a.callWithFallback(ctx, operationID, []provider.AIFeature{provider.FeatureBlendImages}, func(aiProvider provider.AIProvider) error {
+			var err error
+			result, err = aiProvider.EditMultiImages(ctx, editParams)
+			return err
+		})
 		if err != nil {
+			if ctx.Err() != nil {
+				return "", wrapOperationError(ctx, operationID, err)
+			}
 			return "", fmt.Errorf("blend step %d failed: %w", i, err)
 		}
 
 		currentResult = result
+		lastProvider = aiProvider
 	}
 
+	a.recordHistory(provider.FeatureBlendImages, params.Prompt, params, lastProvider, started, []string{currentResult})
 	return currentResult, nil
 }
 
+// ==================== 结果缓存 ====================
+
+// cachePolicy 一次请求的缓存策略
+type cachePolicy struct {
+	key      string // 首选提供商的缓存键，用于查询
+	feature  provider.AIFeature
+	params   interface{}
+	ttl      time.Duration
+	maxBytes int64
+}
+
+// resolveCachePolicy 根据设置计算本次请求的缓存策略（内部方法）
+// 缓存未启用、请求要求跳过缓存或无法计算缓存键时返回 nil。
+// 查询使用调用链中首选提供商的缓存键；结果保存时按实际生成结果的提供商重新计算缓存键，
+// 回退提供商生成的结果不会在首选提供商恢复后被当作其结果返回
+func (a *AIService) resolveCachePolicy(feature provider.AIFeature, params interface{}, bypass bool) *cachePolicy {
+	if bypass || a.cache == nil {
+		return nil
+	}
+
+	aiSettings, err := a.loadAISettings()
+	if err != nil || !aiSettings.CacheEnabled {
+		return nil
+	}
+
+	key, err := a.providerCacheKey(primaryProviderName(aiSettings, feature), feature, params)
+	if err != nil {
+		fmt.Printf("[AIService] Warning: failed to compute cache key: %v\n", err)
+		return nil
+	}
+
+	policy := &cachePolicy{
+		key:      key,
+		feature:  feature,
+		params:   params,
+		ttl:      defaultCacheTTL,
+		maxBytes: defaultCacheMaxBytes,
+	}
+	if aiSettings.CacheTTLHours > 0 {
+		policy.ttl = time.Duration(aiSettings.CacheTTLHours) * time.Hour
+	}
+	if aiSettings.CacheMaxSizeMB > 0 {
+		policy.maxBytes = int64(aiSettings.CacheMaxSizeMB) << 20
+	}
+	return policy
+}
+
+// providerCacheKey 计算指定提供商处理请求时的缓存键（内部方法）
+// providerName 为档案 ID 或提供商类型；缓存键包含档案 ID、模型以及影响结果的模式设置
+func (a *AIService) providerCacheKey(providerName string, feature provider.AIFeature, params interface{}) (string, error) {
+	aiSettings, err := a.loadAISettings()
+	if err != nil {
+		return "", err
+	}
+
+	variant := ""
+	if profile, ok := resolveAIProfile(aiSettings, providerName); ok {
+		providerName = profile.ID
+		variant, err = cacheVariant(profile, feature)
+		if err != nil {
+			return "", err
+		}
+	}
+	return cacheKey(providerName, a.modelName(providerName, feature), variant, string(feature), params)
+}
+
+// cacheVariant 返回档案中影响生成结果的模式设置，参与缓存键计算
+// 包括 Gemini 的 API Key / Vertex AI 模式、OpenAI 的图像模式和服务地址、Cloud 的服务地址以及 ComfyUI 的工作流模板
+func cacheVariant(profile types.AIProfile, feature provider.AIFeature) (string, error) {
+	settings := profile.AIConnectionSettings
+	switch profile.Type {
+	case "gemini":
+		if settings.UseVertexAI {
+			return "vertex:" + settings.VertexProject + "/" + settings.VertexLocation, nil
+		}
+		return "api", nil
+	case "openai":
+		if feature == provider.FeatureEnhancePrompt {
+			return settings.OpenAIBaseURL, nil
+		}
+		mode := settings.OpenAIImageMode
+		if mode == "" {
+			mode = "auto"
+		}
+		baseURL := settings.OpenAIImageBaseURL
+		if baseURL == "" {
+			baseURL = settings.OpenAIBaseURL
+		}
+		return mode + "|" + baseURL, nil
+	case "cloud":
+		return settings.CloudEndpointURL, nil
+	case "sdwebui":
+		// 检查点已通过模型名称区分，这里只包含影响输出的采样与放大参数
+		return fmt.Sprintf("%s|%s|%d|%g|%g|%s", settings.SDWebUIURL, settings.SDWebUISampler, settings.SDWebUISteps,
+			settings.SDWebUICFGScale, settings.SDWebUIDenoisingStrength, settings.SDWebUIUpscaler), nil
+	case "comfyui":
+		// 工作流模板按键排序序列化，结果是确定的
+		data, err := json.Marshal(settings.ComfyUIWorkflows)
+		if err != nil {
+			return "", fmt.Errorf("failed to serialize ComfyUI workflows: %w", err)
+		}
+		return string(data), nil
+	default:
+		return "", nil
+	}
+}
+
+// providerProfileID 返回已创建的提供商实例对应的档案 ID（内部方法）
+// 找不到时返回提供商名称
+func (a *AIService) providerProfileID(aiProvider provider.AIProvider) string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	for id, p := range a.providers {
+		if p == aiProvider {
+			return id
+		}
+	}
+	return aiProvider.Name()
+}
+
+// lookupCache 查询缓存，命中时返回带缓存元数据的结果（内部方法）
+func (a *AIService) lookupCache(policy *cachePolicy) *types.AIResponse {
+	if policy == nil {
+		return nil
+	}
+
+	entry, ok := a.cache.get(policy.key, policy.ttl)
+	if !ok {
+		return nil
+	}
+
+	fmt.Printf("[AIService] Cache hit for %s (%s)\n", policy.feature, policy.key[:12])
+	return &types.AIResponse{
+		Images: entry.Images,
+		Text:   entry.Text,
+		Meta: types.AIResponseMeta{
+			Provider: entry.Provider,
+			Cached:   true,
+			CachedAt: entry.CreatedAt.Format(time.RFC3339),
+		},
+	}
+}
+
+// storeCache 保存结果到缓存，并返回带元数据的结果（内部方法）
+// 保存失败只记录警告，不影响调用结果
+func (a *AIService) storeCache(policy *cachePolicy, aiProvider provider.AIProvider, images []string, text string) *types.AIResponse {
+	response := &types.AIResponse{
+		Images: images,
+		Text:   text,
+		Meta:   types.AIResponseMeta{Provider: aiProvider.Name()},
+	}
+	if policy == nil {
+		return response
+	}
+
+	key, err := a.providerCacheKey(a.providerProfileID(aiProvider), policy.feature, policy.params)
+	if err != nil {
+		fmt.Printf("[AIService] Warning: failed to compute cache key: %v\n", err)
+		return response
+	}
+
+	entry := cacheEntry{
+		Key:       key,
+		Feature:   string(policy.feature),
+		Provider:  aiProvider.Name(),
+		CreatedAt: time.Now(),
+		Images:    images,
+		Text:      text,
+	}
+	if err := a.cache.put(entry, policy.maxBytes); err != nil {
+		fmt.Printf("[AIService] Warning: failed to store cache entry: %v\n", err)
+	}
+	return response
+}
+
+// ==================== 生成历史 ====================
+
+// recordHistory 保存一次生成结果到历史记录（内部方法）
+// 保存失败只记录警告，不影响调用结果
+func (a *AIService) recordHistory(feature provider.AIFeature, prompt string, params interface{}, aiProvider provider.AIProvider, started time.Time, images []string) {
+	if a.historyService == nil || aiProvider == nil {
+		return
+	}
+
+	// 同一类型可能有多个档案，按实际处理请求的档案记录提供商和模型
+	profileID := a.providerProfileID(aiProvider)
+	record := HistoryRecord{
+		Feature:      string(feature),
+		Prompt:       prompt,
+		Params:       params,
+		Provider:     profileID,
+		ProviderType: aiProvider.Name(),
+		Model:        a.modelName(profileID, feature),
+		Duration:     time.Since(started),
+		Images:       images,
+	}
+	if _, err := a.historyService.Record(record); err != nil {
+		fmt.Printf("[AIService] Warning: failed to record generation history: %v\n", err)
+	}
+}
+
+// modelName 获取提供商处理指定功能时配置的模型名称（内部方法）
+// providerName 为档案 ID 或提供商类型；提示词增强使用文本模型，其余功能使用图像模型
+func (a *AIService) modelName(providerName string, feature provider.AIFeature) string {
+	aiSettings, err := a.loadAISettings()
+	if err != nil {
+		return ""
+	}
+	profile, ok := resolveAIProfile(aiSettings, providerName)
+	if !ok {
+		return ""
+	}
+	aiSettings = aiSettingsForProfile(aiSettings, profile)
+
+	textFeature := feature == provider.FeatureEnhancePrompt
+	switch profile.Type {
+	case "gemini":
+		if textFeature {
+			return aiSettings.TextModel
+		}
+		return aiSettings.ImageModel
+	case "openai":
+		if textFeature {
+			return aiSettings.OpenAITextModel
+		}
+		return aiSettings.OpenAIImageModel
+	case "sdwebui":
+		if textFeature {
+			return ""
+		}
+		return aiSettings.SDWebUICheckpoint
+	case "ollama":
+		return aiSettings.OllamaModel
+	case "mock":
+		return "mock"
+	default:
+		return ""
+	}
+}
+
+// RerunHistoryEntry 使用历史记录中的原始参数重新生成
+// 返回新生成的图像列表，新结果同样会保存到历史记录
+func (a *AIService) RerunHistoryEntry(id string) ([]string, error) {
+	if a.historyService == nil {
+		return nil, fmt.Errorf("generation history is not available")
+	}
+
+	detail, err := a.historyService.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	// 重新生成总是跳过结果缓存
+	paramsJSON, err := withBypassCache(detail.Params)
+	if err != nil {
+		return nil, err
+	}
+
+	switch provider.AIFeature(detail.Feature) {
+	case provider.FeatureGenerateImage:
+		return a.GenerateImages(paramsJSON)
+	case provider.FeatureEditImage:
+		result, err := a.EditImage(paramsJSON)
+		if err != nil {
+			return nil, err
+		}
+		return []string{result}, nil
+	case provider.FeatureBlendImages:
+		result, err := a.BlendImages(paramsJSON)
+		if err != nil {
+			return nil, err
+		}
+		return []string{result}, nil
+	default:
+		return nil, apperr.New(apperr.CodeUnsupportedFeature, "history entry %s cannot be re-run: unsupported feature %s", id, detail.Feature)
+	}
+}
+
+// withBypassCache 在参数 JSON 中设置 bypassCache 标志
+func withBypassCache(paramsJSON json.RawMessage) (string, error) {
+	var params map[string]interface{}
+	if err := json.Unmarshal(paramsJSON, &params); err != nil {
+		return "", invalidRequest("invalid history params: %w", err)
+	}
+	params["bypassCache"] = true
+
+	data, err := json.Marshal(params)
+	if err != nil {
+		return "", fmt.Errorf("failed to serialize params: %w", err)
+	}
+	return string(data), nil
+}
+
 // getBlendStyleDescription 获取融合风格描述
 func getBlendStyleDescription(style string) string {
 	switch style {
@@ -340,18 +1288,46 @@ func getBlendStyleDescription(style string) string {
 
 // EnhancePrompt 增强提示词
 func (a *AIService) EnhancePrompt(prompt string) (string, error) {
-	// 获取当前提供商
-	aiProvider, err := a.getCurrentProvider()
+	response, err := a.enhancePrompt(types.EnhancePromptParams{Prompt: prompt}, "")
 	if err != nil {
 		return "", err
 	}
+	return response.Text, nil
+}
 
-	// 检查功能支持
-	caps := aiProvider.GetCapabilities()
-	if !caps.EnhancePrompt {
-		return "", fmt.Errorf("aiProvider %s does not support prompt enhancement", aiProvider.Name())
+// EnhancePromptWithMeta 增强提示词并返回结果元数据（提供商、是否命中缓存）
+func (a *AIService) EnhancePromptWithMeta(paramsJSON string) (*types.AIResponse, error) {
+	var params types.EnhancePromptParams
+	if err := json.Unmarshal([]byte(paramsJSON), &params); err != nil {
+		return nil, invalidRequest("invalid parameters: %w", err)
+	}
+	return a.enhancePrompt(params, requestedOperationID(paramsJSON))
+}
+
+// enhancePrompt 增强提示词（内部方法）
+// requestedID 为客户端指定的操作 ID，为空时自动生成
+func (a *AIService) enhancePrompt(params types.EnhancePromptParams, requestedID string) (*types.AIResponse, error) {
+	// 查询结果缓存
+	policy := a.resolveCachePolicy(provider.FeatureEnhancePrompt, params, params.BypassCache)
+	if response := a.lookupCache(policy); response != nil {
+		return response, nil
+	}
+
+	ctx, operationID, finish, err := a.beginOperation(provider.FeatureEnhancePrompt, requestedID)
+	if err != nil {
+		return nil, err
+	}
+	defer finish()
+
+	var result string
+	aiProvider, err := a.callWithFallback(ctx, operationID, []provider.AIFeature{provider.FeatureEnhancePrompt}, func(aiProvider provider.AIProvider) error {
+		var err error
+		result, err = aiProvider.EnhancePrompt(ctx, params.Prompt)
+		return err
+	})
+	if err != nil {
+		return nil, wrapOperationError(ctx, operationID, err)
 	}
 
-	// 委托给提供商
-	return aiProvider.EnhancePrompt(a.ctx, prompt)
+	return a.storeCache(policy, aiProvider, nil, result), nil
 }
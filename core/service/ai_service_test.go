@@ -0,0 +1,318 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"image"
+	"image/color"
+	_ "image/png"
+	"indraw/core/apperr"
+	"indraw/core/provider"
+	"indraw/core/types"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// newTestAIService 创建使用临时配置文件的 AI 服务，配置文件写入指定的 AI 设置
+func newTestAIService(t *testing.T, ai types.AISettings) *AIService {
+	t.Helper()
+	c := newTestConfigService(t)
+	if err := c.writeSettings(types.Settings{Version: "1.0", AI: ai}); err != nil {
+		t.Fatalf("writeSettings() error = %v", err)
+	}
+	return NewAIService(c, nil, nil)
+}
+
+func TestCallWithFallback(t *testing.T) {
+	a := newTestAIService(t, types.AISettings{
+		Profiles: []types.AIProfile{
+			{ID: "primary", Type: "mock"},
+			{ID: "backup", Type: "mock"},
+		},
+		ActiveProfile:     "primary",
+		FallbackProviders: []string{"backup"},
+	})
+
+	tests := []struct {
+		name      string
+		err       error // 首选提供商返回的错误，回退提供商调用成功
+		wantCalls int
+		wantCode  apperr.Code // 为空时回退提供商应当成功
+	}{
+		{"blocked stops the chain", &provider.NoImageError{Reason: provider.NoImageBlocked, FinishReason: "SAFETY"}, 1, apperr.CodeSafetyBlocked},
+		{"refused stops the chain", &provider.NoImageError{Reason: provider.NoImageRefused, Text: "I can't help with that"}, 1, apperr.CodeSafetyBlocked},
+		{"invalid request stops the chain", invalidRequest("mask size does not match the image"), 1, apperr.CodeInvalidInput},
+		{"provider invalid input falls back", apperr.New(apperr.CodeInvalidInput, "WebP images are not supported by this provider"), 2, ""},
+		{"404 falls back", provider.ClassifyError(&openai.APIError{HTTPStatusCode: 404, Message: "model not found"}), 2, ""},
+		{"text-only reply falls back", &provider.NoImageError{Reason: provider.NoImageTextOnly, Text: "Here is a cat"}, 2, ""},
+		{"quota falls back", apperr.New(apperr.CodeQuotaExceeded, "rate limited"), 2, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			_, err := a.callWithFallback(context.Background(), "op", []provider.AIFeature{provider.FeatureGenerateImage}, func(provider.AIProvider) error {
+				calls++
+				if calls == 1 {
+					return tt.err
+				}
+				return nil
+			})
+
+			if calls != tt.wantCalls {
+				t.Errorf("providers called %d times, want %d", calls, tt.wantCalls)
+			}
+			if tt.wantCode == "" {
+				if err != nil {
+					t.Errorf("callWithFallback() error = %v, want the fallback provider to succeed", err)
+				}
+				return
+			}
+			if code := apperr.CodeOf(err); code != tt.wantCode {
+				t.Errorf("error code = %s, want %s (error: %v)", code, tt.wantCode, err)
+			}
+			if strings.Contains(err.Error(), "all providers failed") {
+				t.Errorf("error = %q, want the provider's message without the fallback summary", err)
+			}
+		})
+	}
+
+	t.Run("all providers failed", func(t *testing.T) {
+		quota := apperr.New(apperr.CodeQuotaExceeded, "rate limited")
+		_, err := a.callWithFallback(context.Background(), "op", []provider.AIFeature{provider.FeatureGenerateImage}, func(provider.AIProvider) error {
+			return quota
+		})
+		if !errors.Is(err, quota) || !strings.Contains(err.Error(), "all providers failed") {
+			t.Errorf("callWithFallback() error = %v, want the last error wrapped with the fallback summary", err)
+		}
+	})
+}
+
+func TestCacheKeyUsesServingProvider(t *testing.T) {
+	a := newTestAIService(t, types.AISettings{
+		Profiles: []types.AIProfile{
+			{ID: "primary", Type: "mock", AIConnectionSettings: types.AIConnectionSettings{MockFailure: "rate_limit"}},
+			{ID: "backup", Type: "mock"},
+		},
+		ActiveProfile:     "primary",
+		FallbackProviders: []string{"backup"},
+		CacheEnabled:      true,
+	})
+	a.cache = &resultCache{dir: t.TempDir()}
+
+	params := types.GenerateImageParams{Prompt: "a cat", Count: 1}
+	paramsJSON, _ := json.Marshal(params)
+	for i := 0; i < 2; i++ {
+		response, err := a.generateImages(string(paramsJSON))
+		if err != nil {
+			t.Fatalf("generateImages() error = %v", err)
+		}
+		// 首选提供商失败时由回退提供商生成，结果不能以首选提供商的缓存键返回
+		if response.Meta.Cached {
+			t.Fatalf("call %d returned a cached fallback result for the primary provider", i+1)
+		}
+	}
+
+	backupKey, err := a.providerCacheKey("backup", provider.FeatureGenerateImage, params)
+	if err != nil {
+		t.Fatalf("providerCacheKey() error = %v", err)
+	}
+	if _, ok := a.cache.get(backupKey, time.Hour); !ok {
+		t.Error("result was not cached under the provider that generated it")
+	}
+}
+
+func TestCacheVariant(t *testing.T) {
+	variant := func(profileType string, feature provider.AIFeature, settings types.AIConnectionSettings) string {
+		t.Helper()
+		v, err := cacheVariant(types.AIProfile{Type: profileType, AIConnectionSettings: settings}, feature)
+		if err != nil {
+			t.Fatalf("cacheVariant() error = %v", err)
+		}
+		return v
+	}
+	image := provider.FeatureGenerateImage
+
+	tests := []struct {
+		name        string
+		profileType string
+		a, b        types.AIConnectionSettings
+		same        bool
+	}{
+		{"gemini API key vs Vertex AI", "gemini", types.AIConnectionSettings{}, types.AIConnectionSettings{UseVertexAI: true}, false},
+		{"openai image modes", "openai", types.AIConnectionSettings{OpenAIImageMode: "image_api"}, types.AIConnectionSettings{OpenAIImageMode: "chat"}, false},
+		{"openai empty mode is auto", "openai", types.AIConnectionSettings{}, types.AIConnectionSettings{OpenAIImageMode: "auto"}, true},
+		{"sdwebui sampler", "sdwebui", types.AIConnectionSettings{SDWebUISampler: "Euler a"}, types.AIConnectionSettings{SDWebUISampler: "DPM++ 2M"}, false},
+		{"sdwebui steps", "sdwebui", types.AIConnectionSettings{SDWebUISteps: 20}, types.AIConnectionSettings{SDWebUISteps: 30}, false},
+		{"sdwebui denoising strength", "sdwebui", types.AIConnectionSettings{SDWebUIDenoisingStrength: 0.5}, types.AIConnectionSettings{SDWebUIDenoisingStrength: 0.75}, false},
+		{"comfyui workflows", "comfyui",
+			types.AIConnectionSettings{ComfyUIWorkflows: map[string]string{"generateImage": `{"1": {}}`}},
+			types.AIConnectionSettings{ComfyUIWorkflows: map[string]string{"generateImage": `{"2": {}}`}}, false},
+		{"unrelated settings", "mock", types.AIConnectionSettings{}, types.AIConnectionSettings{MockLatencyMs: 100}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := variant(tt.profileType, image, tt.a) == variant(tt.profileType, image, tt.b); got != tt.same {
+				t.Errorf("variants equal = %v, want %v", got, tt.same)
+			}
+		})
+	}
+}
+
+func TestResolveProviderChain(t *testing.T) {
+	a := newTestAIService(t, types.AISettings{
+		Profiles: []types.AIProfile{
+			{ID: "default", Type: "gemini"},
+			{ID: "relay", Type: "openai"},
+			{ID: "cloud-1", Type: "cloud"},
+			{ID: "local", Type: "ollama"},
+		},
+		ActiveProfile:     "default",
+		FallbackProviders: []string{"openai", "default"},
+		FeatureRoutes:     map[string]string{"upscale": "cloud", "inpaint": "relay", "editImage": "cloud-1"},
+		PromptProvider:    "local",
+	})
+
+	tests := []struct {
+		name     string
+		features []provider.AIFeature
+		want     []string
+	}{
+		{"unrouted feature uses the active profile", []provider.AIFeature{provider.FeatureGenerateImage}, []string{"default", "relay"}},
+		{"provider type resolves to its profile", []provider.AIFeature{provider.FeatureUpscale}, []string{"cloud-1", "default", "relay"}},
+		{"more specific feature wins", []provider.AIFeature{provider.FeatureEditImage, provider.FeatureInpaint}, []string{"relay", "default"}},
+		{"less specific route applies alone", []provider.AIFeature{provider.FeatureEditImage}, []string{"cloud-1", "default", "relay"}},
+		{"prompt provider for enhancement", []provider.AIFeature{provider.FeatureEnhancePrompt}, []string{"local", "default", "relay"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chain, err := a.resolveProviderChain(tt.features...)
+			if err != nil {
+				t.Fatalf("resolveProviderChain() error = %v", err)
+			}
+			if strings.Join(chain, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("resolveProviderChain(%v) = %v, want %v", tt.features, chain, tt.want)
+			}
+		})
+	}
+}
+
+func TestRecordHistoryUsesServingProfile(t *testing.T) {
+	a := newTestAIService(t, types.AISettings{
+		Profiles: []types.AIProfile{
+			{ID: "primary", Type: "mock", AIConnectionSettings: types.AIConnectionSettings{MockFailure: "rate_limit"}},
+			{ID: "backup", Type: "mock"},
+		},
+		ActiveProfile:     "primary",
+		FallbackProviders: []string{"backup"},
+	})
+	a.historyService = newTestHistoryService(t)
+
+	if _, err := a.generateImages(`{"prompt": "a cat", "count": 1}`); err != nil {
+		t.Fatalf("generateImages() error = %v", err)
+	}
+
+	page, err := a.historyService.List(HistoryQuery{})
+	if err != nil || page.Total != 1 {
+		t.Fatalf("List() = %+v, %v, want one entry", page, err)
+	}
+	if entry := page.Entries[0]; entry.Provider != "backup" || entry.ProviderType != "mock" {
+		t.Errorf("history provider = %q (%q), want backup (mock)", entry.Provider, entry.ProviderType)
+	}
+}
+
+func TestUpscaleImageFallsBackToLanczos(t *testing.T) {
+	// 原生放大服务总是返回服务器错误
+	var upscaleRequests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upscaleRequests.Add(1)
+		http.Error(w, "upscaler crashed", http.StatusInternalServerError)
+	}))
+	t.Cleanup(server.Close)
+
+	tests := []struct {
+		name         string
+		profile      types.AIProfile
+		wantRequests int32
+	}{
+		{"no native upscaler", types.AIProfile{ID: "default", Type: "mock"}, 0},
+		{"native upscaler fails", types.AIProfile{ID: "local", Type: "sdwebui", AIConnectionSettings: types.AIConnectionSettings{SDWebUIURL: server.URL}}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upscaleRequests.Store(0)
+			a := newTestAIService(t, types.AISettings{
+				Profiles:         []types.AIProfile{tt.profile},
+				ActiveProfile:    tt.profile.ID,
+				RetryMaxAttempts: 1,
+			})
+
+			paramsJSON, _ := json.Marshal(types.UpscaleImageParams{ImageData: testPNGDataURL(t, color.NRGBA{G: 255, A: 255}), Scale: 2})
+			result, err := a.UpscaleImage(string(paramsJSON))
+			if err != nil {
+				t.Fatalf("UpscaleImage() error = %v", err)
+			}
+			data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(result, "data:image/png;base64,"))
+			if err != nil {
+				t.Fatalf("result is not a PNG data URL: %v", err)
+			}
+			config, _, err := image.DecodeConfig(bytes.NewReader(data))
+			if err != nil || config.Width != 8 || config.Height != 8 {
+				t.Errorf("upscaled image = %dx%d (%v), want 8x8", config.Width, config.Height, err)
+			}
+			if n := upscaleRequests.Load(); n != tt.wantRequests {
+				t.Errorf("native upscaler received %d requests, want %d", n, tt.wantRequests)
+			}
+		})
+	}
+}
+
+func TestBeginOperation(t *testing.T) {
+	a := newTestAIService(t, types.AISettings{})
+
+	t.Run("client-supplied ID can be cancelled", func(t *testing.T) {
+		ctx, operationID, finish, err := a.beginOperation(provider.FeatureGenerateImage, requestedOperationID(`{"prompt": "a cat", "operationId": "client-1"}`))
+		if err != nil {
+			t.Fatalf("beginOperation() error = %v", err)
+		}
+		defer finish()
+		if operationID != "client-1" {
+			t.Fatalf("operation ID = %q, want the client-supplied ID", operationID)
+		}
+
+		if _, _, _, err := a.beginOperation(provider.FeatureEditImage, "client-1"); apperr.CodeOf(err) != apperr.CodeInvalidInput {
+			t.Errorf("beginOperation() with an ID in use error = %v, want INVALID_INPUT", err)
+		}
+		if err := a.CancelOperation("client-1"); err != nil {
+			t.Fatalf("CancelOperation() error = %v", err)
+		}
+		if ctx.Err() == nil {
+			t.Error("operation context was not cancelled")
+		}
+	})
+
+	t.Run("ID is generated when not supplied", func(t *testing.T) {
+		_, first, finishFirst, err := a.beginOperation(provider.FeatureGenerateImage, requestedOperationID(`{"prompt": "a cat"}`))
+		if err != nil {
+			t.Fatalf("beginOperation() error = %v", err)
+		}
+		defer finishFirst()
+		_, second, finishSecond, err := a.beginOperation(provider.FeatureGenerateImage, "")
+		if err != nil {
+			t.Fatalf("beginOperation() error = %v", err)
+		}
+		defer finishSecond()
+		if !strings.HasPrefix(first, "ai-") || first == second {
+			t.Errorf("generated IDs = %q, %q; want distinct generated IDs", first, second)
+		}
+	})
+}
@@ -8,6 +8,7 @@ import (
 	"crypto/sha256"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"indraw/core/types"
 	"io"
@@ -142,52 +143,146 @@ func (c *ConfigService) decrypt(ciphertext string) (string, error) {
 	return string(plaintext), nil
 }
 
+// secretFields 返回连接配置中需要加密存储的字段
+func secretFields(conn *types.AIConnectionSettings) []struct {
+	name  string
+	value *string
+} {
+	return []struct {
+		name  string
+		value *string
+	}{
+		{"API key", &conn.APIKey},
+		{"Vertex credentials", &conn.VertexCredentials},
+		{"OpenAI API key", &conn.OpenAIAPIKey},
+		{"OpenAI Image API key", &conn.OpenAIImageAPIKey},
+		{"Cloud token", &conn.CloudToken},
+	}
+}
+
+// encryptConnection 加密连接配置中的敏感信息
+func (c *ConfigService) encryptConnection(conn *types.AIConnectionSettings) error {
+	for _, field := range secretFields(conn) {
+		if *field.value == "" {
+			continue
+		}
+		encrypted, err := c.encrypt(*field.value)
+		if err != nil {
+			return fmt.Errorf("failed to encrypt %s: %w", field.name, err)
+		}
+		*field.value = encrypted
+	}
+	return nil
+}
+
+// decryptConnection 解密连接配置中的敏感信息
+// 解密失败时（可能是密钥改变了）清空该字段
+func (c *ConfigService) decryptConnection(conn *types.AIConnectionSettings) {
+	for _, field := range secretFields(conn) {
+		if *field.value == "" {
+			continue
+		}
+		decrypted, err := c.decrypt(*field.value)
+		if err != nil {
+			*field.value = ""
+		} else {
+			*field.value = decrypted
+		}
+	}
+}
+
 // SaveSettings 保存设置
+// 设置以已保存的设置为基础合并，请求中未包含的字段保持不变；
+// 设置中不包含配置档案时（旧版设置界面），扁平连接配置的修改会写回当前档案；
+// 功能路由表包含未知功能或未知提供商时拒绝保存
 func (c *ConfigService) SaveSettings(settingsJSON string) error {
 	var settings types.Settings
 	if err := json.Unmarshal([]byte(settingsJSON), &settings); err != nil {
 		return fmt.Errorf("invalid settings format: %w", err)
 	}
 
-	// 加密敏感信息
-	if settings.AI.APIKey != "" {
-		encrypted, err := c.encrypt(settings.AI.APIKey)
+	if stored, err := c.readSettings(); err == nil {
+		migrateAIProfiles(&stored.AI)
+		applyActiveAIProfile(&stored.AI)
+
+		merged, err := mergeSettingsJSON(stored, settings, []byte(settingsJSON))
 		if err != nil {
-			return fmt.Errorf("failed to encrypt API key: %w", err)
+			return err
 		}
-		settings.AI.APIKey = encrypted
+		mergeAIProfiles(&merged.AI, stored.AI)
+		settings = merged
 	}
 
-	if settings.AI.VertexCredentials != "" {
-		encrypted, err := c.encrypt(settings.AI.VertexCredentials)
-		if err != nil {
-			return fmt.Errorf("failed to encrypt Vertex credentials: %w", err)
-		}
-		settings.AI.VertexCredentials = encrypted
+	if err := validateFeatureRoutes(settings.AI); err != nil {
+		return err
 	}
 
-	if settings.AI.OpenAIAPIKey != "" {
-		encrypted, err := c.encrypt(settings.AI.OpenAIAPIKey)
-		if err != nil {
-			return fmt.Errorf("failed to encrypt OpenAI API key: %w", err)
-		}
-		settings.AI.OpenAIAPIKey = encrypted
+	return c.writeSettings(settings)
+}
+
+// mergeSettingsJSON 将待保存的 JSON 合并到已保存的设置上，返回合并后的设置（不修改 stored）
+// incoming 为同一 JSON 单独解析的结果：请求中包含的映射整体替换（而不是逐键合并），
+// 列表元素不逐个合并：模型配置整体替换，配置档案只使用请求中的列表（未包含时由 mergeAIProfiles 沿用已保存的档案）
+func mergeSettingsJSON(stored, incoming types.Settings, settingsJSON []byte) (types.Settings, error) {
+	// 通过 JSON 深拷贝，避免解析时写入 stored 共享的映射和切片
+	var merged types.Settings
+	data, err := json.Marshal(stored)
+	if err != nil {
+		return merged, fmt.Errorf("failed to copy settings: %w", err)
+	}
+	if err := json.Unmarshal(data, &merged); err != nil {
+		return merged, fmt.Errorf("failed to copy settings: %w", err)
+	}
+	if err := json.Unmarshal(settingsJSON, &merged); err != nil {
+		return merged, fmt.Errorf("invalid settings format: %w", err)
 	}
 
-	if settings.AI.OpenAIImageAPIKey != "" {
-		encrypted, err := c.encrypt(settings.AI.OpenAIImageAPIKey)
-		if err != nil {
-			return fmt.Errorf("failed to encrypt OpenAI Image API key: %w", err)
-		}
-		settings.AI.OpenAIImageAPIKey = encrypted
+	if incoming.AI.FeatureRoutes != nil {
+		merged.AI.FeatureRoutes = incoming.AI.FeatureRoutes
+	}
+	if incoming.AI.ModelPrices != nil {
+		merged.AI.ModelPrices = incoming.AI.ModelPrices
+	}
+	if incoming.AI.ComfyUIWorkflows != nil {
+		merged.AI.ComfyUIWorkflows = incoming.AI.ComfyUIWorkflows
 	}
+	if incoming.App.Transformers != nil {
+		merged.App.Transformers = incoming.App.Transformers
+	}
+	merged.AI.Profiles = incoming.AI.Profiles
+	return merged, nil
+}
 
-	if settings.AI.CloudToken != "" {
-		encrypted, err := c.encrypt(settings.AI.CloudToken)
-		if err != nil {
-			return fmt.Errorf("failed to encrypt Cloud token: %w", err)
+// readSettings 读取配置文件并解密敏感信息（内部方法）
+func (c *ConfigService) readSettings() (types.Settings, error) {
+	var settings types.Settings
+
+	data, err := os.ReadFile(c.configFile)
+	if err != nil {
+		return settings, fmt.Errorf("failed to read config file: %w", err)
+	}
+	if err := json.Unmarshal(data, &settings); err != nil {
+		return settings, fmt.Errorf("invalid config file format: %w", err)
+	}
+
+	c.decryptConnection(&settings.AI.AIConnectionSettings)
+	for i := range settings.AI.Profiles {
+		c.decryptConnection(&settings.AI.Profiles[i].AIConnectionSettings)
+	}
+	return settings, nil
+}
+
+// writeSettings 加密敏感信息并写入配置文件（内部方法）
+func (c *ConfigService) writeSettings(settings types.Settings) error {
+	// 加密敏感信息（复制档案列表，避免修改调用方的数据）
+	if err := c.encryptConnection(&settings.AI.AIConnectionSettings); err != nil {
+		return err
+	}
+	settings.AI.Profiles = append([]types.AIProfile(nil), settings.AI.Profiles...)
+	for i := range settings.AI.Profiles {
+		if err := c.encryptConnection(&settings.AI.Profiles[i].AIConnectionSettings); err != nil {
+			return fmt.Errorf("profile %s: %w", settings.AI.Profiles[i].ID, err)
 		}
-		settings.AI.CloudToken = encrypted
 	}
 
 	// 序列化
@@ -251,75 +346,21 @@ func (c *ConfigService) LoadSettings() (string, error) {
 		return defaultSettings, nil
 	}
 
-	// 读取文件
-	data, err := os.ReadFile(c.configFile)
+	settings, err := c.readSettings()
 	if err != nil {
-		// 读取失败，返回默认设置
-		fmt.Printf("[ConfigService] Warning: failed to read config file: %v\n", err)
+		// 读取或解析失败，返回默认设置
+		fmt.Printf("[ConfigService] Warning: %v\n", err)
 		return c.getDefaultSettings(), nil
 	}
 
-	var settings types.Settings
-	if err := json.Unmarshal(data, &settings); err != nil {
-		// 解析失败，返回默认设置
-		fmt.Printf("[ConfigService] Warning: invalid config file format: %v\n", err)
-		return c.getDefaultSettings(), nil
-	}
-
-	// 如果导出目录为空，设置为用户图片目录
-	if settings.App.ExportDirectory == "" {
-		picturesDir := getUserPicturesDir()
-		if picturesDir != "" {
-			settings.App.ExportDirectory = picturesDir
-		}
-	}
-
-	// 解密敏感信息
-	if settings.AI.APIKey != "" {
-		decrypted, err := c.decrypt(settings.AI.APIKey)
-		if err != nil {
-			// 解密失败，可能是密钥改变了，清空该字段
-			settings.AI.APIKey = ""
-		} else {
-			settings.AI.APIKey = decrypted
-		}
-	}
-
-	if settings.AI.VertexCredentials != "" {
-		decrypted, err := c.decrypt(settings.AI.VertexCredentials)
-		if err != nil {
-			settings.AI.VertexCredentials = ""
-		} else {
-			settings.AI.VertexCredentials = decrypted
-		}
-	}
-
-	if settings.AI.OpenAIAPIKey != "" {
-		decrypted, err := c.decrypt(settings.AI.OpenAIAPIKey)
-		if err != nil {
-			settings.AI.OpenAIAPIKey = ""
-		} else {
-			settings.AI.OpenAIAPIKey = decrypted
-		}
-	}
-
-	if settings.AI.OpenAIImageAPIKey != "" {
-		decrypted, err := c.decrypt(settings.AI.OpenAIImageAPIKey)
-		if err != nil {
-			settings.AI.OpenAIImageAPIKey = ""
-		} else {
-			settings.AI.OpenAIImageAPIKey = decrypted
-		}
-	}
-
-	if settings.AI.CloudToken != "" {
-		decrypted, err := c.decrypt(settings.AI.CloudToken)
-		if err != nil {
-			settings.AI.CloudToken = ""
-		} else {
-			settings.AI.CloudToken = decrypted
+	// 旧版配置自动迁移为配置档案
+	if migrateAIProfiles(&settings.AI) {
+		fmt.Printf("[ConfigService] Migrated AI settings to %d profile(s)\n", len(settings.AI.Profiles))
+		if err := c.writeSettings(settings); err != nil {
+			fmt.Printf("[ConfigService] Warning: failed to save migrated settings: %v\n", err)
 		}
 	}
+	applyActiveAIProfile(&settings.AI)
 
 	// 如果导出目录为空，设置为用户图片目录
 	if settings.App.ExportDirectory == "" {
@@ -343,22 +384,24 @@ func (c *ConfigService) getDefaultSettings() string {
 	defaults := types.Settings{
 		Version: "1.0.0",
 		AI: types.AISettings{
-			Provider:   "gemini",
-			TextModel:  "gemini-2.5-flash",
-			ImageModel: "gemini-2.5-flash-preview-05-20",
-
-			// Vertex AI 默认配置
-			UseVertexAI:    false,
-			VertexLocation: "us-central1",
-
-			// OpenAI 默认配置
-			OpenAIBaseURL:    "https://api.openai.com/v1",
-			OpenAITextModel:  "gpt-4o",
-			OpenAIImageModel: "dall-e-3",
-
-			// Cloud 云服务默认配置
-			CloudEndpointURL: "",
-			CloudToken:       "",
+			Provider: "gemini",
+			AIConnectionSettings: types.AIConnectionSettings{
+				TextModel:  "gemini-2.5-flash",
+				ImageModel: "gemini-2.5-flash-preview-05-20",
+
+				// Vertex AI 默认配置
+				UseVertexAI:    false,
+				VertexLocation: "us-central1",
+
+				// OpenAI 默认配置
+				OpenAIBaseURL:    "https://api.openai.com/v1",
+				OpenAITextModel:  "gpt-4o",
+				OpenAIImageModel: "dall-e-3",
+
+				// Cloud 云服务默认配置
+				CloudEndpointURL: "",
+				CloudToken:       "",
+			},
 		},
 		App: types.AppSettings{
 			ExportDirectory: getUserPicturesDir(), // 默认导出目录为用户图片目录
@@ -378,6 +421,106 @@ func (c *ConfigService) getDefaultSettings() string {
 		},
 	}
 
+	migrateAIProfiles(&defaults.AI)
+
 	data, _ := json.Marshal(defaults)
 	return string(data)
 }
+
+// ==================== 提供商配置档案 ====================
+
+// loadSettingsForUpdate 读取设置并迁移配置档案，用于修改档案（内部方法）
+// 配置文件不存在时使用默认设置
+func (c *ConfigService) loadSettingsForUpdate() (types.Settings, error) {
+	settings, err := c.readSettings()
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			return settings, err
+		}
+		if err := json.Unmarshal([]byte(c.getDefaultSettings()), &settings); err != nil {
+			return settings, fmt.Errorf("failed to create default settings: %w", err)
+		}
+	}
+	migrateAIProfiles(&settings.AI)
+	return settings, nil
+}
+
+// SetActiveAIProfile 切换当前配置档案
+func (c *ConfigService) SetActiveAIProfile(id string) error {
+	settings, err := c.loadSettingsForUpdate()
+	if err != nil {
+		return err
+	}
+	if findAIProfile(settings.AI.Profiles, id) < 0 {
+		return fmt.Errorf("AI profile not found: %s", id)
+	}
+
+	settings.AI.ActiveProfile = id
+	applyActiveAIProfile(&settings.AI)
+	return c.writeSettings(settings)
+}
+
+// SaveAIProfile 新建或更新配置档案，返回保存后的档案
+// ID 为空时按提供商类型生成新 ID；ID 已存在时替换该档案
+func (c *ConfigService) SaveAIProfile(profile types.AIProfile) (types.AIProfile, error) {
+	if !isAIProviderType(profile.Type) {
+		return profile, fmt.Errorf("unsupported AI provider: %s", profile.Type)
+	}
+
+	settings, err := c.loadSettingsForUpdate()
+	if err != nil {
+		return profile, err
+	}
+
+	if profile.ID == "" {
+		profile.ID = uniqueAIProfileID(settings.AI.Profiles, profile.Type)
+	}
+	if profile.Name == "" {
+		profile.Name = profile.ID
+	}
+	if i := findAIProfile(settings.AI.Profiles, profile.ID); i >= 0 {
+		settings.AI.Profiles[i] = profile
+	} else {
+		settings.AI.Profiles = append(settings.AI.Profiles, profile)
+	}
+
+	applyActiveAIProfile(&settings.AI)
+	return profile, c.writeSettings(settings)
+}
+
+// DeleteAIProfile 删除配置档案
+// 不能删除最后一个档案；删除当前档案时切换到第一个档案，回退提供商、功能路由和提示词增强提供商中对该档案的引用一并移除
+func (c *ConfigService) DeleteAIProfile(id string) error {
+	settings, err := c.loadSettingsForUpdate()
+	if err != nil {
+		return err
+	}
+
+	i := findAIProfile(settings.AI.Profiles, id)
+	if i < 0 {
+		return fmt.Errorf("AI profile not found: %s", id)
+	}
+	if len(settings.AI.Profiles) == 1 {
+		return fmt.Errorf("cannot delete the last AI profile")
+	}
+	settings.AI.Profiles = append(settings.AI.Profiles[:i], settings.AI.Profiles[i+1:]...)
+
+	fallbacks := settings.AI.FallbackProviders[:0]
+	for _, name := range settings.AI.FallbackProviders {
+		if name != id {
+			fallbacks = append(fallbacks, name)
+		}
+	}
+	settings.AI.FallbackProviders = fallbacks
+	if settings.AI.PromptProvider == id {
+		settings.AI.PromptProvider = ""
+	}
+	for feature, name := range settings.AI.FeatureRoutes {
+		if name == id {
+			delete(settings.AI.FeatureRoutes, feature)
+		}
+	}
+
+	applyActiveAIProfile(&settings.AI)
+	return c.writeSettings(settings)
+}
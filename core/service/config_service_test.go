@@ -0,0 +1,120 @@
+package service
+
+import (
+	"crypto/sha256"
+	"indraw/core/apperr"
+	"indraw/core/types"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// newTestConfigService 创建使用临时配置文件的配置服务
+func newTestConfigService(t *testing.T) *ConfigService {
+	t.Helper()
+	dir := t.TempDir()
+	return &ConfigService{
+		configDir:     dir,
+		configFile:    filepath.Join(dir, "config.json"),
+		encryptionKey: pbkdf2.Key([]byte("test"), []byte("indraw-ai-editor-salt"), 1, 32, sha256.New),
+	}
+}
+
+func TestSaveSettingsKeepsAbsentFields(t *testing.T) {
+	c := newTestConfigService(t)
+	stored := types.Settings{
+		Version: "1.0",
+		AI: types.AISettings{
+			Provider: "gemini",
+			AIConnectionSettings: types.AIConnectionSettings{
+				APIKey:           "gemini-key",
+				OpenAIAPIKey:     "openai-key",
+				OpenAIImageModel: "gpt-image-1",
+				ComfyUIURL:       "http://127.0.0.1:8188",
+				ComfyUIWorkflows: map[string]string{"generateImage": "{}"},
+				MockLatencyMs:    250,
+			},
+			FallbackProviders: []string{"cloud"},
+			FeatureRoutes:     map[string]string{"removeBackground": "cloud", "upscale": "cloud"},
+			RetryMaxAttempts:  5,
+			CacheEnabled:      true,
+			ModelPrices:       map[string]types.ModelPrice{"gpt-image-1": {PerImage: 0.04}},
+		},
+	}
+	if err := c.writeSettings(stored); err != nil {
+		t.Fatalf("writeSettings() error = %v", err)
+	}
+
+	// 旧版设置界面只发送部分字段
+	settingsJSON := `{"version": "1.0", "ai": {"provider": "openai", "apiKey": "gemini-key", "openaiApiKey": "openai-key",
+		"openaiImageModel": "dall-e-3", "featureRoutes": {"upscale": "openai"}}}`
+	if err := c.SaveSettings(settingsJSON); err != nil {
+		t.Fatalf("SaveSettings() error = %v", err)
+	}
+
+	saved, err := c.readSettings()
+	if err != nil {
+		t.Fatalf("readSettings() error = %v", err)
+	}
+	ai := saved.AI
+
+	if ai.Provider != "openai" || ai.OpenAIImageModel != "dall-e-3" {
+		t.Errorf("provider = %q, image model = %q, want the submitted values", ai.Provider, ai.OpenAIImageModel)
+	}
+	if ai.ComfyUIURL != "http://127.0.0.1:8188" || ai.ComfyUIWorkflows["generateImage"] != "{}" || ai.MockLatencyMs != 250 {
+		t.Errorf("connection settings absent from the request were lost: %+v", ai.AIConnectionSettings)
+	}
+	if len(ai.FallbackProviders) != 1 || ai.RetryMaxAttempts != 5 || !ai.CacheEnabled || ai.ModelPrices["gpt-image-1"].PerImage != 0.04 {
+		t.Errorf("top-level settings absent from the request were lost: %+v", ai)
+	}
+	if len(ai.FeatureRoutes) != 1 || ai.FeatureRoutes["upscale"] != "openai" {
+		t.Errorf("featureRoutes = %v, want the submitted map to replace the stored one", ai.FeatureRoutes)
+	}
+
+	// 切换提供商新建的档案沿用已保存的连接配置，原档案不受修改影响
+	openai, ok := resolveAIProfile(ai, "openai")
+	if !ok || openai.OpenAIAPIKey != "openai-key" || openai.ComfyUIURL != "http://127.0.0.1:8188" {
+		t.Errorf("openai profile = %+v, want the stored connection settings", openai)
+	}
+	if profile, _ := resolveAIProfile(ai, defaultAIProfileID); profile.OpenAIImageModel != "gpt-image-1" {
+		t.Errorf("default profile image model = %q, want it unchanged", profile.OpenAIImageModel)
+	}
+}
+
+func TestSaveSettingsValidatesFeatureRoutes(t *testing.T) {
+	c := newTestConfigService(t)
+	stored := types.Settings{
+		Version: "1.0",
+		AI: types.AISettings{
+			Profiles:      []types.AIProfile{{ID: "default", Type: "gemini"}, {ID: "relay", Type: "openai"}},
+			ActiveProfile: "default",
+		},
+	}
+	if err := c.writeSettings(stored); err != nil {
+		t.Fatalf("writeSettings() error = %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		routes  string
+		wantErr bool
+	}{
+		{"profile ID", `{"upscale": "relay"}`, false},
+		{"provider type without a profile", `{"removeBackground": "cloud", "inpaint": "comfyui"}`, false},
+		{"empty target", `{"generateImage": ""}`, false},
+		{"unknown feature", `{"upscaling": "relay"}`, true},
+		{"unknown target", `{"upscale": "deleted-profile"}`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := c.SaveSettings(`{"version": "1.0", "ai": {"featureRoutes": ` + tt.routes + `}}`)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SaveSettings() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && apperr.CodeOf(err) != apperr.CodeInvalidInput {
+				t.Errorf("error code = %s, want %s", apperr.CodeOf(err), apperr.CodeInvalidInput)
+			}
+		})
+	}
+}
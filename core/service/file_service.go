@@ -5,6 +5,7 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"indraw/core/apperr"
 	"os"
 	"path/filepath"
 	"sort"
@@ -233,7 +234,7 @@ func (f *FileService) SaveProject(projectDataJSON string, suggestedName string)
 	// 解析项目数据以验证格式
 	var projectData ProjectData
 	if err := json.Unmarshal([]byte(projectDataJSON), &projectData); err != nil {
-		return "", fmt.Errorf("invalid project data: %w", err)
+		return "", apperr.New(apperr.CodeInvalidInput, "invalid project data: %w", err)
 	}
 
 	// 添加时间戳
@@ -309,7 +310,7 @@ func (f *FileService) LoadProject() (string, error) {
 	// 检查是否为有效的项目目录（包含 data.json）
 	dataFile := filepath.Join(projectPath, "data.json")
 	if _, err := os.Stat(dataFile); os.IsNotExist(err) {
-		return "", fmt.Errorf("invalid project directory: data.json not found")
+		return "", apperr.New(apperr.CodeInvalidInput, "invalid project directory: data.json not found")
 	}
 
 	// 读取项目数据文件
@@ -321,7 +322,7 @@ func (f *FileService) LoadProject() (string, error) {
 	// 验证 JSON 格式
 	var projectData ProjectData
 	if err := json.Unmarshal(data, &projectData); err != nil {
-		return "", fmt.Errorf("invalid project data format: %w", err)
+		return "", apperr.New(apperr.CodeInvalidInput, "invalid project data format: %w", err)
 	}
 
 	// 添加到最近项目列表
@@ -430,7 +431,7 @@ func (f *FileService) ExportImage(imageDataURL string, suggestedName string, for
 	// 格式: data:image/png;base64,iVBORw0KGgo...
 	const base64Prefix = "data:image/"
 	if len(imageDataURL) < len(base64Prefix) {
-		return "", fmt.Errorf("invalid image data URL")
+		return "", apperr.New(apperr.CodeInvalidInput, "invalid image data URL")
 	}
 
 	// 找到 base64 数据的起始位置
@@ -443,13 +444,13 @@ func (f *FileService) ExportImage(imageDataURL string, suggestedName string, for
 	}
 
 	if base64Start == 0 {
-		return "", fmt.Errorf("invalid image data URL format")
+		return "", apperr.New(apperr.CodeInvalidInput, "invalid image data URL format")
 	}
 
 	// 解码 base64
 	imageData, err := base64.StdEncoding.DecodeString(imageDataURL[base64Start:])
 	if err != nil {
-		return "", fmt.Errorf("failed to decode base64 image: %w", err)
+		return "", apperr.New(apperr.CodeInvalidInput, "failed to decode base64 image: %w", err)
 	}
 
 	// 写入文件
@@ -474,11 +475,11 @@ func (f *FileService) ExportSliceImages(slicesJSON string) (string, error) {
 		ID      int    `json:"id"`
 	}
 	if err := json.Unmarshal([]byte(slicesJSON), &slices); err != nil {
-		return "", fmt.Errorf("invalid slices data: %w", err)
+		return "", apperr.New(apperr.CodeInvalidInput, "invalid slices data: %w", err)
 	}
 
 	if len(slices) == 0 {
-		return "", fmt.Errorf("no slices to export")
+		return "", apperr.New(apperr.CodeInvalidInput, "no slices to export")
 	}
 
 	// 让用户选择保存目录
@@ -594,7 +595,7 @@ func (f *FileService) ListImagesInDirectory(dirPath string) (string, error) {
 		return "", fmt.Errorf("failed to access directory: %w", err)
 	}
 	if !info.IsDir() {
-		return "", fmt.Errorf("path is not a directory: %s", dirPath)
+		return "", apperr.New(apperr.CodeInvalidInput, "path is not a directory: %s", dirPath)
 	}
 
 	// 支持的图片扩展名
@@ -665,7 +666,7 @@ func (f *FileService) ListImagesInDirectory(dirPath string) (string, error) {
 // ReadImageFile 读取图片文件并返回 base64 编码的数据
 func (f *FileService) ReadImageFile(filePath string) (string, error) {
 	if filePath == "" {
-		return "", fmt.Errorf("file path is empty")
+		return "", apperr.New(apperr.CodeInvalidInput, "file path is empty")
 	}
 
 	// 读取文件
@@ -706,7 +707,7 @@ func (f *FileService) ReadImageFile(filePath string) (string, error) {
 func (f *FileService) AutoSave(projectDataJSON string) error {
 	// 快速验证 JSON 格式
 	if !json.Valid([]byte(projectDataJSON)) {
-		return fmt.Errorf("invalid JSON format")
+		return apperr.New(apperr.CodeInvalidInput, "invalid JSON format")
 	}
 
 	// 创建结果通道
@@ -828,11 +829,11 @@ type RecentProjectsData struct {
 // 在指定目录下创建项目文件夹和配置文件
 func (f *FileService) CreateProject(name string, parentDir string, canvasConfigJSON string) (string, error) {
 	if name == "" {
-		return "", fmt.Errorf("project name cannot be empty")
+		return "", apperr.New(apperr.CodeInvalidInput, "project name cannot be empty")
 	}
 
 	if parentDir == "" {
-		return "", fmt.Errorf("parent directory cannot be empty")
+		return "", apperr.New(apperr.CodeInvalidInput, "parent directory cannot be empty")
 	}
 
 	// 创建项目目录
@@ -892,12 +893,12 @@ func (f *FileService) CreateProject(name string, parentDir string, canvasConfigJ
 // ✅ 性能优化：使用合并策略，短时间内多次调用只保存最新数据
 func (f *FileService) SaveProjectToPath(projectPath string, projectDataJSON string) error {
 	if projectPath == "" {
-		return fmt.Errorf("project path cannot be empty")
+		return apperr.New(apperr.CodeInvalidInput, "project path cannot be empty")
 	}
 
 	// 快速验证 JSON 格式
 	if !json.Valid([]byte(projectDataJSON)) {
-		return fmt.Errorf("invalid JSON format")
+		return apperr.New(apperr.CodeInvalidInput, "invalid JSON format")
 	}
 
 	// 创建结果通道
@@ -956,7 +957,7 @@ func (f *FileService) doSaveProjectToPath(projectPath string, projectDataJSON st
 // 返回项目数据的 JSON 字符串
 func (f *FileService) LoadProjectFromPath(projectPath string) (string, error) {
 	if projectPath == "" {
-		return "", fmt.Errorf("project path cannot be empty")
+		return "", apperr.New(apperr.CodeInvalidInput, "project path cannot be empty")
 	}
 
 	// 读取项目数据文件
@@ -969,7 +970,7 @@ func (f *FileService) LoadProjectFromPath(projectPath string) (string, error) {
 	// 验证 JSON 格式
 	var projectData ProjectData
 	if err := json.Unmarshal(data, &projectData); err != nil {
-		return "", fmt.Errorf("invalid project data format: %w", err)
+		return "", apperr.New(apperr.CodeInvalidInput, "invalid project data format: %w", err)
 	}
 
 	// 添加到最近项目列表
@@ -987,7 +988,7 @@ func (f *FileService) LoadProjectFromPath(projectPath string) (string, error) {
 // GetProjectMeta 获取项目元数据
 func (f *FileService) GetProjectMeta(projectPath string) (string, error) {
 	if projectPath == "" {
-		return "", fmt.Errorf("project path cannot be empty")
+		return "", apperr.New(apperr.CodeInvalidInput, "project path cannot be empty")
 	}
 
 	metaFile := filepath.Join(projectPath, "project.json")
@@ -0,0 +1,390 @@
+package service
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ==================== 生成历史记录 ====================
+
+// HistoryEntry 一条生成历史记录
+type HistoryEntry struct {
+	ID           string    `json:"id"`
+	Feature      string    `json:"feature"`                // 功能名称，如 generateImage / editImage / blendImages
+	Prompt       string    `json:"prompt"`                 // 用户提示词
+	Provider     string    `json:"provider"`               // 实际处理请求的提供商档案 ID（旧记录为提供商类型）
+	ProviderType string    `json:"providerType,omitempty"` // 提供商类型，如 openai / gemini
+	Model        string    `json:"model,omitempty"`        // 使用的模型
+	DurationMs   int64     `json:"durationMs"`             // 调用耗时（毫秒）
+	CreatedAt    time.Time `json:"createdAt"`
+	ImageFiles   []string  `json:"imageFiles"` // 结果图像文件名（位于记录目录中）
+}
+
+// HistoryEntryDetail 历史记录详情，包含结果图像和原始请求参数
+type HistoryEntryDetail struct {
+	HistoryEntry
+	Images []string        `json:"images"` // 结果图像（data URL）
+	Params json.RawMessage `json:"params"` // 原始请求参数，用于重新生成
+}
+
+// HistoryRecord 待保存的一次生成结果
+type HistoryRecord struct {
+	Feature      string
+	Prompt       string
+	Params       interface{} // 原始请求参数，序列化后保存
+	Provider     string      // 档案 ID
+	ProviderType string      // 提供商类型
+	Model        string
+	Duration     time.Duration
+	Images       []string // 结果图像（data URL）
+}
+
+// HistoryQuery 历史记录查询条件
+type HistoryQuery struct {
+	Page     int        `json:"page"`               // 页码，从 1 开始
+	PageSize int        `json:"pageSize"`           // 每页数量，默认 20，最大 100
+	Feature  string     `json:"feature,omitempty"`  // 按功能过滤
+	Provider string     `json:"provider,omitempty"` // 按提供商过滤（档案 ID 或提供商类型）
+	Search   string     `json:"search,omitempty"`   // 按提示词关键字过滤（不区分大小写）
+	Since    *time.Time `json:"since,omitempty"`    // 起始时间（含）
+	Until    *time.Time `json:"until,omitempty"`    // 结束时间（不含）
+}
+
+// HistoryPage 历史记录分页结果
+type HistoryPage struct {
+	Entries  []HistoryEntry `json:"entries"`
+	Total    int            `json:"total"` // 过滤后的总条数
+	Page     int            `json:"page"`
+	PageSize int            `json:"pageSize"`
+}
+
+const (
+	historyDefaultPageSize = 20
+	historyMaxPageSize     = 100
+	historyIndexFile       = "index.json"
+	historyParamsFile      = "params.json"
+)
+
+// HistoryService 生成历史服务
+// 记录保存在应用数据目录的 history 子目录下：
+// index.json 保存所有记录的元数据（按时间倒序），每条记录一个子目录，保存结果图像和请求参数
+type HistoryService struct {
+	mu      sync.Mutex
+	dir     string
+	entries []HistoryEntry // 内存中的索引，首次访问时从磁盘加载
+	loaded  bool
+}
+
+// NewHistoryService 创建生成历史服务实例
+func NewHistoryService() *HistoryService {
+	return &HistoryService{}
+}
+
+// getHistoryDir 获取历史记录目录（内部方法，调用方需持有锁）
+func (h *HistoryService) getHistoryDir() (string, error) {
+	if h.dir != "" {
+		return h.dir, nil
+	}
+
+	userConfigDir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get user config dir: %w", err)
+	}
+
+	historyDir := filepath.Join(userConfigDir, "IndrawEditor", "history")
+	if err := os.MkdirAll(historyDir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create history dir: %w", err)
+	}
+
+	h.dir = historyDir
+	return historyDir, nil
+}
+
+// ensureLoaded 确保索引已加载（内部方法，调用方需持有锁）
+func (h *HistoryService) ensureLoaded() error {
+	if h.loaded {
+		return nil
+	}
+
+	dir, err := h.getHistoryDir()
+	if err != nil {
+		return err
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, historyIndexFile))
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read history index: %w", err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &h.entries); err != nil {
+			// 索引损坏时从空索引开始，避免历史功能整体不可用
+			fmt.Printf("[HistoryService] Warning: failed to parse history index: %v\n", err)
+			h.entries = nil
+		}
+	}
+
+	h.loaded = true
+	return nil
+}
+
+// saveIndex 保存索引到磁盘（内部方法，调用方需持有锁）
+// 先写临时文件再重命名，避免写入中断导致索引损坏
+func (h *HistoryService) saveIndex() error {
+	data, err := json.MarshalIndent(h.entries, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to serialize history index: %w", err)
+	}
+
+	indexPath := filepath.Join(h.dir, historyIndexFile)
+	tmpPath := indexPath + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write history index: %w", err)
+	}
+	if err := os.Rename(tmpPath, indexPath); err != nil {
+		return fmt.Errorf("failed to write history index: %w", err)
+	}
+	return nil
+}
+
+// Record 保存一次生成结果
+func (h *HistoryService) Record(record HistoryRecord) (*HistoryEntry, error) {
+	if len(record.Images) == 0 {
+		return nil, fmt.Errorf("no images to record")
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if err := h.ensureLoaded(); err != nil {
+		return nil, err
+	}
+
+	id, err := newHistoryID()
+	if err != nil {
+		return nil, err
+	}
+
+	entryDir := filepath.Join(h.dir, id)
+	if err := os.MkdirAll(entryDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create history entry dir: %w", err)
+	}
+
+	// 保存结果图像
+	imageFiles := make([]string, 0, len(record.Images))
+	for i, dataURL := range record.Images {
+		data, ext, err := decodeDataURL(dataURL)
+		if err != nil {
+			os.RemoveAll(entryDir)
+			return nil, fmt.Errorf("failed to decode image %d: %w", i, err)
+		}
+		name := fmt.Sprintf("image-%d.%s", i, ext)
+		if err := os.WriteFile(filepath.Join(entryDir, name), data, 0644); err != nil {
+			os.RemoveAll(entryDir)
+			return nil, fmt.Errorf("failed to write image %d: %w", i, err)
+		}
+		imageFiles = append(imageFiles, name)
+	}
+
+	// 保存请求参数
+	params, err := json.Marshal(record.Params)
+	if err != nil {
+		os.RemoveAll(entryDir)
+		return nil, fmt.Errorf("failed to serialize params: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(entryDir, historyParamsFile), params, 0644); err != nil {
+		os.RemoveAll(entryDir)
+		return nil, fmt.Errorf("failed to write params: %w", err)
+	}
+
+	entry := HistoryEntry{
+		ID:           id,
+		Feature:      record.Feature,
+		Prompt:       record.Prompt,
+		Provider:     record.Provider,
+		ProviderType: record.ProviderType,
+		Model:        record.Model,
+		DurationMs:   record.Duration.Milliseconds(),
+		CreatedAt:    time.Now(),
+		ImageFiles:   imageFiles,
+	}
+
+	// 新记录插入到最前面
+	h.entries = append([]HistoryEntry{entry}, h.entries...)
+	if err := h.saveIndex(); err != nil {
+		h.entries = h.entries[1:]
+		os.RemoveAll(entryDir)
+		return nil, err
+	}
+
+	return &entry, nil
+}
+
+// List 分页查询历史记录（按时间倒序）
+func (h *HistoryService) List(query HistoryQuery) (*HistoryPage, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if err := h.ensureLoaded(); err != nil {
+		return nil, err
+	}
+
+	if query.Page < 1 {
+		query.Page = 1
+	}
+	if query.PageSize <= 0 {
+		query.PageSize = historyDefaultPageSize
+	}
+	if query.PageSize > historyMaxPageSize {
+		query.PageSize = historyMaxPageSize
+	}
+	search := strings.ToLower(strings.TrimSpace(query.Search))
+
+	matched := make([]HistoryEntry, 0)
+	for _, entry := range h.entries {
+		if query.Feature != "" && entry.Feature != query.Feature {
+			continue
+		}
+		if query.Provider != "" && entry.Provider != query.Provider && entry.ProviderType != query.Provider {
+			continue
+		}
+		if search != "" && !strings.Contains(strings.ToLower(entry.Prompt), search) {
+			continue
+		}
+		if query.Since != nil && entry.CreatedAt.Before(*query.Since) {
+			continue
+		}
+		if query.Until != nil && !entry.CreatedAt.Before(*query.Until) {
+			continue
+		}
+		matched = append(matched, entry)
+	}
+
+	start := (query.Page - 1) * query.PageSize
+	end := start + query.PageSize
+	if start > len(matched) {
+		start = len(matched)
+	}
+	if end > len(matched) {
+		end = len(matched)
+	}
+
+	return &HistoryPage{
+		Entries:  matched[start:end],
+		Total:    len(matched),
+		Page:     query.Page,
+		PageSize: query.PageSize,
+	}, nil
+}
+
+// Get 获取历史记录详情（包括结果图像和请求参数）
+func (h *HistoryService) Get(id string) (*HistoryEntryDetail, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if err := h.ensureLoaded(); err != nil {
+		return nil, err
+	}
+
+	index := h.indexOf(id)
+	if index < 0 {
+		return nil, fmt.Errorf("history entry not found: %s", id)
+	}
+	entry := h.entries[index]
+	entryDir := filepath.Join(h.dir, entry.ID)
+
+	images := make([]string, 0, len(entry.ImageFiles))
+	for _, name := range entry.ImageFiles {
+		data, err := os.ReadFile(filepath.Join(entryDir, name))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read history image: %w", err)
+		}
+		mimeType := "image/" + strings.TrimPrefix(filepath.Ext(name), ".")
+		images = append(images, fmt.Sprintf("data:%s;base64,%s", mimeType, base64.StdEncoding.EncodeToString(data)))
+	}
+
+	params, err := os.ReadFile(filepath.Join(entryDir, historyParamsFile))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read history params: %w", err)
+	}
+
+	return &HistoryEntryDetail{
+		HistoryEntry: entry,
+		Images:       images,
+		Params:       params,
+	}, nil
+}
+
+// Delete 删除历史记录及其文件
+func (h *HistoryService) Delete(id string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if err := h.ensureLoaded(); err != nil {
+		return err
+	}
+
+	index := h.indexOf(id)
+	if index < 0 {
+		return fmt.Errorf("history entry not found: %s", id)
+	}
+
+	h.entries = append(h.entries[:index:index], h.entries[index+1:]...)
+	if err := h.saveIndex(); err != nil {
+		return err
+	}
+
+	if err := os.RemoveAll(filepath.Join(h.dir, id)); err != nil {
+		fmt.Printf("[HistoryService] Warning: failed to remove history files for %s: %v\n", id, err)
+	}
+	return nil
+}
+
+// indexOf 查找记录在索引中的位置（内部方法，调用方需持有锁）
+func (h *HistoryService) indexOf(id string) int {
+	for i, entry := range h.entries {
+		if entry.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// newHistoryID 生成历史记录 ID（时间戳 + 随机后缀，同时可作为目录名）
+func newHistoryID() (string, error) {
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", fmt.Errorf("failed to generate history id: %w", err)
+	}
+	return fmt.Sprintf("%s-%s", time.Now().Format("20060102-150405"), hex.EncodeToString(suffix)), nil
+}
+
+// decodeDataURL 解码 data URL，返回原始数据和文件扩展名
+func decodeDataURL(dataURL string) ([]byte, string, error) {
+	header, encoded, ok := strings.Cut(dataURL, ",")
+	if !ok || !strings.HasPrefix(header, "data:image/") || !strings.HasSuffix(header, ";base64") {
+		return nil, "", fmt.Errorf("invalid image data URL")
+	}
+
+	ext := strings.TrimSuffix(strings.TrimPrefix(header, "data:image/"), ";base64")
+	switch ext {
+	case "jpeg", "jpg":
+		ext = "jpeg"
+	case "png", "webp", "gif":
+	default:
+		ext = "png"
+	}
+
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to decode base64 image: %w", err)
+	}
+	return data, ext, nil
+}
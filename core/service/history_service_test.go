@@ -0,0 +1,265 @@
+package service
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newTestHistoryService 创建使用临时目录的历史服务
+func newTestHistoryService(t *testing.T) *HistoryService {
+	t.Helper()
+	return &HistoryService{dir: t.TempDir()}
+}
+
+// testPNGDataURL 返回指定颜色的 4x4 PNG data URL
+func testPNGDataURL(t *testing.T, c color.NRGBA) string {
+	t.Helper()
+	img := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+	for i := 0; i < len(img.Pix); i += 4 {
+		copy(img.Pix[i:], []uint8{c.R, c.G, c.B, c.A})
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("failed to encode test image: %v", err)
+	}
+	return "data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes())
+}
+
+// testHistoryRecord 返回一条包含一张图像的待保存记录
+func testHistoryRecord(t *testing.T, feature, provider, prompt string) HistoryRecord {
+	t.Helper()
+	return HistoryRecord{
+		Feature:      feature,
+		Prompt:       prompt,
+		Params:       map[string]string{"prompt": prompt},
+		Provider:     provider,
+		ProviderType: "openai",
+		Model:        "gpt-image-1",
+		Duration:     1500 * time.Millisecond,
+		Images:       []string{testPNGDataURL(t, color.NRGBA{R: 255, A: 255})},
+	}
+}
+
+func TestHistoryRecord(t *testing.T) {
+	t.Run("writes images, params and the index", func(t *testing.T) {
+		h := newTestHistoryService(t)
+		record := testHistoryRecord(t, "generateImage", "relay", "a cat")
+		record.Images = append(record.Images, testPNGDataURL(t, color.NRGBA{B: 255, A: 255}))
+
+		entry, err := h.Record(record)
+		if err != nil {
+			t.Fatalf("Record() error = %v", err)
+		}
+		for _, name := range append(entry.ImageFiles, historyParamsFile) {
+			if _, err := os.Stat(filepath.Join(h.dir, entry.ID, name)); err != nil {
+				t.Errorf("file %s was not written: %v", name, err)
+			}
+		}
+		if len(entry.ImageFiles) != 2 || entry.DurationMs != 1500 || entry.ProviderType != "openai" {
+			t.Errorf("entry = %+v", entry)
+		}
+
+		data, err := os.ReadFile(filepath.Join(h.dir, historyIndexFile))
+		if err != nil {
+			t.Fatalf("index was not written: %v", err)
+		}
+		var index []HistoryEntry
+		if err := json.Unmarshal(data, &index); err != nil || len(index) != 1 || index[0].ID != entry.ID {
+			t.Errorf("index = %s (%v), want the new entry", data, err)
+		}
+	})
+
+	t.Run("invalid image rolls back", func(t *testing.T) {
+		h := newTestHistoryService(t)
+		record := testHistoryRecord(t, "generateImage", "relay", "a cat")
+		record.Images = append(record.Images, "not an image")
+
+		if _, err := h.Record(record); err == nil {
+			t.Fatal("Record() succeeded with an invalid image")
+		}
+		assertHistoryEmpty(t, h)
+	})
+
+	t.Run("index write failure rolls back", func(t *testing.T) {
+		h := newTestHistoryService(t)
+		// 临时索引文件的位置被目录占用，索引无法写入
+		if err := os.Mkdir(filepath.Join(h.dir, historyIndexFile+".tmp"), 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := h.Record(testHistoryRecord(t, "generateImage", "relay", "a cat")); err == nil {
+			t.Fatal("Record() succeeded although the index could not be written")
+		}
+		if len(h.entries) != 0 {
+			t.Errorf("in-memory index has %d entries, want 0", len(h.entries))
+		}
+		assertHistoryEmpty(t, h)
+	})
+
+	t.Run("no images", func(t *testing.T) {
+		h := newTestHistoryService(t)
+		record := testHistoryRecord(t, "generateImage", "relay", "a cat")
+		record.Images = nil
+		if _, err := h.Record(record); err == nil {
+			t.Error("Record() succeeded without images")
+		}
+	})
+}
+
+// assertHistoryEmpty 检查历史目录中没有残留的记录目录
+func assertHistoryEmpty(t *testing.T, h *HistoryService) {
+	t.Helper()
+	files, err := os.ReadDir(h.dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		if file.IsDir() && file.Name() != historyIndexFile+".tmp" {
+			t.Errorf("entry directory %s was not removed", file.Name())
+		}
+	}
+}
+
+func TestHistoryList(t *testing.T) {
+	h := newTestHistoryService(t)
+	records := []HistoryRecord{
+		testHistoryRecord(t, "generateImage", "relay", "A red Cat"),
+		testHistoryRecord(t, "editImage", "relay", "make it night"),
+		testHistoryRecord(t, "generateImage", "default", "a dog"),
+		testHistoryRecord(t, "blendImages", "default", "blend the cats"),
+		testHistoryRecord(t, "generateImage", "cloud-1", "a tree"),
+	}
+	records[2].ProviderType = "gemini"
+	records[3].ProviderType = "gemini"
+	records[4].ProviderType = "cloud"
+	for _, record := range records {
+		if _, err := h.Record(record); err != nil {
+			t.Fatalf("Record() error = %v", err)
+		}
+	}
+
+	// 新记录在前；为按时间过滤设置确定的创建时间（最新的为第 0 天）
+	base := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	for i := range h.entries {
+		h.entries[i].CreatedAt = base.AddDate(0, 0, -i)
+	}
+	since := base.AddDate(0, 0, -2)
+	until := base
+
+	tests := []struct {
+		name       string
+		query      HistoryQuery
+		wantTotal  int
+		wantPrompt []string
+	}{
+		{"first page", HistoryQuery{PageSize: 2}, 5, []string{"a tree", "blend the cats"}},
+		{"last partial page", HistoryQuery{Page: 3, PageSize: 2}, 5, []string{"A red Cat"}},
+		{"page past the end", HistoryQuery{Page: 4, PageSize: 2}, 5, []string{}},
+		{"feature", HistoryQuery{Feature: "generateImage"}, 3, []string{"a tree", "a dog", "A red Cat"}},
+		{"provider profile ID", HistoryQuery{Provider: "relay"}, 2, []string{"make it night", "A red Cat"}},
+		{"provider type", HistoryQuery{Provider: "gemini"}, 2, []string{"blend the cats", "a dog"}},
+		{"search is case-insensitive", HistoryQuery{Search: " cat "}, 2, []string{"blend the cats", "A red Cat"}},
+		{"since is inclusive, until exclusive", HistoryQuery{Since: &since, Until: &until}, 2, []string{"blend the cats", "a dog"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page, err := h.List(tt.query)
+			if err != nil {
+				t.Fatalf("List() error = %v", err)
+			}
+			if page.Total != tt.wantTotal {
+				t.Errorf("total = %d, want %d", page.Total, tt.wantTotal)
+			}
+			prompts := make([]string, 0, len(page.Entries))
+			for _, entry := range page.Entries {
+				prompts = append(prompts, entry.Prompt)
+			}
+			if len(prompts) != len(tt.wantPrompt) {
+				t.Fatalf("prompts = %q, want %q", prompts, tt.wantPrompt)
+			}
+			for i := range prompts {
+				if prompts[i] != tt.wantPrompt[i] {
+					t.Errorf("prompts = %q, want %q", prompts, tt.wantPrompt)
+					break
+				}
+			}
+		})
+	}
+
+	t.Run("page size is capped", func(t *testing.T) {
+		page, err := h.List(HistoryQuery{PageSize: 1000})
+		if err != nil {
+			t.Fatalf("List() error = %v", err)
+		}
+		if page.PageSize != historyMaxPageSize || page.Page != 1 {
+			t.Errorf("page = %d, page size = %d, want 1, %d", page.Page, page.PageSize, historyMaxPageSize)
+		}
+	})
+}
+
+func TestHistoryGetAndDelete(t *testing.T) {
+	h := newTestHistoryService(t)
+	record := testHistoryRecord(t, "editImage", "relay", "make it night")
+	entry, err := h.Record(record)
+	if err != nil {
+		t.Fatalf("Record() error = %v", err)
+	}
+
+	detail, err := h.Get(entry.ID)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if len(detail.Images) != 1 || detail.Images[0] != record.Images[0] {
+		t.Errorf("images did not round-trip: %v", detail.Images)
+	}
+	var params map[string]string
+	if err := json.Unmarshal(detail.Params, &params); err != nil || params["prompt"] != "make it night" {
+		t.Errorf("params = %s (%v), want the recorded params", detail.Params, err)
+	}
+
+	if err := h.Delete(entry.ID); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(h.dir, entry.ID)); !os.IsNotExist(err) {
+		t.Errorf("entry directory still exists after Delete(): %v", err)
+	}
+	if _, err := h.Get(entry.ID); err == nil {
+		t.Error("Get() succeeded after Delete()")
+	}
+	if err := h.Delete(entry.ID); err == nil {
+		t.Error("Delete() of a missing entry succeeded")
+	}
+}
+
+func TestHistoryCorruptIndex(t *testing.T) {
+	h := newTestHistoryService(t)
+	if err := os.WriteFile(filepath.Join(h.dir, historyIndexFile), []byte("{not json"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	page, err := h.List(HistoryQuery{})
+	if err != nil {
+		t.Fatalf("List() error = %v, want an empty history", err)
+	}
+	if page.Total != 0 {
+		t.Errorf("total = %d, want 0", page.Total)
+	}
+
+	// 新记录覆盖损坏的索引
+	if _, err := h.Record(testHistoryRecord(t, "generateImage", "relay", "a cat")); err != nil {
+		t.Fatalf("Record() error = %v", err)
+	}
+	data, _ := os.ReadFile(filepath.Join(h.dir, historyIndexFile))
+	var index []HistoryEntry
+	if err := json.Unmarshal(data, &index); err != nil || len(index) != 1 {
+		t.Errorf("index = %s (%v), want one valid entry", data, err)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"crypto/tls"
 	"encoding/json"
 	"fmt"
+	"indraw/core/apperr"
 	"indraw/core/types"
 	"io"
 	"net/http"
@@ -184,7 +185,7 @@ func (m *ModelService) GetModelStatus(modelID string) (*types.ModelStatus, error
 	}
 
 	if modelInfo == nil {
-		return nil, fmt.Errorf("model not found: %s", modelID)
+		return nil, apperr.New(apperr.CodeInvalidInput, "model not found: %s", modelID)
 	}
 
 	// 检查模型是否已下载到本地
@@ -235,7 +236,7 @@ func (m *ModelService) DownloadModel(modelID string, progressCallback func(progr
 	}
 
 	if modelInfo == nil {
-		return fmt.Errorf("model not found: %s", modelID)
+		return apperr.New(apperr.CodeInvalidInput, "model not found: %s", modelID)
 	}
 
 	// 检查是否已存在
@@ -249,7 +250,7 @@ func (m *ModelService) DownloadModel(modelID string, progressCallback func(progr
 
 	// 需要 RepoID 来从 Hugging Face 下载
 	if modelInfo.RepoID == "" {
-		return fmt.Errorf("no Hugging Face repo ID specified for model: %s", modelID)
+		return apperr.New(apperr.CodeInvalidInput, "no Hugging Face repo ID specified for model: %s", modelID)
 	}
 
 	// 使用 DownloadModelFromHuggingFace 方法下载
@@ -292,7 +293,11 @@ func (m *ModelService) downloadFile(fileURL, destPath string, modelID, fileName
 
 	// 检查状态码
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("download failed with status: %s (%d)", resp.Status, resp.StatusCode)
+		code := apperr.CodeForStatus(resp.StatusCode)
+		if code == apperr.CodeUnknown {
+			code = apperr.CodeNetwork
+		}
+		return apperr.New(code, "download failed with status: %s (%d)", resp.Status, resp.StatusCode).With("status", resp.StatusCode)
 	}
 
 	// 获取文件大小
@@ -451,7 +456,7 @@ func (m *ModelService) DownloadModelFromHuggingFace(modelID string, repoID strin
 	m.mu.Lock()
 	if m.downloading[modelID] {
 		m.mu.Unlock()
-		return fmt.Errorf("model is already being downloaded")
+		return apperr.New(apperr.CodeInvalidInput, "model is already being downloaded")
 	}
 	m.downloading[modelID] = true
 	m.mu.Unlock()
@@ -505,11 +510,9 @@ func (m *ModelService) DownloadModelFromHuggingFace(modelID string, repoID strin
 		}
 
 		if err := m.downloadFile(fileURL, destPath, modelID, file); err != nil {
-			// ✅ 发送下载错误事件
-			if m.ctx != nil {
-				runtime.EventsEmit(m.ctx, "model-download-error", modelID, fmt.Sprintf("failed to download required file %s: %v", file, err))
-			}
-			return fmt.Errorf("failed to download required file %s: %w", file, err)
+			err = fmt.Errorf("failed to download required file %s: %w", file, err)
+			m.emitDownloadError(modelID, err)
+			return err
 		}
 	}
 
@@ -554,11 +557,9 @@ func (m *ModelService) DownloadModelFromHuggingFace(modelID string, repoID strin
 	}
 
 	if !onnxDownloaded {
-		// ✅ 发送下载错误事件
-		if m.ctx != nil {
-			runtime.EventsEmit(m.ctx, "model-download-error", modelID, fmt.Sprintf("failed to download any ONNX model file, model %s may not support ONNX format", repoID))
-		}
-		return fmt.Errorf("failed to download any ONNX model file, model %s may not support ONNX format", repoID)
+		err := apperr.New(apperr.CodeUnsupportedFeature, "failed to download any ONNX model file, model %s may not support ONNX format", repoID)
+		m.emitDownloadError(modelID, err)
+		return err
 	}
 
 	// ✅ 发送下载完成事件
@@ -569,6 +570,13 @@ func (m *ModelService) DownloadModelFromHuggingFace(modelID string, repoID strin
 	return nil
 }
 
+// emitDownloadError 发送 "model-download-error" 事件（模型 ID、错误信息、错误代码）
+func (m *ModelService) emitDownloadError(modelID string, err error) {
+	if m.ctx != nil {
+		runtime.EventsEmit(m.ctx, "model-download-error", modelID, err.Error(), apperr.CodeOf(err))
+	}
+}
+
 // createProgressLogger 已移除，现在使用事件系统发送进度
 
 // DownloadModelWithConfig 使用自定义配置下载模型
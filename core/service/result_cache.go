@@ -0,0 +1,256 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ==================== AI 结果缓存 ====================
+
+const (
+	defaultCacheTTL      = 7 * 24 * time.Hour
+	defaultCacheMaxBytes = 512 << 20
+)
+
+// cacheEntry 缓存的 AI 调用结果
+type cacheEntry struct {
+	Key       string    `json:"key"`
+	Feature   string    `json:"feature"`
+	Provider  string    `json:"provider"` // 生成该结果的提供商
+	CreatedAt time.Time `json:"createdAt"`
+	Images    []string  `json:"images,omitempty"`
+	Text      string    `json:"text,omitempty"`
+}
+
+// resultCache 基于内容寻址的磁盘缓存
+// 每个结果保存为 cache 目录下以键命名的 JSON 文件；
+// 文件修改时间记录最近一次访问，超出容量时按最久未使用淘汰
+type resultCache struct {
+	mu  sync.Mutex
+	dir string
+}
+
+// newResultCache 创建结果缓存
+func newResultCache() *resultCache {
+	return &resultCache{}
+}
+
+// getCacheDir 获取缓存目录（内部方法，调用方需持有锁）
+func (c *resultCache) getCacheDir() (string, error) {
+	if c.dir != "" {
+		return c.dir, nil
+	}
+
+	userConfigDir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get user config dir: %w", err)
+	}
+
+	cacheDir := filepath.Join(userConfigDir, "IndrawEditor", "cache")
+	if err := os.MkdirAll(cacheDir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create cache dir: %w", err)
+	}
+
+	c.dir = cacheDir
+	return cacheDir, nil
+}
+
+// get 读取缓存结果，过期的结果会被删除
+// 命中时更新文件修改时间，作为 LRU 的访问时间
+func (c *resultCache) get(key string, ttl time.Duration) (*cacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	dir, err := c.getCacheDir()
+	if err != nil {
+		return nil, false
+	}
+
+	path := filepath.Join(dir, key+".json")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+
+	var entry cacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil || entry.Key != key {
+		os.Remove(path)
+		return nil, false
+	}
+	if ttl > 0 && time.Since(entry.CreatedAt) > ttl {
+		os.Remove(path)
+		return nil, false
+	}
+
+	now := time.Now()
+	os.Chtimes(path, now, now)
+	return &entry, true
+}
+
+// put 保存结果到缓存，并按容量上限淘汰最久未使用的结果
+func (c *resultCache) put(entry cacheEntry, maxBytes int64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	dir, err := c.getCacheDir()
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to serialize cache entry: %w", err)
+	}
+	if maxBytes > 0 && int64(len(data)) > maxBytes {
+		// 单个结果超过容量上限，不缓存
+		return nil
+	}
+
+	path := filepath.Join(dir, entry.Key+".json")
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write cache entry: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("failed to write cache entry: %w", err)
+	}
+
+	c.evict(dir, maxBytes)
+	return nil
+}
+
+// evict 淘汰最久未使用的结果，直到总大小不超过上限（内部方法，调用方需持有锁）
+func (c *resultCache) evict(dir string, maxBytes int64) {
+	if maxBytes <= 0 {
+		return
+	}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+
+	type cacheFile struct {
+		path    string
+		size    int64
+		modTime time.Time
+	}
+	var cached []cacheFile
+	var total int64
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+		info, err := file.Info()
+		if err != nil {
+			continue
+		}
+		cached = append(cached, cacheFile{
+			path:    filepath.Join(dir, file.Name()),
+			size:    info.Size(),
+			modTime: info.ModTime(),
+		})
+		total += info.Size()
+	}
+
+	if total <= maxBytes {
+		return
+	}
+
+	sort.Slice(cached, func(i, j int) bool {
+		return cached[i].modTime.Before(cached[j].modTime)
+	})
+	for _, file := range cached {
+		if total <= maxBytes {
+			break
+		}
+		if err := os.Remove(file.path); err == nil {
+			total -= file.size
+		}
+	}
+}
+
+// cacheKey 计算缓存键
+// 由提供商、模型、模式设置、功能和规范化后的参数计算 SHA-256；
+// 参数中的图像按解码后的字节计算摘要，与 data URL 前缀无关，bypassCache 标志不参与计算
+func cacheKey(providerName, model, variant, feature string, params interface{}) (string, error) {
+	data, err := json.Marshal(params)
+	if err != nil {
+		return "", fmt.Errorf("failed to serialize params: %w", err)
+	}
+
+	var generic interface{}
+	if err := json.Unmarshal(data, &generic); err != nil {
+		return "", fmt.Errorf("failed to normalize params: %w", err)
+	}
+
+	normalized, err := json.Marshal(normalizeCacheValue(generic))
+	if err != nil {
+		return "", fmt.Errorf("failed to normalize params: %w", err)
+	}
+
+	hash := sha256.New()
+	for _, part := range []string{providerName, model, variant, feature} {
+		hash.Write([]byte(part))
+		hash.Write([]byte{0})
+	}
+	hash.Write(normalized)
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// normalizeCacheValue 规范化参数值（递归）
+// json.Marshal 对 map 按键排序，因此规范化后的序列化结果是确定的
+func normalizeCacheValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			if key == "bypassCache" {
+				continue
+			}
+			result[key] = normalizeCacheValue(item)
+		}
+		return result
+	case []interface{}:
+		result := make([]interface{}, len(v))
+		for i, item := range v {
+			result[i] = normalizeCacheValue(item)
+		}
+		return result
+	case string:
+		return normalizeCacheString(v)
+	default:
+		return v
+	}
+}
+
+// normalizeCacheString 将图像数据替换为解码后字节的摘要
+func normalizeCacheString(value string) string {
+	encoded := value
+	if strings.HasPrefix(value, "data:") {
+		_, after, ok := strings.Cut(value, ",")
+		if !ok {
+			return value
+		}
+		encoded = after
+	} else if len(value) < 256 {
+		// 短字符串视为普通文本
+		return value
+	}
+
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return value
+	}
+	sum := sha256.Sum256(data)
+	return "image:" + hex.EncodeToString(sum[:])
+}
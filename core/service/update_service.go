@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"indraw/core/apperr"
 	"os"
 	"runtime"
 
@@ -22,12 +23,13 @@ type UpdateService struct {
 
 // UpdateInfo 更新信息
 type UpdateInfo struct {
-	HasUpdate      bool   `json:"hasUpdate"`
-	LatestVersion  string `json:"latestVersion"`
-	CurrentVersion string `json:"currentVersion"`
-	ReleaseURL     string `json:"releaseUrl"`
-	ReleaseNotes   string `json:"releaseNotes"`
-	Error          string `json:"error,omitempty"`
+	HasUpdate      bool        `json:"hasUpdate"`
+	LatestVersion  string      `json:"latestVersion"`
+	CurrentVersion string      `json:"currentVersion"`
+	ReleaseURL     string      `json:"releaseUrl"`
+	ReleaseNotes   string      `json:"releaseNotes"`
+	Error          string      `json:"error,omitempty"`
+	ErrorCode      apperr.Code `json:"errorCode,omitempty"` // 检测失败时的错误代码
 }
 
 // NewUpdateService 创建更新服务实例
@@ -52,7 +54,8 @@ func (u *UpdateService) CheckForUpdate() (UpdateInfo, error) {
 		return UpdateInfo{
 			HasUpdate:      false,
 			CurrentVersion: u.currentVersion,
-			Error:          fmt.Sprintf("检测更新失败: %v", err),
+			Error:          fmt.Sprintf("failed to check for updates: %v", err),
+			ErrorCode:      updateErrorCode(err),
 		}, nil // 返回错误信息但不返回 error，让前端可以显示
 	}
 
@@ -74,7 +77,8 @@ func (u *UpdateService) CheckForUpdate() (UpdateInfo, error) {
 			CurrentVersion: u.currentVersion,
 			LatestVersion:  latest.Version.String(),
 			ReleaseURL:     latest.URL,
-			Error:          fmt.Sprintf("版本格式解析失败: %v", err),
+			Error:          fmt.Sprintf("failed to parse version: %v", err),
+			ErrorCode:      apperr.CodeInvalidInput,
 		}, nil
 	}
 
@@ -117,40 +121,50 @@ func (u *UpdateService) GetCurrentVersion() string {
 }
 
 // Update 执行更新（下载并替换当前可执行文件）
+// 返回安装的版本号；没有可用的发布或已是最新版本时不执行更新，返回空字符串（不视为错误）
 // 注意：在 Wails 应用中，更新可能需要特殊处理
-func (u *UpdateService) Update() error {
+func (u *UpdateService) Update() (string, error) {
 	repo := fmt.Sprintf("%s/%s", u.repoOwner, u.repoName)
 	latest, found, err := selfupdate.DetectLatest(repo)
 	if err != nil {
-		return fmt.Errorf("检测更新失败: %w", err)
+		return "", apperr.New(updateErrorCode(err), "failed to check for updates: %w", err)
 	}
 
+	// 与 CheckForUpdate 一致，没有发布时视为已是最新版本
 	if !found {
-		return fmt.Errorf("未找到更新")
+		return "", nil
 	}
 
 	// 解析当前版本并检查是否需要更新
 	currentVer, err := semver.ParseTolerant(u.currentVersion)
 	if err != nil {
-		return fmt.Errorf("版本格式解析失败: %w", err)
+		return "", apperr.New(apperr.CodeInvalidInput, "failed to parse version: %w", err)
 	}
 
 	if !latest.Version.GT(currentVer) {
-		return fmt.Errorf("已是最新版本")
+		return "", nil
 	}
 
 	// 获取当前可执行文件路径
 	exe, err := os.Executable()
 	if err != nil {
-		return fmt.Errorf("获取可执行文件路径失败: %w", err)
+		return "", fmt.Errorf("failed to locate executable: %w", err)
 	}
 
 	// 执行更新
 	if err := selfupdate.UpdateTo(latest.AssetURL, exe); err != nil {
-		return fmt.Errorf("更新失败: %w", err)
+		return "", apperr.New(updateErrorCode(err), "failed to install update: %w", err)
 	}
 
-	return nil
+	return latest.Version.String(), nil
+}
+
+// updateErrorCode 访问 GitHub Releases 失败时的错误代码，无法归类时视为网络错误（内部函数）
+func updateErrorCode(err error) apperr.Code {
+	if code := apperr.CodeOf(err); code != apperr.CodeUnknown {
+		return code
+	}
+	return apperr.CodeNetwork
 }
 
 // GetExecutableName 获取当前平台的可执行文件名
@@ -0,0 +1,351 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"indraw/core/provider"
+	"indraw/core/types"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ==================== 用量统计 ====================
+
+// usageDayLayout 账本中日期的格式
+const usageDayLayout = "2006-01-02"
+
+// UsageCounters 用量计数
+type UsageCounters struct {
+	Requests     int64 `json:"requests"`
+	InputTokens  int64 `json:"inputTokens"`
+	OutputTokens int64 `json:"outputTokens"`
+	TotalTokens  int64 `json:"totalTokens"`
+	Images       int64 `json:"images"`
+}
+
+// add 累加一次调用的用量
+func (c *UsageCounters) add(usage provider.Usage) {
+	c.Requests++
+	c.InputTokens += usage.InputTokens
+	c.OutputTokens += usage.OutputTokens
+	c.TotalTokens += usage.TotalTokens
+	c.Images += int64(usage.Images)
+}
+
+// merge 合并另一组计数
+func (c *UsageCounters) merge(other UsageCounters) {
+	c.Requests += other.Requests
+	c.InputTokens += other.InputTokens
+	c.OutputTokens += other.OutputTokens
+	c.TotalTokens += other.TotalTokens
+	c.Images += other.Images
+}
+
+// UsageLedgerEntry 账本条目：某一天某个提供商某个模型的累计用量
+type UsageLedgerEntry struct {
+	Day      string `json:"day"` // 本地日期，格式 2006-01-02
+	Provider string `json:"provider"`
+	Model    string `json:"model"`
+	UsageCounters
+}
+
+// UsageReportRow 用量报告中的一行（带估算费用）
+type UsageReportRow struct {
+	Day      string `json:"day,omitempty"`
+	Provider string `json:"provider,omitempty"`
+	Model    string `json:"model,omitempty"`
+	UsageCounters
+	EstimatedCost float64 `json:"estimatedCost"`
+}
+
+// UsageReport 用量报告
+type UsageReport struct {
+	From           string           `json:"from,omitempty"` // 起始日期（含），为空表示不限
+	To             string           `json:"to,omitempty"`   // 结束日期（含），为空表示不限
+	Totals         UsageReportRow   `json:"totals"`
+	ByProvider     []UsageReportRow `json:"byProvider"`
+	ByModel        []UsageReportRow `json:"byModel"`
+	Entries        []UsageReportRow `json:"entries"`                  // 按日期、提供商、模型的明细
+	UnpricedModels []string         `json:"unpricedModels,omitempty"` // 未配置单价的模型，费用按 0 计算
+}
+
+// UsageService 用量统计服务
+// 按提供商、模型和日期累计用量，账本保存在应用数据目录的 usage.json 中
+type UsageService struct {
+	configService *ConfigService
+	mu            sync.Mutex
+	ledgerFile    string
+	entries       []UsageLedgerEntry
+	loaded        bool
+	now           func() time.Time // 当前时间，为 nil 时使用 time.Now（测试时替换为固定时间）
+}
+
+// NewUsageService 创建用量统计服务实例
+func NewUsageService(configService *ConfigService) *UsageService {
+	return &UsageService{
+		configService: configService,
+	}
+}
+
+// currentTime 返回当前时间（内部方法）
+func (u *UsageService) currentTime() time.Time {
+	if u.now != nil {
+		return u.now()
+	}
+	return time.Now()
+}
+
+// ensureLoaded 确保账本已加载（内部方法，调用方需持有锁）
+func (u *UsageService) ensureLoaded() error {
+	if u.loaded {
+		return nil
+	}
+
+	if u.ledgerFile == "" {
+		userConfigDir, err := os.UserConfigDir()
+		if err != nil {
+			return fmt.Errorf("failed to get user config dir: %w", err)
+		}
+
+		appDataDir := filepath.Join(userConfigDir, "IndrawEditor")
+		if err := os.MkdirAll(appDataDir, 0755); err != nil {
+			return fmt.Errorf("failed to create app data dir: %w", err)
+		}
+		u.ledgerFile = filepath.Join(appDataDir, "usage.json")
+	}
+
+	data, err := os.ReadFile(u.ledgerFile)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read usage ledger: %w", err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &u.entries); err != nil {
+			fmt.Printf("[UsageService] Warning: failed to parse usage ledger: %v\n", err)
+			u.entries = nil
+		}
+	}
+
+	u.loaded = true
+	return nil
+}
+
+// Record 记录一次提供商调用的用量
+func (u *UsageService) Record(usage provider.Usage) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if err := u.ensureLoaded(); err != nil {
+		return err
+	}
+
+	day := u.currentTime().Format(usageDayLayout)
+	index := -1
+	for i := range u.entries {
+		entry := &u.entries[i]
+		if entry.Day == day && entry.Provider == usage.Provider && entry.Model == usage.Model {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		u.entries = append(u.entries, UsageLedgerEntry{Day: day, Provider: usage.Provider, Model: usage.Model})
+		index = len(u.entries) - 1
+	}
+	u.entries[index].add(usage)
+
+	return u.saveLedger()
+}
+
+// saveLedger 保存用量台账到磁盘（内部方法，调用方需持有锁）
+// 先写临时文件再重命名，避免写入中断导致台账损坏
+func (u *UsageService) saveLedger() error {
+	data, err := json.MarshalIndent(u.entries, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to serialize usage ledger: %w", err)
+	}
+
+	tmpPath := u.ledgerFile + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write usage ledger: %w", err)
+	}
+	if err := os.Rename(tmpPath, u.ledgerFile); err != nil {
+		return fmt.Errorf("failed to write usage ledger: %w", err)
+	}
+	return nil
+}
+
+// GetReport 获取指定范围内的用量报告
+// rangeSpec 支持：
+//   - "" 或 "all"：全部记录
+//   - "today"：今天
+//   - "month"：本自然月
+//   - "7d"、"30d" 等：最近 N 天（含今天）
+//   - "2006-01-02..2006-01-31"：指定日期范围（含两端，任一端可省略）
+func (u *UsageService) GetReport(rangeSpec string) (*UsageReport, error) {
+	from, to, err := parseUsageRange(rangeSpec, u.currentTime())
+	if err != nil {
+		return nil, err
+	}
+
+	prices := u.loadModelPrices()
+
+	u.mu.Lock()
+	if err := u.ensureLoaded(); err != nil {
+		u.mu.Unlock()
+		return nil, err
+	}
+	entries := make([]UsageLedgerEntry, len(u.entries))
+	copy(entries, u.entries)
+	u.mu.Unlock()
+
+	report := &UsageReport{From: from, To: to}
+	byProvider := make(map[string]*UsageReportRow)
+	byModel := make(map[string]*UsageReportRow)
+	unpriced := make(map[string]bool)
+
+	for _, entry := range entries {
+		// 日期格式固定，可直接按字符串比较
+		if (from != "" && entry.Day < from) || (to != "" && entry.Day > to) {
+			continue
+		}
+
+		cost, priced := estimateUsageCost(prices, entry.Provider, entry.Model, entry.UsageCounters)
+		if !priced {
+			unpriced[entry.Provider+"/"+entry.Model] = true
+		}
+
+		report.Entries = append(report.Entries, UsageReportRow{
+			Day:           entry.Day,
+			Provider:      entry.Provider,
+			Model:         entry.Model,
+			UsageCounters: entry.UsageCounters,
+			EstimatedCost: cost,
+		})
+
+		report.Totals.merge(entry.UsageCounters)
+		report.Totals.EstimatedCost += cost
+
+		providerRow, ok := byProvider[entry.Provider]
+		if !ok {
+			providerRow = &UsageReportRow{Provider: entry.Provider}
+			byProvider[entry.Provider] = providerRow
+		}
+		providerRow.merge(entry.UsageCounters)
+		providerRow.EstimatedCost += cost
+
+		modelKey := entry.Provider + "/" + entry.Model
+		modelRow, ok := byModel[modelKey]
+		if !ok {
+			modelRow = &UsageReportRow{Provider: entry.Provider, Model: entry.Model}
+			byModel[modelKey] = modelRow
+		}
+		modelRow.merge(entry.UsageCounters)
+		modelRow.EstimatedCost += cost
+	}
+
+	// 明细按日期倒序，汇总按费用和请求数倒序
+	sort.SliceStable(report.Entries, func(i, j int) bool {
+		return report.Entries[i].Day > report.Entries[j].Day
+	})
+	report.ByProvider = sortedUsageRows(byProvider)
+	report.ByModel = sortedUsageRows(byModel)
+	for key := range unpriced {
+		report.UnpricedModels = append(report.UnpricedModels, key)
+	}
+	sort.Strings(report.UnpricedModels)
+
+	return report, nil
+}
+
+// loadModelPrices 从设置中加载模型单价（内部方法）
+func (u *UsageService) loadModelPrices() map[string]types.ModelPrice {
+	if u.configService == nil {
+		return nil
+	}
+
+	settingsJSON, err := u.configService.LoadSettings()
+	if err != nil {
+		return nil
+	}
+
+	var settings types.Settings
+	if err := json.Unmarshal([]byte(settingsJSON), &settings); err != nil {
+		return nil
+	}
+	return settings.AI.ModelPrices
+}
+
+// estimateUsageCost 根据单价估算费用
+// 优先匹配 "provider/model"，其次匹配模型名称；未配置单价时返回 false
+func estimateUsageCost(prices map[string]types.ModelPrice, providerName, model string, counters UsageCounters) (float64, bool) {
+	price, ok := prices[providerName+"/"+model]
+	if !ok {
+		price, ok = prices[model]
+	}
+	if !ok {
+		return 0, false
+	}
+
+	cost := float64(counters.InputTokens)/1e6*price.InputPerMillion +
+		float64(counters.OutputTokens)/1e6*price.OutputPerMillion +
+		float64(counters.Images)*price.PerImage
+	return cost, true
+}
+
+// sortedUsageRows 将汇总行按费用、请求数倒序排列
+func sortedUsageRows(rows map[string]*UsageReportRow) []UsageReportRow {
+	result := make([]UsageReportRow, 0, len(rows))
+	for _, row := range rows {
+		result = append(result, *row)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].EstimatedCost != result[j].EstimatedCost {
+			return result[i].EstimatedCost > result[j].EstimatedCost
+		}
+		if result[i].Requests != result[j].Requests {
+			return result[i].Requests > result[j].Requests
+		}
+		return result[i].Provider+"/"+result[i].Model < result[j].Provider+"/"+result[j].Model
+	})
+	return result
+}
+
+// parseUsageRange 解析报告范围，返回起止日期（含两端，为空表示不限）
+func parseUsageRange(rangeSpec string, now time.Time) (string, string, error) {
+	spec := strings.TrimSpace(strings.ToLower(rangeSpec))
+	today := now.Format(usageDayLayout)
+
+	switch {
+	case spec == "" || spec == "all":
+		return "", "", nil
+	case spec == "today":
+		return today, today, nil
+	case spec == "month":
+		first := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+		return first.Format(usageDayLayout), today, nil
+	case strings.HasSuffix(spec, "d") && !strings.Contains(spec, ".."):
+		days, err := strconv.Atoi(strings.TrimSuffix(spec, "d"))
+		if err != nil || days <= 0 {
+			return "", "", fmt.Errorf("invalid usage range: %s", rangeSpec)
+		}
+		return now.AddDate(0, 0, -(days - 1)).Format(usageDayLayout), today, nil
+	case strings.Contains(spec, ".."):
+		from, to, _ := strings.Cut(spec, "..")
+		for _, day := range []string{from, to} {
+			if day == "" {
+				continue
+			}
+			if _, err := time.Parse(usageDayLayout, day); err != nil {
+				return "", "", fmt.Errorf("invalid usage range date %q: %w", day, err)
+			}
+		}
+		return from, to, nil
+	default:
+		return "", "", fmt.Errorf("invalid usage range: %s", rangeSpec)
+	}
+}
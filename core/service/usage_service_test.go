@@ -0,0 +1,212 @@
+package service
+
+import (
+	"encoding/json"
+	"indraw/core/provider"
+	"indraw/core/types"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// usageTestNow 用量测试使用的固定当前时间
+var usageTestNow = time.Date(2026, 3, 15, 10, 0, 0, 0, time.UTC)
+
+// newTestUsageService 创建使用临时账本和固定时间的用量服务，账本预先写入指定条目
+func newTestUsageService(t *testing.T, prices map[string]types.ModelPrice, entries []UsageLedgerEntry) *UsageService {
+	t.Helper()
+	c := newTestConfigService(t)
+	if err := c.writeSettings(types.Settings{Version: "1.0", AI: types.AISettings{ModelPrices: prices}}); err != nil {
+		t.Fatalf("writeSettings() error = %v", err)
+	}
+
+	u := NewUsageService(c)
+	u.ledgerFile = filepath.Join(t.TempDir(), "usage.json")
+	u.now = func() time.Time { return usageTestNow }
+	if entries != nil {
+		data, _ := json.Marshal(entries)
+		if err := os.WriteFile(u.ledgerFile, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return u
+}
+
+func TestUsageRecordMergesLedger(t *testing.T) {
+	u := newTestUsageService(t, nil, []UsageLedgerEntry{
+		{Day: "2026-03-15", Provider: "openai", Model: "gpt-image-1", UsageCounters: UsageCounters{Requests: 1, Images: 1}},
+	})
+
+	calls := []struct {
+		day   time.Time
+		usage provider.Usage
+	}{
+		{usageTestNow, provider.Usage{Provider: "openai", Model: "gpt-image-1", InputTokens: 100, TotalTokens: 100, Images: 1}},
+		{usageTestNow, provider.Usage{Provider: "openai", Model: "dall-e-3", Images: 2}},
+		{usageTestNow.AddDate(0, 0, 1), provider.Usage{Provider: "openai", Model: "gpt-image-1", Images: 1}},
+	}
+	for _, call := range calls {
+		u.now = func() time.Time { return call.day }
+		if err := u.Record(call.usage); err != nil {
+			t.Fatalf("Record() error = %v", err)
+		}
+	}
+
+	// 从磁盘重新加载，检查同一天同一提供商和模型的用量被合并
+	reloaded := &UsageService{ledgerFile: u.ledgerFile}
+	if err := reloaded.ensureLoaded(); err != nil {
+		t.Fatalf("ensureLoaded() error = %v", err)
+	}
+	want := []UsageLedgerEntry{
+		{Day: "2026-03-15", Provider: "openai", Model: "gpt-image-1", UsageCounters: UsageCounters{Requests: 2, InputTokens: 100, TotalTokens: 100, Images: 2}},
+		{Day: "2026-03-15", Provider: "openai", Model: "dall-e-3", UsageCounters: UsageCounters{Requests: 1, Images: 2}},
+		{Day: "2026-03-16", Provider: "openai", Model: "gpt-image-1", UsageCounters: UsageCounters{Requests: 1, Images: 1}},
+	}
+	if !reflect.DeepEqual(reloaded.entries, want) {
+		t.Errorf("ledger = %+v, want %+v", reloaded.entries, want)
+	}
+	if _, err := os.Stat(u.ledgerFile + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("temporary ledger file was left behind: %v", err)
+	}
+}
+
+func TestUsageGetReport(t *testing.T) {
+	prices := map[string]types.ModelPrice{
+		"openai/gpt-image-1":     {InputPerMillion: 10, PerImage: 0.04},
+		"gpt-image-1":            {PerImage: 0.05},
+		"gemini-2.5-flash-image": {InputPerMillion: 0.3, OutputPerMillion: 2.5, PerImage: 0.039},
+	}
+	u := newTestUsageService(t, prices, []UsageLedgerEntry{
+		{Day: "2026-02-20", Provider: "ollama", Model: "llama3", UsageCounters: UsageCounters{Requests: 5, InputTokens: 500}},
+		{Day: "2026-03-01", Provider: "relay", Model: "gpt-image-1", UsageCounters: UsageCounters{Requests: 3, Images: 3}},
+		{Day: "2026-03-15", Provider: "openai", Model: "gpt-image-1", UsageCounters: UsageCounters{Requests: 2, InputTokens: 1000, Images: 2}},
+		{Day: "2026-03-14", Provider: "gemini", Model: "gemini-2.5-flash-image", UsageCounters: UsageCounters{Requests: 1, InputTokens: 2_000_000, OutputTokens: 1_000_000, Images: 1}},
+	})
+
+	// 费用：openai 0.09（provider/model 单价），gemini 3.139，relay 0.15（按模型名称匹配），ollama 未配置单价
+	tests := []struct {
+		name          string
+		rangeSpec     string
+		wantDays      []string
+		wantRequests  int64
+		wantCost      float64
+		wantProviders []string
+		wantUnpriced  []string
+	}{
+		{"all", "", []string{"2026-03-15", "2026-03-14", "2026-03-01", "2026-02-20"}, 11, 3.379,
+			[]string{"gemini", "relay", "openai", "ollama"}, []string{"ollama/llama3"}},
+		{"month", "month", []string{"2026-03-15", "2026-03-14", "2026-03-01"}, 6, 3.379,
+			[]string{"gemini", "relay", "openai"}, nil},
+		{"last 7 days", "7d", []string{"2026-03-15", "2026-03-14"}, 3, 3.229,
+			[]string{"gemini", "openai"}, nil},
+		{"explicit range", "2026-02-01..2026-03-01", []string{"2026-03-01", "2026-02-20"}, 8, 0.15,
+			[]string{"relay", "ollama"}, []string{"ollama/llama3"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report, err := u.GetReport(tt.rangeSpec)
+			if err != nil {
+				t.Fatalf("GetReport() error = %v", err)
+			}
+
+			var days []string
+			for _, entry := range report.Entries {
+				days = append(days, entry.Day)
+			}
+			if !reflect.DeepEqual(days, tt.wantDays) {
+				t.Errorf("entry days = %v, want %v", days, tt.wantDays)
+			}
+			if report.Totals.Requests != tt.wantRequests || math.Abs(report.Totals.EstimatedCost-tt.wantCost) > 1e-9 {
+				t.Errorf("totals = %d requests, cost %v; want %d, %v", report.Totals.Requests, report.Totals.EstimatedCost, tt.wantRequests, tt.wantCost)
+			}
+			var providers []string
+			for _, row := range report.ByProvider {
+				providers = append(providers, row.Provider)
+			}
+			if !reflect.DeepEqual(providers, tt.wantProviders) {
+				t.Errorf("providers = %v, want %v", providers, tt.wantProviders)
+			}
+			if !reflect.DeepEqual(report.UnpricedModels, tt.wantUnpriced) {
+				t.Errorf("unpriced models = %v, want %v", report.UnpricedModels, tt.wantUnpriced)
+			}
+		})
+	}
+
+	t.Run("models are grouped per provider", func(t *testing.T) {
+		report, err := u.GetReport("all")
+		if err != nil {
+			t.Fatalf("GetReport() error = %v", err)
+		}
+		if len(report.ByModel) != 4 {
+			t.Errorf("model rows = %+v, want one row per provider and model", report.ByModel)
+		}
+	})
+
+	t.Run("invalid range", func(t *testing.T) {
+		if _, err := u.GetReport("last week"); err == nil {
+			t.Error("GetReport() succeeded with an invalid range")
+		}
+	})
+}
+
+func TestEstimateUsageCost(t *testing.T) {
+	prices := map[string]types.ModelPrice{
+		"relay/gpt-image-1": {PerImage: 0.02},
+		"gpt-image-1":       {InputPerMillion: 5, OutputPerMillion: 40, PerImage: 0.04},
+	}
+	counters := UsageCounters{InputTokens: 200_000, OutputTokens: 50_000, Images: 2}
+
+	tests := []struct {
+		name, provider, model string
+		want                  float64
+		wantPriced            bool
+	}{
+		{"provider/model takes precedence", "relay", "gpt-image-1", 0.04, true},
+		{"model name", "openai", "gpt-image-1", 1 + 2 + 0.08, true},
+		{"unpriced", "openai", "dall-e-3", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, priced := estimateUsageCost(prices, tt.provider, tt.model, counters)
+			if priced != tt.wantPriced || math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("estimateUsageCost() = %v, %v; want %v, %v", got, priced, tt.want, tt.wantPriced)
+			}
+		})
+	}
+}
+
+func TestParseUsageRange(t *testing.T) {
+	tests := []struct {
+		spec     string
+		from, to string
+		wantErr  bool
+	}{
+		{"", "", "", false},
+		{"all", "", "", false},
+		{"today", "2026-03-15", "2026-03-15", false},
+		{" Month ", "2026-03-01", "2026-03-15", false},
+		{"1d", "2026-03-15", "2026-03-15", false},
+		{"30d", "2026-02-14", "2026-03-15", false},
+		{"2026-03-01..2026-03-10", "2026-03-01", "2026-03-10", false},
+		{"2026-03-01..", "2026-03-01", "", false},
+		{"..2026-03-10", "", "2026-03-10", false},
+		{"0d", "", "", true},
+		{"xd", "", "", true},
+		{"2026-13-01..", "", "", true},
+		{"yesterday", "", "", true},
+	}
+
+	for _, tt := range tests {
+		from, to, err := parseUsageRange(tt.spec, usageTestNow)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseUsageRange(%q) error = %v, wantErr %v", tt.spec, err, tt.wantErr)
+			continue
+		}
+		if from != tt.from || to != tt.to {
+			t.Errorf("parseUsageRange(%q) = %q, %q; want %q, %q", tt.spec, from, to, tt.from, tt.to)
+		}
+	}
+}
@@ -11,7 +11,54 @@ type Settings struct {
 
 // AISettings AI 服务设置
 type AISettings struct {
-	Provider   string `json:"provider"`
+	Provider string `json:"provider"` // 当前提供商类型（使用配置档案时与当前档案的类型一致）
+
+	// 提供商连接配置
+	// 旧版配置中为唯一的一组连接配置，加载时会自动迁移为默认配置档案；
+	// 迁移后加载设置时为当前档案连接配置的副本，保存时对这些字段的修改会写回当前档案
+	AIConnectionSettings
+
+	// 命名的提供商配置档案，每个档案有独立的类型、凭据、地址、模型和模式
+	Profiles      []AIProfile `json:"profiles,omitempty"`
+	ActiveProfile string      `json:"activeProfile,omitempty"` // 当前档案 ID
+
+	// 按功能路由提供商，键为功能名称（generateImage / editImage / inpaint / blendImages / enhancePrompt /
+	// removeBackground / extendImage / upscale / referenceImage），值为档案 ID 或提供商类型，如 {"removeBackground": "cloud"}
+	// 路由的提供商优先处理该功能，失败时再按当前档案和回退提供商的顺序尝试；未配置的功能使用当前档案
+	FeatureRoutes map[string]string `json:"featureRoutes,omitempty"`
+
+	// 提示词增强使用的提供商（档案 ID 或提供商类型，如 "ollama"），为空时使用当前档案
+	// 兼容旧配置，等同于 FeatureRoutes 中的 enhancePrompt 路由（FeatureRoutes 优先）
+	PromptProvider string `json:"promptProvider,omitempty"`
+
+	// 回退提供商配置
+	// 当前提供商失败（配额、故障、不支持的功能）时，按顺序尝试列表中的提供商（档案 ID 或提供商类型），如 ["cloud", "openai"]
+	FallbackProviders []string `json:"fallbackProviders,omitempty"`
+
+	// 重试配置（针对 429/5xx 和瞬时网络错误；生成等非幂等请求只在 429/503 或请求未发出时重试，避免重复计费）
+	RetryMaxAttempts int `json:"retryMaxAttempts,omitempty"` // 最大尝试次数（含首次请求，默认 3，1 表示不重试）
+	RetryBaseDelayMs int `json:"retryBaseDelayMs,omitempty"` // 首次重试的基础延迟（毫秒，默认 1000）
+
+	// 远程图像下载配置
+	// 聊天模式模型（第三方中继）或云服务返回图像 URL 时，在本地下载后以 data URI 返回，避免链接过期和跨域问题；
+	// 指向本机或内网地址的链接会被拒绝（提供商配置的服务地址除外）
+	RemoteImageMaxSizeMB  int    `json:"remoteImageMaxSizeMb,omitempty"`  // 单张图像大小上限（MB，默认 20）
+	RemoteImageTimeoutSec int    `json:"remoteImageTimeoutSec,omitempty"` // 下载超时（秒，默认 60）
+	RemoteImageProxyURL   string `json:"remoteImageProxyUrl,omitempty"`   // 下载使用的代理地址（如 "http://127.0.0.1:7890"），为空时使用系统代理环境变量
+
+	// 用量计费配置
+	// 按模型名称配置单价，用于估算费用；也可使用 "provider/model" 作为键区分不同提供商的同名模型
+	ModelPrices map[string]ModelPrice `json:"modelPrices,omitempty"`
+
+	// 结果缓存配置（默认关闭）
+	// 相同提供商、模型、功能和参数的请求直接返回缓存结果
+	CacheEnabled   bool `json:"cacheEnabled,omitempty"`
+	CacheTTLHours  int  `json:"cacheTtlHours,omitempty"`  // 缓存有效期（小时，默认 168）
+	CacheMaxSizeMB int  `json:"cacheMaxSizeMb,omitempty"` // 缓存最大占用空间（MB，默认 512），超出时淘汰最久未使用的结果
+}
+
+// AIConnectionSettings 提供商连接配置（凭据、地址、模型和模式）
+type AIConnectionSettings struct {
 	APIKey     string `json:"apiKey"` // 加密存储
 	TextModel  string `json:"textModel"`
 	ImageModel string `json:"imageModel"`
@@ -22,6 +69,10 @@ type AISettings struct {
 	VertexLocation    string `json:"vertexLocation"`    // GCP 区域（如 us-central1）
 	VertexCredentials string `json:"vertexCredentials"` // GCP 服务账号 JSON（加密存储）
 
+	// Gemini 流式模式配置
+	// 开启后图像请求使用流式接口，接收过程中上报进度和模型思考过程中的预览图像
+	GeminiStream bool `json:"geminiStream"` // 图像模型是否使用流式请求（默认 false）
+
 	// OpenAI 配置
 	OpenAIAPIKey       string `json:"openaiApiKey"`      // 加密存储
 	OpenAIImageAPIKey  string `json:"openaiImageApiKey"` // 加密存储
@@ -44,6 +95,51 @@ type AISettings struct {
 	// Cloud 云服务配置
 	CloudEndpointURL string `json:"cloudEndpointUrl"` // 云服务端点 URL
 	CloudToken       string `json:"cloudToken"`       // 云服务认证 Token（加密存储）
+
+	// ComfyUI 本地服务配置
+	ComfyUIURL string `json:"comfyuiUrl,omitempty"` // ComfyUI 服务地址（默认 http://127.0.0.1:8188）
+	// 按功能配置的 API 格式工作流模板，键为 generateImage / editImage / inpaint / blendImages / upscale
+	// 模板中可使用占位符：{{prompt}}、{{width}}、{{height}}、{{seed}}、{{image}}、{{mask}}、{{image_1}}…、{{reference_image}}、{{sketch_image}}、{{scale}}
+	ComfyUIWorkflows map[string]string `json:"comfyuiWorkflows,omitempty"`
+
+	// Stable Diffusion WebUI（AUTOMATIC1111 / Forge）本地服务配置，需以 --api 参数启动
+	SDWebUIURL               string  `json:"sdwebuiUrl,omitempty"`               // 服务地址（默认 http://127.0.0.1:7860）
+	SDWebUICheckpoint        string  `json:"sdwebuiCheckpoint,omitempty"`        // 模型检查点（sd-models 返回的 title），为空时使用服务端当前模型
+	SDWebUISampler           string  `json:"sdwebuiSampler,omitempty"`           // 采样器名称，如 "DPM++ 2M"，为空时使用服务端默认值
+	SDWebUISteps             int     `json:"sdwebuiSteps,omitempty"`             // 采样步数，为 0 时使用服务端默认值
+	SDWebUICFGScale          float64 `json:"sdwebuiCfgScale,omitempty"`          // CFG 引导系数，为 0 时使用服务端默认值
+	SDWebUIDenoisingStrength float64 `json:"sdwebuiDenoisingStrength,omitempty"` // 图生图重绘幅度（默认 0.75）
+	SDWebUIUpscaler          string  `json:"sdwebuiUpscaler,omitempty"`          // 放大算法名称（默认 "R-ESRGAN 4x+"）
+
+	// Ollama 本地大模型配置（仅用于提示词增强）
+	OllamaURL   string `json:"ollamaUrl,omitempty"`   // 服务地址（默认 http://127.0.0.1:11434）
+	OllamaModel string `json:"ollamaModel,omitempty"` // 模型名称，如 "llama3.2"，为空时使用第一个已安装的模型
+
+	// Mock 模拟提供商配置（离线开发和测试用，不访问网络）
+	// 提示词中的 "[mock:rate_limit]"、"[mock:timeout]"、"[mock:safety]" 指令会强制对应的失败
+	MockLatencyMs   int     `json:"mockLatencyMs,omitempty"`   // 模拟延迟（毫秒，默认 0）
+	MockFailure     string  `json:"mockFailure,omitempty"`     // 模拟失败类型：rate_limit / timeout / safety，为空时不模拟失败
+	MockFailureRate float64 `json:"mockFailureRate,omitempty"` // 失败概率（0-1，按提示词哈希确定，同一提示词结果相同），1 表示总是失败；仅在设置了 MockFailure 时生效，未设置概率（0）时同样总是失败
+
+	// 输入图像规范化配置（上传到提供商之前统一处理，格式和大小上限由提供商决定）
+	InputImageMaxDimension int    `json:"inputImageMaxDimension,omitempty"` // 最长边上限（像素），为 0 时使用提供商默认值，只能比默认值更小
+	InputImageAlpha        string `json:"inputImageAlpha,omitempty"`        // 透明通道处理：preserve / flatten（合成到白色背景），为空时保留
+}
+
+// AIProfile 命名的提供商配置档案
+// 回退提供商、提示词增强提供商等配置中可以使用档案 ID 引用档案，也可以使用提供商类型（引用该类型的第一个档案）
+type AIProfile struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	Type string `json:"type"` // 提供商类型：gemini / openai / cloud / comfyui / sdwebui / ollama / mock
+	AIConnectionSettings
+}
+
+// ModelPrice 模型单价
+type ModelPrice struct {
+	InputPerMillion  float64 `json:"inputPerMillion"`  // 每百万输入 Token 的价格
+	OutputPerMillion float64 `json:"outputPerMillion"` // 每百万输出 Token 的价格
+	PerImage         float64 `json:"perImage"`         // 每张图像的价格
 }
 
 // OpenAI 图像模式常量
@@ -53,6 +149,12 @@ const (
 	OpenAIImageModeChat     = "chat"      // 使用 Chat Completion API
 )
 
+// 输入图像透明通道处理常量
+const (
+	InputImageAlphaPreserve = "preserve" // 保留透明通道（默认）
+	InputImageAlphaFlatten  = "flatten"  // 合成到白色背景
+)
+
 // TransformersModelInfo 模型信息（配置文件中的模型定义）
 type TransformersModelInfo struct {
 	ID          string `json:"id"`          // 模型唯一标识（目录名）
@@ -138,12 +240,22 @@ type GenerateImageParams struct {
 	SketchImage    string `json:"sketchImage,omitempty"`    // base64 编码的草图图像
 	ImageSize      string `json:"imageSize"`                // "1K", "2K", "4K"
 	AspectRatio    string `json:"aspectRatio"`              // "1:1", "16:9", "9:16", "3:4", "4:3"
+	Count          int    `json:"count,omitempty"`          // 候选图像数量（默认 1，最多 4）
+	BypassCache    bool   `json:"bypassCache,omitempty"`    // 跳过结果缓存，强制重新生成
 }
 
 // EditImageParams 图像编辑参数
 type EditImageParams struct {
-	ImageData string `json:"imageData"` // base64 编码的图像
-	Prompt    string `json:"prompt"`
+	ImageData   string `json:"imageData"` // base64 编码的图像
+	Prompt      string `json:"prompt"`
+	Mask        string `json:"mask,omitempty"`        // base64 编码的蒙版（画笔绘制的 alpha PNG，不透明区域为重绘区域），为空时编辑整张图像
+	BypassCache bool   `json:"bypassCache,omitempty"` // 跳过结果缓存，强制重新生成
+}
+
+// EnhancePromptParams 提示词增强参数
+type EnhancePromptParams struct {
+	Prompt      string `json:"prompt"`
+	BypassCache bool   `json:"bypassCache,omitempty"` // 跳过结果缓存，强制重新生成
 }
 
 // MultiImageEditParams 多图编辑参数
@@ -159,3 +271,37 @@ type BlendImagesParams struct {
 	Prompt string   `json:"prompt"` // 用户提示词（可选）
 	Style  string   `json:"style"`  // 融合风格: "Seamless", "Double Exposure", "Splash Effect", "Glitch/Cyberpunk", "Surreal"
 }
+
+// ExtendImageParams 图像扩展（外绘）参数
+// 可以分别指定四个方向的扩展像素，或者指定目标宽高比（四个方向均为 0 时生效，原图居中）
+type ExtendImageParams struct {
+	ImageData   string `json:"imageData"`             // base64 编码的原图
+	Prompt      string `json:"prompt,omitempty"`      // 扩展区域的内容描述（可选）
+	Top         int    `json:"top,omitempty"`         // 上方扩展像素
+	Right       int    `json:"right,omitempty"`       // 右侧扩展像素
+	Bottom      int    `json:"bottom,omitempty"`      // 下方扩展像素
+	Left        int    `json:"left,omitempty"`        // 左侧扩展像素
+	AspectRatio string `json:"aspectRatio,omitempty"` // 目标宽高比，如 "16:9"
+}
+
+// UpscaleImageParams 图像放大参数
+type UpscaleImageParams struct {
+	ImageData string `json:"imageData"` // base64 编码的图像
+	Scale     int    `json:"scale"`     // 放大倍数：2 或 4
+}
+
+// ==================== AI 服务响应结构体 ====================
+
+// AIResponseMeta AI 调用结果的元数据
+type AIResponseMeta struct {
+	Provider string `json:"provider,omitempty"` // 实际处理请求的提供商
+	Cached   bool   `json:"cached"`             // 结果是否来自缓存
+	CachedAt string `json:"cachedAt,omitempty"` // 缓存结果的生成时间（RFC3339）
+}
+
+// AIResponse 带元数据的 AI 调用结果
+type AIResponse struct {
+	Images []string       `json:"images,omitempty"` // 图像结果（data URL）
+	Text   string         `json:"text,omitempty"`   // 文本结果
+	Meta   AIResponseMeta `json:"meta"`
+}
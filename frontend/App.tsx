@@ -49,6 +49,7 @@ import { loadAndFitImage } from '@/utils/imageLayout';
 import { scaleImageToFit } from '@/utils/cropImage';
 import { DEFAULT_BRUSH_CONFIG, DEFAULT_ERASER_CONFIG, DEFAULT_LAYER_PROPS, DEFAULT_TEXT_PROPS } from '@/constants';
 import { createLayerName } from '@/utils/layerName';
+import { getErrorCode, getErrorMessage } from '@/utils/appError';
 import FullScreenLoading from '@/components/FullScreenLoading';
 import ImageCropModal from '@/components/ImageCropModal';
 import ImageSliceModal from '@/components/ImageSliceModal';
@@ -125,6 +126,14 @@ export default function App() {
   // i18n
   const { t } = useTranslation(['common', 'dialog', 'message']);
 
+  // AI 操作失败提示：用户主动取消时不提示，其余按错误代码显示本地化的错误信息
+  const alertAIError = (title: string, error: unknown) => {
+    if (getErrorCode(error) === 'CANCELLED') {
+      return;
+    }
+    alert(`${title}: ${getErrorMessage(error, t('message:error.processingFailed', '处理失败'))}`);
+  };
+
   // Hooks
   const projectManager = useProjectManager();
 
@@ -1062,8 +1071,8 @@ export default function App() {
       });
 
       setActiveTool('select');
-    } catch (error: any) {
-      alert(`AI Generation failed: ${error.message}`);
+    } catch (error) {
+      alertAIError(t('message:error.aiGenerateFailed', 'AI 生成失败'), error);
     } finally {
       // 关闭全屏 Loading，恢复用户操作
       setProcessingState('idle');
@@ -1142,9 +1151,9 @@ export default function App() {
       // 选中新图层
       setSelectedIds([newLayer.id]);
 
-    } catch (e: any) {
+    } catch (e) {
       console.error("Blend failed", e);
-      alert(`Blend failed: ${e.message}`);
+      alertAIError(t('message:error.blendFailed', 'AI 融合失败'), e);
     } finally {
       // 关闭全屏 Loading，恢复用户操作
       setProcessingState('idle');
@@ -1306,9 +1315,9 @@ export default function App() {
       setLocalRedrawPrompt('');
       setActiveTool('select');
 
-    } catch (e: any) {
+    } catch (e) {
       console.error(e);
-      alert(`LocalRedrawing failed: ${e.message}`);
+      alertAIError(t('message:error.localRedrawFailed', 'AI 局部重绘失败'), e);
     } finally {
       setProcessingState('idle');
     }
@@ -1328,9 +1337,9 @@ export default function App() {
         src: resultBase64,
         name: `${targetLayer.name} (No BG)`
       });
-    } catch (e: any) {
+    } catch (e) {
       console.error("Remove BG failed", e);
-      alert(`Failed to remove background: ${e.message}`);
+      alertAIError(t('message:error.removeBackgroundFailed', '移除背景失败'), e);
     } finally {
       setProcessingState('idle');
     }
@@ -1463,9 +1472,9 @@ Keep high quality and clarity.`;
       );
       layerManager.updateLayersWithHistory(updatedLayers, 'history.aiTransform');
 
-    } catch (e: any) {
+    } catch (e) {
       console.error("AI Transform failed", e);
-      alert(`AI Transform failed: ${e.message}`);
+      alertAIError(t('message:error.aiTransformFailed', 'AI 变换失败'), e);
     } finally {
       setProcessingState('idle');
     }
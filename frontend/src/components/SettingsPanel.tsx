@@ -25,10 +25,11 @@ import {
 } from 'lucide-react';
 import clsx from 'clsx';
 import { useSettings } from '../contexts/SettingsContext';
-import { Settings as SettingsType, SettingsCategory } from '@/types';
+import { Settings as SettingsType, SettingsCategory, AIProvider, MockFailure } from '@/types';
 import ConfirmDialog from './ConfirmDialog';
 // ✅ 导入 wailsRuntime 以获取 window.runtime 类型定义
 import '../utils/wailsRuntime';
+import { getErrorMessage, type AppErrorCode } from '../utils/appError';
 import {
   getAvailableModels,
   getModelStatus,
@@ -38,7 +39,7 @@ import {
   setDownloadConfig,
   HFDownloadConfig,
 } from '../services/transformersService';
-import { SelectDirectory, CheckAIProviderAvailability, CheckForUpdate, GetCurrentVersion, Update } from '../../wailsjs/go/core/App';
+import { SelectDirectory, CheckAIProviderAvailability, SetActiveAIProfile, CheckForUpdate, GetCurrentVersion, Update } from '../../wailsjs/go/core/App';
 
 // ==================== 类型定义 ====================
 
@@ -401,14 +402,14 @@ export default function SettingsPanel({ isOpen, onClose }: SettingsPanelProps) {
       setUpdateInfo(result);
 
       if (result.hasUpdate) {
-        showMessage('success', `发现新版本: ${result.latestVersion}`);
+        showMessage('success', t('settings.about.updateFound', '发现新版本: {{version}}', { version: result.latestVersion }));
       } else if (result.error) {
-        showMessage('error', result.error);
+        showMessage('error', getErrorMessage({ code: result.errorCode || 'UNKNOWN', message: result.error }));
       } else {
-        showMessage('success', '当前已是最新版本');
+        showMessage('success', t('settings.about.latestVersionInstalled', '已安装最新版本'));
       }
     } catch (error: any) {
-      const errorMessage = error.message || '检查更新失败';
+      const errorMessage = getErrorMessage(error, t('settings.about.checkUpdateFailed', '检查更新失败'));
       setUpdateInfo({
         hasUpdate: false,
         latestVersion: currentVersion,
@@ -433,17 +434,24 @@ export default function SettingsPanel({ isOpen, onClose }: SettingsPanelProps) {
     setShowUpdateConfirm(false);
 
     try {
-      await Update();
-      showMessage('success', `成功更新到版本 ${updateInfo.latestVersion}，请重启应用程序以应用更新`);
+      // 返回安装的版本号，为空表示已是最新版本（没有执行更新）
+      const installedVersion = await Update();
+      if (!installedVersion) {
+        showMessage('success', t('settings.about.latestVersionInstalled', '已安装最新版本'));
+        setUpdateInfo({ ...updateInfo, hasUpdate: false });
+        return;
+      }
+      showMessage('success', t('settings.about.updateSuccess', '成功更新到版本 {{version}}，请重启应用程序以应用更新', { version: installedVersion }));
       // 更新当前版本显示
-      setCurrentVersion(updateInfo.latestVersion);
+      setCurrentVersion(installedVersion);
       setUpdateInfo({
         ...updateInfo,
         hasUpdate: false,
-        currentVersion: updateInfo.latestVersion,
+        latestVersion: installedVersion,
+        currentVersion: installedVersion,
       });
     } catch (error: any) {
-      const errorMessage = error.message || '更新失败';
+      const errorMessage = getErrorMessage(error, t('settings.about.updateFailed', '更新失败'));
       showMessage('error', errorMessage);
     } finally {
       setUpdating(false);
@@ -516,14 +524,14 @@ export default function SettingsPanel({ isOpen, onClose }: SettingsPanelProps) {
     });
 
     // 监听下载错误事件
-    const unsubscribeError = window.runtime.EventsOn('model-download-error', (modelId: string, error: string) => {
+    const unsubscribeError = window.runtime.EventsOn('model-download-error', (modelId: string, error: string, code?: AppErrorCode) => {
       setDownloadingModelId(null);
       setDownloadProgress(prev => {
         const newProgress = { ...prev };
         delete newProgress[modelId];
         return newProgress;
       });
-      showMessage('error', `下载失败: ${error}`);
+      showMessage('error', `下载失败: ${getErrorMessage({ code: code || 'UNKNOWN', message: error })}`);
       loadModels(); // 重新加载状态
     });
 
@@ -690,18 +698,19 @@ export default function SettingsPanel({ isOpen, onClose }: SettingsPanelProps) {
       const resultJSON = await CheckAIProviderAvailability(provider);
       const result = JSON.parse(resultJSON);
       
+      const message = result.code ? getErrorMessage(result) : result.message || '';
       setAvailabilityStatus({
         available: result.available,
-        message: result.message || '',
+        message,
       });
 
       if (result.available) {
         showMessage('success', t('settings.ai.availabilityCheckSuccess', '服务可用'));
       } else {
-        showMessage('error', result.message || t('settings.ai.availabilityCheckFailed', '服务不可用'));
+        showMessage('error', message || t('settings.ai.availabilityCheckFailed', '服务不可用'));
       }
     } catch (error: any) {
-      const errorMessage = error.message || t('settings.ai.availabilityCheckError', '检测失败');
+      const errorMessage = getErrorMessage(error, t('settings.ai.availabilityCheckError', '检测失败'));
       setAvailabilityStatus({
         available: false,
         message: errorMessage,
@@ -712,9 +721,45 @@ export default function SettingsPanel({ isOpen, onClose }: SettingsPanelProps) {
     }
   };
 
+  // 切换配置档案（先保存未保存的修改，切换后重新加载设置）
+  const handleSwitchProfile = async (id: string) => {
+    try {
+      if (hasUnsavedChanges) {
+        if (!(await manualSaveSettings())) {
+          showMessage('error', t('settings.saveError', '保存失败，请重试'));
+          return;
+        }
+        setHasUnsavedChanges(false);
+      }
+      await SetActiveAIProfile(id);
+      await reloadSettings();
+      setAvailabilityStatus(null);
+      showMessage('success', t('settings.ai.profileSwitched', '已切换配置档案'));
+    } catch (error) {
+      showMessage('error', getErrorMessage(error, t('settings.ai.profileSwitchError', '切换配置档案失败')));
+    }
+  };
+
   // 渲染 AI 设置
   const renderAISettings = () => (
     <div className="space-y-4">
+      {/* 配置档案选择 */}
+      {settings.ai.profiles && settings.ai.profiles.length > 1 && (
+        <InputGroup
+          label={t('settings.ai.profile', '配置档案')}
+          hint={t('settings.ai.profileHint', '切换已保存的提供商配置（凭据、地址和模型）')}
+        >
+          <SelectInput
+            value={settings.ai.activeProfile || settings.ai.profiles[0].id}
+            onChange={handleSwitchProfile}
+            options={settings.ai.profiles.map((profile) => ({
+              value: profile.id,
+              label: `${profile.name || profile.id} (${profile.type})`,
+            }))}
+          />
+        </InputGroup>
+      )}
+
       {/* 服务提供商选择 */}
       <InputGroup
         label={t('settings.ai.provider', '服务提供商')}
@@ -722,11 +767,12 @@ export default function SettingsPanel({ isOpen, onClose }: SettingsPanelProps) {
       >
         <SelectInput
           value={settings.ai.provider}
-          onChange={(val) => handleUpdateCategory('ai', { provider: val as 'gemini' | 'openai' | 'cloud' })}
+          onChange={(val) => handleUpdateCategory('ai', { provider: val as AIProvider })}
           options={[
             { value: 'gemini', label: t('settings.ai.providerGemini', 'Google Gemini') },
             { value: 'openai', label: t('settings.ai.providerOpenai', 'OpenAI 兼容') },
             { value: 'cloud', label: t('settings.ai.providerCloud', '云服务') },
+            { value: 'mock', label: t('settings.ai.providerMock', '模拟（离线测试）') },
           ]}
         />
       </InputGroup>
@@ -868,6 +914,22 @@ export default function SettingsPanel({ isOpen, onClose }: SettingsPanelProps) {
             />
           </InputGroup>
 
+          {/* Gemini 流式模式配置 */}
+          <InputGroup
+            label={t('settings.ai.geminiStreamMode', '流式模式配置')}
+            hint={t('settings.ai.geminiStreamModeHint', '流式请求可以显示生成进度和预览图像')}
+          >
+            <label className="flex items-center gap-2 text-sm text-gray-300 cursor-pointer">
+              <input
+                type="checkbox"
+                checked={settings.ai.geminiStream ?? false}
+                onChange={(e) => handleUpdateCategory('ai', { geminiStream: e.target.checked })}
+                className="w-4 h-4 rounded border-tech-600 bg-tech-900 text-cyan-500 focus:ring-cyan-500 focus:ring-offset-0"
+              />
+              <span>{t('settings.ai.geminiStream', '图像模型使用流式请求')}</span>
+            </label>
+          </InputGroup>
+
           {/* 服务可用性检测 */}
           <div className="pt-2 border-t border-tech-700">
             <div className="flex items-center justify-between mb-2">
@@ -1218,6 +1280,59 @@ export default function SettingsPanel({ isOpen, onClose }: SettingsPanelProps) {
         </>
       )}
 
+      {/* 模拟提供商配置 */}
+      {settings.ai.provider === 'mock' && (
+        <>
+          <InputGroup
+            label={t('settings.ai.mockLatency', '模拟延迟（毫秒）')}
+            hint={t('settings.ai.mockLatencyHint', '每次请求等待的时间，用于测试进度和取消')}
+          >
+            <NumberInput
+              value={settings.ai.mockLatencyMs || 0}
+              onChange={(val) => handleUpdateCategory('ai', { mockLatencyMs: Math.max(0, val) })}
+              min={0}
+              step={100}
+            />
+          </InputGroup>
+
+          <InputGroup
+            label={t('settings.ai.mockFailure', '模拟失败')}
+            hint={t('settings.ai.mockFailureHint', '让请求以指定的错误失败，用于测试错误处理和回退')}
+          >
+            <SelectInput
+              value={settings.ai.mockFailure || ''}
+              onChange={(val) => handleUpdateCategory('ai', { mockFailure: val as MockFailure })}
+              options={[
+                { value: '', label: t('settings.ai.mockFailureNone', '不失败') },
+                { value: 'rate_limit', label: t('settings.ai.mockFailureRateLimit', '限流（429）') },
+                { value: 'timeout', label: t('settings.ai.mockFailureTimeout', '超时') },
+                { value: 'safety', label: t('settings.ai.mockFailureSafety', '安全拦截') },
+              ]}
+            />
+          </InputGroup>
+
+          {settings.ai.mockFailure && (
+            <InputGroup
+              label={t('settings.ai.mockFailureRate', '失败概率')}
+              hint={t('settings.ai.mockFailureRateHint', '0 到 1 之间，同一提示词的结果总是相同；1 或 0（未设置）表示每次都失败')}
+            >
+              <NumberInput
+                value={settings.ai.mockFailureRate || 0}
+                onChange={(val) => handleUpdateCategory('ai', { mockFailureRate: Math.min(1, Math.max(0, val)) })}
+                min={0}
+                max={1}
+                step={0.1}
+              />
+            </InputGroup>
+          )}
+
+          <div className="p-3 bg-green-900/20 border border-green-700/50 rounded text-xs text-green-400">
+            <p className="font-medium mb-1">{t('settings.ai.mockNote', 'ℹ️ 模拟提供商说明')}</p>
+            <p className="text-green-500">{t('settings.ai.mockDesc', '模拟提供商不访问网络，根据提示词生成确定性的图像，适用于离线开发和测试。提示词中的 [mock:rate_limit]、[mock:timeout]、[mock:safety] 会强制对应的失败。')}</p>
+          </div>
+        </>
+      )}
+
     </div>
   );
 
@@ -1249,7 +1364,7 @@ export default function SettingsPanel({ isOpen, onClose }: SettingsPanelProps) {
       await performModelSwitch(modelId);
     } catch (error: any) {
       console.error('Failed to switch model:', error);
-      showMessage('error', error.message || '切换模型失败');
+      showMessage('error', getErrorMessage(error, '切换模型失败'));
     }
   };
 
@@ -1288,7 +1403,7 @@ export default function SettingsPanel({ isOpen, onClose }: SettingsPanelProps) {
       // 注意：下载完成和错误处理由事件监听器处理
     } catch (error: any) {
       console.error('Failed to start model download:', error);
-      showMessage('error', error.message || '启动下载失败');
+      showMessage('error', getErrorMessage(error, '启动下载失败'));
       setDownloadingModelId(null);
     }
   };
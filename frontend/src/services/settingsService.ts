@@ -6,6 +6,7 @@
 
 import {
   Settings,
+  AIProvider,
   AIServiceSettings,
   AppSettings,
   TransformersModelSettings,
@@ -33,6 +34,9 @@ export const DEFAULT_AI_SETTINGS: AIServiceSettings = {
   vertexLocation: 'us-central1',
   vertexCredentials: '',
 
+  // Gemini 流式模式配置
+  geminiStream: false,  // 图像模型是否使用流式请求（默认 false）
+
   // OpenAI 兼容 API 配置
   openaiApiKey: '',
   openaiImageApiKey: '',  // 图像 API 独立 API Key（可选）
@@ -89,13 +93,22 @@ export const DEFAULT_SETTINGS: Settings = {
 
 // ==================== 验证工具 ====================
 
+/**
+ * 支持的服务提供商（与后端 aiProviderTypes 保持一致）
+ */
+const AI_PROVIDERS: AIProvider[] = ['gemini', 'openai', 'cloud', 'comfyui', 'sdwebui', 'ollama', 'mock'];
+
 /**
  * 验证 AI 设置
+ * 只校验界面编辑的字段，其余字段（本地服务、回退、路由、缓存等配置）原样保留，
+ * 避免保存时被重置
  */
 function validateAISettings(settings: Partial<AIServiceSettings>): AIServiceSettings {
   return {
+    ...settings,
+
     // 服务提供商
-    provider: settings.provider === 'gemini' || settings.provider === 'openai' || settings.provider === 'cloud'
+    provider: settings.provider && AI_PROVIDERS.includes(settings.provider)
       ? settings.provider
       : DEFAULT_AI_SETTINGS.provider,
 
@@ -122,6 +135,11 @@ function validateAISettings(settings: Partial<AIServiceSettings>): AIServiceSett
       ? settings.vertexCredentials
       : DEFAULT_AI_SETTINGS.vertexCredentials,
 
+    // Gemini 流式模式配置
+    geminiStream: typeof settings.geminiStream === 'boolean'
+      ? settings.geminiStream
+      : DEFAULT_AI_SETTINGS.geminiStream,
+
     // OpenAI 配置
     openaiApiKey: typeof settings.openaiApiKey === 'string'
       ? settings.openaiApiKey
@@ -223,6 +241,7 @@ function validateAppSettings(settings: Partial<AppSettings>): AppSettings {
  */
 function validateSettings(settings: Partial<Settings>): Settings {
   return {
+    ...settings,
     version: SETTINGS_VERSION,
     ai: validateAISettings(settings.ai || {}),
     app: validateAppSettings(settings.app || {}),
@@ -256,8 +275,11 @@ export async function loadSettings(): Promise<Settings> {
  */
 export async function saveSettings(settings: Settings): Promise<boolean> {
   try {
+    // 配置档案通过 SetActiveAIProfile 等方法单独修改，不随设置提交：
+    // 后端按提供商选项切换档案，并将连接配置的修改写回当前档案
     const validated = validateSettings(settings);
-    const settingsJSON = JSON.stringify(validated);
+    const { profiles, activeProfile, ...ai } = validated.ai;
+    const settingsJSON = JSON.stringify({ ...validated, ai });
     await SaveSettings(settingsJSON);
     return true;
   } catch (error) {
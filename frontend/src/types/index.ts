@@ -7,7 +7,7 @@ export type ShapeType = 'polygon' | 'star' | 'rounded-rect' | 'ellipse' | 'arrow
 /**
  * AI 服务提供商类型
  */
-export type AIProvider = 'gemini' | 'openai' | 'cloud';
+export type AIProvider = 'gemini' | 'openai' | 'cloud' | 'comfyui' | 'sdwebui' | 'ollama' | 'mock';
 
 /**
  * OpenAI 图像模式类型
@@ -17,6 +17,11 @@ export type AIProvider = 'gemini' | 'openai' | 'cloud';
  */
 export type OpenAIImageMode = 'auto' | 'image_api' | 'chat';
 
+/**
+ * 模拟提供商的失败类型（为空时不模拟失败）
+ */
+export type MockFailure = '' | 'rate_limit' | 'timeout' | 'safety';
+
 /**
  * AI 服务配置
  */
@@ -35,6 +40,9 @@ export interface AIServiceSettings {
   vertexLocation: string;
   vertexCredentials: string;
 
+  // Gemini 流式模式配置
+  geminiStream?: boolean;  // 图像模型是否使用流式请求，开启后显示进度和预览图像（默认 false）
+
   // OpenAI 兼容 API 配置
   openaiApiKey: string;
   openaiImageApiKey?: string;  // 图像 API 独立 API Key（可选）
@@ -57,6 +65,27 @@ export interface AIServiceSettings {
   // Cloud 云服务配置
   cloudEndpointUrl?: string;  // 云服务端点 URL
   cloudToken?: string;         // 云服务认证 Token
+
+  // 模拟提供商配置（离线开发和测试用）
+  mockLatencyMs?: number;      // 模拟延迟（毫秒）
+  mockFailure?: MockFailure;   // 模拟失败类型
+  mockFailureRate?: number;    // 失败概率（0-1），1 或 0（未设置）表示每次都失败
+
+  // 配置档案（由后端管理，通过 SetActiveAIProfile 等方法修改）
+  // 上述连接配置为当前档案的副本，保存时的修改会写回当前档案
+  profiles?: AIProfile[];
+  activeProfile?: string;  // 当前档案 ID
+}
+
+/**
+ * AI 配置档案
+ * 每个档案有独立的提供商类型和连接配置
+ */
+export interface AIProfile {
+  id: string;
+  name: string;
+  type: AIProvider;
+  [key: string]: unknown;  // 连接配置字段（与 AIServiceSettings 相同）
 }
 
 /**
@@ -0,0 +1,76 @@
+/**
+ * 后端错误解析工具
+ * Go 绑定方法返回的错误经 ErrorFormatter 序列化为 {code, message, details} 对象，
+ * 前端按错误代码做程序化处理和本地化，不依赖错误信息的文本
+ */
+
+import i18n from '../locales';
+
+/**
+ * 稳定的错误代码（与 core/apperr 保持一致）
+ */
+export type AppErrorCode =
+  | 'AUTH_INVALID'
+  | 'QUOTA_EXCEEDED'
+  | 'SAFETY_BLOCKED'
+  | 'UNSUPPORTED_FEATURE'
+  | 'NETWORK'
+  | 'TIMEOUT'
+  | 'CANCELLED'
+  | 'INVALID_INPUT'
+  | 'UNKNOWN';
+
+/**
+ * 后端返回的结构化错误
+ */
+export interface AppError {
+  code: AppErrorCode;
+  message: string;
+  details?: Record<string, any>;
+}
+
+/**
+ * 判断是否为后端返回的结构化错误
+ */
+export function isAppError(error: unknown): error is AppError {
+  return typeof error === 'object' && error !== null
+    && typeof (error as AppError).code === 'string'
+    && typeof (error as AppError).message === 'string';
+}
+
+/**
+ * 将任意错误转换为结构化错误（字符串、Error 对象等视为 UNKNOWN）
+ */
+export function toAppError(error: unknown): AppError {
+  if (isAppError(error)) {
+    return error;
+  }
+  if (error instanceof Error) {
+    return { code: 'UNKNOWN', message: error.message };
+  }
+  return { code: 'UNKNOWN', message: String(error) };
+}
+
+/**
+ * 获取错误代码
+ */
+export function getErrorCode(error: unknown): AppErrorCode {
+  return toAppError(error).code;
+}
+
+/**
+ * 获取面向用户的错误信息
+ * 有对应翻译的错误代码显示本地化文本，后端的原始信息作为补充；
+ * UNKNOWN 或无翻译时直接显示原始信息
+ * @param error 捕获的错误
+ * @param fallback 没有任何错误信息时显示的文本
+ */
+export function getErrorMessage(error: unknown, fallback = ''): string {
+  const { code, message } = toAppError(error);
+  const key = `message:errorCodes.${code}`;
+  if (code === 'UNKNOWN' || !i18n.exists(key)) {
+    return message || fallback;
+  }
+  const localized = i18n.t(key);
+  return message ? `${localized} (${message})` : localized;
+}
@@ -5,10 +5,12 @@ go 1.24.0
 require (
 	cloud.google.com/go/auth v0.17.0
 	github.com/blang/semver v3.5.1+incompatible
+	github.com/gorilla/websocket v1.5.3
 	github.com/run-bigpig/go-github-selfupdate v1.0.0
 	github.com/sashabaranov/go-openai v1.41.2
 	github.com/wailsapp/wails/v2 v2.11.0
 	golang.org/x/crypto v0.45.0
+	golang.org/x/image v0.25.0
 	google.golang.org/genai v1.36.0
 )
 
@@ -28,7 +30,6 @@ require (
 	github.com/google/uuid v1.6.0 // indirect
 	github.com/googleapis/enterprise-certificate-proxy v0.3.6 // indirect
 	github.com/googleapis/gax-go/v2 v2.15.0 // indirect
-	github.com/gorilla/websocket v1.5.3 // indirect
 	github.com/inconshreveable/go-update v0.0.0-20160112193335-8152e7eb6ccf // indirect
 	github.com/jchv/go-winloader v0.0.0-20250406163304-c1995be93bd1 // indirect
 	github.com/labstack/echo/v4 v4.13.3 // indirect
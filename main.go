@@ -3,6 +3,7 @@ package main
 import (
 	"embed"
 	"indraw/core"
+	"indraw/core/apperr"
 
 	"github.com/wailsapp/wails/v2"
 	"github.com/wailsapp/wails/v2/pkg/options"
@@ -38,6 +39,8 @@ func main() {
 		Bind: []interface{}{
 			app,
 		},
+		// 绑定方法返回的错误以 {"code", "message", "details"} 对象传给前端，前端按错误代码本地化
+		ErrorFormatter: apperr.Format,
 		// Windows 特定配置
 		Windows: &windows.Options{
 			// 使用系统主题